@@ -4,8 +4,45 @@ import (
 	"encoding/json"
 
 	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/torrent"
 )
 
+// postFilesTorrent registers a file on the tracker using the contents of a bencoded .torrent file, storing the
+// file's metainfo
+func postFilesTorrent(body []byte) (string, error) {
+	// Parse metainfo from body, computing the info hash
+	meta := new(torrent.Metainfo)
+	if err := meta.UnmarshalBinary(body); err != nil {
+		return "Malformed torrent file", nil
+	}
+
+	// Check for an existing file, such as one awaiting approval, so that it is updated rather than duplicated
+	file, err := new(data.FileRecord).Load(meta.InfoHash, "info_hash")
+	if err != nil {
+		return "", err
+	}
+
+	// Store metadata, and mark file as verified
+	file.InfoHash = meta.InfoHash
+	file.Verified = true
+	file.Name = meta.Name
+	file.Size = meta.Size
+	file.FileCount = meta.Files
+	file.PieceLength = meta.PieceLength
+
+	if err := file.Save(); err != nil {
+		return "", err
+	}
+
+	// Store original metainfo, so it may be served to users with their own announce URL
+	t := data.TorrentRecord{InfoHash: meta.InfoHash, Metainfo: body}
+	if err := t.Save(); err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
+
 // getFilesJSON returns a JSON representation of one or more data.FileRecords
 func getFilesJSON(ID int) ([]byte, error) {
 	// Check for a valid integer ID
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/mdlayher/goat/goat/common"
@@ -85,3 +88,61 @@ func TestGetFilesJSON(t *testing.T) {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
 }
+
+// TestPostFilesTorrent verifies that /api/files registers files from .torrent files
+func TestPostFilesTorrent(t *testing.T) {
+	log.Println("TestPostFilesTorrent()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate mock .torrent file, with a single file and one piece
+	info := "d6:lengthi1024e4:name8:goat.txt12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20) + "e"
+	body := []byte("d8:announce14:http://tracker4:info" + info + "e")
+
+	hash := sha1.Sum([]byte(info))
+	infoHash := hex.EncodeToString(hash[:])
+
+	// Verify malformed torrents are rejected
+	clientErr, err := postFilesTorrent([]byte("d4:infod4:name4:goatee"))
+	if clientErr == "" || err != nil {
+		t.Fatalf("Malformed torrent file was not rejected: %s %v", clientErr, err)
+	}
+
+	// Register mock file
+	clientErr, err = postFilesTorrent(body)
+	if clientErr != "" || err != nil {
+		t.Fatalf("Failed to register mock torrent file: %s %v", clientErr, err)
+	}
+
+	// Load mock file to fetch ID
+	file, err := new(data.FileRecord).Load(infoHash, "info_hash")
+	if file == (data.FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Request output JSON from API for this file
+	res, err := getFilesJSON(file.ID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve files JSON: %s", err.Error())
+	}
+
+	// Verify metadata is returned
+	var file2 data.JSONFileRecord
+	if err := json.Unmarshal(res, &file2); err != nil {
+		t.Fatalf("Failed to unmarshal result JSON for single file: %s", err.Error())
+	}
+
+	if !file2.Verified || file2.Name != "goat.txt" || file2.Size != 1024 || file2.FileCount != 1 || file2.PieceLength != 16384 {
+		t.Fatalf("Unexpected file metadata: %v", file2)
+	}
+
+	// Delete mock file
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"compress/gzip"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -72,6 +73,19 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 		// Server status
 		case "status":
 			res, err = getStatusJSON()
+		// Personalised .torrent files
+		case "torrents":
+			res, err = getTorrent(ID, session.Passkey, trackerURL(r))
+			if err == nil {
+				if res == nil {
+					http.Error(w, ErrorResponse("Torrent not found"), 404)
+					return
+				}
+
+				// Serve as a .torrent file, rather than JSON
+				w.Header().Set("Content-Type", "application/x-bittorrent")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%d.torrent\"", ID))
+			}
 		// Users registered to tracker
 		case "users":
 			res, err = getUsersJSON(ID)
@@ -114,6 +128,10 @@ func Router(w http.ResponseWriter, r *http.Request, session data.UserRecord) {
 
 		// Choose API method
 		switch apiMethod {
+		// Files on tracker
+		case "files":
+			// Attempt to register file from .torrent file
+			clientErr, serverErr = postFilesTorrent(body)
 		// Users registered to tracker
 		case "users":
 			// Attempt to create user from JSON
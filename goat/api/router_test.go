@@ -21,6 +21,8 @@ var apiTests = []struct {
 	{"GET", "/api/files", 200},
 	{"GET", "/api/files/1", 200},
 	{"GET", "/api/status", 200},
+	{"GET", "/api/torrents", 404},
+	{"GET", "/api/torrents/a", 400},
 	{"GET", "/api/users", 200},
 	{"GET", "/api/users/1", 200},
 	{"PUT", "/api/", 405},
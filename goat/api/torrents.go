@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/torrent"
+)
+
+// getTorrent returns the .torrent file for the file with matching ID, carrying the announce URL of the user
+// with the specified passkey.  A nil result is returned if the file does not exist, or if it was not
+// registered using a .torrent file.
+func getTorrent(ID int, passkey string, baseURL string) ([]byte, error) {
+	// A specific file is required
+	if ID < 1 {
+		return nil, nil
+	}
+
+	// Load file
+	file, err := new(data.FileRecord).Load(ID, "id")
+	if err != nil || file == (data.FileRecord{}) {
+		return nil, err
+	}
+
+	// Load original metainfo
+	t, err := new(data.TorrentRecord).Load(file.InfoHash, "info_hash")
+	if err != nil || t.InfoHash == "" {
+		return nil, err
+	}
+
+	// Any additional announce URLs are placed in their own tiers, following the primary announce URL
+	announce := announceURL(baseURL, passkey)
+	var announceList [][]string
+	if extra := common.Static.Config.Torrent.AnnounceList; len(extra) > 0 {
+		announceList = append(announceList, []string{announce})
+		for _, u := range extra {
+			announceList = append(announceList, []string{announceURL(u, passkey)})
+		}
+	}
+
+	return torrent.WithAnnounce(t.Metainfo, announce, announceList)
+}
+
+// announceURL builds a user's announce URL from the base URL of a tracker
+func announceURL(baseURL string, passkey string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + passkey + "/announce"
+}
+
+// trackerURL returns the configured base URL of the tracker, or one derived from the host a request was sent to
+func trackerURL(r *http.Request) string {
+	if u := common.Static.Config.Torrent.AnnounceURL; u != "" {
+		return u
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
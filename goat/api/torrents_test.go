@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/torrent"
+)
+
+// TestGetTorrent verifies that /api/torrents returns a .torrent file carrying the user's announce URL
+func TestGetTorrent(t *testing.T) {
+	log.Println("TestGetTorrent()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Register mock .torrent file, with an announce-list which should be replaced
+	info := "d6:lengthi2048e4:name9:goat2.txt12:piece lengthi16384e6:pieces20:" + strings.Repeat("b", 20) + "e"
+	body := []byte("d8:announce14:http://tracker13:announce-listll14:http://trackeree4:info" + info + "e")
+	if clientErr, err := postFilesTorrent(body); clientErr != "" || err != nil {
+		t.Fatalf("Failed to register mock torrent file: %s %v", clientErr, err)
+	}
+
+	meta := new(torrent.Metainfo)
+	if err := meta.UnmarshalBinary(body); err != nil {
+		t.Fatalf("Failed to unmarshal mock torrent file: %s", err.Error())
+	}
+
+	file, err := new(data.FileRecord).Load(meta.InfoHash, "info_hash")
+	if file == (data.FileRecord{}) || err != nil {
+		t.Fatalf("Failed to load mock file: %v", err)
+	}
+
+	// Unknown files have no .torrent file
+	if res, err := getTorrent(-1, "abc", "http://goat"); res != nil || err != nil {
+		t.Fatalf("Unexpected .torrent file for unknown file: %s %v", string(res), err)
+	}
+
+	var tests = []struct {
+		announceList []string
+		out          string
+	}{
+		// Announce URL only
+		{nil, "d8:announce29:http://goat:8080/abc/announce4:info" + info + "e"},
+		// Announce URL, with an additional tier
+		{[]string{"udp://goat:8080/"}, "d8:announce29:http://goat:8080/abc/announce13:announce-listll29:http://goat:8080/abc/announceel28:udp://goat:8080/abc/announceee4:info" + info + "e"},
+	}
+
+	for i, test := range tests {
+		common.Static.Config.Torrent.AnnounceList = test.announceList
+
+		res, err := getTorrent(file.ID, "abc", "http://goat:8080/")
+		if err != nil {
+			t.Fatalf("[%02d] Failed to retrieve .torrent file: %s", i, err.Error())
+		}
+
+		if !bytes.Equal(res, []byte(test.out)) {
+			t.Fatalf("[%02d] Unexpected .torrent file:\n- %s\n- %s", i, string(res), test.out)
+		}
+	}
+
+	// Delete mock file and metainfo
+	if err := (data.TorrentRecord{InfoHash: meta.InfoHash}).Delete(); err != nil {
+		t.Fatalf("Failed to delete mock torrent: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestTrackerURL verifies that the tracker's base URL is configured, or derived from the request
+func TestTrackerURL(t *testing.T) {
+	log.Println("TestTrackerURL()")
+
+	r, err := http.NewRequest("GET", "http://localhost:8080/api/torrents/1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+
+	common.Static.Config.Torrent.AnnounceURL = ""
+	if u := trackerURL(r); u != "http://localhost:8080" {
+		t.Fatalf("Unexpected derived tracker URL: %s", u)
+	}
+
+	common.Static.Config.Torrent.AnnounceURL = "https://goat.example.com"
+	if u := trackerURL(r); u != "https://goat.example.com" {
+		t.Fatalf("Unexpected configured tracker URL: %s", u)
+	}
+	common.Static.Config.Torrent.AnnounceURL = ""
+}
@@ -0,0 +1,148 @@
+package goat
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// privateNets are the loopback and private address ranges, from which the ip parameter may be honoured
+// when IPParam is set to "private"
+var privateNets = parseNets([]string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+})
+
+// parseNets parses a list of CIDR subnets, skipping any which are invalid.  A bare IP address is treated
+// as a subnet containing only that address.
+func parseNets(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0)
+	for _, c := range cidrs {
+		// Convert bare addresses to single-address subnets
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			continue
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// inNets reports whether an IP address is contained by any of a list of subnets
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustedNets are the trusted proxy subnets, parsed from configuration once at startup by
+// loadTrustedProxies
+var trustedNets []*net.IPNet
+
+// loadTrustedProxies parses the configured trusted proxy subnets, logging them along with a warning if any
+// are invalid.  It must be called before any listener is started.
+func loadTrustedProxies() {
+	trusted := common.Static.Config.Proxy.Trusted
+	trustedNets = parseNets(trusted)
+
+	if len(trusted) > 0 {
+		log.Printf("Trusted proxies: %s", strings.Join(trusted, ", "))
+
+		if len(trustedNets) != len(trusted) {
+			log.Println("proxy: ignoring invalid trusted proxy subnets")
+		}
+	}
+}
+
+// ipParamAllowed reports whether the ip parameter sent by a client at the specified address may be
+// honoured.  IPParam may be "ignore", to never honour it, or "private", to only honour it for clients on
+// loopback and private networks, such as a seedbox on the tracker's own network.  Otherwise, it is always
+// honoured.
+func ipParamAllowed(remote net.IP) bool {
+	switch common.Static.Config.Proxy.IPParam {
+	case "ignore":
+		return false
+	case "private":
+		return inNets(remote, privateNets)
+	}
+
+	return true
+}
+
+// httpClientIP determines the IP address of the client which made an HTTP request.  Forwarding headers
+// are only honoured when the request arrives from a trusted proxy, and the ip parameter only as configured.
+func httpClientIP(r *http.Request, query url.Values) string {
+	// Use connection address, stripping port and any IPv6 brackets
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	// Requests from trusted proxies carry the client's address in a header
+	if inNets(net.ParseIP(ip), trustedNets) {
+		if forwarded := forwardedIP(r.Header, trustedNets); forwarded != "" {
+			ip = forwarded
+		}
+	}
+
+	// Check if a valid IP was set by the client, and if it may be used
+	if param := query.Get("ip"); net.ParseIP(param) != nil && ipParamAllowed(net.ParseIP(ip)) {
+		return param
+	}
+
+	return ip
+}
+
+// forwardedIP retrieves the client address from the X-Forwarded-For or X-Real-IP headers set by a trusted
+// proxy.  X-Forwarded-For is read from right to left, skipping any trusted proxies, so that a client
+// cannot spoof its address by sending the header itself.  An empty string is returned if no valid
+// address is found.
+func forwardedIP(header http.Header, trusted []*net.IPNet) string {
+	// Proxies may append to the header, or add their own header
+	if xff := strings.Join(header["X-Forwarded-For"], ","); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+
+			// Closest address which is not a trusted proxy is the client
+			if i == 0 || !inNets(ip, trusted) {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+package goat
+
+import (
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestHTTPClientIP verifies that client IP addresses are detected properly behind trusted proxies, and that
+// the ip parameter is honoured as configured
+func TestHTTPClientIP(t *testing.T) {
+	log.Println("TestHTTPClientIP()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Proxy.Trusted = []string{"10.0.0.0/8", "2001:db8::1", "invalid"}
+	loadTrustedProxies()
+
+	var tests = []struct {
+		ipParam string
+		remote  string
+		xff     string
+		xRealIP string
+		param   string
+		result  string
+	}{
+		// Direct clients use their connection address, and may not spoof headers
+		{"allow", "203.0.113.1:6881", "", "", "", "203.0.113.1"},
+		{"allow", "[2001:db8::2]:6881", "", "", "", "2001:db8::2"},
+		{"allow", "203.0.113.1:6881", "198.51.100.1", "198.51.100.2", "", "203.0.113.1"},
+		// Trusted proxies forward the client's address
+		{"allow", "10.0.0.1:80", "198.51.100.1", "", "", "198.51.100.1"},
+		{"allow", "[2001:db8::1]:80", "", "198.51.100.2", "", "198.51.100.2"},
+		{"allow", "10.0.0.1:80", "", "", "", "10.0.0.1"},
+		// Addresses prepended by the client are skipped, as are trusted proxies in the chain
+		{"allow", "10.0.0.1:80", "192.0.2.1, 198.51.100.1, 10.0.0.2", "", "", "198.51.100.1"},
+		{"allow", "10.0.0.1:80", "10.0.0.3, 10.0.0.2", "", "", "10.0.0.3"},
+		// Invalid forwarded addresses fall back to the connection address
+		{"allow", "10.0.0.1:80", "bogus, 198.51.100.1", "", "", "198.51.100.1"},
+		{"allow", "10.0.0.1:80", "198.51.100.1, bogus", "", "", "10.0.0.1"},
+		// ip parameter is honoured, if valid and allowed
+		{"allow", "203.0.113.1:6881", "", "", "192.0.2.5", "192.0.2.5"},
+		{"allow", "203.0.113.1:6881", "", "", "bogus", "203.0.113.1"},
+		{"", "203.0.113.1:6881", "", "", "192.0.2.5", "192.0.2.5"},
+		{"ignore", "127.0.0.1:6881", "", "", "192.0.2.5", "127.0.0.1"},
+		{"private", "203.0.113.1:6881", "", "", "192.0.2.5", "203.0.113.1"},
+		{"private", "192.168.1.5:6881", "", "", "192.0.2.5", "192.0.2.5"},
+		{"private", "10.0.0.1:80", "198.51.100.1", "", "192.0.2.5", "198.51.100.1"},
+	}
+
+	for i, test := range tests {
+		common.Static.Config.Proxy.IPParam = test.ipParam
+
+		r, err := http.NewRequest("GET", "/announce?ip="+test.param, nil)
+		if err != nil {
+			t.Fatalf("[%02d] Failed to create request: %s", i, err.Error())
+		}
+		r.RemoteAddr = test.remote
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if test.xRealIP != "" {
+			r.Header.Set("X-Real-IP", test.xRealIP)
+		}
+
+		if ip := httpClientIP(r, r.URL.Query()); ip != test.result {
+			t.Fatalf("[%02d] Client IP, expected %s, got %s", i, test.result, ip)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package goat
+
+import (
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
+)
+
+// clientVerdict applies the client rules to a client's raw peer_id, returning a data.ClientVerdict result.
+// Requests which carry no peer_id, such as scrapes, match no rules.  Rules are cached briefly, so that every
+// announce need not load them.
+func clientVerdict(ctx context.Context, peerID string) (int, error) {
+	if peerID == "" {
+		return data.ClientUnknown, nil
+	}
+
+	rules, err := new(data.ClientRuleRecordRepository).CachedContext(ctx)
+	if err != nil {
+		return data.ClientUnknown, err
+	}
+
+	return data.ClientVerdict(rules, peerID), nil
+}
@@ -0,0 +1,94 @@
+package goat
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// Command runs a one-off administrative command instead of the tracker, returning the process exit code.
+// Available commands are:
+//   - export [file]: write all tracker state to a state archive (default: standard output)
+//   - import [file]: read tracker state from a state archive (default: standard input)
+// Because the database backend is chosen at build time, moving between backends is done by exporting
+// with a goat built for one backend, and importing with a goat built for the other.
+func Command(args []string) int {
+	// Commands report only to standard error, so standard output may carry an archive
+	log.SetOutput(os.Stderr)
+
+	if len(args) == 0 || len(args) > 2 || (args[0] != "export" && args[0] != "import") {
+		log.Println("usage: goat [flags] export|import [file]")
+		return 2
+	}
+
+	// Load configuration
+	config, err := common.LoadConfig()
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	common.Static.Config = config
+
+	// Attempt database connection, and bring schema up to date
+	if !data.DBPing() {
+		log.Printf("cannot connect to database %s", data.DBName())
+		return 1
+	}
+	defer data.DBClose()
+
+	if _, err := data.Migrate(); err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+
+	// Use file if specified, or standard input and output
+	path := "-"
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	if args[0] == "export" {
+		var w io.WriteCloser = os.Stdout
+		if path != "-" {
+			if w, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600); err != nil {
+				log.Println(err.Error())
+				return 1
+			}
+		}
+
+		count, err := data.ExportState(w)
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+
+		if err := w.Close(); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+
+		log.Printf("export: wrote %d records from %s", count, data.DBName())
+		return 0
+	}
+
+	var r io.ReadCloser = os.Stdin
+	if path != "-" {
+		if r, err = os.Open(path); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+	}
+	defer r.Close()
+
+	count, err := data.ImportState(r)
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+
+	log.Printf("import: imported %d records into %s", count, data.DBName())
+	return 0
+}
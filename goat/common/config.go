@@ -14,10 +14,12 @@ var ConfigPath *string
 
 // dbConf represents database configuration
 type dbConf struct {
-	Host     string
-	Database string
-	Username string
-	Password string
+	Host         string
+	Database     string
+	Username     string
+	Password     string
+	MaxOpenConns int
+	MaxIdleConns int
 }
 
 // sslConf represents SSL configuration
@@ -35,18 +37,70 @@ type redisConf struct {
 	Password string
 }
 
+// retentionConf represents announce and scrape log retention configuration
+type retentionConf struct {
+	AnnounceLog int
+	ScrapeLog   int
+	Archive     string
+}
+
+// scrapeConf represents scrape configuration
+type scrapeConf struct {
+	Full        bool
+	Cache       int
+	MinInterval int
+}
+
+// peerConf represents peer list selection configuration
+type peerConf struct {
+	ExcludeRequester   bool
+	SeedersGetLeechers bool
+	Order              string
+	PreferSubnet       bool
+	SubnetIPv4         int
+	SubnetIPv6         int
+}
+
+// registrationConf represents torrent registration configuration
+type registrationConf struct {
+	Mode          string
+	OpenLimit     int
+	ApprovalLimit int
+}
+
+// proxyConf represents trusted reverse proxy configuration
+type proxyConf struct {
+	Trusted       []string
+	ProxyProtocol bool
+	IPParam       string
+}
+
+// torrentConf represents personalised .torrent file configuration
+type torrentConf struct {
+	AnnounceURL  string
+	AnnounceList []string
+}
+
 // Conf represents server configuration
 type Conf struct {
-	Port      int
-	Passkey   bool
-	Whitelist bool
-	Interval  int
-	HTTP      bool
-	API       bool
-	UDP       bool
-	SSL       sslConf
-	DB        dbConf
-	Redis     redisConf
+	Port           int
+	Passkey        bool
+	Whitelist      bool
+	Interval       int
+	RequestTimeout int
+	HTTP           bool
+	API            bool
+	UDP            bool
+	WebSocket      bool
+	SSL            sslConf
+	DB             dbConf
+	Redis          redisConf
+	Retention      retentionConf
+	Scrape         scrapeConf
+	Peers          peerConf
+	Proxy          proxyConf
+	Registration   registrationConf
+	Torrent        torrentConf
 }
 
 // LoadConfig loads configuration
@@ -11,6 +11,9 @@ var Static struct {
 	// Stats about HTTP server
 	HTTP TimedStats
 
+	// Stats about announce and scrape log writes
+	Logs LogStats
+
 	// Maintenance
 	Maintenance bool
 
@@ -31,3 +34,10 @@ type TimedStats struct {
 	Hour     int64 `json:"hour"`
 	Total    int64 `json:"total"`
 }
+
+// LogStats represents statistics about announce and scrape logs written to storage
+type LogStats struct {
+	Queued  int64 `json:"queued"`
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"`
+}
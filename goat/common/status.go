@@ -22,6 +22,7 @@ type ServerStatus struct {
 	API          TimedStats `json:"api"`
 	HTTP         TimedStats `json:"http"`
 	UDP          TimedStats `json:"udp"`
+	Logs         LogStats   `json:"logs"`
 }
 
 // GetServerStatus returns the tracker's current status in a ServerStatus struct
@@ -67,6 +68,13 @@ func GetServerStatus() (ServerStatus, error) {
 		atomic.LoadInt64(&Static.UDP.Total),
 	}
 
+	// Log writer status
+	logStatus := LogStats{
+		atomic.LoadInt64(&Static.Logs.Queued),
+		atomic.LoadInt64(&Static.Logs.Written),
+		atomic.LoadInt64(&Static.Logs.Dropped),
+	}
+
 	// Build status struct
 	status := ServerStatus{
 		os.Getpid(),
@@ -82,6 +90,7 @@ func GetServerStatus() (ServerStatus, error) {
 		apiStatus,
 		httpStatus,
 		udpStatus,
+		logStatus,
 	}
 
 	// Return status struct
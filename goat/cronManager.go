@@ -2,6 +2,8 @@ package goat
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +17,7 @@ func cronManager() {
 	// Run on startup
 	go cronAPIKeyReaper()
 	go cronPeerReaper()
+	go cronLogRetention()
 
 	// cronAPIKeyReaper - run once per hour
 	apiKeyReaper := time.NewTicker(1 * time.Hour)
@@ -22,6 +25,9 @@ func cronManager() {
 	// cronPeerReaper - run at regular announce interval
 	peerReaper := time.NewTicker(time.Duration(common.Static.Config.Interval) * time.Second)
 
+	// cronLogRetention - run once per hour
+	logRetention := time.NewTicker(1 * time.Hour)
+
 	// cronPrintCurrentStatus - run every 5 minutes
 	status := time.NewTicker(5 * time.Minute)
 
@@ -35,6 +41,8 @@ func cronManager() {
 			go cronAPIKeyReaper()
 		case <-peerReaper.C:
 			go cronPeerReaper()
+		case <-logRetention.C:
+			go cronLogRetention()
 		case <-status.C:
 			go cronPrintCurrentStatus()
 		}
@@ -141,6 +149,106 @@ func cronPeerReaper() {
 	log.Printf("cronPeerReaper: complete, reaped %d peers on %d files", total, len(files))
 }
 
+// logRetentionRunning is 1 while cronLogRetention is running, so that a run which outlasts its interval is
+// not joined by another, archiving the same logs twice
+var logRetentionRunning int32
+
+// cronLogRetention archives announce and scrape logs older than their configured retention, and
+// deletes them from the database
+func cronLogRetention() {
+	conf := common.Static.Config.Retention
+
+	// Retention disabled for all logs
+	if conf.AnnounceLog <= 0 && conf.ScrapeLog <= 0 {
+		return
+	}
+
+	// Only one run at a time
+	if !atomic.CompareAndSwapInt32(&logRetentionRunning, 0, 1) {
+		log.Println("cronLogRetention: previous run still in progress, skipping")
+		return
+	}
+	defer atomic.StoreInt32(&logRetentionRunning, 0)
+
+	log.Println("cronLogRetention: starting")
+
+	// Ensure archive directory exists
+	if err := os.MkdirAll(conf.Archive, 0775); err != nil {
+		log.Println(err.Error())
+		log.Println("cronLogRetention: failed to create archive directory:", conf.Archive)
+		return
+	}
+
+	// Sum of logs archived
+	var total int64
+	atomic.StoreInt64(&total, 0)
+
+	// Retention jobs for each log table
+	jobs := []struct {
+		table   string
+		days    int
+		archive func(int64, *data.LogArchive) (int, error)
+	}{
+		{"announce_log", conf.AnnounceLog, data.ArchiveAnnounceLogs},
+		{"scrape_log", conf.ScrapeLog, data.ArchiveScrapeLogs},
+	}
+
+	// WaitGroup to wait for all tables to finish being archived
+	var wg sync.WaitGroup
+	wg.Add(len(jobs))
+
+	// Iterate all tables in parallel
+	now := time.Now()
+	for _, j := range jobs {
+		go func(table string, days int, archive func(int64, *data.LogArchive) (int, error), count *int64, wg *sync.WaitGroup) {
+			// Inform WaitGroup this goroutine is done
+			defer wg.Done()
+
+			// Retention disabled for this table
+			if days <= 0 {
+				return
+			}
+
+			// Open a new archive file for this run
+			path := filepath.Join(conf.Archive, table+"-"+now.Format("20060102-150405")+".ndjson.gz")
+			a, err := data.NewLogArchive(path)
+			if err != nil {
+				log.Println(err.Error())
+				log.Println("cronLogRetention: failed to create archive:", path)
+				return
+			}
+
+			// Archive and delete logs older than retention
+			archived, err := archive(now.AddDate(0, 0, -days).Unix(), a)
+			if err != nil {
+				log.Println(err.Error())
+				log.Println("cronLogRetention: failed to archive logs from table:", table)
+			}
+
+			if err := a.Close(); err != nil {
+				log.Println(err.Error())
+			}
+
+			// Increment archive counter
+			atomic.AddInt64(count, int64(archived))
+
+			// Remove archives with nothing in them
+			if archived == 0 {
+				if err := os.Remove(path); err != nil {
+					log.Println(err.Error())
+				}
+				return
+			}
+
+			log.Printf("cronLogRetention: archived %d logs from table %s to %s", archived, table, path)
+		}(j.table, j.days, j.archive, &total, &wg)
+	}
+
+	// Wait for all goroutines to finish
+	wg.Wait()
+	log.Printf("cronLogRetention: complete, archived %d logs from %d tables", total, len(jobs))
+}
+
 // cronPrintCurrentStatus logs the regular status check banner
 func cronPrintCurrentStatus() {
 	// Grab server status
@@ -12,6 +12,7 @@ import (
 type AnnounceLog struct {
 	ID         int
 	InfoHash   string `db:"info_hash"`
+	PeerID     string `db:"peer_id"`
 	Passkey    string
 	Key        string
 	IP         string
@@ -101,6 +102,14 @@ func (a *AnnounceLog) FromValues(query url.Values) error {
 		return errors.New("info_hash must be exactly 20 characters")
 	}
 
+	// peer_id (20 characters, 40 characters after hex encode), if present
+	if query.Get("peer_id") != "" {
+		a.PeerID = hex.EncodeToString([]byte(query.Get("peer_id")))
+		if len(a.PeerID) != 40 {
+			return errors.New("peer_id must be exactly 20 characters")
+		}
+	}
+
 	// passkey
 	a.Passkey = query.Get("passkey")
 
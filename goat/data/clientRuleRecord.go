@@ -0,0 +1,268 @@
+package data
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// clientVersionAlphabet orders the characters used in peer_id version numbers, from lowest to highest.  Azureus-style
+// peer_ids (-qB4500-) use only digits, while Shadow-style peer_ids (S58B-----) use the full alphabet.
+const clientVersionAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz."
+
+// clientVersionLength is the maximum length of a peer_id version number
+const clientVersionLength = 5
+
+// clientRuleTTL is how long client rules returned by CachedContext are reused before being loaded again.  Rules
+// saved or deleted by this process take effect at once, while rules changed elsewhere take effect within this time.
+const clientRuleTTL = 10 * time.Second
+
+// clientRuleCache holds the most recently loaded client rules, and a generation which is advanced each time the
+// rules change, so that rules loaded before a change are never cached after it
+var clientRuleCache struct {
+	sync.Mutex
+	rules      []ClientRuleRecord
+	expires    time.Time
+	generation int
+}
+
+// invalidateClientRules discards the cached client rules, so the next announce loads them from storage
+func invalidateClientRules() {
+	clientRuleCache.Lock()
+	defer clientRuleCache.Unlock()
+
+	clientRuleCache.rules = nil
+	clientRuleCache.expires = time.Time{}
+	clientRuleCache.generation++
+}
+
+// Client rule verdicts, returned by ClientVerdict
+const (
+	// ClientUnknown is returned when no rule matches a client
+	ClientUnknown = iota
+	// ClientWhitelisted is returned when a whitelist rule matches a client
+	ClientWhitelisted
+	// ClientBlacklisted is returned when a blacklist rule matches a client
+	ClientBlacklisted
+)
+
+// ClientRuleRecord represents a rule which whitelists or blacklists torrent clients by their peer_id.  Clients
+// match a rule when their peer_id begins with Prefix, and the version number which follows it, up to the next
+// "-", lies between MinVersion and MaxVersion, if set.
+type ClientRuleRecord struct {
+	ID          int    `json:"id"`
+	Prefix      string `json:"prefix"`
+	MinVersion  string `db:"min_version" json:"minVersion"`
+	MaxVersion  string `db:"max_version" json:"maxVersion"`
+	Blacklist   bool   `json:"blacklist"`
+	Description string `json:"description"`
+}
+
+// ClientRuleRecordRepository is used to contain methods to load multiple ClientRuleRecord structs
+type ClientRuleRecordRepository struct {
+}
+
+// Match reports whether a raw peer_id matches this rule
+func (c ClientRuleRecord) Match(peerID string) bool {
+	if c.Prefix == "" || !strings.HasPrefix(peerID, c.Prefix) {
+		return false
+	}
+
+	// Version number follows the prefix, and ends at the next "-"
+	version := peerID[len(c.Prefix):]
+	if len(version) > clientVersionLength {
+		version = version[:clientVersionLength]
+	}
+	if i := strings.Index(version, "-"); i != -1 {
+		version = version[:i]
+	}
+
+	if c.MinVersion != "" && compareClientVersions(version, c.MinVersion) < 0 {
+		return false
+	}
+	if c.MaxVersion != "" && compareClientVersions(version, c.MaxVersion) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// compareClientVersions compares two peer_id version numbers character by character, returning -1, 0, or 1 if a is
+// lower than, equal to, or higher than b.  A missing character is lower than any other.
+func compareClientVersions(a string, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		va, vb := -1, -1
+		if i < len(a) {
+			va = strings.IndexByte(clientVersionAlphabet, a[i])
+		}
+		if i < len(b) {
+			vb = strings.IndexByte(clientVersionAlphabet, b[i])
+		}
+
+		if va < vb {
+			return -1
+		}
+		if va > vb {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// ClientVerdict applies a list of client rules to a raw peer_id.  Blacklist rules take precedence over whitelist
+// rules, so that a broken version range can be blocked within an otherwise whitelisted client.
+func ClientVerdict(rules []ClientRuleRecord, peerID string) int {
+	verdict := ClientUnknown
+	for _, r := range rules {
+		if !r.Match(peerID) {
+			continue
+		}
+
+		if r.Blacklist {
+			return ClientBlacklisted
+		}
+
+		verdict = ClientWhitelisted
+	}
+
+	return verdict
+}
+
+// Delete ClientRuleRecord from storage
+func (c ClientRuleRecord) Delete() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete ClientRuleRecord
+	if err = db.DeleteClientRuleRecord(c.ID, "id"); err != nil {
+		return err
+	}
+	invalidateClientRules()
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Load ClientRuleRecord from storage
+func (c ClientRuleRecord) Load(id interface{}, col string) (ClientRuleRecord, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return ClientRuleRecord{}, err
+	}
+
+	// Load ClientRuleRecord using specified column
+	if c, err = db.LoadClientRuleRecord(id, col); err != nil {
+		return ClientRuleRecord{}, err
+	}
+
+	if err := db.Close(); err != nil {
+		return ClientRuleRecord{}, err
+	}
+
+	return c, nil
+}
+
+// Save ClientRuleRecord to storage, replacing any existing rule with the same prefix and version range
+func (c ClientRuleRecord) Save() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save ClientRuleRecord
+	if err := db.SaveClientRuleRecord(c); err != nil {
+		return err
+	}
+	invalidateClientRules()
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// All loads all ClientRuleRecord structs from storage
+func (c ClientRuleRecordRepository) All() ([]ClientRuleRecord, error) {
+	return c.all(context.Background())
+}
+
+// all implements All, binding ctx to the queries of backends which can cancel them
+func (c ClientRuleRecordRepository) all(ctx context.Context) ([]ClientRuleRecord, error) {
+	rules := make([]ClientRuleRecord, 0)
+
+	// Open database connection
+	db, err := dbConnect(ctx)
+	if err != nil {
+		return rules, err
+	}
+
+	// Retrieve all rules
+	rules, err = db.GetAllClientRuleRecords()
+	if err != nil {
+		return rules, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// AllContext loads all ClientRuleRecord structs from storage, giving up once ctx is done
+func (c ClientRuleRecordRepository) AllContext(ctx context.Context) ([]ClientRuleRecord, error) {
+	var rules []ClientRuleRecord
+	if err := withContext(ctx, func() (err error) {
+		rules, err = c.all(ctx)
+		return
+	}); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// CachedContext returns all ClientRuleRecord structs, loading them from storage only if the cached copy has expired,
+// and giving up once ctx is done
+func (c ClientRuleRecordRepository) CachedContext(ctx context.Context) ([]ClientRuleRecord, error) {
+	clientRuleCache.Lock()
+	if time.Now().Before(clientRuleCache.expires) {
+		rules := clientRuleCache.rules
+		clientRuleCache.Unlock()
+
+		return rules, nil
+	}
+	generation := clientRuleCache.generation
+	clientRuleCache.Unlock()
+
+	// Load rules without holding the lock, so a slow load does not hold up announces which could give up sooner
+	rules, err := c.AllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only cache the rules if they did not change while loading
+	clientRuleCache.Lock()
+	if clientRuleCache.generation == generation {
+		clientRuleCache.rules = rules
+		clientRuleCache.expires = time.Now().Add(clientRuleTTL)
+	}
+	clientRuleCache.Unlock()
+
+	return rules, nil
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+
+	"code.google.com/p/go.net/context"
+)
+
+// TestClientRuleRecord verifies that ClientRuleRecord save, load, and delete work properly
+func TestClientRuleRecord(t *testing.T) {
+	log.Println("TestClientRuleRecord()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate mock ClientRuleRecord
+	rule := ClientRuleRecord{
+		Prefix:      "-GX",
+		MinVersion:  "1000",
+		Description: "goat_test",
+	}
+
+	// verdict applies the cached client rules, which must reflect each change made using a ClientRuleRecord
+	verdict := func(expected int) {
+		rules, err := new(ClientRuleRecordRepository).CachedContext(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to load cached client rules: %s", err.Error())
+		}
+
+		if v := ClientVerdict(rules, "-GX1000-abcdefghijkl"); v != expected {
+			t.Fatalf("Cached client rules, expected verdict %d, got %d", expected, v)
+		}
+	}
+	verdict(ClientUnknown)
+
+	// Save mock rule, and save it again as a blacklist rule, which replaces it
+	if err := rule.Save(); err != nil {
+		t.Fatalf("Failed to save mock client rule: %s", err.Error())
+	}
+	verdict(ClientWhitelisted)
+
+	rule.Blacklist = true
+	if err := rule.Save(); err != nil {
+		t.Fatalf("Failed to save mock client rule: %s", err.Error())
+	}
+	verdict(ClientBlacklisted)
+
+	// Load mock rule to fetch ID
+	rule, err = rule.Load(rule.Prefix, "prefix")
+	if rule == (ClientRuleRecord{}) || err != nil || !rule.Blacklist {
+		t.Fatalf("Failed to load mock client rule: %v", rule)
+	}
+
+	rules, err := new(ClientRuleRecordRepository).All()
+	if err != nil {
+		t.Fatalf("Failed to load all client rules: %s", err.Error())
+	}
+	count := 0
+	for _, r := range rules {
+		if r.Prefix == rule.Prefix {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("Client rules, expected 1 mock rule, got %d", count)
+	}
+
+	// Delete mock rule
+	if err := rule.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock client rule: %s", err.Error())
+	}
+	verdict(ClientUnknown)
+}
+
+// TestClientVerdict verifies that client rules match peer_id prefixes and version ranges properly
+func TestClientVerdict(t *testing.T) {
+	log.Println("TestClientVerdict()")
+
+	rules := []ClientRuleRecord{
+		// qBittorrent 4.5.0 through 4.6.x, except 4.5.2
+		{Prefix: "-qB", MinVersion: "4500", MaxVersion: "46ZZ"},
+		{Prefix: "-qB452", Blacklist: true},
+		// Any Transmission
+		{Prefix: "-TR"},
+		// Shadow-style BitTornado 5.8.11 and newer
+		{Prefix: "T", MinVersion: "58B"},
+		// Xunlei, always
+		{Prefix: "-XL", Blacklist: true},
+	}
+
+	var tests = []struct {
+		peerID  string
+		verdict int
+	}{
+		{"-qB4500-abcdefghijkl", ClientWhitelisted},
+		{"-qB4630-abcdefghijkl", ClientWhitelisted},
+		{"-qB4520-abcdefghijkl", ClientBlacklisted},
+		{"-qB4430-abcdefghijkl", ClientUnknown},
+		{"-qB4700-abcdefghijkl", ClientUnknown},
+		{"-TR2940-abcdefghijkl", ClientWhitelisted},
+		{"T58B-----abcdefghijk", ClientWhitelisted},
+		{"T6------abcdefghijkl", ClientWhitelisted},
+		{"T58A-----abcdefghijk", ClientUnknown},
+		{"T57-----abcdefghijkl", ClientUnknown},
+		{"-XL0012-abcdefghijkl", ClientBlacklisted},
+		{"-UT3550-abcdefghijkl", ClientUnknown},
+		{"", ClientUnknown},
+	}
+
+	for i, test := range tests {
+		if verdict := ClientVerdict(rules, test.peerID); verdict != test.verdict {
+			t.Fatalf("[%02d] Verdict for %q, expected %d, got %d", i, test.peerID, test.verdict, verdict)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"errors"
+)
+
+// ErrUnknownColumn is returned when a record is loaded or deleted using a column its table does not have
+var ErrUnknownColumn = errors.New("data: unknown column")
+
+// dbColumns is the whitelist of columns, by table, which records may be loaded or deleted by
+// NOTE: some backends place the column name directly into a query, so every handle returned by
+// DBConnect is wrapped in a columnChecker, which refuses any column not on this list before the
+// backend sees it
+var dbColumns = map[string][]string{
+	"announce_log": {"id", "info_hash", "peer_id", "passkey", "key", "ip", "port", "udp", "uploaded", "downloaded", "left", "event", "client", "time"},
+	"api_keys":     {"id", "user_id", "pubkey", "secret", "expire"},
+	"client_rules": {"id", "prefix"},
+	"files":        {"id", "info_hash", "verified", "create_time", "update_time"},
+	"files_users":  {"file_id", "user_id", "ip", "active", "completed", "announced", "uploaded", "downloaded", "left", "time"},
+	"peers":        {"info_hash", "peer_id", "ipv6", "user_id", "ip", "port", "left", "time"},
+	"scrape_log":   {"id", "info_hash", "passkey", "ip", "time"},
+	"sessions":     {"info_hash", "peer_id", "key", "user_id", "uploaded", "downloaded", "time"},
+	"torrents":     {"info_hash"},
+	"users":        {"id", "username", "password", "passkey", "torrent_limit"},
+	"whitelist":    {"id", "client", "approved"},
+}
+
+// dbColumn verifies that a column is on the whitelist for a table, so it is safe to use in a query
+func dbColumn(table string, col string) error {
+	for _, c := range dbColumns[table] {
+		if c == col {
+			return nil
+		}
+	}
+
+	return ErrUnknownColumn
+}
+
+// columnChecker wraps a dbModel, checking every column passed to it against the whitelist, so that no
+// caller can reach a backend with an arbitrary column
+type columnChecker struct {
+	dbModel
+}
+
+// DeleteAnnounceLog deletes an AnnounceLog, if col is on the whitelist
+func (c columnChecker) DeleteAnnounceLog(id interface{}, col string) error {
+	if err := dbColumn("announce_log", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteAnnounceLog(id, col)
+}
+
+// LoadAnnounceLog loads an AnnounceLog, if col is on the whitelist
+func (c columnChecker) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error) {
+	if err := dbColumn("announce_log", col); err != nil {
+		return AnnounceLog{}, err
+	}
+
+	return c.dbModel.LoadAnnounceLog(id, col)
+}
+
+// DeleteAPIKey deletes an APIKey, if col is on the whitelist
+func (c columnChecker) DeleteAPIKey(id interface{}, col string) error {
+	if err := dbColumn("api_keys", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteAPIKey(id, col)
+}
+
+// LoadAPIKey loads an APIKey, if col is on the whitelist
+func (c columnChecker) LoadAPIKey(id interface{}, col string) (APIKey, error) {
+	if err := dbColumn("api_keys", col); err != nil {
+		return APIKey{}, err
+	}
+
+	return c.dbModel.LoadAPIKey(id, col)
+}
+
+// DeleteClientRuleRecord deletes a ClientRuleRecord, if col is on the whitelist
+func (c columnChecker) DeleteClientRuleRecord(id interface{}, col string) error {
+	if err := dbColumn("client_rules", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteClientRuleRecord(id, col)
+}
+
+// LoadClientRuleRecord loads a ClientRuleRecord, if col is on the whitelist
+func (c columnChecker) LoadClientRuleRecord(id interface{}, col string) (ClientRuleRecord, error) {
+	if err := dbColumn("client_rules", col); err != nil {
+		return ClientRuleRecord{}, err
+	}
+
+	return c.dbModel.LoadClientRuleRecord(id, col)
+}
+
+// DeleteFileRecord deletes a FileRecord, if col is on the whitelist
+func (c columnChecker) DeleteFileRecord(id interface{}, col string) error {
+	if err := dbColumn("files", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteFileRecord(id, col)
+}
+
+// LoadFileRecord loads a FileRecord, if col is on the whitelist
+func (c columnChecker) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
+	if err := dbColumn("files", col); err != nil {
+		return FileRecord{}, err
+	}
+
+	return c.dbModel.LoadFileRecord(id, col)
+}
+
+// LoadFileUserRepository loads FileUserRecords, if col is on the whitelist
+func (c columnChecker) LoadFileUserRepository(id interface{}, col string) ([]FileUserRecord, error) {
+	if err := dbColumn("files_users", col); err != nil {
+		return []FileUserRecord{}, err
+	}
+
+	return c.dbModel.LoadFileUserRepository(id, col)
+}
+
+// DeleteScrapeLog deletes a ScrapeLog, if col is on the whitelist
+func (c columnChecker) DeleteScrapeLog(id interface{}, col string) error {
+	if err := dbColumn("scrape_log", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteScrapeLog(id, col)
+}
+
+// LoadScrapeLog loads a ScrapeLog, if col is on the whitelist
+func (c columnChecker) LoadScrapeLog(id interface{}, col string) (ScrapeLog, error) {
+	if err := dbColumn("scrape_log", col); err != nil {
+		return ScrapeLog{}, err
+	}
+
+	return c.dbModel.LoadScrapeLog(id, col)
+}
+
+// DeleteTorrentRecord deletes a TorrentRecord, if col is on the whitelist
+func (c columnChecker) DeleteTorrentRecord(id interface{}, col string) error {
+	if err := dbColumn("torrents", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteTorrentRecord(id, col)
+}
+
+// LoadTorrentRecord loads a TorrentRecord, if col is on the whitelist
+func (c columnChecker) LoadTorrentRecord(id interface{}, col string) (TorrentRecord, error) {
+	if err := dbColumn("torrents", col); err != nil {
+		return TorrentRecord{}, err
+	}
+
+	return c.dbModel.LoadTorrentRecord(id, col)
+}
+
+// DeleteUserRecord deletes a UserRecord, if col is on the whitelist
+func (c columnChecker) DeleteUserRecord(id interface{}, col string) error {
+	if err := dbColumn("users", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteUserRecord(id, col)
+}
+
+// LoadUserRecord loads a UserRecord, if col is on the whitelist
+func (c columnChecker) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
+	if err := dbColumn("users", col); err != nil {
+		return UserRecord{}, err
+	}
+
+	return c.dbModel.LoadUserRecord(id, col)
+}
+
+// DeleteWhitelistRecord deletes a WhitelistRecord, if col is on the whitelist
+func (c columnChecker) DeleteWhitelistRecord(id interface{}, col string) error {
+	if err := dbColumn("whitelist", col); err != nil {
+		return err
+	}
+
+	return c.dbModel.DeleteWhitelistRecord(id, col)
+}
+
+// LoadWhitelistRecord loads a WhitelistRecord, if col is on the whitelist
+func (c columnChecker) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord, error) {
+	if err := dbColumn("whitelist", col); err != nil {
+		return WhitelistRecord{}, err
+	}
+
+	return c.dbModel.LoadWhitelistRecord(id, col)
+}
@@ -0,0 +1,730 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestDBConformance runs the dbModel conformance suite against the database backend selected by build tags
+func TestDBConformance(t *testing.T) {
+	log.Println("TestDBConformance()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	defer db.Close()
+
+	dbConformance(t, db, DBName())
+}
+
+// dbConformance verifies that a dbModel implementation behaves the way goat expects of a database backend.
+// Any new backend should be run against this suite, by passing it a connection to that backend.
+func dbConformance(t *testing.T, db dbModel, name string) {
+	// Generate unique values for this run, so existing data is not disturbed
+	now := time.Now().UnixNano()
+	c := conformance{
+		t:    t,
+		db:   db,
+		name: name,
+		hash: fmt.Sprintf("%040x", now),
+		tag:  fmt.Sprintf("c%d", now%1000000000000000),
+	}
+
+	c.announceLog()
+	c.apiKey()
+	c.clientRuleRecord()
+	c.fileRecord()
+	c.fileUserRecord()
+	c.scrapeLog()
+	c.sessionRecord()
+	c.torrentRecord()
+	c.userRecord()
+	c.whitelistRecord()
+	c.counts()
+	c.peerList()
+	c.reaping()
+	c.apiKeyExpiry()
+}
+
+// conformance holds the state of a single dbModel conformance run
+type conformance struct {
+	t    *testing.T
+	db   dbModel
+	name string
+
+	// hash is a unique info hash, and tag is a unique short string, for this run
+	hash string
+	tag  string
+}
+
+// fatalf reports a conformance failure, identifying the backend which failed
+func (c conformance) fatalf(format string, args ...interface{}) {
+	c.t.Fatalf("%s: "+format, append([]interface{}{c.name}, args...)...)
+}
+
+// check fails the conformance run if err is not nil
+func (c conformance) check(err error, action string) {
+	if err != nil {
+		c.fatalf("failed to %s: %s", action, err.Error())
+	}
+}
+
+// file saves and returns a verified FileRecord with a unique info hash
+func (c conformance) file(suffix string) FileRecord {
+	infoHash := c.hash[:40-len(suffix)] + suffix
+	c.check(c.db.SaveFileRecord(FileRecord{InfoHash: infoHash, Verified: true}), "save file")
+
+	file, err := c.db.LoadFileRecord(infoHash, "info_hash")
+	c.check(err, "load file")
+	if file.ID == 0 {
+		c.fatalf("saved file %s could not be loaded", infoHash)
+	}
+
+	return file
+}
+
+// deleteAnnounceLogs deletes all AnnounceLogs for an info hash, one at a time by ID
+func (c conformance) deleteAnnounceLogs(infoHash string) {
+	for {
+		a, err := c.db.LoadAnnounceLog(infoHash, "info_hash")
+		if err != nil || a.ID == 0 {
+			return
+		}
+
+		c.check(c.db.DeleteAnnounceLog(a.ID, "id"), "delete announce log")
+	}
+}
+
+// announceLog verifies AnnounceLog create, load, and delete
+func (c conformance) announceLog() {
+	a := AnnounceLog{
+		InfoHash:   c.hash,
+		Passkey:    c.hash,
+		Key:        "conform",
+		IP:         "10.0.0.1",
+		Port:       6881,
+		UDP:        true,
+		Uploaded:   1024,
+		Downloaded: 2048,
+		Left:       4096,
+		Event:      "started",
+		Client:     "conformance",
+	}
+	c.check(c.db.SaveAnnounceLog(a), "save announce log")
+
+	a2, err := c.db.LoadAnnounceLog(c.hash, "info_hash")
+	c.check(err, "load announce log")
+	if a2.ID == 0 || a2.Time == 0 {
+		c.fatalf("announce log not assigned an ID and time: %v", a2)
+	}
+
+	a.ID, a.Time = a2.ID, a2.Time
+	if a2 != a {
+		c.fatalf("announce log does not match: %v != %v", a2, a)
+	}
+
+	c.check(c.db.DeleteAnnounceLog(a2.ID, "id"), "delete announce log")
+	if a2, _ = c.db.LoadAnnounceLog(c.hash, "info_hash"); a2 != (AnnounceLog{}) {
+		c.fatalf("announce log not deleted: %v", a2)
+	}
+}
+
+// apiKey verifies APIKey create, load, update, and delete
+func (c conformance) apiKey() {
+	key := APIKey{UserID: 1, Pubkey: c.hash, Secret: c.hash[1:] + "0", Expire: 1000}
+	c.check(c.db.SaveAPIKey(key), "save API key")
+
+	key2, err := c.db.LoadAPIKey(c.hash, "pubkey")
+	c.check(err, "load API key")
+	key.ID = key2.ID
+	if key2.ID == 0 || key2 != key {
+		c.fatalf("API key does not match: %v != %v", key2, key)
+	}
+
+	// Saving an existing key updates its expiration
+	key.Expire = 2000
+	c.check(c.db.SaveAPIKey(key), "update API key")
+	if key2, _ = c.db.LoadAPIKey(key.ID, "id"); key2.Expire != 2000 {
+		c.fatalf("API key expiration not updated: %d", key2.Expire)
+	}
+
+	c.check(c.db.DeleteAPIKey(c.hash, "pubkey"), "delete API key")
+	if key2, _ = c.db.LoadAPIKey(c.hash, "pubkey"); key2 != (APIKey{}) {
+		c.fatalf("API key not deleted: %v", key2)
+	}
+}
+
+// fileRecord verifies FileRecord create, load, update, list, and delete
+func (c conformance) fileRecord() {
+	file := c.file("f")
+	if !file.Verified || file.CreateTime == 0 || file.UpdateTime == 0 {
+		c.fatalf("file not saved correctly: %v", file)
+	}
+
+	// Saving an existing file updates it in place
+	file.Verified = false
+	c.check(c.db.SaveFileRecord(file), "update file")
+	file2, err := c.db.LoadFileRecord(file.ID, "id")
+	c.check(err, "load file")
+	if file2.ID != file.ID || file2.Verified {
+		c.fatalf("file not updated: %v", file2)
+	}
+
+	// File appears in list of all files
+	files, err := c.db.GetAllFileRecords()
+	c.check(err, "list files")
+	found := false
+	for _, f := range files {
+		found = found || f.ID == file.ID
+	}
+	if !found {
+		c.fatalf("file %d missing from list of all files", file.ID)
+	}
+
+	c.check(c.db.DeleteFileRecord(file.InfoHash, "info_hash"), "delete file")
+	if file2, _ = c.db.LoadFileRecord(file.ID, "id"); file2 != (FileRecord{}) {
+		c.fatalf("file not deleted: %v", file2)
+	}
+}
+
+// fileUserRecord verifies FileUserRecord create, load, update, and delete
+func (c conformance) fileUserRecord() {
+	file := c.file("fu")
+	defer c.db.DeleteFileRecord(file.ID, "id")
+
+	fileUser := FileUserRecord{
+		FileID:     file.ID,
+		UserID:     1,
+		IP:         "10.0.0.2",
+		Active:     true,
+		Announced:  1,
+		Uploaded:   1024,
+		Downloaded: 2048,
+		Left:       4096,
+	}
+	c.check(c.db.SaveFileUserRecord(fileUser), "save file user")
+
+	fileUser2, err := c.db.LoadFileUserRecord(file.ID, 1, "10.0.0.2")
+	c.check(err, "load file user")
+	if fileUser2.Time == 0 {
+		c.fatalf("file user not assigned a time: %v", fileUser2)
+	}
+
+	fileUser.Time = fileUser2.Time
+	if fileUser2 != fileUser {
+		c.fatalf("file user does not match: %v != %v", fileUser2, fileUser)
+	}
+
+	// Saving an existing relationship updates it in place
+	fileUser.Announced = 2
+	fileUser.Left = 0
+	fileUser.Completed = true
+	c.check(c.db.SaveFileUserRecord(fileUser), "update file user")
+
+	fileUsers, err := c.db.LoadFileUserRepository(file.ID, "file_id")
+	c.check(err, "load file user repository")
+	if len(fileUsers) != 1 || fileUsers[0].Announced != 2 || !fileUsers[0].Completed || fileUsers[0].Left != 0 {
+		c.fatalf("file user not updated: %v", fileUsers)
+	}
+
+	c.check(c.db.DeleteFileUserRecord(file.ID, 1, "10.0.0.2"), "delete file user")
+	if fileUser2, _ = c.db.LoadFileUserRecord(file.ID, 1, "10.0.0.2"); fileUser2 != (FileUserRecord{}) {
+		c.fatalf("file user not deleted: %v", fileUser2)
+	}
+}
+
+// scrapeLog verifies ScrapeLog create, load, and delete
+func (c conformance) scrapeLog() {
+	s := ScrapeLog{InfoHash: c.hash, Passkey: c.hash, IP: "10.0.0.3"}
+	c.check(c.db.SaveScrapeLog(s), "save scrape log")
+
+	s2, err := c.db.LoadScrapeLog(c.hash, "info_hash")
+	c.check(err, "load scrape log")
+	if s2.ID == 0 || s2.Time == 0 || s2.InfoHash != s.InfoHash || s2.Passkey != s.Passkey || s2.IP != s.IP {
+		c.fatalf("scrape log does not match: %v", s2)
+	}
+
+	c.check(c.db.DeleteScrapeLog(s2.ID, "id"), "delete scrape log")
+	if s2, _ = c.db.LoadScrapeLog(c.hash, "info_hash"); s2 != (ScrapeLog{}) {
+		c.fatalf("scrape log not deleted: %v", s2)
+	}
+}
+
+// sessionRecord verifies that announce sessions are keyed by info hash, peer_id, and announce key, that saving
+// a session replaces its totals, and that stale sessions are pruned
+func (c conformance) sessionRecord() {
+	peerID := strings.Repeat("ef", 20)
+	defer c.db.DeleteSessionRecord(c.hash, peerID, "a")
+	defer c.db.DeleteSessionRecord(c.hash, peerID, "b")
+
+	for _, s := range []SessionRecord{
+		{InfoHash: c.hash, PeerID: peerID, Key: "a", UserID: 1, Uploaded: 1024, Downloaded: 2048},
+		{InfoHash: c.hash, PeerID: peerID, Key: "a", UserID: 1, Uploaded: 4096, Downloaded: 8192},
+		{InfoHash: c.hash, PeerID: peerID, Key: "b", UserID: 2, Uploaded: 1},
+	} {
+		c.check(c.db.SaveSessionRecord(s), "save session")
+	}
+
+	s, err := c.db.LoadSessionRecord(c.hash, peerID, "a")
+	c.check(err, "load session")
+	if s.UserID != 1 || s.Uploaded != 4096 || s.Downloaded != 8192 || s.Time == 0 {
+		c.fatalf("session not updated: %v", s)
+	}
+
+	s, err = c.db.LoadSessionRecord(c.hash, peerID, "b")
+	c.check(err, "load session")
+	if s.UserID != 2 || s.Uploaded != 1 {
+		c.fatalf("session with different key does not match: %v", s)
+	}
+
+	// Missing sessions load as empty records
+	s, err = c.db.LoadSessionRecord(c.hash, peerID, "c")
+	c.check(err, "load missing session")
+	if s != (SessionRecord{}) {
+		c.fatalf("missing session not empty: %v", s)
+	}
+
+	c.check(c.db.DeleteSessionRecord(c.hash, peerID, "b"), "delete session")
+	if s, _ = c.db.LoadSessionRecord(c.hash, peerID, "b"); s != (SessionRecord{}) {
+		c.fatalf("session not deleted: %v", s)
+	}
+
+	// Sessions which announced recently survive, and stale sessions are pruned
+	for _, test := range []struct {
+		interval time.Duration
+		want     int
+	}{{time.Hour, 0}, {-time.Minute, 1}} {
+		count, err := c.db.DeleteStaleSessionRecords(c.hash, test.interval)
+		c.check(err, "delete stale sessions")
+		if count != test.want {
+			c.fatalf("stale sessions, expected %d, got %d", test.want, count)
+		}
+	}
+}
+
+// torrentRecord verifies TorrentRecord create, load, update, list, and delete, using metainfo which is not
+// valid UTF-8, and so must be stored as raw bytes
+func (c conformance) torrentRecord() {
+	t := TorrentRecord{InfoHash: c.hash, Metainfo: []byte("d4:infod4:name4:goatee\x00\x80\xff\\'\"")}
+	c.check(c.db.SaveTorrentRecord(t), "save torrent")
+
+	t2, err := c.db.LoadTorrentRecord(c.hash, "info_hash")
+	c.check(err, "load torrent")
+	if t2.InfoHash != t.InfoHash || !bytes.Equal(t2.Metainfo, t.Metainfo) {
+		c.fatalf("torrent does not match: %q != %q", t2.Metainfo, t.Metainfo)
+	}
+
+	// Saving an existing torrent replaces its metainfo
+	t.Metainfo = []byte("d4:infod4:name5:goat2ee")
+	c.check(c.db.SaveTorrentRecord(t), "update torrent")
+	t2, err = c.db.LoadTorrentRecord(c.hash, "info_hash")
+	c.check(err, "load torrent")
+	if !bytes.Equal(t2.Metainfo, t.Metainfo) {
+		c.fatalf("torrent not updated: %q", t2.Metainfo)
+	}
+
+	// Torrent appears in list of all torrents
+	torrents, err := c.db.GetAllTorrentRecords()
+	c.check(err, "list torrents")
+	found := false
+	for _, tr := range torrents {
+		found = found || tr.InfoHash == t.InfoHash
+	}
+	if !found {
+		c.fatalf("torrent %s missing from list of all torrents", t.InfoHash)
+	}
+
+	c.check(c.db.DeleteTorrentRecord(c.hash, "info_hash"), "delete torrent")
+	if t2, _ = c.db.LoadTorrentRecord(c.hash, "info_hash"); t2.InfoHash != "" {
+		c.fatalf("torrent not deleted: %v", t2)
+	}
+}
+
+// userRecord verifies UserRecord create, load, update, list, and delete
+func (c conformance) userRecord() {
+	user := UserRecord{Username: c.tag, Password: c.hash, Passkey: c.hash, TorrentLimit: 10}
+	c.check(c.db.SaveUserRecord(user), "save user")
+
+	user2, err := c.db.LoadUserRecord(c.tag, "username")
+	c.check(err, "load user")
+	user.ID = user2.ID
+	if user2.ID == 0 || user2 != user {
+		c.fatalf("user does not match: %v != %v", user2, user)
+	}
+
+	// Saving an existing user updates it in place
+	user.TorrentLimit = 20
+	c.check(c.db.SaveUserRecord(user), "update user")
+	if user2, _ = c.db.LoadUserRecord(user.ID, "id"); user2.TorrentLimit != 20 {
+		c.fatalf("user not updated: %v", user2)
+	}
+
+	// User appears in list of all users
+	users, err := c.db.GetAllUserRecords()
+	c.check(err, "list users")
+	found := false
+	for _, u := range users {
+		found = found || u.ID == user.ID
+	}
+	if !found {
+		c.fatalf("user %d missing from list of all users", user.ID)
+	}
+
+	c.check(c.db.DeleteUserRecord(c.tag, "username"), "delete user")
+	if user2, _ = c.db.LoadUserRecord(user.ID, "id"); user2 != (UserRecord{}) {
+		c.fatalf("user not deleted: %v", user2)
+	}
+}
+
+// whitelistRecord verifies WhitelistRecord create, load, list, and delete
+func (c conformance) whitelistRecord() {
+	w := WhitelistRecord{Client: c.tag, Approved: true}
+	c.check(c.db.SaveWhitelistRecord(w), "save whitelist")
+
+	w2, err := c.db.LoadWhitelistRecord(c.tag, "client")
+	c.check(err, "load whitelist")
+	w.ID = w2.ID
+	if w2.ID == 0 || w2 != w {
+		c.fatalf("whitelist does not match: %v != %v", w2, w)
+	}
+
+	// Whitelist entry appears in list of all entries
+	whitelist, err := c.db.GetAllWhitelistRecords()
+	c.check(err, "list whitelist")
+	found := false
+	for _, wl := range whitelist {
+		found = found || wl == w
+	}
+	if !found {
+		c.fatalf("whitelist %d missing from list of all entries", w.ID)
+	}
+
+	c.check(c.db.DeleteWhitelistRecord(c.tag, "client"), "delete whitelist")
+	if w2, _ = c.db.LoadWhitelistRecord(c.tag, "client"); w2 != (WhitelistRecord{}) {
+		c.fatalf("whitelist not deleted: %v", w2)
+	}
+}
+
+// clientRuleRecord verifies that client rules can be saved, loaded, listed, and deleted, and that saving a rule
+// replaces any rule with the same prefix and version range
+func (c conformance) clientRuleRecord() {
+	prefix := "-" + c.tag
+
+	// rules returns the rules with this run's prefixes, keyed by version range
+	rules := func() map[string]ClientRuleRecord {
+		all, err := c.db.GetAllClientRuleRecords()
+		c.check(err, "list client rules")
+
+		found := map[string]ClientRuleRecord{}
+		for _, r := range all {
+			if strings.HasPrefix(r.Prefix, prefix) {
+				found[r.Prefix+"/"+r.MinVersion+"/"+r.MaxVersion] = r
+			}
+		}
+
+		return found
+	}
+
+	// Two rules share a prefix, with different version ranges, and a third has no version range
+	saved := []ClientRuleRecord{
+		{Prefix: prefix, MinVersion: "0001", MaxVersion: "0009", Description: "first"},
+		{Prefix: prefix, MinVersion: "1000", Blacklist: true, Description: "second"},
+		{Prefix: prefix + "x", Description: "third"},
+	}
+	for _, r := range saved {
+		c.check(c.db.SaveClientRuleRecord(r), "save client rule")
+	}
+
+	found := rules()
+	if len(found) != len(saved) {
+		c.fatalf("client rules, expected %d, got %d: %v", len(saved), len(found), found)
+	}
+	for _, r := range saved {
+		r2 := found[r.Prefix+"/"+r.MinVersion+"/"+r.MaxVersion]
+		r.ID = r2.ID
+		if r2.ID == 0 || r2 != r {
+			c.fatalf("client rule does not match: %v != %v", r2, r)
+		}
+	}
+
+	// Rule can be loaded by ID and by prefix
+	first := found[prefix+"/0001/0009"]
+	r2, err := c.db.LoadClientRuleRecord(first.ID, "id")
+	c.check(err, "load client rule")
+	if r2 != first {
+		c.fatalf("client rule by id does not match: %v != %v", r2, first)
+	}
+	r2, err = c.db.LoadClientRuleRecord(prefix+"x", "prefix")
+	c.check(err, "load client rule")
+	if r2.Description != "third" {
+		c.fatalf("client rule by prefix does not match: %v", r2)
+	}
+
+	// Saving a rule with the same prefix and version range replaces it, even without a version range
+	c.check(c.db.SaveClientRuleRecord(ClientRuleRecord{Prefix: prefix, MinVersion: "0001", MaxVersion: "0009", Blacklist: true, Description: "replaced"}), "replace client rule")
+	c.check(c.db.SaveClientRuleRecord(ClientRuleRecord{Prefix: prefix + "x", Description: "replaced"}), "replace client rule")
+
+	found = rules()
+	if len(found) != len(saved) {
+		c.fatalf("client rules after replace, expected %d, got %d: %v", len(saved), len(found), found)
+	}
+	if r := found[prefix+"/0001/0009"]; r.ID != first.ID || !r.Blacklist || r.Description != "replaced" {
+		c.fatalf("client rule not replaced: %v", r)
+	}
+	if r := found[prefix+"x//"]; r.Description != "replaced" {
+		c.fatalf("client rule without version range not replaced: %v", r)
+	}
+	if r := found[prefix+"/1000/"]; r.Description != "second" {
+		c.fatalf("client rule with another version range replaced: %v", r)
+	}
+
+	// Rules can be deleted
+	for _, r := range found {
+		c.check(c.db.DeleteClientRuleRecord(r.ID, "id"), "delete client rule")
+	}
+	if found = rules(); len(found) != 0 {
+		c.fatalf("client rules not deleted: %v", found)
+	}
+	if r2, _ = c.db.LoadClientRuleRecord(first.ID, "id"); r2 != (ClientRuleRecord{}) {
+		c.fatalf("client rule not deleted: %v", r2)
+	}
+}
+
+// counts verifies completed, seeder, and leecher counts on files, and seeding and leeching counts on users
+func (c conformance) counts() {
+	file := c.file("c")
+	defer c.db.DeleteFileRecord(file.ID, "id")
+
+	// Two active seeders, one inactive seeder, and one active leecher
+	uid := int(time.Now().UnixNano()%100000000) + 100000000
+	fileUsers := []FileUserRecord{
+		{FileID: file.ID, UserID: uid, IP: "10.0.1.1", Active: true, Completed: true, Left: 0, Uploaded: 100},
+		{FileID: file.ID, UserID: uid, IP: "10.0.1.2", Active: true, Completed: true, Left: 0, Uploaded: 200},
+		{FileID: file.ID, UserID: uid, IP: "10.0.1.3", Active: false, Completed: true, Left: 0},
+		{FileID: file.ID, UserID: uid + 1, IP: "10.0.1.4", Active: true, Completed: false, Left: 1024, Downloaded: 300},
+	}
+	for _, f := range fileUsers {
+		c.check(c.db.SaveFileUserRecord(f), "save file user")
+		defer c.db.DeleteFileUserRecord(f.FileID, f.UserID, f.IP)
+	}
+
+	expect := func(what string, count int, err error, want int) {
+		c.check(err, "count "+what)
+		if count != want {
+			c.fatalf("%s, expected %d, got %d", what, want, count)
+		}
+	}
+
+	count, err := c.db.CountFileRecordCompleted(file.ID)
+	expect("completed", count, err, 3)
+	count, err = c.db.CountFileRecordSeeders(file.ID)
+	expect("seeders", count, err, 2)
+	count, err = c.db.CountFileRecordLeechers(file.ID)
+	expect("leechers", count, err, 1)
+
+	// Counts of every file agree with the counts of a single file
+	stats, err := c.db.CountAllFileRecordStats()
+	c.check(err, "count all file stats")
+	if stats[file.ID] != (FileStats{Completed: 3, Seeders: 2, Leechers: 1}) {
+		c.fatalf("all file stats, expected 3/2/1, got %v", stats[file.ID])
+	}
+
+	count, err = c.db.GetUserSeeding(uid)
+	expect("user seeding", count, err, 2)
+	count, err = c.db.GetUserLeeching(uid + 1)
+	expect("user leeching", count, err, 1)
+
+	// Lifetime totals are accumulated, and are zero for a user without any traffic
+	c.check(c.db.AddUserTraffic(uid, 100, 0), "add user traffic")
+	c.check(c.db.AddUserTraffic(uid, 200, 0), "add user traffic")
+	c.check(c.db.AddUserTraffic(uid+1, 0, 300), "add user traffic")
+
+	uploaded, err := c.db.GetUserUploaded(uid)
+	expect("user uploaded", int(uploaded), err, 300)
+	downloaded, err := c.db.GetUserDownloaded(uid + 1)
+	expect("user downloaded", int(downloaded), err, 300)
+	uploaded, err = c.db.GetUserUploaded(uid + 2)
+	expect("user without traffic uploaded", int(uploaded), err, 0)
+}
+
+// peerList verifies that peer lists contain active peers from the peers table, that peers are keyed by
+// info hash, peer_id, and address family, and that stale peers are pruned
+func (c conformance) peerList() {
+	file := c.file("p")
+	defer c.db.DeleteFileRecord(file.ID, "id")
+
+	peerID := strings.Repeat("ab", 20)
+	peerID2 := strings.Repeat("cd", 20)
+	defer c.db.DeletePeerRecord(file.InfoHash, peerID)
+	defer c.db.DeletePeerRecord(file.InfoHash, peerID2)
+	peerID3 := strings.Repeat("ef", 20)
+	defer c.db.DeletePeerRecord(file.InfoHash, peerID3)
+
+	// One peer which announces as a leecher, then changes port and completes, one leecher which also
+	// reports an IPv6 address, and one WebTorrent leecher, which has no port and is never listed
+	for _, p := range []PeerRecord{
+		{InfoHash: file.InfoHash, PeerID: peerID, UserID: 1, IP: "10.0.2.1", Port: 6881, Left: 1},
+		{InfoHash: file.InfoHash, PeerID: peerID, UserID: 1, IP: "10.0.2.1", Port: 6889, Left: 0},
+		{InfoHash: file.InfoHash, PeerID: peerID2, UserID: 2, IP: "10.0.2.2", Port: 6882, Left: 1},
+		{InfoHash: file.InfoHash, PeerID: peerID2, UserID: 2, IPv6: true, IP: "2001:db8::2", Port: 6882, Left: 1},
+		{InfoHash: file.InfoHash, PeerID: peerID3, UserID: 3, IP: "10.0.2.3", Port: 0, Left: 1},
+	} {
+		c.check(c.db.SavePeerRecord(p), "save peer record")
+	}
+
+	// Peer lists contain each peer once per address family, at its latest port, along with whether it is a seeder
+	peers, err := c.db.GetFileRecordPeerList(file.InfoHash, peerQuery{Limit: 50})
+	c.check(err, "get peer list")
+	expected := map[Peer]bool{
+		Peer{IP: "10.0.2.1", Port: 6889, PeerID: peerID, Seeder: true}: true,
+		Peer{IP: "10.0.2.2", Port: 6882, PeerID: peerID2}:              true,
+		Peer{IP: "2001:db8::2", Port: 6882, PeerID: peerID2}:           true,
+	}
+	if len(peers) != len(expected) {
+		c.fatalf("peer list does not match: %v", peers)
+	}
+	for _, p := range peers {
+		if !expected[p] {
+			c.fatalf("peer list does not match: %v", peers)
+		}
+	}
+
+	// Peer lists never exceed their limit
+	peers, err = c.db.GetFileRecordPeerList(file.InfoHash, peerQuery{Limit: 1})
+	c.check(err, "get limited peer list")
+	if len(peers) != 1 {
+		c.fatalf("peer list exceeded limit: %v", peers)
+	}
+
+	// Queries leave out seeders, and the requester by peer_id or address, in both counts and lists
+	for i, test := range []struct {
+		q     peerQuery
+		peers []string
+	}{
+		{peerQuery{}, []string{"10.0.2.1", "10.0.2.2", "2001:db8::2"}},
+		{peerQuery{Leechers: true}, []string{"10.0.2.2", "2001:db8::2"}},
+		{peerQuery{PeerID: peerID2}, []string{"10.0.2.1"}},
+		{peerQuery{IP: "10.0.2.1", Port: 6889}, []string{"10.0.2.2", "2001:db8::2"}},
+		{peerQuery{IP: "10.0.2.1", Port: 6881}, []string{"10.0.2.1", "10.0.2.2", "2001:db8::2"}},
+	} {
+		count, err := c.db.CountFileRecordPeers(file.InfoHash, test.q)
+		c.check(err, "count peers")
+		if count != len(test.peers) {
+			c.fatalf("[%02d] peer count, expected %d, got %d", i, len(test.peers), count)
+		}
+
+		// A stable order pages through peers by peer_id and address
+		test.q.Stable = true
+		for j, ip := range test.peers {
+			test.q.Offset, test.q.Limit = j, 1
+			peers, err := c.db.GetFileRecordPeerList(file.InfoHash, test.q)
+			c.check(err, "get peer list page")
+			if len(peers) != 1 || peers[0].IP != ip {
+				c.fatalf("[%02d] peer list page %d, expected %s, got %v", i, j, ip, peers)
+			}
+		}
+	}
+
+	// Deleting a peer removes it for all address families
+	c.check(c.db.DeletePeerRecord(file.InfoHash, peerID2), "delete peer record")
+	c.check(c.db.DeletePeerRecord(file.InfoHash, peerID3), "delete peer record")
+	peers, err = c.db.GetFileRecordPeerList(file.InfoHash, peerQuery{Limit: 50})
+	c.check(err, "get peer list")
+	if len(peers) != 1 || peers[0].PeerID != peerID {
+		c.fatalf("peer list does not match after delete: %v", peers)
+	}
+
+	// Peers which announced recently are not stale, but are once the interval has passed
+	for _, test := range []struct {
+		interval time.Duration
+		want     int
+	}{{time.Hour, 0}, {-time.Minute, 1}} {
+		count, err := c.db.DeleteStalePeerRecords(file.InfoHash, test.interval)
+		c.check(err, "delete stale peers")
+		if count != test.want {
+			c.fatalf("stale peers, expected %d, got %d", test.want, count)
+		}
+	}
+}
+
+// reaping verifies that inactive peers are found, and no longer counted once marked inactive
+func (c conformance) reaping() {
+	file := c.file("r")
+	defer c.db.DeleteFileRecord(file.ID, "id")
+
+	c.check(c.db.SaveFileUserRecord(FileUserRecord{FileID: file.ID, UserID: 1, IP: "10.0.3.1", Active: true, Completed: true}), "save file user")
+	defer c.db.DeleteFileUserRecord(file.ID, 1, "10.0.3.1")
+
+	// Peer just announced, so it is not yet inactive
+	users, err := c.db.GetInactiveUserInfo(file.ID, time.Hour)
+	c.check(err, "get inactive users")
+	if len(users) != 0 {
+		c.fatalf("recent peer reported inactive: %v", users)
+	}
+
+	// Using an interval which ends in the future, the peer is inactive
+	users, err = c.db.GetInactiveUserInfo(file.ID, -time.Hour)
+	c.check(err, "get inactive users")
+	if len(users) != 1 || users[0] != (peerInfo{1, "10.0.3.1"}) {
+		c.fatalf("inactive peers do not match: %v", users)
+	}
+
+	// Reaped peer is no longer active, or counted as a seeder
+	c.check(c.db.MarkFileUsersInactive(file.ID, users), "mark users inactive")
+
+	fileUser, err := c.db.LoadFileUserRecord(file.ID, 1, "10.0.3.1")
+	c.check(err, "load file user")
+	if fileUser.Active {
+		c.fatalf("reaped peer still active: %v", fileUser)
+	}
+
+	if seeders, _ := c.db.CountFileRecordSeeders(file.ID); seeders != 0 {
+		c.fatalf("reaped peer still counted as seeder")
+	}
+
+	if users, _ = c.db.GetInactiveUserInfo(file.ID, -time.Hour); len(users) != 0 {
+		c.fatalf("reaped peer reported inactive again: %v", users)
+	}
+}
+
+// apiKeyExpiry verifies that expired API keys are listed with their expiration, so they may be reaped
+func (c conformance) apiKeyExpiry() {
+	expired := APIKey{UserID: 1, Pubkey: c.hash[1:] + "e", Secret: c.hash[2:] + "ee", Expire: time.Now().Add(-time.Hour).Unix()}
+	c.check(c.db.SaveAPIKey(expired), "save expired API key")
+	defer c.db.DeleteAPIKey(expired.Pubkey, "pubkey")
+
+	keys, err := c.db.GetAllAPIKeys()
+	c.check(err, "list API keys")
+
+	// Find key, and verify it is reported as expired
+	var key APIKey
+	for _, k := range keys {
+		if k.Pubkey == expired.Pubkey {
+			key = k
+		}
+	}
+	if key.Expire != expired.Expire || key.Expire > time.Now().Unix() {
+		c.fatalf("expired API key not listed with expiration: %v", key)
+	}
+
+	// Reaping the key removes it
+	c.check(c.db.DeleteAPIKey(key.ID, "id"), "delete expired API key")
+	if key, _ = c.db.LoadAPIKey(expired.Pubkey, "pubkey"); key != (APIKey{}) {
+		c.fatalf("expired API key not deleted: %v", key)
+	}
+}
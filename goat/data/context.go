@@ -0,0 +1,71 @@
+package data
+
+import (
+	"errors"
+
+	"code.google.com/p/go.net/context"
+)
+
+// ErrTimeout is returned when a data call does not complete before its context is done
+var ErrTimeout = errors.New("data: operation timed out")
+
+// dbMaxInFlight is the most data calls which may run in the background at once.  Calls which time out keep
+// running until their backend answers, so this caps how many may pile up behind a stalled backend.
+const dbMaxInFlight = 1024
+
+// dbInFlight holds a slot for each data call running in the background
+var dbInFlight = make(chan struct{}, dbMaxInFlight)
+
+// dbContexter is implemented by backends which can cancel queries, such as those using database/sql
+type dbContexter interface {
+	withContext(context.Context) dbModel
+}
+
+// dbConnect connects to the database backend, binding ctx to the connection if the backend can cancel
+// queries, so that they stop once ctx is done
+func dbConnect(ctx context.Context) (dbModel, error) {
+	db, err := DBConnectFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := db.(dbContexter); ok {
+		db = c.withContext(ctx)
+	}
+
+	return columnChecker{db}, nil
+}
+
+// withContext runs fn in the background, returning ErrTimeout if ctx is done before fn completes
+// NOTE: only backends which implement dbContexter cancel their queries, so on other backends a timed
+// out fn still runs to completion, but its caller is free to move on
+func withContext(ctx context.Context, fn func() error) error {
+	// Context already done, so do not bother starting fn
+	if ctx.Err() != nil {
+		return ErrTimeout
+	}
+
+	// Wait for a background slot, or give up once ctx is done
+	select {
+	case dbInFlight <- struct{}{}:
+	case <-ctx.Done():
+		return ErrTimeout
+	}
+
+	// Buffered, so fn may always finish, even if nobody is waiting for it
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			<-dbInFlight
+		}()
+
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrTimeout
+	}
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// TestWithContext verifies that data calls return their own result, or ErrTimeout once their context is done
+func TestWithContext(t *testing.T) {
+	log.Println("TestWithContext()")
+
+	// Call which completes in time returns its own error
+	errFn := errors.New("data: test error")
+	if err := withContext(context.Background(), func() error { return errFn }); err != errFn {
+		t.Fatalf("withContext returned wrong error: %v", err)
+	}
+
+	// Call which is slower than its deadline times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := withContext(ctx, func() error {
+		<-time.After(500 * time.Millisecond)
+		return nil
+	})
+	if err != ErrTimeout {
+		t.Fatalf("withContext did not time out: %v", err)
+	}
+
+	// Call with a cancelled context is never started
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	started := false
+	if err := withContext(ctx, func() error { started = true; return nil }); err != ErrTimeout || started {
+		t.Fatalf("withContext ran call with cancelled context")
+	}
+
+	// Call which finds every background slot taken times out without being started
+	for i := 0; i < dbMaxInFlight; i++ {
+		dbInFlight <- struct{}{}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := withContext(ctx, func() error { started = true; return nil }); err != ErrTimeout || started {
+		t.Fatalf("withContext ran call with no background slot free")
+	}
+
+	for i := 0; i < dbMaxInFlight; i++ {
+		<-dbInFlight
+	}
+}
@@ -1,7 +1,10 @@
 package data
 
 import (
+	"database/sql"
 	"time"
+
+	"github.com/mdlayher/goat/goat/common"
 )
 
 var (
@@ -18,12 +21,28 @@ var (
 // MySQLDSN is set via command-line, and can be used to override all MySQL configuration
 var MySQLDSN *string
 
+// PostgresDSN is set via command-line, and can be used to override all PostgreSQL configuration
+var PostgresDSN *string
+
 // QLDBPath is set via command-line, and can be used to override ql database location
 var QLDBPath *string
 
+// MemoryDBPath is set via command-line, and can be used to snapshot the memory database to disk
+var MemoryDBPath *string
+
 // DBConnect connects to a database
 func DBConnect() (dbModel, error) {
-	return DBConnectFunc()
+	db, err := DBConnectFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return columnChecker{db}, nil
+}
+
+// DBClose closes the database backend, and any connections it holds open
+func DBClose() {
+	DBCloseFunc()
 }
 
 // DBName will retrieve the name of the database backend currently in use
@@ -36,14 +55,31 @@ func DBPing() bool {
 	return DBPingFunc()
 }
 
+// dbPoolLimits applies the configured connection pool limits to a database handle
+func dbPoolLimits(db *sql.DB) {
+	// Limit total open connections, if configured
+	if common.Static.Config.DB.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(common.Static.Config.DB.MaxOpenConns)
+	}
+
+	// Limit idle connections kept in the pool, if configured
+	if common.Static.Config.DB.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(common.Static.Config.DB.MaxIdleConns)
+	}
+}
+
 // dbModel represents a database interface, and defines functions which act on it
 type dbModel interface {
+	// Close releases a handle obtained from DBConnect; shared connections are only closed by DBClose
 	Close() error
 
 	// --- AnnounceLog.go ---
 	DeleteAnnounceLog(interface{}, string) error
 	LoadAnnounceLog(interface{}, string) (AnnounceLog, error)
 	SaveAnnounceLog(AnnounceLog) error
+	SaveAnnounceLogs([]AnnounceLog) error
+	GetExpiredAnnounceLogs(int64, int) ([]AnnounceLog, error)
+	DeleteAnnounceLogs([]int) error
 
 	// --- APIKey.go ---
 	DeleteAPIKey(interface{}, string) error
@@ -51,6 +87,12 @@ type dbModel interface {
 	SaveAPIKey(APIKey) error
 	GetAllAPIKeys() ([]APIKey, error)
 
+	// --- ClientRuleRecord.go ---
+	DeleteClientRuleRecord(interface{}, string) error
+	LoadClientRuleRecord(interface{}, string) (ClientRuleRecord, error)
+	SaveClientRuleRecord(ClientRuleRecord) error
+	GetAllClientRuleRecords() ([]ClientRuleRecord, error)
+
 	// --- FileRecord.go ---
 	DeleteFileRecord(interface{}, string) error
 	LoadFileRecord(interface{}, string) (FileRecord, error)
@@ -58,7 +100,9 @@ type dbModel interface {
 	CountFileRecordCompleted(int) (int, error)
 	CountFileRecordSeeders(int) (int, error)
 	CountFileRecordLeechers(int) (int, error)
-	GetFileRecordPeerList(string, int, bool) ([]Peer, error)
+	CountAllFileRecordStats() (map[int]FileStats, error)
+	CountFileRecordPeers(string, peerQuery) (int, error)
+	GetFileRecordPeerList(string, peerQuery) ([]Peer, error)
 	GetInactiveUserInfo(int, time.Duration) ([]peerInfo, error)
 	MarkFileUsersInactive(int, []peerInfo) error
 	GetAllFileRecords() ([]FileRecord, error)
@@ -69,10 +113,30 @@ type dbModel interface {
 	SaveFileUserRecord(FileUserRecord) error
 	LoadFileUserRepository(interface{}, string) ([]FileUserRecord, error)
 
+	// --- PeerRecord.go ---
+	DeletePeerRecord(string, string) error
+	SavePeerRecord(PeerRecord) error
+	DeleteStalePeerRecords(string, time.Duration) (int, error)
+
 	// --- ScrapeLog.go ---
 	DeleteScrapeLog(interface{}, string) error
 	LoadScrapeLog(interface{}, string) (ScrapeLog, error)
 	SaveScrapeLog(ScrapeLog) error
+	SaveScrapeLogs([]ScrapeLog) error
+	GetExpiredScrapeLogs(int64, int) ([]ScrapeLog, error)
+	DeleteScrapeLogs([]int) error
+
+	// --- SessionRecord.go ---
+	DeleteSessionRecord(string, string, string) error
+	LoadSessionRecord(string, string, string) (SessionRecord, error)
+	SaveSessionRecord(SessionRecord) error
+	DeleteStaleSessionRecords(string, time.Duration) (int, error)
+
+	// --- TorrentRecord.go ---
+	DeleteTorrentRecord(interface{}, string) error
+	LoadTorrentRecord(interface{}, string) (TorrentRecord, error)
+	SaveTorrentRecord(TorrentRecord) error
+	GetAllTorrentRecords() ([]TorrentRecord, error)
 
 	// --- UserRecord.go ---
 	DeleteUserRecord(interface{}, string) error
@@ -80,6 +144,7 @@ type dbModel interface {
 	SaveUserRecord(UserRecord) error
 	GetUserUploaded(int) (int64, error)
 	GetUserDownloaded(int) (int64, error)
+	AddUserTraffic(int, int64, int64) error
 	GetUserSeeding(int) (int, error)
 	GetUserLeeching(int) (int, error)
 	GetAllUserRecords() ([]UserRecord, error)
@@ -88,4 +153,5 @@ type dbModel interface {
 	DeleteWhitelistRecord(interface{}, string) error
 	LoadWhitelistRecord(interface{}, string) (WhitelistRecord, error)
 	SaveWhitelistRecord(WhitelistRecord) error
+	GetAllWhitelistRecords() ([]WhitelistRecord, error)
 }
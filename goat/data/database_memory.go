@@ -0,0 +1,1483 @@
+// +build memory
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// memwdb is the single, shared in-memory database used by this backend
+var memwdb *memw
+
+// memwdbErr is the error which prevented memwdb from being restored, if any.  It is kept so that later connections
+// also fail, rather than starting an empty database which would overwrite the snapshot on close.
+var memwdbErr error
+
+// memwdbLock guards the creation of memwdb, and memwdbErr
+var memwdbLock sync.Mutex
+
+// init performs startup routines for database_memory
+func init() {
+	// DBConnectFunc returns the shared in-memory database, restoring a snapshot on first use
+	DBConnectFunc = func() (dbModel, error) {
+		memwdbLock.Lock()
+		defer memwdbLock.Unlock()
+
+		if memwdbErr != nil {
+			return nil, memwdbErr
+		}
+		if memwdb != nil {
+			return memwdb, nil
+		}
+
+		db := &memw{store: newMemStore()}
+
+		// Restore previous state, if a snapshot path was provided
+		if MemoryDBPath != nil && *MemoryDBPath != "" {
+			log.Println("Loading memory database snapshot: " + *MemoryDBPath)
+			if err := db.Restore(*MemoryDBPath); err != nil {
+				memwdbErr = err
+				return nil, err
+			}
+		}
+
+		// Only share the database once it is fully restored
+		memwdb = db
+		return memwdb, nil
+	}
+
+	// DBCloseFunc writes a snapshot of the in-memory database, if a snapshot path was provided.  No snapshot is
+	// written if the previous one could not be restored, so that it is not lost.
+	DBCloseFunc = func() {
+		memwdbLock.Lock()
+		db := memwdb
+		memwdbLock.Unlock()
+
+		if db == nil || MemoryDBPath == nil || *MemoryDBPath == "" {
+			return
+		}
+
+		log.Println("Writing memory database snapshot: " + *MemoryDBPath)
+		if err := db.Snapshot(*MemoryDBPath); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	// DBNameFunc returns the name of this backend
+	DBNameFunc = func() string {
+		return "memory"
+	}
+}
+
+// memStore contains all records held by the in-memory backend
+type memStore struct {
+	AnnounceLogs []AnnounceLog
+	APIKeys      []APIKey
+	ClientRules  []ClientRuleRecord
+	Files        []FileRecord
+	FilesUsers   []FileUserRecord
+	Peers        []PeerRecord
+	ScrapeLogs   []ScrapeLog
+	Sessions     []SessionRecord
+	Torrents     []TorrentRecord
+	Users        []UserRecord
+	UserTotals   []memUserTotals
+	Whitelist    []WhitelistRecord
+
+	// NextID tracks the next auto-increment ID for each table
+	NextID map[string]int
+}
+
+// memUserTotals holds a user's lifetime upload and download totals
+type memUserTotals struct {
+	UserID     int
+	Uploaded   int64
+	Downloaded int64
+}
+
+// newMemStore creates an empty memStore
+func newMemStore() memStore {
+	return memStore{
+		NextID: map[string]int{},
+	}
+}
+
+// nextID returns the next auto-increment ID for a table
+func (s *memStore) nextID(table string) int {
+	s.NextID[table]++
+	return s.NextID[table]
+}
+
+// memw contains the in-memory store, and a lock which guards it
+type memw struct {
+	sync.RWMutex
+	store memStore
+}
+
+// Close is a no-op, because the in-memory database is shared
+func (db *memw) Close() error {
+	return nil
+}
+
+// Snapshot writes the current contents of the in-memory database to a JSON file
+func (db *memw) Snapshot(path string) error {
+	db.RLock()
+	defer db.RUnlock()
+
+	// Write to a temporary file first, so a failed write does not destroy the previous snapshot
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(file).Encode(db.store); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Restore replaces the contents of the in-memory database using a JSON snapshot file
+func (db *memw) Restore(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		// A missing snapshot simply means we start with an empty database
+		if os.IsNotExist(err) {
+			log.Println("Could not find memory database snapshot, starting empty")
+			return nil
+		}
+
+		return err
+	}
+	defer file.Close()
+
+	store := newMemStore()
+	if err := json.NewDecoder(file).Decode(&store); err != nil {
+		return err
+	}
+
+	if store.NextID == nil {
+		store.NextID = map[string]int{}
+	}
+
+	// Snapshots taken before lifetime totals were stored begin with the sum of each user's file/user records
+	if store.UserTotals == nil {
+		for _, u := range store.FilesUsers {
+			store.addUserTraffic(u.UserID, u.Uploaded, u.Downloaded)
+		}
+	}
+
+	db.Lock()
+	db.store = store
+	db.Unlock()
+
+	return nil
+}
+
+// memMatch compares a query value against a record value, regardless of numeric or string type
+func memMatch(id interface{}, value interface{}) bool {
+	return fmt.Sprint(id) == fmt.Sprint(value)
+}
+
+// memBool converts a boolean into the integer form used by the SQL backends
+func memBool(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// --- AnnounceLog.go ---
+
+// announceLogColumn returns the value of the named column for an AnnounceLog
+func announceLogColumn(a AnnounceLog, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return a.ID, nil
+	case "info_hash":
+		return a.InfoHash, nil
+	case "peer_id":
+		return a.PeerID, nil
+	case "passkey":
+		return a.Passkey, nil
+	case "key":
+		return a.Key, nil
+	case "ip":
+		return a.IP, nil
+	case "port":
+		return a.Port, nil
+	case "udp":
+		return memBool(a.UDP), nil
+	case "uploaded":
+		return a.Uploaded, nil
+	case "downloaded":
+		return a.Downloaded, nil
+	case "left":
+		return a.Left, nil
+	case "event":
+		return a.Event, nil
+	case "client":
+		return a.Client, nil
+	case "time":
+		return a.Time, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteAnnounceLog deletes an AnnounceLog using a defined ID and column
+func (db *memw) DeleteAnnounceLog(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	logs := make([]AnnounceLog, 0, len(db.store.AnnounceLogs))
+	for _, a := range db.store.AnnounceLogs {
+		value, err := announceLogColumn(a, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			logs = append(logs, a)
+		}
+	}
+	db.store.AnnounceLogs = logs
+
+	return nil
+}
+
+// LoadAnnounceLog loads an AnnounceLog using a defined ID and column for query
+func (db *memw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, a := range db.store.AnnounceLogs {
+		value, err := announceLogColumn(a, col)
+		if err != nil {
+			return AnnounceLog{}, err
+		}
+
+		if memMatch(id, value) {
+			return a, nil
+		}
+	}
+
+	return AnnounceLog{}, nil
+}
+
+// SaveAnnounceLog saves an AnnounceLog to database
+func (db *memw) SaveAnnounceLog(a AnnounceLog) error {
+	db.Lock()
+	defer db.Unlock()
+
+	a.ID = db.store.nextID("announce_log")
+	a.Time = time.Now().Unix()
+	db.store.AnnounceLogs = append(db.store.AnnounceLogs, a)
+
+	return nil
+}
+
+// SaveAnnounceLogs saves a batch of AnnounceLogs to database, keeping their announce times
+func (db *memw) SaveAnnounceLogs(logs []AnnounceLog) error {
+	db.Lock()
+	defer db.Unlock()
+
+	for _, a := range logs {
+		a.ID = db.store.nextID("announce_log")
+		db.store.AnnounceLogs = append(db.store.AnnounceLogs, a)
+	}
+
+	return nil
+}
+
+// GetExpiredAnnounceLogs returns up to limit AnnounceLogs logged before the specified time, oldest first
+func (db *memw) GetExpiredAnnounceLogs(before int64, limit int) ([]AnnounceLog, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	// Logs are stored in ID order, so the first matches are the oldest
+	logs := make([]AnnounceLog, 0)
+	for _, l := range db.store.AnnounceLogs {
+		if len(logs) >= limit {
+			break
+		}
+
+		if l.Time < before {
+			logs = append(logs, l)
+		}
+	}
+
+	return logs, nil
+}
+
+// DeleteAnnounceLogs deletes AnnounceLogs with the specified IDs
+func (db *memw) DeleteAnnounceLogs(ids []int) error {
+	db.Lock()
+	defer db.Unlock()
+
+	deleted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	logs := make([]AnnounceLog, 0, len(db.store.AnnounceLogs))
+	for _, l := range db.store.AnnounceLogs {
+		if !deleted[l.ID] {
+			logs = append(logs, l)
+		}
+	}
+	db.store.AnnounceLogs = logs
+
+	return nil
+}
+
+// --- APIKey.go ---
+
+// apiKeyColumn returns the value of the named column for an APIKey
+func apiKeyColumn(key APIKey, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return key.ID, nil
+	case "user_id":
+		return key.UserID, nil
+	case "pubkey":
+		return key.Pubkey, nil
+	case "secret":
+		return key.Secret, nil
+	case "expire":
+		return key.Expire, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteAPIKey deletes an APIKey using a defined ID and column
+func (db *memw) DeleteAPIKey(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	keys := make([]APIKey, 0, len(db.store.APIKeys))
+	for _, k := range db.store.APIKeys {
+		value, err := apiKeyColumn(k, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			keys = append(keys, k)
+		}
+	}
+	db.store.APIKeys = keys
+
+	return nil
+}
+
+// LoadAPIKey loads an APIKey using a defined ID and column for query
+func (db *memw) LoadAPIKey(id interface{}, col string) (APIKey, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, k := range db.store.APIKeys {
+		value, err := apiKeyColumn(k, col)
+		if err != nil {
+			return APIKey{}, err
+		}
+
+		if memMatch(id, value) {
+			return k, nil
+		}
+	}
+
+	return APIKey{}, nil
+}
+
+// SaveAPIKey saves an APIKey to the database
+func (db *memw) SaveAPIKey(key APIKey) error {
+	db.Lock()
+	defer db.Unlock()
+
+	// Update expiration time on existing key
+	for i, k := range db.store.APIKeys {
+		if k.Pubkey == key.Pubkey || k.Secret == key.Secret {
+			db.store.APIKeys[i].Expire = key.Expire
+			return nil
+		}
+	}
+
+	key.ID = db.store.nextID("api_keys")
+	db.store.APIKeys = append(db.store.APIKeys, key)
+
+	return nil
+}
+
+// GetAllAPIKeys returns a list of all APIKeys known to the database
+func (db *memw) GetAllAPIKeys() ([]APIKey, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	keys := make([]APIKey, len(db.store.APIKeys))
+	copy(keys, db.store.APIKeys)
+
+	return keys, nil
+}
+
+// --- ClientRuleRecord.go ---
+
+// clientRuleRecordColumn returns the value of the named column for a ClientRuleRecord
+func clientRuleRecordColumn(c ClientRuleRecord, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return c.ID, nil
+	case "prefix":
+		return c.Prefix, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteClientRuleRecord deletes a ClientRuleRecord using a defined ID and column
+func (db *memw) DeleteClientRuleRecord(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	rules := make([]ClientRuleRecord, 0, len(db.store.ClientRules))
+	for _, c := range db.store.ClientRules {
+		value, err := clientRuleRecordColumn(c, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			rules = append(rules, c)
+		}
+	}
+	db.store.ClientRules = rules
+
+	return nil
+}
+
+// LoadClientRuleRecord loads a ClientRuleRecord using a defined ID and column for query
+func (db *memw) LoadClientRuleRecord(id interface{}, col string) (ClientRuleRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, c := range db.store.ClientRules {
+		value, err := clientRuleRecordColumn(c, col)
+		if err != nil {
+			return ClientRuleRecord{}, err
+		}
+
+		if memMatch(id, value) {
+			return c, nil
+		}
+	}
+
+	return ClientRuleRecord{}, nil
+}
+
+// SaveClientRuleRecord saves a ClientRuleRecord to the database, updating any rule with the same prefix and
+// version range
+func (db *memw) SaveClientRuleRecord(c ClientRuleRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	for i, rule := range db.store.ClientRules {
+		if rule.Prefix == c.Prefix && rule.MinVersion == c.MinVersion && rule.MaxVersion == c.MaxVersion {
+			db.store.ClientRules[i].Blacklist = c.Blacklist
+			db.store.ClientRules[i].Description = c.Description
+			return nil
+		}
+	}
+
+	c.ID = db.store.nextID("client_rules")
+	db.store.ClientRules = append(db.store.ClientRules, c)
+
+	return nil
+}
+
+// GetAllClientRuleRecords returns a list of all ClientRuleRecords known to the database
+func (db *memw) GetAllClientRuleRecords() ([]ClientRuleRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	rules := make([]ClientRuleRecord, len(db.store.ClientRules))
+	copy(rules, db.store.ClientRules)
+
+	return rules, nil
+}
+
+// --- FileRecord.go ---
+
+// fileRecordColumn returns the value of the named column for a FileRecord
+func fileRecordColumn(f FileRecord, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return f.ID, nil
+	case "info_hash":
+		return f.InfoHash, nil
+	case "verified":
+		return memBool(f.Verified), nil
+	case "create_time":
+		return f.CreateTime, nil
+	case "update_time":
+		return f.UpdateTime, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteFileRecord deletes a FileRecord using a defined ID and column
+func (db *memw) DeleteFileRecord(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	files := make([]FileRecord, 0, len(db.store.Files))
+	for _, f := range db.store.Files {
+		value, err := fileRecordColumn(f, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			files = append(files, f)
+		}
+	}
+	db.store.Files = files
+
+	return nil
+}
+
+// LoadFileRecord loads a FileRecord using a defined ID and column for query
+func (db *memw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, f := range db.store.Files {
+		value, err := fileRecordColumn(f, col)
+		if err != nil {
+			return FileRecord{}, err
+		}
+
+		if memMatch(id, value) {
+			return f, nil
+		}
+	}
+
+	return FileRecord{}, nil
+}
+
+// SaveFileRecord saves a FileRecord to the database
+func (db *memw) SaveFileRecord(f FileRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	now := time.Now().Unix()
+
+	// Update existing file with matching info hash
+	for i, file := range db.store.Files {
+		if file.InfoHash == f.InfoHash {
+			db.store.Files[i].Verified = f.Verified
+			db.store.Files[i].Name = f.Name
+			db.store.Files[i].Size = f.Size
+			db.store.Files[i].FileCount = f.FileCount
+			db.store.Files[i].PieceLength = f.PieceLength
+			db.store.Files[i].UpdateTime = now
+			return nil
+		}
+	}
+
+	f.ID = db.store.nextID("files")
+	f.CreateTime = now
+	f.UpdateTime = now
+	db.store.Files = append(db.store.Files, f)
+
+	return nil
+}
+
+// fileID returns the ID of the file with the specified info hash, or 0 if it does not exist.
+// The caller must hold the lock.
+func (db *memw) fileID(infoHash string) int {
+	for _, f := range db.store.Files {
+		if f.InfoHash == infoHash {
+			return f.ID
+		}
+	}
+
+	return 0
+}
+
+// countFileUsers counts file/user relationships for a file which satisfy a condition
+func (db *memw) countFileUsers(fid int, cond func(FileUserRecord) bool) int {
+	db.RLock()
+	defer db.RUnlock()
+
+	count := 0
+	for _, u := range db.store.FilesUsers {
+		if u.FileID == fid && cond(u) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// CountFileRecordCompleted counts the number of peers who have completed this file
+func (db *memw) CountFileRecordCompleted(id int) (int, error) {
+	return db.countFileUsers(id, func(u FileUserRecord) bool {
+		return u.Completed && u.Left == 0
+	}), nil
+}
+
+// CountFileRecordSeeders counts the number of peers who are actively seeding this file
+func (db *memw) CountFileRecordSeeders(id int) (int, error) {
+	return db.countFileUsers(id, func(u FileUserRecord) bool {
+		return u.Active && u.Completed && u.Left == 0
+	}), nil
+}
+
+// CountFileRecordLeechers counts the number of peers who are actively leeching this file
+func (db *memw) CountFileRecordLeechers(id int) (int, error) {
+	return db.countFileUsers(id, func(u FileUserRecord) bool {
+		return u.Active && !u.Completed && u.Left > 0
+	}), nil
+}
+
+// CountAllFileRecordStats counts the completions, seeders, and leechers on every file
+func (db *memw) CountAllFileRecordStats() (map[int]FileStats, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	// Counts are defined as in CountFileRecordCompleted, CountFileRecordSeeders, and CountFileRecordLeechers
+	stats := map[int]FileStats{}
+	for _, u := range db.store.FilesUsers {
+		fileStats := stats[u.FileID]
+		if u.Completed && u.Left == 0 {
+			fileStats.Completed++
+			if u.Active {
+				fileStats.Seeders++
+			}
+		}
+		if u.Active && !u.Completed && u.Left > 0 {
+			fileStats.Leechers++
+		}
+		stats[u.FileID] = fileStats
+	}
+
+	return stats, nil
+}
+
+// memPeerRecords sorts PeerRecords most recent first, or by peer_id and address for a stable order
+type memPeerRecords struct {
+	records []PeerRecord
+	stable  bool
+}
+
+func (p memPeerRecords) Len() int      { return len(p.records) }
+func (p memPeerRecords) Swap(i, j int) { p.records[i], p.records[j] = p.records[j], p.records[i] }
+func (p memPeerRecords) Less(i, j int) bool {
+	a, b := p.records[i], p.records[j]
+	if !p.stable {
+		return a.Time > b.Time
+	}
+	if a.PeerID != b.PeerID {
+		return a.PeerID < b.PeerID
+	}
+
+	return a.IP < b.IP
+}
+
+// peers returns the reachable peers on a file which announced in the current announce period, and which
+// match a peerQuery, in the order the query asks for
+func (db *memw) peers(infoHash string, q peerQuery) []Peer {
+	db.RLock()
+	defer db.RUnlock()
+
+	records := make([]PeerRecord, 0)
+	since := time.Now().Unix() - int64(common.Static.Config.Interval)
+	for _, p := range db.store.Peers {
+		peer := Peer{IP: p.IP, Port: uint16(p.Port), PeerID: p.PeerID, Seeder: p.Left == 0}
+		if p.InfoHash == infoHash && p.Time >= since && p.Port != 0 && !q.excludes(peer) {
+			records = append(records, p)
+		}
+	}
+	sort.Stable(memPeerRecords{records, q.Stable})
+
+	peers := make([]Peer, 0, len(records))
+	for _, p := range records {
+		peers = append(peers, Peer{IP: p.IP, Port: uint16(p.Port), PeerID: p.PeerID, Seeder: p.Left == 0})
+	}
+
+	return peers
+}
+
+// CountFileRecordPeers counts the peers on a file which match a peerQuery
+func (db *memw) CountFileRecordPeers(infoHash string, q peerQuery) (int, error) {
+	return len(db.peers(infoHash, q)), nil
+}
+
+// GetFileRecordPeerList returns a list of Peers, containing IP/port pairs, which match a peerQuery
+func (db *memw) GetFileRecordPeerList(infoHash string, q peerQuery) ([]Peer, error) {
+	peers := db.peers(infoHash, q)
+
+	// Apply offset and limit
+	if q.Offset >= len(peers) {
+		return []Peer{}, nil
+	}
+	peers = peers[q.Offset:]
+	if len(peers) > q.Limit {
+		peers = peers[:q.Limit]
+	}
+
+	return peers, nil
+}
+
+// GetInactiveUserInfo returns a list of users who have not been active for the specified time interval
+func (db *memw) GetInactiveUserInfo(fid int, interval time.Duration) ([]peerInfo, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	users := make([]peerInfo, 0)
+	since := time.Now().Unix() - int64(interval/time.Second)
+	for _, u := range db.store.FilesUsers {
+		if u.FileID == fid && u.Active && u.Time < since {
+			users = append(users, peerInfo{UserID: u.UserID, IP: u.IP})
+		}
+	}
+
+	return users, nil
+}
+
+// MarkFileUsersInactive sets users to be inactive once they have been reaped
+func (db *memw) MarkFileUsersInactive(fid int, users []peerInfo) error {
+	db.Lock()
+	defer db.Unlock()
+
+	for _, p := range users {
+		for i, u := range db.store.FilesUsers {
+			if u.FileID == fid && u.UserID == p.UserID && u.IP == p.IP {
+				db.store.FilesUsers[i].Active = false
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetAllFileRecords returns a list of all FileRecords known to the database
+func (db *memw) GetAllFileRecords() ([]FileRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	files := make([]FileRecord, len(db.store.Files))
+	copy(files, db.store.Files)
+
+	return files, nil
+}
+
+// --- FileUserRecord.go ---
+
+// fileUserRecordColumn returns the value of the named column for a FileUserRecord
+func fileUserRecordColumn(f FileUserRecord, col string) (interface{}, error) {
+	switch col {
+	case "file_id":
+		return f.FileID, nil
+	case "user_id":
+		return f.UserID, nil
+	case "ip":
+		return f.IP, nil
+	case "active":
+		return memBool(f.Active), nil
+	case "completed":
+		return memBool(f.Completed), nil
+	case "announced":
+		return f.Announced, nil
+	case "uploaded":
+		return f.Uploaded, nil
+	case "downloaded":
+		return f.Downloaded, nil
+	case "left":
+		return f.Left, nil
+	case "time":
+		return f.Time, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteFileUserRecord deletes a FileUserRecord using a file ID, user ID, and IP triple
+func (db *memw) DeleteFileUserRecord(fid, uid int, ip string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	fileUsers := make([]FileUserRecord, 0, len(db.store.FilesUsers))
+	for _, u := range db.store.FilesUsers {
+		if u.FileID != fid || u.UserID != uid || u.IP != ip {
+			fileUsers = append(fileUsers, u)
+		}
+	}
+	db.store.FilesUsers = fileUsers
+
+	return nil
+}
+
+// LoadFileUserRecord loads a FileUserRecord using a file ID, user ID, and IP triple
+func (db *memw) LoadFileUserRecord(fid, uid int, ip string) (FileUserRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, u := range db.store.FilesUsers {
+		if u.FileID == fid && u.UserID == uid && u.IP == ip {
+			return u, nil
+		}
+	}
+
+	return FileUserRecord{}, nil
+}
+
+// SaveFileUserRecord saves a FileUserRecord to the database
+func (db *memw) SaveFileUserRecord(f FileUserRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	f.Time = time.Now().Unix()
+
+	// Update existing file/user relationship record
+	for i, u := range db.store.FilesUsers {
+		if u.FileID == f.FileID && u.UserID == f.UserID && u.IP == f.IP {
+			db.store.FilesUsers[i] = f
+			return nil
+		}
+	}
+
+	db.store.FilesUsers = append(db.store.FilesUsers, f)
+
+	return nil
+}
+
+// LoadFileUserRepository loads all FileUserRecords matching a defined ID and column for query
+func (db *memw) LoadFileUserRepository(id interface{}, col string) ([]FileUserRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	fileUsers := make([]FileUserRecord, 0)
+	for _, u := range db.store.FilesUsers {
+		value, err := fileUserRecordColumn(u, col)
+		if err != nil {
+			return fileUsers, err
+		}
+
+		if memMatch(id, value) {
+			fileUsers = append(fileUsers, u)
+		}
+	}
+
+	return fileUsers, nil
+}
+
+// --- PeerRecord.go ---
+
+// DeletePeerRecord deletes all PeerRecords for a peer on a file, using an info hash and peer_id pair
+func (db *memw) DeletePeerRecord(infoHash string, peerID string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	peers := make([]PeerRecord, 0, len(db.store.Peers))
+	for _, p := range db.store.Peers {
+		if p.InfoHash != infoHash || p.PeerID != peerID {
+			peers = append(peers, p)
+		}
+	}
+	db.store.Peers = peers
+
+	return nil
+}
+
+// SavePeerRecord saves a PeerRecord, replacing the peer's record for its address family
+func (db *memw) SavePeerRecord(p PeerRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	p.Time = time.Now().Unix()
+
+	// Update existing peer record
+	for i, r := range db.store.Peers {
+		if r.InfoHash == p.InfoHash && r.PeerID == p.PeerID && r.IPv6 == p.IPv6 {
+			db.store.Peers[i] = p
+			return nil
+		}
+	}
+
+	db.store.Peers = append(db.store.Peers, p)
+
+	return nil
+}
+
+// DeleteStalePeerRecords deletes PeerRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *memw) DeleteStalePeerRecords(infoHash string, interval time.Duration) (int, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	since := time.Now().Unix() - int64(interval/time.Second)
+	peers := make([]PeerRecord, 0, len(db.store.Peers))
+	for _, p := range db.store.Peers {
+		if p.InfoHash != infoHash || p.Time >= since {
+			peers = append(peers, p)
+		}
+	}
+
+	count := len(db.store.Peers) - len(peers)
+	db.store.Peers = peers
+
+	return count, nil
+}
+
+// --- ScrapeLog.go ---
+
+// scrapeLogColumn returns the value of the named column for a ScrapeLog
+func scrapeLogColumn(s ScrapeLog, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return s.ID, nil
+	case "info_hash":
+		return s.InfoHash, nil
+	case "passkey":
+		return s.Passkey, nil
+	case "ip":
+		return s.IP, nil
+	case "time":
+		return s.Time, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteScrapeLog deletes a ScrapeLog using a defined ID and column
+func (db *memw) DeleteScrapeLog(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	logs := make([]ScrapeLog, 0, len(db.store.ScrapeLogs))
+	for _, s := range db.store.ScrapeLogs {
+		value, err := scrapeLogColumn(s, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			logs = append(logs, s)
+		}
+	}
+	db.store.ScrapeLogs = logs
+
+	return nil
+}
+
+// LoadScrapeLog loads a ScrapeLog using a defined ID and column for query
+func (db *memw) LoadScrapeLog(id interface{}, col string) (ScrapeLog, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, s := range db.store.ScrapeLogs {
+		value, err := scrapeLogColumn(s, col)
+		if err != nil {
+			return ScrapeLog{}, err
+		}
+
+		if memMatch(id, value) {
+			return s, nil
+		}
+	}
+
+	return ScrapeLog{}, nil
+}
+
+// SaveScrapeLog saves a ScrapeLog to the database
+func (db *memw) SaveScrapeLog(s ScrapeLog) error {
+	db.Lock()
+	defer db.Unlock()
+
+	s.ID = db.store.nextID("scrape_log")
+	s.Time = time.Now().Unix()
+	db.store.ScrapeLogs = append(db.store.ScrapeLogs, s)
+
+	return nil
+}
+
+// SaveScrapeLogs saves a batch of ScrapeLogs to the database, keeping their scrape times
+func (db *memw) SaveScrapeLogs(logs []ScrapeLog) error {
+	db.Lock()
+	defer db.Unlock()
+
+	for _, s := range logs {
+		s.ID = db.store.nextID("scrape_log")
+		db.store.ScrapeLogs = append(db.store.ScrapeLogs, s)
+	}
+
+	return nil
+}
+
+// GetExpiredScrapeLogs returns up to limit ScrapeLogs logged before the specified time, oldest first
+func (db *memw) GetExpiredScrapeLogs(before int64, limit int) ([]ScrapeLog, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	// Logs are stored in ID order, so the first matches are the oldest
+	logs := make([]ScrapeLog, 0)
+	for _, l := range db.store.ScrapeLogs {
+		if len(logs) >= limit {
+			break
+		}
+
+		if l.Time < before {
+			logs = append(logs, l)
+		}
+	}
+
+	return logs, nil
+}
+
+// DeleteScrapeLogs deletes ScrapeLogs with the specified IDs
+func (db *memw) DeleteScrapeLogs(ids []int) error {
+	db.Lock()
+	defer db.Unlock()
+
+	deleted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	logs := make([]ScrapeLog, 0, len(db.store.ScrapeLogs))
+	for _, l := range db.store.ScrapeLogs {
+		if !deleted[l.ID] {
+			logs = append(logs, l)
+		}
+	}
+	db.store.ScrapeLogs = logs
+
+	return nil
+}
+
+// --- SessionRecord.go ---
+
+// DeleteSessionRecord deletes a SessionRecord using an info hash, peer_id, and announce key
+func (db *memw) DeleteSessionRecord(infoHash string, peerID string, key string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	sessions := make([]SessionRecord, 0, len(db.store.Sessions))
+	for _, s := range db.store.Sessions {
+		if s.InfoHash != infoHash || s.PeerID != peerID || s.Key != key {
+			sessions = append(sessions, s)
+		}
+	}
+	db.store.Sessions = sessions
+
+	return nil
+}
+
+// LoadSessionRecord loads a SessionRecord using an info hash, peer_id, and announce key
+func (db *memw) LoadSessionRecord(infoHash string, peerID string, key string) (SessionRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, s := range db.store.Sessions {
+		if s.InfoHash == infoHash && s.PeerID == peerID && s.Key == key {
+			return s, nil
+		}
+	}
+
+	return SessionRecord{}, nil
+}
+
+// SaveSessionRecord saves a SessionRecord, replacing any existing record for the same session
+func (db *memw) SaveSessionRecord(s SessionRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	s.Time = time.Now().Unix()
+
+	// Update existing session record
+	for i, r := range db.store.Sessions {
+		if r.InfoHash == s.InfoHash && r.PeerID == s.PeerID && r.Key == s.Key {
+			db.store.Sessions[i] = s
+			return nil
+		}
+	}
+
+	db.store.Sessions = append(db.store.Sessions, s)
+
+	return nil
+}
+
+// DeleteStaleSessionRecords deletes SessionRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *memw) DeleteStaleSessionRecords(infoHash string, interval time.Duration) (int, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	since := time.Now().Unix() - int64(interval/time.Second)
+	sessions := make([]SessionRecord, 0, len(db.store.Sessions))
+	for _, s := range db.store.Sessions {
+		if s.InfoHash != infoHash || s.Time >= since {
+			sessions = append(sessions, s)
+		}
+	}
+
+	count := len(db.store.Sessions) - len(sessions)
+	db.store.Sessions = sessions
+
+	return count, nil
+}
+
+// --- TorrentRecord.go ---
+
+// torrentRecordColumn returns the value of the named column for a TorrentRecord
+func torrentRecordColumn(t TorrentRecord, col string) (interface{}, error) {
+	switch col {
+	case "info_hash":
+		return t.InfoHash, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteTorrentRecord deletes a TorrentRecord using a defined ID and column
+func (db *memw) DeleteTorrentRecord(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	torrents := make([]TorrentRecord, 0, len(db.store.Torrents))
+	for _, t := range db.store.Torrents {
+		value, err := torrentRecordColumn(t, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			torrents = append(torrents, t)
+		}
+	}
+	db.store.Torrents = torrents
+
+	return nil
+}
+
+// LoadTorrentRecord loads a TorrentRecord using a defined ID and column for query
+func (db *memw) LoadTorrentRecord(id interface{}, col string) (TorrentRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, t := range db.store.Torrents {
+		value, err := torrentRecordColumn(t, col)
+		if err != nil {
+			return TorrentRecord{}, err
+		}
+
+		if memMatch(id, value) {
+			return t, nil
+		}
+	}
+
+	return TorrentRecord{}, nil
+}
+
+// SaveTorrentRecord saves a TorrentRecord to the database, replacing any existing metainfo for its info hash
+func (db *memw) SaveTorrentRecord(t TorrentRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	// Copy metainfo, so the caller cannot modify stored data
+	t.Metainfo = append([]byte(nil), t.Metainfo...)
+
+	for i, torrent := range db.store.Torrents {
+		if torrent.InfoHash == t.InfoHash {
+			db.store.Torrents[i].Metainfo = t.Metainfo
+			return nil
+		}
+	}
+
+	db.store.Torrents = append(db.store.Torrents, t)
+
+	return nil
+}
+
+// GetAllTorrentRecords returns a list of all TorrentRecords known to the database
+func (db *memw) GetAllTorrentRecords() ([]TorrentRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	torrents := make([]TorrentRecord, len(db.store.Torrents))
+	copy(torrents, db.store.Torrents)
+
+	return torrents, nil
+}
+
+// --- UserRecord.go ---
+
+// userRecordColumn returns the value of the named column for a UserRecord
+func userRecordColumn(u UserRecord, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return u.ID, nil
+	case "username":
+		return u.Username, nil
+	case "password":
+		return u.Password, nil
+	case "passkey":
+		return u.Passkey, nil
+	case "torrent_limit":
+		return u.TorrentLimit, nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteUserRecord deletes a UserRecord using a defined ID and column
+func (db *memw) DeleteUserRecord(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	users := make([]UserRecord, 0, len(db.store.Users))
+	for _, u := range db.store.Users {
+		value, err := userRecordColumn(u, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			users = append(users, u)
+		}
+	}
+	db.store.Users = users
+
+	return nil
+}
+
+// LoadUserRecord loads a UserRecord using a defined ID and column for query
+func (db *memw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, u := range db.store.Users {
+		value, err := userRecordColumn(u, col)
+		if err != nil {
+			return UserRecord{}, err
+		}
+
+		if memMatch(id, value) {
+			return u, nil
+		}
+	}
+
+	return UserRecord{}, nil
+}
+
+// SaveUserRecord saves a UserRecord to the database
+func (db *memw) SaveUserRecord(u UserRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	// Update existing user which shares a unique key with this one
+	for i, user := range db.store.Users {
+		if user.Username == u.Username || user.Password == u.Password || user.Passkey == u.Passkey {
+			u.ID = user.ID
+			db.store.Users[i] = u
+			return nil
+		}
+	}
+
+	u.ID = db.store.nextID("users")
+	db.store.Users = append(db.store.Users, u)
+
+	return nil
+}
+
+// sumFileUsers sums a value over all file/user relationships for a user
+func (db *memw) sumFileUsers(uid int, value func(FileUserRecord) int64) int64 {
+	db.RLock()
+	defer db.RUnlock()
+
+	var sum int64
+	for _, u := range db.store.FilesUsers {
+		if u.UserID == uid {
+			sum += value(u)
+		}
+	}
+
+	return sum
+}
+
+// GetUserUploaded loads the total number of bytes this user has uploaded
+func (db *memw) GetUserUploaded(uid int) (int64, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	return db.store.userTotals(uid).Uploaded, nil
+}
+
+// GetUserDownloaded loads the total number of bytes this user has downloaded
+func (db *memw) GetUserDownloaded(uid int) (int64, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	return db.store.userTotals(uid).Downloaded, nil
+}
+
+// AddUserTraffic adds uploaded and downloaded bytes to this user's lifetime totals
+func (db *memw) AddUserTraffic(uid int, uploaded int64, downloaded int64) error {
+	db.Lock()
+	defer db.Unlock()
+
+	db.store.addUserTraffic(uid, uploaded, downloaded)
+	return nil
+}
+
+// userTotals returns a user's lifetime totals, which are zero until their first traffic is accounted
+func (s *memStore) userTotals(uid int) memUserTotals {
+	for _, t := range s.UserTotals {
+		if t.UserID == uid {
+			return t
+		}
+	}
+
+	return memUserTotals{UserID: uid}
+}
+
+// addUserTraffic adds uploaded and downloaded bytes to a user's lifetime totals
+func (s *memStore) addUserTraffic(uid int, uploaded int64, downloaded int64) {
+	for i, t := range s.UserTotals {
+		if t.UserID == uid {
+			s.UserTotals[i].Uploaded += uploaded
+			s.UserTotals[i].Downloaded += downloaded
+			return
+		}
+	}
+
+	s.UserTotals = append(s.UserTotals, memUserTotals{UserID: uid, Uploaded: uploaded, Downloaded: downloaded})
+}
+
+// GetUserSeeding calculates the total number of files this user is actively seeding
+func (db *memw) GetUserSeeding(uid int) (int, error) {
+	return int(db.sumFileUsers(uid, func(u FileUserRecord) int64 {
+		return int64(memBool(u.Active && u.Completed && u.Left == 0))
+	})), nil
+}
+
+// GetUserLeeching calculates the total number of files this user is actively leeching
+func (db *memw) GetUserLeeching(uid int) (int, error) {
+	return int(db.sumFileUsers(uid, func(u FileUserRecord) int64 {
+		return int64(memBool(u.Active && !u.Completed && u.Left > 0))
+	})), nil
+}
+
+// GetAllUserRecords returns a list of all UserRecords known to the database
+func (db *memw) GetAllUserRecords() ([]UserRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	users := make([]UserRecord, len(db.store.Users))
+	copy(users, db.store.Users)
+
+	return users, nil
+}
+
+// --- WhitelistRecord.go ---
+
+// whitelistRecordColumn returns the value of the named column for a WhitelistRecord
+func whitelistRecordColumn(w WhitelistRecord, col string) (interface{}, error) {
+	switch col {
+	case "id":
+		return w.ID, nil
+	case "client":
+		return w.Client, nil
+	case "approved":
+		return memBool(w.Approved), nil
+	}
+
+	return nil, ErrUnknownColumn
+}
+
+// DeleteWhitelistRecord deletes a WhitelistRecord using a defined ID and column
+func (db *memw) DeleteWhitelistRecord(id interface{}, col string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	whitelist := make([]WhitelistRecord, 0, len(db.store.Whitelist))
+	for _, w := range db.store.Whitelist {
+		value, err := whitelistRecordColumn(w, col)
+		if err != nil {
+			return err
+		}
+
+		if !memMatch(id, value) {
+			whitelist = append(whitelist, w)
+		}
+	}
+	db.store.Whitelist = whitelist
+
+	return nil
+}
+
+// LoadWhitelistRecord loads a WhitelistRecord using a defined ID and column for query
+func (db *memw) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, w := range db.store.Whitelist {
+		value, err := whitelistRecordColumn(w, col)
+		if err != nil {
+			return WhitelistRecord{}, err
+		}
+
+		if memMatch(id, value) {
+			return w, nil
+		}
+	}
+
+	return WhitelistRecord{}, nil
+}
+
+// SaveWhitelistRecord saves a WhitelistRecord to the database
+func (db *memw) SaveWhitelistRecord(w WhitelistRecord) error {
+	db.Lock()
+	defer db.Unlock()
+
+	// Existing clients are left untouched, matching the SQL backends
+	for _, wl := range db.store.Whitelist {
+		if wl.Client == w.Client {
+			return nil
+		}
+	}
+
+	w.ID = db.store.nextID("whitelist")
+	db.store.Whitelist = append(db.store.Whitelist, w)
+
+	return nil
+}
+
+// GetAllWhitelistRecords returns a list of all WhitelistRecords known to the database
+func (db *memw) GetAllWhitelistRecords() ([]WhitelistRecord, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	whitelist := make([]WhitelistRecord, len(db.store.Whitelist))
+	copy(whitelist, db.store.Whitelist)
+
+	return whitelist, nil
+}
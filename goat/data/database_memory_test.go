@@ -0,0 +1,144 @@
+// +build memory
+
+package data
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestMemorySnapshot verifies that the memory database can be snapshotted to disk and restored
+func TestMemorySnapshot(t *testing.T) {
+	log.Println("TestMemorySnapshot()")
+
+	// Create a temporary snapshot location
+	dir, err := ioutil.TempDir("", "goat")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/goat.json"
+
+	// Generate a database with a single file
+	db := &memw{store: newMemStore()}
+	if err := db.SaveFileRecord(FileRecord{InfoHash: "deadbeef", Verified: true}); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Write snapshot to disk
+	if err := db.Snapshot(path); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err.Error())
+	}
+
+	// Restore snapshot into a new database
+	db2 := &memw{store: newMemStore()}
+	if err := db2.Restore(path); err != nil {
+		t.Fatalf("Failed to restore snapshot: %s", err.Error())
+	}
+
+	// Verify file was restored
+	file, err := db2.LoadFileRecord("deadbeef", "info_hash")
+	if err != nil || file.ID != 1 || !file.Verified {
+		t.Fatalf("Restored file does not match: %v", file)
+	}
+
+	// Verify auto-increment IDs continue where they left off
+	if err := db2.SaveFileRecord(FileRecord{InfoHash: "beefdead"}); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	file2, err := db2.LoadFileRecord("beefdead", "info_hash")
+	if err != nil || file2.ID != 2 {
+		t.Fatalf("ID, expected 2, got %d", file2.ID)
+	}
+
+	// Verify a missing snapshot results in an empty database
+	db3 := &memw{store: newMemStore()}
+	if err := db3.Restore(dir + "/missing.json"); err != nil {
+		t.Fatalf("Failed to restore missing snapshot: %s", err.Error())
+	}
+}
+
+// TestMemoryRestoreFailure verifies that a snapshot which cannot be restored fails every connection, and is not
+// overwritten on close
+func TestMemoryRestoreFailure(t *testing.T) {
+	log.Println("TestMemoryRestoreFailure()")
+
+	// Create a corrupt snapshot
+	dir, err := ioutil.TempDir("", "goat")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/goat.json"
+
+	if err := ioutil.WriteFile(path, []byte("{corrupt"), 0644); err != nil {
+		t.Fatalf("Failed to write snapshot: %s", err.Error())
+	}
+
+	// Swap out the shared database for the duration of the test
+	oldDB, oldErr, oldPath := memwdb, memwdbErr, MemoryDBPath
+	memwdb, memwdbErr, MemoryDBPath = nil, nil, &path
+	defer func() {
+		memwdb, memwdbErr, MemoryDBPath = oldDB, oldErr, oldPath
+	}()
+
+	// Verify every connection fails
+	for i := 0; i < 2; i++ {
+		if db, err := DBConnectFunc(); err == nil || db != nil {
+			t.Fatalf("[%02d] Connected to database with corrupt snapshot", i)
+		}
+	}
+
+	// Verify the corrupt snapshot is left alone
+	DBCloseFunc()
+	if buf, err := ioutil.ReadFile(path); err != nil || string(buf) != "{corrupt" {
+		t.Fatalf("Snapshot overwritten on close: %s", string(buf))
+	}
+}
+
+// TestMemoryPeerListOrder verifies that the memory database lists the most recently announced peers first,
+// as the SQL backends do, or peers by peer_id when a stable order is requested
+func TestMemoryPeerListOrder(t *testing.T) {
+	log.Println("TestMemoryPeerListOrder()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate peers which announced oldest first, in reverse peer_id order
+	now := time.Now().Unix()
+	db := &memw{store: newMemStore()}
+	db.store.Peers = []PeerRecord{
+		{InfoHash: "deadbeef", PeerID: "cc", IP: "10.0.0.3", Port: 6883, Time: now - 20},
+		{InfoHash: "deadbeef", PeerID: "bb", IP: "10.0.0.2", Port: 6882, Time: now - 10},
+		{InfoHash: "deadbeef", PeerID: "aa", IP: "10.0.0.1", Port: 6881, Time: now},
+	}
+
+	for i, test := range []struct {
+		q      peerQuery
+		result []string
+	}{
+		{peerQuery{Limit: 50}, []string{"aa", "bb", "cc"}},
+		{peerQuery{Limit: 2}, []string{"aa", "bb"}},
+		{peerQuery{Stable: true, Offset: 1, Limit: 50}, []string{"bb", "cc"}},
+	} {
+		peers, err := db.GetFileRecordPeerList("deadbeef", test.q)
+		if err != nil || len(peers) != len(test.result) {
+			t.Fatalf("[%02d] Peer list, expected %v, got %v", i, test.result, peers)
+		}
+		for j, p := range peers {
+			if p.PeerID != test.result[j] {
+				t.Fatalf("[%02d] Peer list, expected %v, got %v", i, test.result, peers)
+			}
+		}
+	}
+}
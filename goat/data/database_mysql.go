@@ -1,4 +1,4 @@
-// +build !ql
+// +build !ql,!memory,!postgres
 
 package data
 
@@ -6,10 +6,14 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
 
+	"code.google.com/p/go.net/context"
+
 	// Bring in the MySQL driver
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -19,6 +23,14 @@ import (
 func init() {
 	// DBConnectFunc connects to MySQL database
 	DBConnectFunc = func() (dbModel, error) {
+		dbwdbMutex.Lock()
+		defer dbwdbMutex.Unlock()
+
+		// Reuse the shared connection pool, if already open
+		if dbwdb != nil {
+			return dbwdb, nil
+		}
+
 		var conn string
 		// Generate connection string using configuration file
 		if MySQLDSN == nil || *MySQLDSN == "" {
@@ -28,9 +40,30 @@ func init() {
 			conn = *MySQLDSN
 		}
 
-		// Return connection and associated errors
+		// Open connection pool, and apply configured pool limits
 		db, err := sqlx.Connect("mysql", conn)
-		return &dbw{db}, err
+		if err != nil {
+			return nil, err
+		}
+		dbPoolLimits(db.DB)
+
+		dbwdb = &dbw{DB: db}
+		return dbwdb, nil
+	}
+
+	// DBCloseFunc closes the shared MySQL connection pool
+	DBCloseFunc = func() {
+		dbwdbMutex.Lock()
+		defer dbwdbMutex.Unlock()
+
+		if dbwdb == nil {
+			return
+		}
+
+		if err := dbwdb.DB.Close(); err != nil {
+			log.Println(err.Error())
+		}
+		dbwdb = nil
 	}
 
 	// DBNameFunc returns the name of this backend
@@ -40,13 +73,13 @@ func init() {
 
 	// DBPingFunc tests the connection to the MySQL database
 	DBPingFunc = func() bool {
-		db, err := DBConnect()
+		db, err := DBConnectFunc()
 		if err != nil {
 			log.Println(err.Error())
 			return false
 		}
 
-		if err = db.(*dbw).Close(); err != nil {
+		if err = db.(*dbw).Ping(); err != nil {
 			log.Println(err.Error())
 			return false
 		}
@@ -55,14 +88,60 @@ func init() {
 	}
 }
 
+var (
+	// dbwdb is the shared MySQL connection pool, opened on first use
+	dbwdb *dbw
+
+	// dbwdbMutex guards opening and closing of the shared connection pool
+	dbwdbMutex sync.Mutex
+)
+
 // dbw contains a sqlx MySQL database connection
 type dbw struct {
 	*sqlx.DB
+
+	// ctx is bound by withContext, and cancels queries made using this handle once done
+	ctx context.Context
 }
 
-// Close database connection
+// Close releases this handle; the shared connection pool remains open until DBCloseFunc is called
 func (db *dbw) Close() error {
-	return db.DB.Close()
+	return nil
+}
+
+// withContext returns a handle on the shared connection pool whose queries are cancelled once ctx is done.
+// NOTE: transactions are not bound to ctx, so a transaction is never abandoned part of the way through.
+func (db *dbw) withContext(ctx context.Context) dbModel {
+	return &dbw{DB: db.DB, ctx: ctx}
+}
+
+// queryContext returns the context bound to this handle, if any
+func (db *dbw) queryContext() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+
+	return db.ctx
+}
+
+// Exec executes a query, cancelling it once this handle's context is done
+func (db *dbw) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(db.queryContext(), query, args...)
+}
+
+// Get retrieves a single row, cancelling the query once this handle's context is done
+func (db *dbw) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.GetContext(db.queryContext(), dest, query, args...)
+}
+
+// Queryx retrieves rows, cancelling the query once this handle's context is done
+func (db *dbw) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return db.DB.QueryxContext(db.queryContext(), query, args...)
+}
+
+// Select retrieves rows into a slice, cancelling the query once this handle's context is done
+func (db *dbw) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.SelectContext(db.queryContext(), dest, query, args...)
 }
 
 // --- AnnounceLog.go ---
@@ -89,15 +168,59 @@ func (db *dbw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error)
 // SaveAnnounceLog saves an AnnounceLog to database
 func (db *dbw) SaveAnnounceLog(a AnnounceLog) error {
 	query := "INSERT INTO announce_log " +
-		"(`info_hash`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
-		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP());"
+		"(`info_hash`, `peer_id`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP());"
 
 	tx := db.MustBegin()
-	tx.Exec(query, a.InfoHash, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client)
+	tx.Exec(query, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client)
 
 	return tx.Commit()
 }
 
+// SaveAnnounceLogs saves a batch of AnnounceLogs to database, using a single multi-row insert
+func (db *dbw) SaveAnnounceLogs(logs []AnnounceLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO announce_log " +
+		"(`info_hash`, `peer_id`, `passkey`, `key`, `ip`, `port`, `udp`, `uploaded`, `downloaded`, `left`, `event`, `client`, `time`) " +
+		"VALUES " + strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), ", len(logs)), ", ") + ";"
+
+	args := make([]interface{}, 0, len(logs)*13)
+	for _, a := range logs {
+		args = append(args, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client, a.Time)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// GetExpiredAnnounceLogs returns up to limit AnnounceLogs logged before the specified time, oldest first
+func (db *dbw) GetExpiredAnnounceLogs(before int64, limit int) ([]AnnounceLog, error) {
+	logs := make([]AnnounceLog, 0)
+	if err := db.Select(&logs, "SELECT * FROM announce_log WHERE `time` < ? ORDER BY id LIMIT ?;", before, limit); err != nil && err != sql.ErrNoRows {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+// DeleteAnnounceLogs deletes AnnounceLogs with the specified IDs
+func (db *dbw) DeleteAnnounceLogs(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := db.Exec("DELETE FROM announce_log WHERE id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+");", args...)
+	return err
+}
+
 // --- APIKey.go ---
 
 // DeleteAPIKey deletes an APIKey using a defined ID and column
@@ -142,6 +265,8 @@ func (db *dbw) GetAllAPIKeys() ([]APIKey, error) {
 		return keys, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&key); err != nil {
 			break
@@ -153,6 +278,52 @@ func (db *dbw) GetAllAPIKeys() ([]APIKey, error) {
 	return keys, nil
 }
 
+// --- ClientRuleRecord.go ---
+
+// DeleteClientRuleRecord deletes a ClientRuleRecord using a defined ID and column
+func (db *dbw) DeleteClientRuleRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec("DELETE FROM client_rules WHERE `"+col+"` = ?", id)
+
+	return tx.Commit()
+}
+
+// LoadClientRuleRecord loads a ClientRuleRecord using a defined ID and column for query
+func (db *dbw) LoadClientRuleRecord(id interface{}, col string) (ClientRuleRecord, error) {
+	query := "SELECT * FROM client_rules WHERE `" + col + "`=? ORDER BY id LIMIT 1;"
+
+	result := ClientRuleRecord{}
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return ClientRuleRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveClientRuleRecord saves a ClientRuleRecord to the database, updating any rule with the same prefix and
+// version range
+func (db *dbw) SaveClientRuleRecord(c ClientRuleRecord) error {
+	query := "INSERT INTO client_rules " +
+		"(`prefix`, `min_version`, `max_version`, `blacklist`, `description`) " +
+		"VALUES (?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE `blacklist`=values(`blacklist`), `description`=values(`description`);"
+
+	tx := db.MustBegin()
+	tx.Exec(query, c.Prefix, c.MinVersion, c.MaxVersion, c.Blacklist, c.Description)
+
+	return tx.Commit()
+}
+
+// GetAllClientRuleRecords returns a list of all ClientRuleRecords known to the database
+func (db *dbw) GetAllClientRuleRecords() ([]ClientRuleRecord, error) {
+	rules := make([]ClientRuleRecord, 0)
+	if err := db.Select(&rules, "SELECT * FROM client_rules ORDER BY id;"); err != nil && err != sql.ErrNoRows {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
 // --- FileRecord.go ---
 
 // DeleteFileRecord deletes an AnnounceLog using a defined ID and column
@@ -177,13 +348,14 @@ func (db *dbw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 // SaveFileRecord saves a FileRecord to the database
 func (db *dbw) SaveFileRecord(f FileRecord) error {
 	query := "INSERT INTO files " +
-		"(`info_hash`, `verified`, `create_time`, `update_time`) " +
-		"VALUES (?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP()) " +
+		"(`info_hash`, `verified`, `name`, `size`, `file_count`, `piece_length`, `create_time`, `update_time`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP()) " +
 		"ON DUPLICATE KEY UPDATE " +
-		"`verified`=values(`verified`), `update_time`=UNIX_TIMESTAMP();"
+		"`verified`=values(`verified`), `name`=values(`name`), `size`=values(`size`), " +
+		"`file_count`=values(`file_count`), `piece_length`=values(`piece_length`), `update_time`=UNIX_TIMESTAMP();"
 
 	tx := db.MustBegin()
-	tx.Exec(query, f.InfoHash, f.Verified)
+	tx.Exec(query, f.InfoHash, f.Verified, f.Name, f.Size, f.FileCount, f.PieceLength)
 
 	return tx.Commit()
 }
@@ -227,41 +399,76 @@ func (db *dbw) CountFileRecordLeechers(id int) (int, error) {
 	return result.Leechers, nil
 }
 
-// GetFileRecordPeerList returns a list of Peers, containing IP/port pairs
-func (db *dbw) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]Peer, error) {
-	// Get IP and port of all peers who have recently announced on this file
-
-	// Choose query depending on if client is HTTP or not
-	var query string
-	if http {
-		// For HTTP, we can intelligently select active peers using the files_users table
-		query = `SELECT DISTINCT announce_log.ip,announce_log.port FROM announce_log
-			JOIN files ON announce_log.info_hash = files.info_hash
-			JOIN files_users ON files.id = files_users.file_id
-			AND announce_log.ip = files_users.ip
-			WHERE files_users.active=1
-			AND files.info_hash=?
-			AND (UNIX_TIMESTAMP() - ?) <= announce_log.time
-			LIMIT ?;`
-	} else {
-		// Because UDP announces are anonymous, we give the client a "best guess" of peers
-		// who have been active in the current announce period.
-		query = `SELECT DISTINCT announce_log.ip,announce_log.port FROM announce_log
-			JOIN files ON announce_log.info_hash = files.info_hash
-			WHERE files.info_hash=?
-			AND (UNIX_TIMESTAMP() - ?) <= announce_log.time
-			LIMIT ?;`
+// CountAllFileRecordStats counts the completions, seeders, and leechers on every file, in a single query
+func (db *dbw) CountAllFileRecordStats() (map[int]FileStats, error) {
+	// Counts are defined as in CountFileRecordCompleted, CountFileRecordSeeders, and CountFileRecordLeechers
+	query := "SELECT file_id, " +
+		"SUM(completed = 1 AND `left` = 0) AS completed, " +
+		"SUM(active = 1 AND completed = 1 AND `left` = 0) AS seeders, " +
+		"SUM(active = 1 AND completed = 0 AND `left` > 0) AS leechers " +
+		"FROM files_users GROUP BY file_id;"
+	result := []struct {
+		FileID int `db:"file_id"`
+		FileStats
+	}{}
+
+	if err := db.Select(&result, query); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	stats := make(map[int]FileStats, len(result))
+	for _, r := range result {
+		stats[r.FileID] = r.FileStats
 	}
 
+	return stats, nil
+}
+
+// mysqlPeerFilter selects the reachable peers on a file which recently announced, and which a peerQuery does
+// not exclude, using the arguments generated by mysqlPeerArgs
+const mysqlPeerFilter = "WHERE `info_hash`=? AND (UNIX_TIMESTAMP() - ?) <= `time` AND `port` <> 0 " +
+	"AND (? = '' OR `peer_id` <> ?) AND NOT (`ip` = ? AND `port` = ?) AND (? = FALSE OR `left` > 0) "
+
+// mysqlPeerArgs generates the arguments of mysqlPeerFilter for a peerQuery
+func mysqlPeerArgs(infoHash string, q peerQuery) []interface{} {
+	return []interface{}{infoHash, common.Static.Config.Interval, q.PeerID, q.PeerID, q.IP, q.Port, q.Leechers}
+}
+
+// CountFileRecordPeers counts the peers on a file which match a peerQuery
+func (db *dbw) CountFileRecordPeers(infoHash string, q peerQuery) (int, error) {
+	query := "SELECT COUNT(*) AS peers FROM peers " + mysqlPeerFilter + ";"
+
+	result := struct{ Peers int }{0}
+	if err := db.Get(&result, query, mysqlPeerArgs(infoHash, q)...); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Peers, nil
+}
+
+// GetFileRecordPeerList returns a list of Peers, containing IP/port pairs, which match a peerQuery
+func (db *dbw) GetFileRecordPeerList(infoHash string, q peerQuery) ([]Peer, error) {
+	// Get IP and port of peers who have recently announced on this file, most recent first unless a
+	// stable order is needed, and whether they are a seeder
+	order := "ORDER BY `time` DESC "
+	if q.Stable {
+		order = "ORDER BY `peer_id`, `ip` "
+	}
+
+	query := "SELECT `ip`, `port`, `peer_id`, `left`=0 AS seeder FROM peers " +
+		mysqlPeerFilter + order + "LIMIT ? OFFSET ?;"
+
 	// Create output list
 	peers := make([]Peer, 0)
 
 	// Perform query
-	rows, err := db.Queryx(query, infoHash, common.Static.Config.Interval, limit)
+	rows, err := db.Queryx(query, append(mysqlPeerArgs(infoHash, q), q.Limit, q.Offset)...)
 	if err != nil && err != sql.ErrNoRows {
 		return peers, err
 	}
 
+	defer rows.Close()
+
 	// Scan each peer from database
 	peer := Peer{}
 	for rows.Next() {
@@ -289,6 +496,8 @@ func (db *dbw) GetInactiveUserInfo(fid int, interval time.Duration) (users []pee
 
 	var rows *sqlx.Rows
 	if rows, err = db.Queryx(query, checkInterval, fid); err == nil && err != sql.ErrNoRows {
+		defer rows.Close()
+
 		for rows.Next() {
 			if err = rows.StructScan(&result); err == nil {
 				users = append(users, result)
@@ -321,6 +530,8 @@ func (db *dbw) GetAllFileRecords() ([]FileRecord, error) {
 		return files, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&file); err != nil {
 			break
@@ -380,6 +591,8 @@ func (db *dbw) LoadFileUserRepository(id interface{}, col string) ([]FileUserRec
 		return files, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&user); err != nil {
 			log.Println(err.Error())
@@ -392,6 +605,46 @@ func (db *dbw) LoadFileUserRepository(id interface{}, col string) ([]FileUserRec
 	return files, nil
 }
 
+// --- PeerRecord.go ---
+
+// DeletePeerRecord deletes all PeerRecords for a peer on a file, using an info hash and peer_id pair
+func (db *dbw) DeletePeerRecord(infoHash string, peerID string) error {
+	tx := db.MustBegin()
+	tx.Exec("DELETE FROM peers WHERE `info_hash`=? AND `peer_id`=?", infoHash, peerID)
+
+	return tx.Commit()
+}
+
+// SavePeerRecord saves a PeerRecord to the database, replacing the peer's record for its address family
+func (db *dbw) SavePeerRecord(p PeerRecord) error {
+	// Insert or update a peer record
+	query := "INSERT INTO peers " +
+		"(`info_hash`, `peer_id`, `ipv6`, `user_id`, `ip`, `port`, `left`, `time`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP()) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"`user_id`=values(`user_id`), `ip`=values(`ip`), `port`=values(`port`), `left`=values(`left`), " +
+		"`time`=UNIX_TIMESTAMP();"
+
+	tx := db.MustBegin()
+	tx.Exec(query, p.InfoHash, p.PeerID, p.IPv6, p.UserID, p.IP, p.Port, p.Left)
+
+	return tx.Commit()
+}
+
+// DeleteStalePeerRecords deletes PeerRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *dbw) DeleteStalePeerRecords(infoHash string, interval time.Duration) (int, error) {
+	query := "DELETE FROM peers WHERE `info_hash`=? AND `time` < (UNIX_TIMESTAMP() - ?);"
+
+	res, err := db.Exec(query, infoHash, int64(interval/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
 // --- ScrapeLog.go ---
 
 // DeleteScrapeLog deletes a ScrapeLog using a defined ID and column
@@ -426,6 +679,143 @@ func (db *dbw) SaveScrapeLog(s ScrapeLog) error {
 	return tx.Commit()
 }
 
+// SaveScrapeLogs saves a batch of ScrapeLogs to the database, using a single multi-row insert
+func (db *dbw) SaveScrapeLogs(logs []ScrapeLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO scrape_log " +
+		"(`info_hash`, `passkey`, `ip`, `time`) " +
+		"VALUES " + strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(logs)), ", ") + ";"
+
+	args := make([]interface{}, 0, len(logs)*4)
+	for _, s := range logs {
+		args = append(args, s.InfoHash, s.Passkey, s.IP, s.Time)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// GetExpiredScrapeLogs returns up to limit ScrapeLogs logged before the specified time, oldest first
+func (db *dbw) GetExpiredScrapeLogs(before int64, limit int) ([]ScrapeLog, error) {
+	logs := make([]ScrapeLog, 0)
+	if err := db.Select(&logs, "SELECT * FROM scrape_log WHERE `time` < ? ORDER BY id LIMIT ?;", before, limit); err != nil && err != sql.ErrNoRows {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+// DeleteScrapeLogs deletes ScrapeLogs with the specified IDs
+func (db *dbw) DeleteScrapeLogs(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := db.Exec("DELETE FROM scrape_log WHERE id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+");", args...)
+	return err
+}
+
+// --- SessionRecord.go ---
+
+// DeleteSessionRecord deletes a SessionRecord using an info hash, peer_id, and announce key
+func (db *dbw) DeleteSessionRecord(infoHash string, peerID string, key string) error {
+	tx := db.MustBegin()
+	tx.Exec("DELETE FROM sessions WHERE `info_hash`=? AND `peer_id`=? AND `key`=?", infoHash, peerID, key)
+
+	return tx.Commit()
+}
+
+// LoadSessionRecord loads a SessionRecord using an info hash, peer_id, and announce key
+func (db *dbw) LoadSessionRecord(infoHash string, peerID string, key string) (SessionRecord, error) {
+	query := "SELECT * FROM sessions WHERE `info_hash`=? AND `peer_id`=? AND `key`=?;"
+
+	result := SessionRecord{}
+	if err := db.Get(&result, query, infoHash, peerID, key); err != nil && err != sql.ErrNoRows {
+		return SessionRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveSessionRecord saves a SessionRecord to the database, replacing any existing record for the same session
+func (db *dbw) SaveSessionRecord(s SessionRecord) error {
+	// Insert or update a session record
+	query := "INSERT INTO sessions " +
+		"(`info_hash`, `peer_id`, `key`, `user_id`, `uploaded`, `downloaded`, `time`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, UNIX_TIMESTAMP()) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"`user_id`=values(`user_id`), `uploaded`=values(`uploaded`), `downloaded`=values(`downloaded`), " +
+		"`time`=UNIX_TIMESTAMP();"
+
+	tx := db.MustBegin()
+	tx.Exec(query, s.InfoHash, s.PeerID, s.Key, s.UserID, s.Uploaded, s.Downloaded)
+
+	return tx.Commit()
+}
+
+// DeleteStaleSessionRecords deletes SessionRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *dbw) DeleteStaleSessionRecords(infoHash string, interval time.Duration) (int, error) {
+	query := "DELETE FROM sessions WHERE `info_hash`=? AND `time` < (UNIX_TIMESTAMP() - ?);"
+
+	res, err := db.Exec(query, infoHash, int64(interval/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// --- TorrentRecord.go ---
+
+// DeleteTorrentRecord deletes a TorrentRecord using a defined ID and column
+func (db *dbw) DeleteTorrentRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec("DELETE FROM torrents WHERE `"+col+"` = ?", id)
+
+	return tx.Commit()
+}
+
+// LoadTorrentRecord loads a TorrentRecord using a defined ID and column for query
+func (db *dbw) LoadTorrentRecord(id interface{}, col string) (TorrentRecord, error) {
+	result := TorrentRecord{}
+	if err := db.Get(&result, "SELECT * FROM torrents WHERE `"+col+"`=?", id); err != nil && err != sql.ErrNoRows {
+		return TorrentRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveTorrentRecord saves a TorrentRecord to the database, replacing any existing metainfo for its info hash
+func (db *dbw) SaveTorrentRecord(t TorrentRecord) error {
+	query := "INSERT INTO torrents (`info_hash`, `metainfo`) VALUES (?, ?) " +
+		"ON DUPLICATE KEY UPDATE `metainfo`=values(`metainfo`);"
+
+	tx := db.MustBegin()
+	tx.Exec(query, t.InfoHash, t.Metainfo)
+
+	return tx.Commit()
+}
+
+// GetAllTorrentRecords returns a list of all TorrentRecords known to the database
+func (db *dbw) GetAllTorrentRecords() ([]TorrentRecord, error) {
+	torrents := make([]TorrentRecord, 0)
+	if err := db.Select(&torrents, "SELECT * FROM torrents;"); err != nil && err != sql.ErrNoRows {
+		return torrents, err
+	}
+
+	return torrents, nil
+}
+
 // --- UserRecord.go ---
 
 // DeleteUserRecord deletes a UserRecord using a defined ID and column
@@ -462,10 +852,10 @@ func (db *dbw) SaveUserRecord(u UserRecord) error {
 	return tx.Commit()
 }
 
-// GetUserUploaded calculates the total number of bytes this user has uploaded
+// GetUserUploaded loads the total number of bytes this user has uploaded
 func (db *dbw) GetUserUploaded(uid int) (int64, error) {
-	// Calculate sum of this user's upload via their file/user relationship records
-	query := "SELECT SUM(uploaded) AS uploaded FROM files_users WHERE user_id=?;"
+	// Load this user's lifetime upload, which is zero until their first traffic is accounted
+	query := "SELECT uploaded FROM user_totals WHERE user_id=?;"
 
 	result := struct{ Uploaded int64 }{0}
 	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
@@ -475,10 +865,10 @@ func (db *dbw) GetUserUploaded(uid int) (int64, error) {
 	return result.Uploaded, nil
 }
 
-// GetUserDownloaded calculates the total number of bytes this user has downloaded
+// GetUserDownloaded loads the total number of bytes this user has downloaded
 func (db *dbw) GetUserDownloaded(uid int) (int64, error) {
-	// Calculate sum of this user's download via their file/user relationship records
-	query := "SELECT SUM(downloaded) AS downloaded FROM files_users WHERE user_id=?;"
+	// Load this user's lifetime download, which is zero until their first traffic is accounted
+	query := "SELECT downloaded FROM user_totals WHERE user_id=?;"
 
 	result := struct{ Downloaded int64 }{0}
 	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
@@ -488,6 +878,19 @@ func (db *dbw) GetUserDownloaded(uid int) (int64, error) {
 	return result.Downloaded, nil
 }
 
+// AddUserTraffic adds uploaded and downloaded bytes to this user's lifetime totals
+func (db *dbw) AddUserTraffic(uid int, uploaded int64, downloaded int64) error {
+	// Insert or increment this user's totals
+	query := "INSERT INTO user_totals (`user_id`, `uploaded`, `downloaded`) VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"`uploaded`=`uploaded`+values(`uploaded`), `downloaded`=`downloaded`+values(`downloaded`);"
+
+	tx := db.MustBegin()
+	tx.Exec(query, uid, uploaded, downloaded)
+
+	return tx.Commit()
+}
+
 // GetUserSeeding calculates the total number of files this user is actively seeding
 func (db *dbw) GetUserSeeding(uid int) (int, error) {
 	// Calculate sum of this user's seeding torrents via their file/user relationship records
@@ -523,6 +926,8 @@ func (db *dbw) GetAllUserRecords() ([]UserRecord, error) {
 		return users, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		if err = rows.StructScan(&user); err != nil {
 			break
@@ -570,3 +975,13 @@ func (db *dbw) SaveWhitelistRecord(w WhitelistRecord) error {
 
 	return tx.Commit()
 }
+
+// GetAllWhitelistRecords returns a list of all WhitelistRecords known to the database
+func (db *dbw) GetAllWhitelistRecords() ([]WhitelistRecord, error) {
+	whitelist := make([]WhitelistRecord, 0)
+	if err := db.Select(&whitelist, "SELECT * FROM whitelist;"); err != nil && err != sql.ErrNoRows {
+		return whitelist, err
+	}
+
+	return whitelist, nil
+}
@@ -0,0 +1,1011 @@
+// +build postgres
+
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/jmoiron/sqlx"
+	// Bring in the PostgreSQL driver
+	_ "github.com/lib/pq"
+)
+
+// init performs startup routines for database_postgres
+func init() {
+	// DBConnectFunc connects to PostgreSQL database
+	DBConnectFunc = func() (dbModel, error) {
+		pgwdbMutex.Lock()
+		defer pgwdbMutex.Unlock()
+
+		// Reuse the shared connection pool, if already open
+		if pgwdb != nil {
+			return pgwdb, nil
+		}
+
+		var conn string
+		// Generate connection string using configuration file
+		if PostgresDSN == nil || *PostgresDSN == "" {
+			conn = fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", common.Static.Config.DB.Username, common.Static.Config.DB.Password, common.Static.Config.DB.Database)
+		} else {
+			// Use connection string passed by command line flag
+			conn = *PostgresDSN
+		}
+
+		// Open connection pool, and apply configured pool limits
+		db, err := sqlx.Connect("postgres", conn)
+		if err != nil {
+			return nil, err
+		}
+		dbPoolLimits(db.DB)
+
+		pgwdb = &pgw{DB: db}
+		return pgwdb, nil
+	}
+
+	// DBCloseFunc closes the shared PostgreSQL connection pool
+	DBCloseFunc = func() {
+		pgwdbMutex.Lock()
+		defer pgwdbMutex.Unlock()
+
+		if pgwdb == nil {
+			return
+		}
+
+		if err := pgwdb.DB.Close(); err != nil {
+			log.Println(err.Error())
+		}
+		pgwdb = nil
+	}
+
+	// DBNameFunc returns the name of this backend
+	DBNameFunc = func() string {
+		return "PostgreSQL"
+	}
+
+	// DBPingFunc tests the connection to the PostgreSQL database
+	DBPingFunc = func() bool {
+		db, err := DBConnectFunc()
+		if err != nil {
+			log.Println(err.Error())
+			return false
+		}
+
+		if err = db.(*pgw).Ping(); err != nil {
+			log.Println(err.Error())
+			return false
+		}
+
+		return true
+	}
+}
+
+// pgNow is the PostgreSQL equivalent of MySQL's UNIX_TIMESTAMP()
+const pgNow = "extract(epoch FROM now())::integer"
+
+var (
+	// pgwdb is the shared PostgreSQL connection pool, opened on first use
+	pgwdb *pgw
+
+	// pgwdbMutex guards opening and closing of the shared connection pool
+	pgwdbMutex sync.Mutex
+)
+
+// pgValues generates a VALUES list of numbered placeholders, for a multi-row insert
+func pgValues(rows int, cols int) string {
+	values := make([]string, rows)
+	for r := 0; r < rows; r++ {
+		params := make([]string, cols)
+		for c := 0; c < cols; c++ {
+			params[c] = "$" + strconv.Itoa(r*cols+c+1)
+		}
+
+		values[r] = "(" + strings.Join(params, ", ") + ")"
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// pgw contains a sqlx PostgreSQL database connection
+type pgw struct {
+	*sqlx.DB
+
+	// ctx is bound by withContext, and cancels queries made using this handle once done
+	ctx context.Context
+}
+
+// Close releases this handle; the shared connection pool remains open until DBCloseFunc is called
+func (db *pgw) Close() error {
+	return nil
+}
+
+// withContext returns a handle on the shared connection pool whose queries are cancelled once ctx is done.
+// NOTE: transactions are not bound to ctx, so a transaction is never abandoned part of the way through.
+func (db *pgw) withContext(ctx context.Context) dbModel {
+	return &pgw{DB: db.DB, ctx: ctx}
+}
+
+// queryContext returns the context bound to this handle, if any
+func (db *pgw) queryContext() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+
+	return db.ctx
+}
+
+// Exec executes a query, cancelling it once this handle's context is done
+func (db *pgw) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(db.queryContext(), query, args...)
+}
+
+// Get retrieves a single row, cancelling the query once this handle's context is done
+func (db *pgw) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.GetContext(db.queryContext(), dest, query, args...)
+}
+
+// Queryx retrieves rows, cancelling the query once this handle's context is done
+func (db *pgw) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return db.DB.QueryxContext(db.queryContext(), query, args...)
+}
+
+// Select retrieves rows into a slice, cancelling the query once this handle's context is done
+func (db *pgw) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.SelectContext(db.queryContext(), dest, query, args...)
+}
+
+// --- AnnounceLog.go ---
+
+// DeleteAnnounceLog deletes an AnnounceLog using a defined ID and column
+func (db *pgw) DeleteAnnounceLog(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM announce_log WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadAnnounceLog loads an AnnounceLog using a defined ID and column for query
+func (db *pgw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error) {
+	data := AnnounceLog{}
+
+	if err := db.Get(&data, `SELECT * FROM announce_log WHERE "`+col+`" = $1 LIMIT 1`, id); err != nil && err != sql.ErrNoRows {
+		return AnnounceLog{}, err
+	}
+
+	return data, nil
+}
+
+// SaveAnnounceLog saves an AnnounceLog to database
+func (db *pgw) SaveAnnounceLog(a AnnounceLog) error {
+	query := `INSERT INTO announce_log
+		(info_hash, peer_id, passkey, "key", ip, port, udp, uploaded, downloaded, "left", event, client, "time")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, ` + pgNow + `);`
+
+	tx := db.MustBegin()
+	tx.Exec(query, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client)
+
+	return tx.Commit()
+}
+
+// SaveAnnounceLogs saves a batch of AnnounceLogs to database, using a single multi-row insert
+func (db *pgw) SaveAnnounceLogs(logs []AnnounceLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO announce_log
+		(info_hash, peer_id, passkey, "key", ip, port, udp, uploaded, downloaded, "left", event, client, "time")
+		VALUES ` + pgValues(len(logs), 13) + `;`
+
+	args := make([]interface{}, 0, len(logs)*13)
+	for _, a := range logs {
+		args = append(args, a.InfoHash, a.PeerID, a.Passkey, a.Key, a.IP, a.Port, a.UDP, a.Uploaded, a.Downloaded, a.Left, a.Event, a.Client, a.Time)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// GetExpiredAnnounceLogs returns up to limit AnnounceLogs logged before the specified time, oldest first
+func (db *pgw) GetExpiredAnnounceLogs(before int64, limit int) ([]AnnounceLog, error) {
+	logs := make([]AnnounceLog, 0)
+	if err := db.Select(&logs, `SELECT * FROM announce_log WHERE "time" < $1 ORDER BY id LIMIT $2;`, before, limit); err != nil && err != sql.ErrNoRows {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+// DeleteAnnounceLogs deletes AnnounceLogs with the specified IDs
+func (db *pgw) DeleteAnnounceLogs(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := db.Exec("DELETE FROM announce_log WHERE id IN ("+strings.TrimSuffix(strings.TrimPrefix(pgValues(1, len(ids)), "("), ")")+");", args...)
+	return err
+}
+
+// --- APIKey.go ---
+
+// DeleteAPIKey deletes an APIKey using a defined ID and column
+func (db *pgw) DeleteAPIKey(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM api_keys WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadAPIKey loads an APIKey using a defined ID and column for query
+func (db *pgw) LoadAPIKey(id interface{}, col string) (APIKey, error) {
+	key := APIKey{}
+
+	err := db.Get(&key, `SELECT * FROM api_keys WHERE "`+col+`" = $1 LIMIT 1`, id)
+	if err != nil && err != sql.ErrNoRows {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// SaveAPIKey saves an APIKey to the database
+func (db *pgw) SaveAPIKey(key APIKey) error {
+	query := `INSERT INTO api_keys (user_id, pubkey, secret, expire)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (pubkey) DO UPDATE SET expire = EXCLUDED.expire;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, key.UserID, key.Pubkey, key.Secret, key.Expire)
+
+	return tx.Commit()
+}
+
+// GetAllAPIKeys returns a list of all APIKeys known to the database
+func (db *pgw) GetAllAPIKeys() ([]APIKey, error) {
+	rows, err := db.Queryx("SELECT * FROM api_keys")
+	keys, key := []APIKey{}, APIKey{}
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err.Error())
+		return keys, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&key); err != nil {
+			break
+		}
+
+		keys = append(keys[:], key)
+	}
+
+	return keys, nil
+}
+
+// --- ClientRuleRecord.go ---
+
+// DeleteClientRuleRecord deletes a ClientRuleRecord using a defined ID and column
+func (db *pgw) DeleteClientRuleRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM client_rules WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadClientRuleRecord loads a ClientRuleRecord using a defined ID and column for query
+func (db *pgw) LoadClientRuleRecord(id interface{}, col string) (ClientRuleRecord, error) {
+	query := `SELECT * FROM client_rules WHERE "` + col + `" = $1 ORDER BY id LIMIT 1;`
+
+	result := ClientRuleRecord{}
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return ClientRuleRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveClientRuleRecord saves a ClientRuleRecord to the database, updating any rule with the same prefix and
+// version range
+func (db *pgw) SaveClientRuleRecord(c ClientRuleRecord) error {
+	query := `INSERT INTO client_rules
+		(prefix, min_version, max_version, blacklist, description)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (prefix, min_version, max_version) DO UPDATE SET
+		blacklist = EXCLUDED.blacklist, description = EXCLUDED.description;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, c.Prefix, c.MinVersion, c.MaxVersion, c.Blacklist, c.Description)
+
+	return tx.Commit()
+}
+
+// GetAllClientRuleRecords returns a list of all ClientRuleRecords known to the database
+func (db *pgw) GetAllClientRuleRecords() ([]ClientRuleRecord, error) {
+	rules := make([]ClientRuleRecord, 0)
+	if err := db.Select(&rules, "SELECT * FROM client_rules ORDER BY id;"); err != nil && err != sql.ErrNoRows {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// --- FileRecord.go ---
+
+// DeleteFileRecord deletes a FileRecord using a defined ID and column
+func (db *pgw) DeleteFileRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM files WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadFileRecord loads a FileRecord using a defined ID and column for query
+func (db *pgw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
+	data := FileRecord{}
+
+	if err := db.Get(&data, `SELECT * FROM files WHERE "`+col+`" = $1 LIMIT 1`, id); err != nil && err != sql.ErrNoRows {
+		return FileRecord{}, err
+	}
+
+	return data, nil
+}
+
+// SaveFileRecord saves a FileRecord to the database
+func (db *pgw) SaveFileRecord(f FileRecord) error {
+	query := `INSERT INTO files
+		(info_hash, verified, name, size, file_count, piece_length, create_time, update_time)
+		VALUES ($1, $2, $3, $4, $5, $6, ` + pgNow + `, ` + pgNow + `)
+		ON CONFLICT (info_hash) DO UPDATE SET
+		verified = EXCLUDED.verified, name = EXCLUDED.name, size = EXCLUDED.size,
+		file_count = EXCLUDED.file_count, piece_length = EXCLUDED.piece_length, update_time = ` + pgNow + `;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, f.InfoHash, f.Verified, f.Name, f.Size, f.FileCount, f.PieceLength)
+
+	return tx.Commit()
+}
+
+// CountFileRecordCompleted counts the number of peers who have completed this file
+func (db *pgw) CountFileRecordCompleted(id int) (int, error) {
+	// Calculate number of completions on this file, defined as users who are completed, and 0 left
+	query := `SELECT COUNT(user_id) AS completed FROM files_users WHERE file_id = $1 AND completed = true AND "left" = 0;`
+	result := struct{ Completed int }{0}
+
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Completed, nil
+}
+
+// CountFileRecordSeeders counts the number of peers who are actively seeding this file
+func (db *pgw) CountFileRecordSeeders(id int) (int, error) {
+	// Calculate number of seeders on this file, defined as users who are active, completed, and 0 left
+	query := `SELECT COUNT(user_id) AS seeders FROM files_users WHERE file_id = $1 AND active = true AND completed = true AND "left" = 0;`
+	result := struct{ Seeders int }{0}
+
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Seeders, nil
+}
+
+// CountFileRecordLeechers counts the number of peers who are actively leeching this file
+func (db *pgw) CountFileRecordLeechers(id int) (int, error) {
+	// Calculate number of leechers on this file, defined as users who are active, not completed, and some left
+	query := `SELECT COUNT(user_id) AS leechers FROM files_users WHERE file_id = $1 AND active = true AND completed = false AND "left" > 0;`
+	result := struct{ Leechers int }{0}
+
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Leechers, nil
+}
+
+// CountAllFileRecordStats counts the completions, seeders, and leechers on every file, in a single query
+func (db *pgw) CountAllFileRecordStats() (map[int]FileStats, error) {
+	// Counts are defined as in CountFileRecordCompleted, CountFileRecordSeeders, and CountFileRecordLeechers
+	query := `SELECT file_id,
+		COUNT(user_id) FILTER (WHERE completed = true AND "left" = 0) AS completed,
+		COUNT(user_id) FILTER (WHERE active = true AND completed = true AND "left" = 0) AS seeders,
+		COUNT(user_id) FILTER (WHERE active = true AND completed = false AND "left" > 0) AS leechers
+		FROM files_users GROUP BY file_id;`
+	result := []struct {
+		FileID int `db:"file_id"`
+		FileStats
+	}{}
+
+	if err := db.Select(&result, query); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	stats := make(map[int]FileStats, len(result))
+	for _, r := range result {
+		stats[r.FileID] = r.FileStats
+	}
+
+	return stats, nil
+}
+
+// pgPeerFilter selects the reachable peers on a file which recently announced, and which a peerQuery does
+// not exclude, using the arguments generated by pgPeerArgs
+const pgPeerFilter = `WHERE info_hash = $1
+		AND (` + pgNow + ` - $2) <= "time"
+		AND port <> 0
+		AND ($3 = '' OR peer_id <> $3)
+		AND NOT (ip = $4 AND port = $5)
+		AND ($6 = false OR "left" > 0)`
+
+// pgPeerArgs generates the arguments of pgPeerFilter for a peerQuery
+func pgPeerArgs(infoHash string, q peerQuery) []interface{} {
+	return []interface{}{infoHash, common.Static.Config.Interval, q.PeerID, q.IP, q.Port, q.Leechers}
+}
+
+// CountFileRecordPeers counts the peers on a file which match a peerQuery
+func (db *pgw) CountFileRecordPeers(infoHash string, q peerQuery) (int, error) {
+	query := `SELECT COUNT(*) AS peers FROM peers ` + pgPeerFilter + `;`
+
+	result := struct{ Peers int }{0}
+	if err := db.Get(&result, query, pgPeerArgs(infoHash, q)...); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Peers, nil
+}
+
+// GetFileRecordPeerList returns a list of Peers, containing IP/port pairs, which match a peerQuery
+func (db *pgw) GetFileRecordPeerList(infoHash string, q peerQuery) ([]Peer, error) {
+	// Get IP and port of peers who have recently announced on this file, most recent first unless a
+	// stable order is needed, and whether they are a seeder
+	order := `ORDER BY "time" DESC`
+	if q.Stable {
+		order = `ORDER BY peer_id, ip`
+	}
+
+	query := `SELECT ip, port, peer_id, "left" = 0 AS seeder FROM peers ` + pgPeerFilter + `
+		` + order + `
+		LIMIT $7 OFFSET $8;`
+
+	// Create output list
+	peers := make([]Peer, 0)
+
+	// Perform query
+	rows, err := db.Queryx(query, append(pgPeerArgs(infoHash, q), q.Limit, q.Offset)...)
+	if err != nil && err != sql.ErrNoRows {
+		return peers, err
+	}
+
+	defer rows.Close()
+
+	// Scan each peer from database
+	peer := Peer{}
+	for rows.Next() {
+		// Check for error
+		if err = rows.StructScan(&peer); err != nil {
+			return peers, nil
+		}
+
+		// Append peer to list
+		peers = append(peers[:], peer)
+	}
+
+	return peers, nil
+}
+
+// GetInactiveUserInfo returns a list of users who have not been active for the specified time interval
+func (db *pgw) GetInactiveUserInfo(fid int, interval time.Duration) (users []peerInfo, err error) {
+	query := `SELECT user_id, ip FROM files_users
+		WHERE "time" < (` + pgNow + ` - $1)
+		AND active = true
+		AND file_id = $2;`
+
+	result := peerInfo{}
+	checkInterval := int(interval / time.Second)
+
+	var rows *sqlx.Rows
+	if rows, err = db.Queryx(query, checkInterval, fid); err == nil && err != sql.ErrNoRows {
+		defer rows.Close()
+
+		for rows.Next() {
+			if err = rows.StructScan(&result); err == nil {
+				users = append(users, result)
+			}
+		}
+	}
+
+	return
+}
+
+// MarkFileUsersInactive sets users to be inactive once they have been reaped
+func (db *pgw) MarkFileUsersInactive(fid int, users []peerInfo) error {
+	query := "UPDATE files_users SET active = false WHERE file_id = $1 AND user_id = $2 AND ip = $3;"
+
+	tx := db.MustBegin()
+	for _, u := range users {
+		tx.Exec(query, fid, u.UserID, u.IP)
+	}
+
+	return tx.Commit()
+}
+
+// GetAllFileRecords returns a list of all FileRecords known to the database
+func (db *pgw) GetAllFileRecords() ([]FileRecord, error) {
+	rows, err := db.Queryx("SELECT * FROM files")
+	files, file := []FileRecord{}, FileRecord{}
+
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err.Error())
+		return files, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&file); err != nil {
+			break
+		}
+
+		files = append(files[:], file)
+	}
+
+	return files, nil
+}
+
+// --- FileUserRecord.go ---
+
+// DeleteFileUserRecord deletes a FileUserRecord using a file ID, user ID, and IP triple
+func (db *pgw) DeleteFileUserRecord(fid, uid int, ip string) error {
+	tx := db.MustBegin()
+	tx.Exec("DELETE FROM files_users WHERE file_id = $1 AND user_id = $2 AND ip = $3", fid, uid, ip)
+
+	return tx.Commit()
+}
+
+// LoadFileUserRecord loads a FileUserRecord using a file ID, user ID, and IP triple
+func (db *pgw) LoadFileUserRecord(fid, uid int, ip string) (FileUserRecord, error) {
+	query := "SELECT * FROM files_users WHERE file_id = $1 AND user_id = $2 AND ip = $3;"
+
+	data := FileUserRecord{}
+	if err := db.Get(&data, query, fid, uid, ip); err != nil && err != sql.ErrNoRows {
+		return FileUserRecord{}, err
+	}
+
+	return data, nil
+}
+
+// SaveFileUserRecord saves a FileUserRecord to the database
+func (db *pgw) SaveFileUserRecord(f FileUserRecord) error {
+	// Insert or update a file/user relationship record
+	query := `INSERT INTO files_users
+		(file_id, user_id, ip, active, completed, announced, uploaded, downloaded, "left", "time")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, ` + pgNow + `)
+		ON CONFLICT (file_id, user_id, ip) DO UPDATE SET
+		active = EXCLUDED.active, completed = EXCLUDED.completed, announced = EXCLUDED.announced,
+		uploaded = EXCLUDED.uploaded, downloaded = EXCLUDED.downloaded, "left" = EXCLUDED."left",
+		"time" = ` + pgNow + `;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, f.FileID, f.UserID, f.IP, f.Active, f.Completed, f.Announced, f.Uploaded, f.Downloaded, f.Left)
+
+	return tx.Commit()
+}
+
+// LoadFileUserRepository loads all FileUserRecords matching a defined ID and column for query
+func (db *pgw) LoadFileUserRepository(id interface{}, col string) ([]FileUserRecord, error) {
+	rows, err := db.Queryx(`SELECT * FROM files_users WHERE "`+col+`" = $1`, id)
+	files, user := []FileUserRecord{}, FileUserRecord{}
+
+	if err != nil && err != sql.ErrNoRows {
+		return files, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&user); err != nil {
+			log.Println(err.Error())
+			break
+		}
+
+		files = append(files[:], user)
+	}
+
+	return files, nil
+}
+
+// --- PeerRecord.go ---
+
+// DeletePeerRecord deletes all PeerRecords for a peer on a file, using an info hash and peer_id pair
+func (db *pgw) DeletePeerRecord(infoHash string, peerID string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM peers WHERE info_hash = $1 AND peer_id = $2`, infoHash, peerID)
+
+	return tx.Commit()
+}
+
+// SavePeerRecord saves a PeerRecord to the database, replacing the peer's record for its address family
+func (db *pgw) SavePeerRecord(p PeerRecord) error {
+	// Insert or update a peer record
+	query := `INSERT INTO peers
+		(info_hash, peer_id, ipv6, user_id, ip, port, "left", "time")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, ` + pgNow + `)
+		ON CONFLICT (info_hash, peer_id, ipv6) DO UPDATE SET
+		user_id = EXCLUDED.user_id, ip = EXCLUDED.ip, port = EXCLUDED.port, "left" = EXCLUDED."left",
+		"time" = ` + pgNow + `;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, p.InfoHash, p.PeerID, p.IPv6, p.UserID, p.IP, p.Port, p.Left)
+
+	return tx.Commit()
+}
+
+// DeleteStalePeerRecords deletes PeerRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *pgw) DeleteStalePeerRecords(infoHash string, interval time.Duration) (int, error) {
+	query := `DELETE FROM peers WHERE info_hash = $1 AND "time" < (` + pgNow + ` - $2);`
+
+	res, err := db.Exec(query, infoHash, int64(interval/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// --- ScrapeLog.go ---
+
+// DeleteScrapeLog deletes a ScrapeLog using a defined ID and column
+func (db *pgw) DeleteScrapeLog(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM scrape_log WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadScrapeLog loads a ScrapeLog using a defined ID and column for query
+func (db *pgw) LoadScrapeLog(id interface{}, col string) (ScrapeLog, error) {
+	query := `SELECT * FROM scrape_log WHERE "` + col + `" = $1 LIMIT 1;`
+
+	data := ScrapeLog{}
+	if err := db.Get(&data, query, id); err != nil && err != sql.ErrNoRows {
+		return ScrapeLog{}, err
+	}
+
+	return data, nil
+}
+
+// SaveScrapeLog saves a ScrapeLog to the database
+func (db *pgw) SaveScrapeLog(s ScrapeLog) error {
+	query := `INSERT INTO scrape_log
+		(info_hash, passkey, ip, "time")
+		VALUES ($1, $2, $3, ` + pgNow + `);`
+
+	tx := db.MustBegin()
+	tx.Exec(query, s.InfoHash, s.Passkey, s.IP)
+
+	return tx.Commit()
+}
+
+// SaveScrapeLogs saves a batch of ScrapeLogs to the database, using a single multi-row insert
+func (db *pgw) SaveScrapeLogs(logs []ScrapeLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO scrape_log
+		(info_hash, passkey, ip, "time")
+		VALUES ` + pgValues(len(logs), 4) + `;`
+
+	args := make([]interface{}, 0, len(logs)*4)
+	for _, s := range logs {
+		args = append(args, s.InfoHash, s.Passkey, s.IP, s.Time)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// GetExpiredScrapeLogs returns up to limit ScrapeLogs logged before the specified time, oldest first
+func (db *pgw) GetExpiredScrapeLogs(before int64, limit int) ([]ScrapeLog, error) {
+	logs := make([]ScrapeLog, 0)
+	if err := db.Select(&logs, `SELECT * FROM scrape_log WHERE "time" < $1 ORDER BY id LIMIT $2;`, before, limit); err != nil && err != sql.ErrNoRows {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+// DeleteScrapeLogs deletes ScrapeLogs with the specified IDs
+func (db *pgw) DeleteScrapeLogs(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := db.Exec("DELETE FROM scrape_log WHERE id IN ("+strings.TrimSuffix(strings.TrimPrefix(pgValues(1, len(ids)), "("), ")")+");", args...)
+	return err
+}
+
+// --- SessionRecord.go ---
+
+// DeleteSessionRecord deletes a SessionRecord using an info hash, peer_id, and announce key
+func (db *pgw) DeleteSessionRecord(infoHash string, peerID string, key string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM sessions WHERE info_hash = $1 AND peer_id = $2 AND "key" = $3`, infoHash, peerID, key)
+
+	return tx.Commit()
+}
+
+// LoadSessionRecord loads a SessionRecord using an info hash, peer_id, and announce key
+func (db *pgw) LoadSessionRecord(infoHash string, peerID string, key string) (SessionRecord, error) {
+	query := `SELECT * FROM sessions WHERE info_hash = $1 AND peer_id = $2 AND "key" = $3;`
+
+	result := SessionRecord{}
+	if err := db.Get(&result, query, infoHash, peerID, key); err != nil && err != sql.ErrNoRows {
+		return SessionRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveSessionRecord saves a SessionRecord to the database, replacing any existing record for the same session
+func (db *pgw) SaveSessionRecord(s SessionRecord) error {
+	// Insert or update a session record
+	query := `INSERT INTO sessions
+		(info_hash, peer_id, "key", user_id, uploaded, downloaded, "time")
+		VALUES ($1, $2, $3, $4, $5, $6, ` + pgNow + `)
+		ON CONFLICT (info_hash, peer_id, "key") DO UPDATE SET
+		user_id = EXCLUDED.user_id, uploaded = EXCLUDED.uploaded, downloaded = EXCLUDED.downloaded,
+		"time" = ` + pgNow + `;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, s.InfoHash, s.PeerID, s.Key, s.UserID, s.Uploaded, s.Downloaded)
+
+	return tx.Commit()
+}
+
+// DeleteStaleSessionRecords deletes SessionRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *pgw) DeleteStaleSessionRecords(infoHash string, interval time.Duration) (int, error) {
+	query := `DELETE FROM sessions WHERE info_hash = $1 AND "time" < (` + pgNow + ` - $2);`
+
+	res, err := db.Exec(query, infoHash, int64(interval/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// --- TorrentRecord.go ---
+
+// DeleteTorrentRecord deletes a TorrentRecord using a defined ID and column
+func (db *pgw) DeleteTorrentRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM torrents WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadTorrentRecord loads a TorrentRecord using a defined ID and column for query
+func (db *pgw) LoadTorrentRecord(id interface{}, col string) (TorrentRecord, error) {
+	result := TorrentRecord{}
+	if err := db.Get(&result, `SELECT * FROM torrents WHERE "`+col+`"=$1`, id); err != nil && err != sql.ErrNoRows {
+		return TorrentRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveTorrentRecord saves a TorrentRecord to the database, replacing any existing metainfo for its info hash
+func (db *pgw) SaveTorrentRecord(t TorrentRecord) error {
+	query := `INSERT INTO torrents (info_hash, metainfo) VALUES ($1, $2)
+		ON CONFLICT (info_hash) DO UPDATE SET metainfo = EXCLUDED.metainfo;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, t.InfoHash, t.Metainfo)
+
+	return tx.Commit()
+}
+
+// GetAllTorrentRecords returns a list of all TorrentRecords known to the database
+func (db *pgw) GetAllTorrentRecords() ([]TorrentRecord, error) {
+	torrents := make([]TorrentRecord, 0)
+	if err := db.Select(&torrents, "SELECT * FROM torrents;"); err != nil && err != sql.ErrNoRows {
+		return torrents, err
+	}
+
+	return torrents, nil
+}
+
+// --- UserRecord.go ---
+
+// DeleteUserRecord deletes a UserRecord using a defined ID and column
+func (db *pgw) DeleteUserRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM users WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadUserRecord loads a UserRecord using a defined ID and column for query
+func (db *pgw) LoadUserRecord(id interface{}, col string) (UserRecord, error) {
+	query := `SELECT * FROM users WHERE "` + col + `" = $1 LIMIT 1;`
+
+	data := UserRecord{}
+	if err := db.Get(&data, query, id); err != nil && err != sql.ErrNoRows {
+		return UserRecord{}, err
+	}
+
+	return data, nil
+}
+
+// SaveUserRecord saves a UserRecord to the database
+func (db *pgw) SaveUserRecord(u UserRecord) error {
+	query := `INSERT INTO users
+		(username, password, passkey, torrent_limit)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username) DO UPDATE SET
+		password = EXCLUDED.password, passkey = EXCLUDED.passkey, torrent_limit = EXCLUDED.torrent_limit;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, u.Username, u.Password, u.Passkey, u.TorrentLimit)
+
+	return tx.Commit()
+}
+
+// GetUserUploaded loads the total number of bytes this user has uploaded
+func (db *pgw) GetUserUploaded(uid int) (int64, error) {
+	// Load this user's lifetime upload, which is zero until their first traffic is accounted
+	query := "SELECT uploaded FROM user_totals WHERE user_id = $1;"
+
+	result := struct{ Uploaded int64 }{0}
+	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Uploaded, nil
+}
+
+// GetUserDownloaded loads the total number of bytes this user has downloaded
+func (db *pgw) GetUserDownloaded(uid int) (int64, error) {
+	// Load this user's lifetime download, which is zero until their first traffic is accounted
+	query := "SELECT downloaded FROM user_totals WHERE user_id = $1;"
+
+	result := struct{ Downloaded int64 }{0}
+	if err := db.Get(&result, query, uid); err != nil && err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return result.Downloaded, nil
+}
+
+// AddUserTraffic adds uploaded and downloaded bytes to this user's lifetime totals
+func (db *pgw) AddUserTraffic(uid int, uploaded int64, downloaded int64) error {
+	// Insert or increment this user's totals
+	query := `INSERT INTO user_totals (user_id, uploaded, downloaded) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+		uploaded = user_totals.uploaded + EXCLUDED.uploaded, downloaded = user_totals.downloaded + EXCLUDED.downloaded;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, uid, uploaded, downloaded)
+
+	return tx.Commit()
+}
+
+// GetUserSeeding calculates the total number of files this user is actively seeding
+func (db *pgw) GetUserSeeding(uid int) (int, error) {
+	// Calculate sum of this user's seeding torrents via their file/user relationship records
+	query := `SELECT COUNT(user_id) AS seeding FROM files_users WHERE user_id = $1 AND active = true AND completed = true AND "left" = 0;`
+
+	result := struct{ Seeding int }{0}
+	if err := db.Get(&result, query, uid); err != nil {
+		return -1, err
+	}
+
+	return result.Seeding, nil
+}
+
+// GetUserLeeching calculates the total number of files this user is actively leeching
+func (db *pgw) GetUserLeeching(uid int) (int, error) {
+	// Calculate sum of this user's leeching torrents via their file/user relationship records
+	query := `SELECT COUNT(user_id) AS leeching FROM files_users WHERE user_id = $1 AND active = true AND completed = false AND "left" > 0;`
+
+	result := struct{ Leeching int }{0}
+	if err := db.Get(&result, query, uid); err != nil {
+		return -1, err
+	}
+
+	return result.Leeching, nil
+}
+
+// GetAllUserRecords returns a list of all UserRecords known to the database
+func (db *pgw) GetAllUserRecords() ([]UserRecord, error) {
+	rows, err := db.Queryx("SELECT * FROM users")
+	users, user := []UserRecord{}, UserRecord{}
+
+	if err != nil && err != sql.ErrNoRows {
+		return users, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.StructScan(&user); err != nil {
+			break
+		}
+
+		users = append(users[:], user)
+	}
+
+	return users, nil
+}
+
+// --- WhitelistRecord.go ---
+
+// DeleteWhitelistRecord deletes a WhitelistRecord using a defined ID and column
+func (db *pgw) DeleteWhitelistRecord(id interface{}, col string) error {
+	tx := db.MustBegin()
+	tx.Exec(`DELETE FROM whitelist WHERE "`+col+`" = $1`, id)
+
+	return tx.Commit()
+}
+
+// LoadWhitelistRecord loads a WhitelistRecord using a defined ID and column for query
+func (db *pgw) LoadWhitelistRecord(id interface{}, col string) (WhitelistRecord, error) {
+	query := `SELECT * FROM whitelist WHERE "` + col + `" = $1 LIMIT 1;`
+
+	result := WhitelistRecord{}
+	if err := db.Get(&result, query, id); err != nil && err != sql.ErrNoRows {
+		return WhitelistRecord{}, err
+	}
+
+	return result, nil
+}
+
+// SaveWhitelistRecord saves a WhitelistRecord to the database
+func (db *pgw) SaveWhitelistRecord(w WhitelistRecord) error {
+	// Existing clients are left untouched, matching the MySQL backend
+	query := `INSERT INTO whitelist
+		(client, approved)
+		VALUES ($1, $2)
+		ON CONFLICT (client) DO NOTHING;`
+
+	tx := db.MustBegin()
+	tx.Exec(query, w.Client, w.Approved)
+
+	return tx.Commit()
+}
+
+// GetAllWhitelistRecords returns a list of all WhitelistRecords known to the database
+func (db *pgw) GetAllWhitelistRecords() ([]WhitelistRecord, error) {
+	whitelist := make([]WhitelistRecord, 0)
+	if err := db.Select(&whitelist, "SELECT * FROM whitelist;"); err != nil && err != sql.ErrNoRows {
+		return whitelist, err
+	}
+
+	return whitelist, nil
+}
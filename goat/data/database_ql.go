@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/user"
 	ospath "path"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/goat/goat/common"
@@ -23,27 +24,32 @@ var (
 	qlOptions = ql.Options{CanCreate: true}
 	qlwdb     *qlw
 
-	// Map of compiled ql queries
-	qlc = map[string]ql.List{}
+	// Map of compiled ql queries, and a lock which guards it
+	qlc     = map[string]ql.List{}
+	qlcLock sync.Mutex
 
 	// Map of all queries available to ql
 	qlq = map[string]string{
 		// AnnounceLog
 		"announcelog_delete_id":       "DELETE FROM announce_log WHERE id()==$1",
-		"announcelog_load_id":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE id()==$1 ORDER BY id()",
-		"announcelog_load_info_hash":  "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE info_hash==$1 ORDER BY id()",
-		"announcelog_load_passkey":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE passkey==$1 ORDER BY id()",
-		"announcelog_load_key":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE key==$1 ORDER BY id()",
-		"announcelog_load_ip":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE ip==$1 ORDER BY id()",
-		"announcelog_load_port":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE port==$1 ORDER BY id()",
-		"announcelog_load_udp":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE udp==$1 ORDER BY id()",
-		"announcelog_load_uploaded":   "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE uploaded==$1 ORDER BY id()",
-		"announcelog_load_downloaded": "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE downloaded==$1 ORDER BY id()",
-		"announcelog_load_left":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE left==$1 ORDER BY id()",
-		"announcelog_load_event":      "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE event==$1 ORDER BY id()",
-		"announcelog_load_client":     "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE client==$1 ORDER BY id()",
-		"announcelog_load_time":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts FROM announce_log WHERE time==$1 ORDER BY id()",
-		"announcelog_save":            "INSERT INTO announce_log VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,now());",
+		"announcelog_load_id":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE id()==$1 ORDER BY id()",
+		"announcelog_load_info_hash":  "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE info_hash==$1 ORDER BY id()",
+		"announcelog_load_passkey":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE passkey==$1 ORDER BY id()",
+		"announcelog_load_key":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE key==$1 ORDER BY id()",
+		"announcelog_load_ip":         "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE ip==$1 ORDER BY id()",
+		"announcelog_load_port":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE port==$1 ORDER BY id()",
+		"announcelog_load_udp":        "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE udp==$1 ORDER BY id()",
+		"announcelog_load_uploaded":   "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE uploaded==$1 ORDER BY id()",
+		"announcelog_load_downloaded": "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE downloaded==$1 ORDER BY id()",
+		"announcelog_load_left":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE left==$1 ORDER BY id()",
+		"announcelog_load_event":      "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE event==$1 ORDER BY id()",
+		"announcelog_load_client":     "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE client==$1 ORDER BY id()",
+		"announcelog_load_time":       "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE time==$1 ORDER BY id()",
+		"announcelog_load_peer_id":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE peer_id==$1 ORDER BY id()",
+		"announcelog_save":            "INSERT INTO announce_log (info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,now(),$12);",
+		"announcelog_delete_expired":  "DELETE FROM announce_log WHERE id()==$1",
+		"announcelog_find_expired":    "SELECT id(),info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id FROM announce_log WHERE ts<$1 ORDER BY id() LIMIT $2",
+		"announcelog_save_time":       "INSERT INTO announce_log (info_hash,passkey,key,ip,port,udp,uploaded,downloaded,left,event,client,ts,peer_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13);",
 
 		// APIKey
 		"apikey_delete_id":     "DELETE FROM api_keys WHERE id()==$1",
@@ -54,19 +60,30 @@ var (
 		"apikey_insert":        "INSERT INTO api_keys VALUES ($1, $2, $3, $4)",
 		"apikey_update":        "UPDATE api_keys expire=$2 WHERE id()==$1",
 
+		// ClientRuleRecord
+		"clientrule_delete_id":     "DELETE FROM client_rules WHERE id()==$1",
+		"clientrule_delete_prefix": "DELETE FROM client_rules WHERE prefix==$1",
+		"clientrule_load_all":      "SELECT id(),prefix,min_version,max_version,blacklist,description FROM client_rules ORDER BY id()",
+		"clientrule_load_id":       "SELECT id(),prefix,min_version,max_version,blacklist,description FROM client_rules WHERE id()==$1",
+		"clientrule_load_prefix":   "SELECT id(),prefix,min_version,max_version,blacklist,description FROM client_rules WHERE prefix==$1 ORDER BY id()",
+		"clientrule_find":          "SELECT id() FROM client_rules WHERE prefix==$1 && min_version==$2 && max_version==$3",
+		"clientrule_insert":        "INSERT INTO client_rules VALUES ($1, $2, $3, $4, $5)",
+		"clientrule_update":        "UPDATE client_rules blacklist=$2, description=$3 WHERE id()==$1",
+
 		// FileRecord
-		"filerecord_delete_id":          "DELETE FROM files WHERE id()==$1",
-		"filerecord_delete_info_hash":   "DELETE FROM files WHERE info_hash==$1",
-		"filerecord_find_peerlist_http": "SELECT DISTINCT a.ip, a.port FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, (SELECT file_id, ip FROM files_users) AS u WHERE a.ip==u.ip && (now()-$1) <= a.time && f.info_hash==$2",
-		"filerecord_find_peerlist_udp":  "SELECT DISTINCT a.ip, a.port FROM announce_log AS a, (SELECT id() AS id, info_hash FROM files) AS f, WHERE (now()-$1) <= a.time && f.info_hash==$2",
-		"filerecord_load_all":           "SELECT id(),info_hash,verified,create_time,update_time FROM files",
-		"filerecord_load_id":            "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE id()==$1 ORDER BY id()",
-		"filerecord_load_info_hash":     "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE info_hash==$1 ORDER BY id()",
-		"filerecord_load_verified":      "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE verified==$1 ORDER BY id()",
-		"filerecord_load_create_time":   "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE create_time==$1 ORDER BY id()",
-		"filerecord_load_update_time":   "SELECT id(),info_hash,verified,create_time,update_time FROM files WHERE update_time==$1 ORDER BY id()",
-		"filerecord_insert":             "INSERT INTO files VALUES ($1,$2,now(),now())",
-		"filerecord_update":             "UPDATE files verified=$2,update_time=now() WHERE id()==$1",
+		"filerecord_delete_id":            "DELETE FROM files WHERE id()==$1",
+		"filerecord_delete_info_hash":     "DELETE FROM files WHERE info_hash==$1",
+		"filerecord_count_peers":          "SELECT count(*) FROM peers WHERE info_hash==$1 && ts>=(now()-$2) && port!=0 && ($3==\"\" || peer_id!=$3) && !(ip==$4 && port==$5) && (!$6 || left>0)",
+		"filerecord_find_peerlist":        "SELECT ip, port, peer_id, left==0, ts FROM peers WHERE info_hash==$1 && ts>=(now()-$2) && port!=0 && ($3==\"\" || peer_id!=$3) && !(ip==$4 && port==$5) && (!$6 || left>0) ORDER BY ts DESC LIMIT $7 OFFSET $8",
+		"filerecord_find_peerlist_stable": "SELECT ip, port, peer_id, left==0, ts FROM peers WHERE info_hash==$1 && ts>=(now()-$2) && port!=0 && ($3==\"\" || peer_id!=$3) && !(ip==$4 && port==$5) && (!$6 || left>0) ORDER BY peer_id, ip LIMIT $7 OFFSET $8",
+		"filerecord_load_all":             "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files",
+		"filerecord_load_id":              "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files WHERE id()==$1 ORDER BY id()",
+		"filerecord_load_info_hash":       "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files WHERE info_hash==$1 ORDER BY id()",
+		"filerecord_load_verified":        "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files WHERE verified==$1 ORDER BY id()",
+		"filerecord_load_create_time":     "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files WHERE create_time==$1 ORDER BY id()",
+		"filerecord_load_update_time":     "SELECT id(),info_hash,verified,name,size,file_count,piece_length,create_time,update_time FROM files WHERE update_time==$1 ORDER BY id()",
+		"filerecord_insert":               "INSERT INTO files (info_hash,verified,name,size,file_count,piece_length,create_time,update_time) VALUES ($1,$2,$3,$4,$5,$6,now(),now())",
+		"filerecord_update":               "UPDATE files verified=$2,name=$3,size=$4,file_count=$5,piece_length=$6,update_time=now() WHERE id()==$1",
 
 		// fileUser
 		"fileuser_delete":          "DELETE FROM files_users WHERE file_id==$1 && user_id==$2 && ip==$3",
@@ -75,11 +92,22 @@ var (
 		"fileuser_count_completed": "SELECT count(user_id) FROM files_users WHERE file_id==$1 && completed==true && left==0",
 		"fileuser_count_seeders":   "SELECT count(user_id) FROM files_users WHERE file_id==$1 && active==true && completed==true && left==0",
 		"fileuser_count_leechers":  "SELECT count(user_id) FROM files_users WHERE file_id==$1 && active==true && completed==false && left>0",
+		"fileuser_all_completed":   "SELECT file_id, count(user_id) FROM files_users WHERE completed==true && left==0 GROUP BY file_id",
+		"fileuser_all_seeders":     "SELECT file_id, count(user_id) FROM files_users WHERE active==true && completed==true && left==0 GROUP BY file_id",
+		"fileuser_all_leechers":    "SELECT file_id, count(user_id) FROM files_users WHERE active==true && completed==false && left>0 GROUP BY file_id",
 		"fileuser_find_inactive":   "SELECT user_id, ip FROM files_users WHERE (ts<(now()-$2)) && active==true && file_id==$1",
 		"fileuser_mark_inactive":   "UPDATE files_users active=false WHERE file_id==$1 && user_id==$2 && ip==$3",
 		"fileuser_insert":          "INSERT INTO files_users VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,now())",
 		"fileuser_update":          "UPDATE files_users active=$4,completed=$5,announced=$6,uploaded=$7,downloaded=$8,left=$9,ts=now() WHERE file_id==$1 && user_id==$2 && ip==$3",
 
+		// PeerRecord
+		"peer_delete":       "DELETE FROM peers WHERE info_hash==$1 && peer_id==$2",
+		"peer_load":         "SELECT info_hash FROM peers WHERE info_hash==$1 && peer_id==$2 && ipv6==$3",
+		"peer_insert":       "INSERT INTO peers (info_hash,peer_id,ipv6,user_id,ip,port,left,ts) VALUES ($1,$2,$3,$4,$5,$6,$7,now())",
+		"peer_update":       "UPDATE peers user_id=$4,ip=$5,port=$6,left=$7,ts=now() WHERE info_hash==$1 && peer_id==$2 && ipv6==$3",
+		"peer_count_stale":  "SELECT count(*) FROM peers WHERE info_hash==$1 && ts<(now()-$2)",
+		"peer_delete_stale": "DELETE FROM peers WHERE info_hash==$1 && ts<(now()-$2)",
+
 		// ScrapeLog
 		"scrapelog_delete_id":      "DELETE FROM scrape_log WHERE id()==$1",
 		"scrapelog_load_id":        "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE id()==$1",
@@ -87,6 +115,24 @@ var (
 		"scrapelog_load_passkey":   "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE passkey==$1",
 		"scrapelog_load_ip":        "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE ip==$1",
 		"scrapelog_insert":         "INSERT INTO scrape_log VALUES ($1, $2, $3, now())",
+		"scrapelog_delete_expired": "DELETE FROM scrape_log WHERE id()==$1",
+		"scrapelog_find_expired":   "SELECT id(),info_hash,passkey,ip,ts FROM scrape_log WHERE ts<$1 ORDER BY id() LIMIT $2",
+		"scrapelog_insert_time":    "INSERT INTO scrape_log VALUES ($1, $2, $3, $4)",
+
+		// SessionRecord
+		"session_delete":       "DELETE FROM sessions WHERE info_hash==$1 && peer_id==$2 && key==$3",
+		"session_load":         "SELECT info_hash,peer_id,key,user_id,uploaded,downloaded,ts FROM sessions WHERE info_hash==$1 && peer_id==$2 && key==$3",
+		"session_insert":       "INSERT INTO sessions (info_hash,peer_id,key,user_id,uploaded,downloaded,ts) VALUES ($1,$2,$3,$4,$5,$6,now())",
+		"session_update":       "UPDATE sessions user_id=$4,uploaded=$5,downloaded=$6,ts=now() WHERE info_hash==$1 && peer_id==$2 && key==$3",
+		"session_count_stale":  "SELECT count(*) FROM sessions WHERE info_hash==$1 && ts<(now()-$2)",
+		"session_delete_stale": "DELETE FROM sessions WHERE info_hash==$1 && ts<(now()-$2)",
+
+		// TorrentRecord
+		"torrent_delete_info_hash": "DELETE FROM torrents WHERE info_hash==$1",
+		"torrent_load_all":         "SELECT info_hash,metainfo FROM torrents",
+		"torrent_load_info_hash":   "SELECT info_hash,metainfo FROM torrents WHERE info_hash==$1",
+		"torrent_insert":           "INSERT INTO torrents VALUES ($1,$2)",
+		"torrent_update":           "UPDATE torrents metainfo=$2 WHERE info_hash==$1",
 
 		// UserRecord
 		"user_delete_username":    "DELETE FROM users WHERE username==$1",
@@ -98,13 +144,16 @@ var (
 		"user_load_torrent_limit": "SELECT id(),username,password,passkey,torrent_limit FROM users WHERE torrent_limit==$1",
 		"user_insert":             "INSERT INTO users VALUES($1, $2, $3, $4)",
 		"user_update":             "UPDATE users username=$2, password=$3, passkey=$4, torrent_limit=$5 WHERE id()==$1",
-		"user_uploaded":           "SELECT sum(uploaded) AS uploaded FROM files_users WHERE user_id==$1",
-		"user_downloaded":         "SELECT sum(downloaded) AS downloaded FROM files_users WHERE user_id==$1",
+		"user_uploaded":           "SELECT uploaded FROM user_totals WHERE user_id==$1",
+		"user_downloaded":         "SELECT downloaded FROM user_totals WHERE user_id==$1",
+		"user_totals_insert":      "INSERT INTO user_totals VALUES ($1, $2, $3)",
+		"user_totals_update":      "UPDATE user_totals uploaded=uploaded+$2, downloaded=downloaded+$3 WHERE user_id==$1",
 		"user_seeding":            "SELECT count(user_id) AS seeding FROM files_users WHERE user_id==$1 && active==true && completed==true && left==0",
 		"user_leeching":           "SELECT count(user_id) AS leeching FROM files_users WHERE user_id==$1 && active==true && completed==false && left>0",
 
 		// WhitelistRecord
 		"whitelist_delete_client": "DELETE FROM whitelist WHERE client==$1",
+		"whitelist_load_all":      "SELECT id(),client,approved FROM whitelist",
 		"whitelist_load_id":       "SELECT id(),client,approved FROM whitelist WHERE id()==$1",
 		"whitelist_load_client":   "SELECT id(),client,approved FROM whitelist WHERE client==$1",
 		"whitelist_load_approved": "SELECT id(),client,approved FROM whitelist WHERE approved==$1",
@@ -254,6 +303,7 @@ func (db *qlw) LoadAnnounceLog(id interface{}, col string) (AnnounceLog, error)
 			Event:      data[10].(string),
 			Client:     data[11].(string),
 			Time:       data[12].(time.Time).Unix(),
+			PeerID:     data[13].(string),
 		}
 
 		return false, nil
@@ -269,11 +319,85 @@ func (db *qlw) SaveAnnounceLog(a AnnounceLog) (err error) {
 		a.IP, int32(a.Port), a.UDP,
 		a.Uploaded, a.Downloaded,
 		a.Left, a.Event, a.Client,
-		time.Unix(a.Time, 0))
+		a.PeerID)
 
 	return
 }
 
+// SaveAnnounceLogs saves a batch of AnnounceLogs to database, within a single transaction
+func (db *qlw) SaveAnnounceLogs(logs []AnnounceLog) error {
+	list, err := qlCompile("announcelog_save_time", false)
+	if err != nil {
+		return err
+	}
+
+	tx := db.NewTransaction()
+	for _, a := range logs {
+		if _, _, err := tx.Execute(list,
+			a.InfoHash, a.Passkey, a.Key,
+			a.IP, int32(a.Port), a.UDP,
+			a.Uploaded, a.Downloaded,
+			a.Left, a.Event, a.Client,
+			time.Unix(a.Time, 0), a.PeerID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetExpiredAnnounceLogs returns up to limit AnnounceLogs logged before the specified time, oldest first
+func (db *qlw) GetExpiredAnnounceLogs(before int64, limit int) ([]AnnounceLog, error) {
+	logs := make([]AnnounceLog, 0)
+
+	rs, _, err := qlQuery(db, "announcelog_find_expired", true, time.Unix(before, 0), int64(limit))
+	if err != nil || len(rs) < 1 {
+		return logs, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		logs = append(logs, AnnounceLog{
+			ID:         int(data[0].(int64)),
+			InfoHash:   data[1].(string),
+			Passkey:    data[2].(string),
+			Key:        data[3].(string),
+			IP:         data[4].(string),
+			Port:       int(data[5].(int32)),
+			UDP:        data[6].(bool),
+			Uploaded:   data[7].(int64),
+			Downloaded: data[8].(int64),
+			Left:       data[9].(int64),
+			Event:      data[10].(string),
+			Client:     data[11].(string),
+			Time:       data[12].(time.Time).Unix(),
+			PeerID:     data[13].(string),
+		})
+
+		return true, nil
+	})
+
+	return logs, err
+}
+
+// DeleteAnnounceLogs deletes AnnounceLogs with the specified IDs, within a single transaction
+func (db *qlw) DeleteAnnounceLogs(ids []int) error {
+	list, err := qlCompile("announcelog_delete_expired", false)
+	if err != nil {
+		return err
+	}
+
+	tx := db.NewTransaction()
+	for _, id := range ids {
+		if _, _, err := tx.Execute(list, int64(id)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // --- APIKey.go ---
 
 // DeleteAPIKey deletes an AnnounceLog using a defined ID and column for query
@@ -340,6 +464,81 @@ func (db *qlw) GetAllAPIKeys() (keys []APIKey, err error) {
 	return
 }
 
+// --- ClientRuleRecord.go ---
+
+// qlClientRuleRecord converts a ql result row to a ClientRuleRecord
+func qlClientRuleRecord(data []interface{}) ClientRuleRecord {
+	return ClientRuleRecord{
+		ID:          int(data[0].(int64)),
+		Prefix:      data[1].(string),
+		MinVersion:  data[2].(string),
+		MaxVersion:  data[3].(string),
+		Blacklist:   data[4].(bool),
+		Description: data[5].(string),
+	}
+}
+
+// DeleteClientRuleRecord deletes a ClientRuleRecord using a defined column and ID
+func (db *qlw) DeleteClientRuleRecord(id interface{}, col string) (err error) {
+	_, _, err = qlQuery(db, "clientrule_delete_"+col, true, id)
+	return
+}
+
+// LoadClientRuleRecord loads a ClientRuleRecord using a defined ID and column for query
+func (db *qlw) LoadClientRuleRecord(id interface{}, col string) (ClientRuleRecord, error) {
+	rs, _, err := qlQuery(db, "clientrule_load_"+col, true, id)
+
+	result := ClientRuleRecord{}
+	if err != nil || len(rs) < 1 {
+		return result, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		result = qlClientRuleRecord(data)
+		return false, nil
+	})
+
+	return result, err
+}
+
+// SaveClientRuleRecord saves a ClientRuleRecord to the database, updating any rule with the same prefix and
+// version range
+func (db *qlw) SaveClientRuleRecord(c ClientRuleRecord) error {
+	// Check for an existing rule with this prefix and version range
+	var id int64
+	rs, _, err := qlQuery(db, "clientrule_find", true, c.Prefix, c.MinVersion, c.MaxVersion)
+	if err == nil && len(rs) > 0 {
+		err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+			id = data[0].(int64)
+			return false, nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		_, _, err = qlQuery(db, "clientrule_insert", true, c.Prefix, c.MinVersion, c.MaxVersion, c.Blacklist, c.Description)
+	} else {
+		_, _, err = qlQuery(db, "clientrule_update", true, id, c.Blacklist, c.Description)
+	}
+
+	return err
+}
+
+// GetAllClientRuleRecords returns a list of all ClientRuleRecords known to the database
+func (db *qlw) GetAllClientRuleRecords() (rules []ClientRuleRecord, err error) {
+	rules = make([]ClientRuleRecord, 0)
+	if rs, _, err := qlQuery(db, "clientrule_load_all", false); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			rules = append(rules, qlClientRuleRecord(data))
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // --- FileRecord.go ---
 
 // DeleteFileRecord deletes an AnnounceLog using a defined ID and column for query
@@ -367,11 +566,15 @@ func (db *qlw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 
 	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 		result = FileRecord{
-			ID:         int(data[0].(int64)),
-			InfoHash:   data[1].(string),
-			Verified:   data[2].(bool),
-			CreateTime: data[3].(time.Time).Unix(),
-			UpdateTime: data[4].(time.Time).Unix(),
+			ID:          int(data[0].(int64)),
+			InfoHash:    data[1].(string),
+			Verified:    data[2].(bool),
+			Name:        data[3].(string),
+			Size:        data[4].(int64),
+			FileCount:   int(data[5].(int64)),
+			PieceLength: data[6].(int64),
+			CreateTime:  data[7].(time.Time).Unix(),
+			UpdateTime:  data[8].(time.Time).Unix(),
 		}
 
 		return false, nil
@@ -383,9 +586,9 @@ func (db *qlw) LoadFileRecord(id interface{}, col string) (FileRecord, error) {
 // SaveFileRecord saves a fileRecord to the database
 func (db *qlw) SaveFileRecord(f FileRecord) (err error) {
 	if fr, _ := db.LoadFileRecord(f.ID, "id"); (fr == FileRecord{}) && err == nil {
-		_, _, err = qlQuery(db, "filerecord_insert", true, f.InfoHash, f.Verified)
+		_, _, err = qlQuery(db, "filerecord_insert", true, f.InfoHash, f.Verified, f.Name, f.Size, int64(f.FileCount), f.PieceLength)
 	} else {
-		_, _, err = qlQuery(db, "filerecord_update", true, int64(f.ID), f.Verified)
+		_, _, err = qlQuery(db, "filerecord_update", true, int64(f.ID), f.Verified, f.Name, f.Size, int64(f.FileCount), f.PieceLength)
 	}
 
 	return
@@ -409,31 +612,70 @@ func (db *qlw) CountFileRecordLeechers(id int) (int, error) {
 	return int(leechers), err
 }
 
-// GetFileRecordPeerList returns a list of Peers
-func (db *qlw) GetFileRecordPeerList(infoHash string, limit int, http bool) ([]Peer, error) {
-	// Select query using HTTP bool
-	var query string
-	if http {
-		query = "filerecord_find_peerlist_http"
-	} else {
-		query = "filerecord_find_peerlist_udp"
+// CountAllFileRecordStats counts the completions, seeders, and leechers on every file, with one grouped query
+// for each count
+func (db *qlw) CountAllFileRecordStats() (map[int]FileStats, error) {
+	stats := map[int]FileStats{}
+
+	for key, set := range map[string]func(*FileStats, int){
+		"fileuser_all_completed": func(s *FileStats, n int) { s.Completed = n },
+		"fileuser_all_seeders":   func(s *FileStats, n int) { s.Seeders = n },
+		"fileuser_all_leechers":  func(s *FileStats, n int) { s.Leechers = n },
+	} {
+		rs, _, err := qlQuery(db, key, false)
+		if err == nil && len(rs) > 0 {
+			err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+				id := int(data[0].(int64))
+				fileStats := stats[id]
+				set(&fileStats, int(data[1].(int64)))
+				stats[id] = fileStats
+
+				return true, nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// qlPeerArgs generates the arguments of the peer list queries for a peerQuery
+func qlPeerArgs(infoHash string, q peerQuery) []interface{} {
+	interval := time.Duration(common.Static.Config.Interval) * time.Second
+	return []interface{}{infoHash, interval, q.PeerID, q.IP, int32(q.Port), q.Leechers}
+}
+
+// CountFileRecordPeers counts the peers on a file which match a peerQuery
+func (db *qlw) CountFileRecordPeers(infoHash string, q peerQuery) (int, error) {
+	peers, err := qlQueryI64(db, "filerecord_count_peers", qlPeerArgs(infoHash, q)...)
+	return int(peers), err
+}
+
+// GetFileRecordPeerList returns a list of Peers which match a peerQuery
+func (db *qlw) GetFileRecordPeerList(infoHash string, q peerQuery) ([]Peer, error) {
+	// Peers are most recent first, unless a stable order is needed
+	query := "filerecord_find_peerlist"
+	if q.Stable {
+		query = "filerecord_find_peerlist_stable"
 	}
 
-	rs, _, err := qlQuery(db, query, true, common.Static.Config.Interval, infoHash)
+	rs, _, err := qlQuery(db, query, true, append(qlPeerArgs(infoHash, q), int64(q.Limit), int64(q.Offset))...)
 
 	// Generate peer list
 	peers := make([]Peer, 0)
 
 	if err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
-			peer := Peer{
-				IP:   data[0].(string),
-				Port: uint16(data[1].(int32)),
-			}
-
-			peers = append(peers[:], peer)
+			peers = append(peers[:], Peer{
+				IP:     data[0].(string),
+				Port:   uint16(data[1].(int32)),
+				PeerID: data[2].(string),
+				Seeder: data[3].(bool),
+			})
 
-			return len(peers) < limit, nil
+			return true, nil
 		})
 	}
 
@@ -455,18 +697,21 @@ func (db *qlw) GetInactiveUserInfo(fid int, interval time.Duration) (users []pee
 
 // MarkFileUsersInactive sets users to be inactive once they have been reaped
 func (db *qlw) MarkFileUsersInactive(fid int, users []peerInfo) (err error) {
-	if list, err := qlCompile("fileuser_mark_inactive", false); err == nil {
-		tx := db.NewTransaction()
+	list, err := qlCompile("fileuser_mark_inactive", false)
+	if err != nil {
+		return err
+	}
 
-		for _, user := range users {
-			if _, _, err = tx.Execute(list, int64(fid), int64(user.UserID), user.IP); err != nil {
-				tx.Rollback()
+	tx := db.NewTransaction()
 
-				return err
-			}
+	for _, user := range users {
+		if _, _, err = tx.Execute(list, int64(fid), int64(user.UserID), user.IP); err != nil {
+			tx.Rollback()
+
+			return err
 		}
-		err = tx.Commit()
 	}
+	err = tx.Commit()
 
 	return
 }
@@ -476,11 +721,15 @@ func (db *qlw) GetAllFileRecords() (files []FileRecord, err error) {
 	if rs, _, err := qlQuery(db, "filerecord_load_all", false); err == nil && len(rs) > 0 {
 		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
 			files = append(files, FileRecord{
-				ID:         int(data[0].(int64)),
-				InfoHash:   data[1].(string),
-				Verified:   data[2].(bool),
-				CreateTime: data[3].(time.Time).Unix(),
-				UpdateTime: data[4].(time.Time).Unix(),
+				ID:          int(data[0].(int64)),
+				InfoHash:    data[1].(string),
+				Verified:    data[2].(bool),
+				Name:        data[3].(string),
+				Size:        data[4].(int64),
+				FileCount:   int(data[5].(int64)),
+				PieceLength: data[6].(int64),
+				CreateTime:  data[7].(time.Time).Unix(),
+				UpdateTime:  data[8].(time.Time).Unix(),
 			})
 
 			return true, nil
@@ -573,6 +822,50 @@ func (db *qlw) LoadFileUserRepository(id interface{}, col string) (files []FileU
 	return
 }
 
+// --- PeerRecord.go ---
+
+// DeletePeerRecord deletes all PeerRecords for a peer on a file, using an info hash and peer_id pair
+func (db *qlw) DeletePeerRecord(infoHash string, peerID string) error {
+	_, _, err := qlQuery(db, "peer_delete", true, infoHash, peerID)
+	return err
+}
+
+// SavePeerRecord saves a PeerRecord to the database, replacing the peer's record for its address family
+func (db *qlw) SavePeerRecord(p PeerRecord) error {
+	// Check for an existing record for this peer and address family
+	exists := false
+	rs, _, err := qlQuery(db, "peer_load", true, p.InfoHash, p.PeerID, p.IPv6)
+	if err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			exists = true
+			return false, nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	query := "peer_insert"
+	if exists {
+		query = "peer_update"
+	}
+
+	_, _, err = qlQuery(db, query, true, p.InfoHash, p.PeerID, p.IPv6, int64(p.UserID), p.IP, int32(p.Port), p.Left)
+	return err
+}
+
+// DeleteStalePeerRecords deletes PeerRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *qlw) DeleteStalePeerRecords(infoHash string, interval time.Duration) (int, error) {
+	count, err := qlQueryI64(db, "peer_count_stale", infoHash, interval)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	_, _, err = qlQuery(db, "peer_delete_stale", true, infoHash, interval)
+	return int(count), err
+}
+
 // --- ScrapeLog.go ---
 
 // DeleteScrapeLog deletes an ScrapeLog using a defined ID and column for query
@@ -610,6 +903,189 @@ func (db *qlw) SaveScrapeLog(s ScrapeLog) (err error) {
 	return
 }
 
+// SaveScrapeLogs saves a batch of ScrapeLogs to the database, within a single transaction
+func (db *qlw) SaveScrapeLogs(logs []ScrapeLog) error {
+	list, err := qlCompile("scrapelog_insert_time", false)
+	if err != nil {
+		return err
+	}
+
+	tx := db.NewTransaction()
+	for _, s := range logs {
+		if _, _, err := tx.Execute(list, s.InfoHash, s.Passkey, s.IP, time.Unix(s.Time, 0)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetExpiredScrapeLogs returns up to limit ScrapeLogs logged before the specified time, oldest first
+func (db *qlw) GetExpiredScrapeLogs(before int64, limit int) ([]ScrapeLog, error) {
+	logs := make([]ScrapeLog, 0)
+
+	rs, _, err := qlQuery(db, "scrapelog_find_expired", true, time.Unix(before, 0), int64(limit))
+	if err != nil || len(rs) < 1 {
+		return logs, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		logs = append(logs, ScrapeLog{
+			ID:       int(data[0].(int64)),
+			InfoHash: data[1].(string),
+			Passkey:  data[2].(string),
+			IP:       data[3].(string),
+			Time:     data[4].(time.Time).Unix(),
+		})
+
+		return true, nil
+	})
+
+	return logs, err
+}
+
+// DeleteScrapeLogs deletes ScrapeLogs with the specified IDs, within a single transaction
+func (db *qlw) DeleteScrapeLogs(ids []int) error {
+	list, err := qlCompile("scrapelog_delete_expired", false)
+	if err != nil {
+		return err
+	}
+
+	tx := db.NewTransaction()
+	for _, id := range ids {
+		if _, _, err := tx.Execute(list, int64(id)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// --- SessionRecord.go ---
+
+// qlSessionRecord converts a ql result row into a SessionRecord
+func qlSessionRecord(data []interface{}) SessionRecord {
+	return SessionRecord{
+		InfoHash:   data[0].(string),
+		PeerID:     data[1].(string),
+		Key:        data[2].(string),
+		UserID:     int(data[3].(int64)),
+		Uploaded:   data[4].(int64),
+		Downloaded: data[5].(int64),
+		Time:       data[6].(time.Time).Unix(),
+	}
+}
+
+// DeleteSessionRecord deletes a SessionRecord using an info hash, peer_id, and announce key
+func (db *qlw) DeleteSessionRecord(infoHash string, peerID string, key string) error {
+	_, _, err := qlQuery(db, "session_delete", true, infoHash, peerID, key)
+	return err
+}
+
+// LoadSessionRecord loads a SessionRecord using an info hash, peer_id, and announce key
+func (db *qlw) LoadSessionRecord(infoHash string, peerID string, key string) (SessionRecord, error) {
+	rs, _, err := qlQuery(db, "session_load", true, infoHash, peerID, key)
+
+	result := SessionRecord{}
+	if err != nil || len(rs) < 1 {
+		return result, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		result = qlSessionRecord(data)
+		return false, nil
+	})
+
+	return result, err
+}
+
+// SaveSessionRecord saves a SessionRecord to the database, replacing any existing record for the same session
+func (db *qlw) SaveSessionRecord(s SessionRecord) error {
+	s2, err := db.LoadSessionRecord(s.InfoHash, s.PeerID, s.Key)
+	if err != nil {
+		return err
+	}
+
+	query := "session_insert"
+	if s2.InfoHash != "" {
+		query = "session_update"
+	}
+
+	_, _, err = qlQuery(db, query, true, s.InfoHash, s.PeerID, s.Key, int64(s.UserID), s.Uploaded, s.Downloaded)
+	return err
+}
+
+// DeleteStaleSessionRecords deletes SessionRecords on a file which have not announced within the specified
+// time interval, returning the number deleted
+func (db *qlw) DeleteStaleSessionRecords(infoHash string, interval time.Duration) (int, error) {
+	count, err := qlQueryI64(db, "session_count_stale", infoHash, interval)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	_, _, err = qlQuery(db, "session_delete_stale", true, infoHash, interval)
+	return int(count), err
+}
+
+// --- TorrentRecord.go ---
+
+// qlTorrentRecord converts a ql result row into a TorrentRecord
+func qlTorrentRecord(data []interface{}) TorrentRecord {
+	return TorrentRecord{
+		InfoHash: data[0].(string),
+		Metainfo: data[1].([]byte),
+	}
+}
+
+// DeleteTorrentRecord deletes a TorrentRecord using a defined ID and column
+func (db *qlw) DeleteTorrentRecord(id interface{}, col string) (err error) {
+	_, _, err = qlQuery(db, "torrent_delete_"+col, true, id)
+	return
+}
+
+// LoadTorrentRecord loads a TorrentRecord using a defined ID and column for query
+func (db *qlw) LoadTorrentRecord(id interface{}, col string) (TorrentRecord, error) {
+	rs, _, err := qlQuery(db, "torrent_load_"+col, true, id)
+
+	result := TorrentRecord{}
+	if err != nil || len(rs) < 1 {
+		return result, err
+	}
+
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		result = qlTorrentRecord(data)
+		return false, nil
+	})
+
+	return result, err
+}
+
+// SaveTorrentRecord saves a TorrentRecord to the database, replacing any existing metainfo for its info hash
+func (db *qlw) SaveTorrentRecord(t TorrentRecord) (err error) {
+	if t2, _ := db.LoadTorrentRecord(t.InfoHash, "info_hash"); t2.InfoHash == "" {
+		_, _, err = qlQuery(db, "torrent_insert", true, t.InfoHash, t.Metainfo)
+	} else {
+		_, _, err = qlQuery(db, "torrent_update", true, t.InfoHash, t.Metainfo)
+	}
+
+	return
+}
+
+// GetAllTorrentRecords returns a list of all TorrentRecords known to the database
+func (db *qlw) GetAllTorrentRecords() (torrents []TorrentRecord, err error) {
+	torrents = make([]TorrentRecord, 0)
+	if rs, _, err := qlQuery(db, "torrent_load_all", false); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			torrents = append(torrents, qlTorrentRecord(data))
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // --- UserRecord.go ---
 
 // DeleteUserRecord deletes an AnnounceLog using a defined ID and column for query
@@ -664,14 +1140,38 @@ func (db *qlw) SaveUserRecord(u UserRecord) (err error) {
 	return
 }
 
-// GetUserUploaded calculates the total number of bytes this user has uploaded
+// GetUserUploaded loads the total number of bytes this user has uploaded
 func (db *qlw) GetUserUploaded(uid int) (int64, error) {
-	return qlQueryI64(db, "user_uploaded", uid)
+	return qlQueryI64(db, "user_uploaded", int64(uid))
 }
 
-// GetUserDownloaded calculates the total number of bytes this user has downloaded
+// GetUserDownloaded loads the total number of bytes this user has downloaded
 func (db *qlw) GetUserDownloaded(uid int) (int64, error) {
-	return qlQueryI64(db, "user_downloaded", uid)
+	return qlQueryI64(db, "user_downloaded", int64(uid))
+}
+
+// AddUserTraffic adds uploaded and downloaded bytes to this user's lifetime totals
+func (db *qlw) AddUserTraffic(uid int, uploaded int64, downloaded int64) error {
+	// Check for existing totals for this user
+	exists := false
+	rs, _, err := qlQuery(db, "user_uploaded", true, int64(uid))
+	if err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			exists = true
+			return false, nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	query := "user_totals_insert"
+	if exists {
+		query = "user_totals_update"
+	}
+
+	_, _, err = qlQuery(db, query, true, int64(uid), uploaded, downloaded)
+	return err
 }
 
 // GetUserSeeding calculates the total number of files this user is actively seeding
@@ -752,19 +1252,37 @@ func (db *qlw) SaveWhitelistRecord(w WhitelistRecord) (err error) {
 	return
 }
 
+// GetAllWhitelistRecords returns a list of all WhitelistRecords known to the database
+func (db *qlw) GetAllWhitelistRecords() (whitelist []WhitelistRecord, err error) {
+	if rs, _, err := qlQuery(db, "whitelist_load_all", false); err == nil && len(rs) > 0 {
+		err = rs[0].Do(false, func(data []interface{}) (bool, error) {
+			whitelist = append(whitelist, WhitelistRecord{
+				ID:       int(data[0].(int64)),
+				Client:   data[1].(string),
+				Approved: data[2].(bool),
+			})
+
+			return true, nil
+		})
+	}
+
+	return
+}
+
 // qlQuery provides a wrapper to compile a ql query
 func qlQuery(db *qlw, key string, wraptx bool, arg ...interface{}) ([]ql.Recordset, int, error) {
-	var err error
-	if list, err := qlCompile(key, wraptx); err == nil {
-		return db.Execute(ql.NewRWCtx(), list, arg...)
+	list, err := qlCompile(key, wraptx)
+	if err != nil {
+		return []ql.Recordset(nil), 0, err
 	}
 
-	return []ql.Recordset(nil), 0, err
+	return db.Execute(ql.NewRWCtx(), list, arg...)
 }
 
 // qlQueryI64 provides a wrapper to return int64 values from ql
 func qlQueryI64(db *qlw, key string, arg ...interface{}) (i int64, err error) {
-	if rs, _, err := qlQuery(db, key, false, arg...); err == nil && len(rs) > 0 {
+	rs, _, err := qlQuery(db, key, false, arg...)
+	if err == nil && len(rs) > 0 {
 		err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
 			i = data[0].(int64)
 
@@ -775,27 +1293,32 @@ func qlQueryI64(db *qlw, key string, arg ...interface{}) (i int64, err error) {
 	return
 }
 
-// qlCompile provides a wrapper to create safe, transaction-encased queries
-func qlCompile(key string, wraptx bool) (list ql.List, err error) {
-	var src string
-	if l, ok := qlc[key]; !ok {
-		if src, ok = qlq[key]; !ok {
-			src = key
-		}
-		if wraptx {
-			src = "BEGIN TRANSACTION; " + src + "; COMMIT;"
-		}
-		if l, e := ql.Compile(src); err != nil {
-			err = e
-		} else {
-			list = l
-		}
-		qlc[key] = list
-	} else {
-		list = l
+// qlCompile provides a wrapper to create safe, transaction-encased queries.  Queries are named in qlq, and
+// many names end with a column, so a missing name means an unknown column was requested.
+func qlCompile(key string, wraptx bool) (ql.List, error) {
+	qlcLock.Lock()
+	defer qlcLock.Unlock()
+
+	if list, ok := qlc[key]; ok {
+		return list, nil
 	}
 
-	return
+	src, ok := qlq[key]
+	if !ok {
+		return ql.List{}, ErrUnknownColumn
+	}
+	if wraptx {
+		src = "BEGIN TRANSACTION; " + src + "; COMMIT;"
+	}
+
+	list, err := ql.Compile(src)
+	if err != nil {
+		return ql.List{}, err
+	}
+
+	// Only successfully compiled queries are cached
+	qlc[key] = list
+	return list, nil
 }
 
 // Pre-compiled begin transaction, commit and rollback statements
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestDBConnect verifies that DBConnect shares a single database handle between callers
+func TestDBConnect(t *testing.T) {
+	log.Println("TestDBConnect()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Open two handles
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+
+	db2, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+
+	// Verify both handles share the same connection
+	if db != db2 {
+		t.Fatalf("DBConnect returned different handles: %p, %p", db, db2)
+	}
+
+	// Closing a handle must not close the shared connection
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database handle: %s", err.Error())
+	}
+
+	if !DBPing() {
+		t.Fatalf("Database unreachable after handle was closed")
+	}
+}
+
+// TestDBUnknownColumn verifies that records cannot be loaded using columns off the whitelist
+func TestDBUnknownColumn(t *testing.T) {
+	log.Println("TestDBUnknownColumn()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Attempt to smuggle a condition in via the column name
+	col := "id` = 1 OR `id"
+
+	if _, err := new(FileRecord).Load(1, col); err != ErrUnknownColumn {
+		t.Fatalf("FileRecord.Load accepted unknown column: %v", err)
+	}
+
+	if _, err := new(UserRecord).Load(1, col); err != ErrUnknownColumn {
+		t.Fatalf("UserRecord.Load accepted unknown column: %v", err)
+	}
+
+	if _, err := new(FileUserRecordRepository).Select(1, col); err != ErrUnknownColumn {
+		t.Fatalf("FileUserRecordRepository.Select accepted unknown column: %v", err)
+	}
+
+	// Callers which use a database handle directly are checked too
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+
+	if _, err := db.LoadUserRecord(1, col); err != ErrUnknownColumn {
+		t.Fatalf("LoadUserRecord accepted unknown column: %v", err)
+	}
+
+	if err := db.DeleteFileRecord(1, col); err != ErrUnknownColumn {
+		t.Fatalf("DeleteFileRecord accepted unknown column: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database connection: %s", err.Error())
+	}
+
+	// Whitelisted columns are still accepted
+	if _, err := new(FileRecord).Load("goat-unknown-column", "info_hash"); err != nil {
+		t.Fatalf("FileRecord.Load refused known column: %s", err.Error())
+	}
+}
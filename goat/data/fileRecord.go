@@ -3,16 +3,27 @@ package data
 import (
 	"time"
 
-	"github.com/mdlayher/goat/goat/common"
+	"code.google.com/p/go.net/context"
 )
 
 // FileRecord represents a file tracked by tracker
 type FileRecord struct {
-	ID         int    `json:"id"`
-	InfoHash   string `db:"info_hash" json:"infoHash"`
-	Verified   bool   `json:"verified"`
-	CreateTime int64  `db:"create_time" json:"createTime"`
-	UpdateTime int64  `db:"update_time" json:"updateTime"`
+	ID          int    `json:"id"`
+	InfoHash    string `db:"info_hash" json:"infoHash"`
+	Verified    bool   `json:"verified"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	FileCount   int    `db:"file_count" json:"fileCount"`
+	PieceLength int64  `db:"piece_length" json:"pieceLength"`
+	CreateTime  int64  `db:"create_time" json:"createTime"`
+	UpdateTime  int64  `db:"update_time" json:"updateTime"`
+}
+
+// FileStats contains the number of completions, seeders, and leechers on a file
+type FileStats struct {
+	Completed int
+	Seeders   int
+	Leechers  int
 }
 
 // FileRecordRepository is used to contain methods to load multiple FileRecord structs
@@ -21,15 +32,19 @@ type FileRecordRepository struct {
 
 // JSONFileRecord represents output FileRecord JSON for API
 type JSONFileRecord struct {
-	ID         int              `json:"id"`
-	InfoHash   string           `json:"infoHash"`
-	Verified   bool             `json:"verified"`
-	CreateTime int64            `json:"createTime"`
-	UpdateTime int64            `json:"updateTime"`
-	Completed  int              `json:"completed"`
-	Seeders    int              `json:"seeders"`
-	Leechers   int              `json:"leechers"`
-	FileUsers  []FileUserRecord `json:"fileUsers"`
+	ID          int              `json:"id"`
+	InfoHash    string           `json:"infoHash"`
+	Verified    bool             `json:"verified"`
+	Name        string           `json:"name"`
+	Size        int64            `json:"size"`
+	FileCount   int              `json:"fileCount"`
+	PieceLength int64            `json:"pieceLength"`
+	CreateTime  int64            `json:"createTime"`
+	UpdateTime  int64            `json:"updateTime"`
+	Completed   int              `json:"completed"`
+	Seeders     int              `json:"seeders"`
+	Leechers    int              `json:"leechers"`
+	FileUsers   []FileUserRecord `json:"fileUsers"`
 }
 
 // peerInfo represents a peer which will be marked as active or not
@@ -45,6 +60,10 @@ func (f FileRecord) ToJSON() (JSONFileRecord, error) {
 	j.ID = f.ID
 	j.InfoHash = f.InfoHash
 	j.Verified = f.Verified
+	j.Name = f.Name
+	j.Size = f.Size
+	j.FileCount = f.FileCount
+	j.PieceLength = f.PieceLength
 	j.CreateTime = f.CreateTime
 	j.UpdateTime = f.UpdateTime
 
@@ -97,8 +116,13 @@ func (f FileRecord) Delete() error {
 
 // Save FileRecord to storage
 func (f FileRecord) Save() error {
+	return f.save(context.Background())
+}
+
+// save implements Save, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) save(ctx context.Context) error {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return err
 	}
@@ -118,8 +142,13 @@ func (f FileRecord) Save() error {
 
 // Load FileRecord from storage
 func (f FileRecord) Load(id interface{}, col string) (FileRecord, error) {
+	return f.load(context.Background(), id, col)
+}
+
+// load implements Load, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) load(ctx context.Context, id interface{}, col string) (FileRecord, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return FileRecord{}, err
 	}
@@ -137,37 +166,56 @@ func (f FileRecord) Load(id interface{}, col string) (FileRecord, error) {
 	return f, nil
 }
 
-// CompactPeerList returns a packed byte array of peers who are active on this file
-func (f FileRecord) CompactPeerList(numwant int, http bool) ([]byte, error) {
+// CompactPeerList returns packed byte arrays of IPv4 and IPv6 peers who are active on this file,
+// selected for the requesting peer
+func (f FileRecord) CompactPeerList(numwant int, req PeerRequest) ([]byte, []byte, error) {
+	return f.compactPeerList(context.Background(), numwant, req)
+}
+
+// compactPeerList implements CompactPeerList, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) compactPeerList(ctx context.Context, numwant int, req PeerRequest) ([]byte, []byte, error) {
 	// Retrieve list of peers
-	peers, err := f.PeerList(numwant, http)
+	peers, err := f.peerList(ctx, numwant, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Create buffer to store compact peers
+	// Create buffers to store compact peers, one per address family
 	compactPeers := make([]byte, 0)
+	compactPeers6 := make([]byte, 0)
 
 	// Iterate peers
 	for _, peer := range peers {
-		// Marshal each peer to binary
+		// Marshal each peer to binary, skipping any which did not announce a usable IP
 		peerBuf, err := peer.MarshalBinary()
+		if err == ErrPeerIP {
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		// Append peer to compact list
-		compactPeers = append(compactPeers[:], peerBuf...)
+		// Append peer to compact list for its address family
+		if peer.IPv6() {
+			compactPeers6 = append(compactPeers6[:], peerBuf...)
+		} else {
+			compactPeers = append(compactPeers[:], peerBuf...)
+		}
 	}
 
-	// Return compact peer list
-	return compactPeers, nil
+	// Return compact peer lists
+	return compactPeers, compactPeers6, nil
 }
 
 // Completed returns the number of completions, active or not, on this file
 func (f FileRecord) Completed() (int, error) {
+	return f.completed(context.Background())
+}
+
+// completed implements Completed, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) completed(ctx context.Context) (int, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -188,8 +236,18 @@ func (f FileRecord) Completed() (int, error) {
 
 // Seeders returns the number of seeders on this file
 func (f FileRecord) Seeders() (int, error) {
+	return f.seeders(context.Background())
+}
+
+// seeders implements Seeders, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) seeders(ctx context.Context) (int, error) {
+	// If a swarm store is enabled, count active seeders there instead
+	if s := swarmConnect(); s != nil {
+		return s.CountSeeders(f.InfoHash, swarmWindow())
+	}
+
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -210,8 +268,18 @@ func (f FileRecord) Seeders() (int, error) {
 
 // Leechers returns the number of leechers on this file
 func (f FileRecord) Leechers() (int, error) {
+	return f.leechers(context.Background())
+}
+
+// leechers implements Leechers, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) leechers(ctx context.Context) (int, error) {
+	// If a swarm store is enabled, count active leechers there instead
+	if s := swarmConnect(); s != nil {
+		return s.CountLeechers(f.InfoHash, swarmWindow())
+	}
+
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -230,19 +298,45 @@ func (f FileRecord) Leechers() (int, error) {
 	return leechers, nil
 }
 
-// PeerList returns a list of peers on this torrent, for tracker announce
-func (f FileRecord) PeerList(numwant int, http bool) ([]Peer, error) {
+// PeerList returns a list of peers on this torrent, for tracker announce, selected for the requesting peer.
+// Peers which announced without a port are WebTorrent peers, which can only be reached using WebRTC offers
+// relayed by the tracker, so they are never returned.
+func (f FileRecord) PeerList(numwant int, req PeerRequest) ([]Peer, error) {
+	return f.peerList(context.Background(), numwant, req)
+}
+
+// peerList implements PeerList, binding ctx to the queries of backends which can cancel them
+func (f FileRecord) peerList(ctx context.Context, numwant int, req PeerRequest) ([]Peer, error) {
 	// List of peers
 	peers := make([]Peer, 0)
 
+	// If a swarm store is enabled, retrieve peers from there instead
+	if s := swarmConnect(); s != nil {
+		window := swarmWindow()
+		peers, err := loadPeers(f.InfoHash, req, numwant, func(q peerQuery) (int, error) {
+			return s.CountPeers(f.InfoHash, q, window)
+		}, func(q peerQuery) ([]Peer, error) {
+			return s.PeerList(f.InfoHash, q, window)
+		})
+		if err != nil {
+			return peers, err
+		}
+
+		return selectPeers(peers, req, numwant), nil
+	}
+
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return peers, err
 	}
 
-	// Return list of peers, up to numwant
-	if peers, err = db.GetFileRecordPeerList(f.InfoHash, numwant, http); err != nil {
+	// Load peers for this requester
+	if peers, err = loadPeers(f.InfoHash, req, numwant, func(q peerQuery) (int, error) {
+		return db.CountFileRecordPeers(f.InfoHash, q)
+	}, func(q peerQuery) ([]Peer, error) {
+		return db.GetFileRecordPeerList(f.InfoHash, q)
+	}); err != nil {
 		return peers, err
 	}
 
@@ -251,19 +345,22 @@ func (f FileRecord) PeerList(numwant int, http bool) ([]Peer, error) {
 		return peers, err
 	}
 
-	return peers, nil
+	return selectPeers(peers, req, numwant), nil
 }
 
 // PeerReaper reaps peers who have not recently announced on this torrent, and mark them inactive
 func (f FileRecord) PeerReaper() (int, error) {
+	// Peers which have not announced in just above maximum interval are considered inactive
+	interval := swarmWindow() + 60*time.Second
+
 	// Open database connection
 	db, err := DBConnect()
 	if err != nil {
 		return 0, err
 	}
 
-	// Retrieve list of inactive users (have not announced in just above maximum interval)
-	users, err := db.GetInactiveUserInfo(f.ID, time.Duration(int64(common.Static.Config.Interval))*time.Second+60)
+	// Retrieve list of inactive users
+	users, err := db.GetInactiveUserInfo(f.ID, interval)
 	if err != nil {
 		return 0, err
 	}
@@ -273,12 +370,75 @@ func (f FileRecord) PeerReaper() (int, error) {
 		return 0, err
 	}
 
+	// Prune inactive peers from the peers table, unless a swarm store is tracking them instead
+	count := 0
+	s := swarmConnect()
+	if s == nil {
+		if count, err = db.DeleteStalePeerRecords(f.InfoHash, interval); err != nil {
+			return 0, err
+		}
+
+		// Forget where peer lists were rotating once the swarm is empty
+		remaining, err := db.CountFileRecordPeers(f.InfoHash, peerQuery{})
+		if err != nil {
+			return 0, err
+		}
+		if remaining == 0 {
+			forgetRotation(f.InfoHash)
+		}
+	}
+
+	// Forget announce sessions which have long since gone quiet, so the sessions table does not grow without bound
+	if _, err := db.DeleteStaleSessionRecords(f.InfoHash, sessionExpiry); err != nil {
+		return 0, err
+	}
+
 	// Close database connection
 	if err := db.Close(); err != nil {
 		return 0, err
 	}
 
-	return len(users), nil
+	// If a swarm store is enabled, reap its peers instead
+	if s != nil {
+		if count, err = s.ReapPeers(f.InfoHash, interval); err != nil {
+			return 0, err
+		}
+
+		// Forget where peer lists were rotating once the swarm is empty
+		remaining, err := s.CountPeers(f.InfoHash, peerQuery{}, swarmWindow())
+		if err != nil {
+			return 0, err
+		}
+		if remaining == 0 {
+			forgetRotation(f.InfoHash)
+		}
+	}
+
+	return count, nil
+}
+
+// SwarmAnnounce records a peer's announce on this file, by a user, in the swarm store if one is enabled,
+// or in the peers table otherwise
+func (f FileRecord) SwarmAnnounce(a AnnounceLog, userID int) error {
+	// Peers which did not report a peer_id cannot be told apart, so they are not tracked
+	if a.PeerID == "" {
+		return nil
+	}
+
+	// If a swarm store is enabled, it tracks peers instead
+	if s := swarmConnect(); s != nil {
+		return s.Announce(f.InfoHash, a)
+	}
+
+	peer := new(PeerRecord)
+	peer.FromAnnounceLog(a, userID)
+
+	// Stopped peers are removed immediately, rather than waiting for the reaper
+	if a.Event == "stopped" {
+		return peer.Delete()
+	}
+
+	return peer.Save()
 }
 
 // Users loads all FileUserRecord structs associated with this FileRecord struct
@@ -309,3 +469,143 @@ func (f FileRecordRepository) All() ([]FileRecord, error) {
 
 	return files, nil
 }
+
+// Stats returns the number of completions, seeders, and leechers on each of the specified files, keyed by ID.
+// Counts for all files are retrieved together, rather than with three queries per file.
+func (f FileRecordRepository) Stats(files []FileRecord) (map[int]FileStats, error) {
+	return f.stats(context.Background(), files)
+}
+
+// stats implements Stats, binding ctx to the queries of backends which can cancel them
+func (f FileRecordRepository) stats(ctx context.Context, files []FileRecord) (map[int]FileStats, error) {
+	// Open database connection
+	db, err := dbConnect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve counts for every file with users
+	all, err := db.CountAllFileRecordStats()
+	if err != nil {
+		return nil, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+
+	// Files without users have no counts
+	stats := make(map[int]FileStats, len(files))
+	for _, file := range files {
+		stats[file.ID] = all[file.ID]
+	}
+
+	// If a swarm store is enabled, count active seeders and leechers there instead
+	s := swarmConnect()
+	if s == nil {
+		return stats, nil
+	}
+
+	window := swarmWindow()
+	for _, file := range files {
+		fileStats := stats[file.ID]
+		if fileStats.Seeders, err = s.CountSeeders(file.InfoHash, window); err != nil {
+			return nil, err
+		}
+		if fileStats.Leechers, err = s.CountLeechers(file.InfoHash, window); err != nil {
+			return nil, err
+		}
+		stats[file.ID] = fileStats
+	}
+
+	return stats, nil
+}
+
+// StatsContext returns the number of completions, seeders, and leechers on each of the specified files,
+// giving up once ctx is done
+func (f FileRecordRepository) StatsContext(ctx context.Context, files []FileRecord) (map[int]FileStats, error) {
+	var stats map[int]FileStats
+	if err := withContext(ctx, func() (err error) {
+		stats, err = f.stats(ctx, files)
+		return
+	}); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// LoadContext loads a FileRecord from storage, giving up once ctx is done
+func (f FileRecord) LoadContext(ctx context.Context, id interface{}, col string) (FileRecord, error) {
+	var file FileRecord
+	if err := withContext(ctx, func() (err error) {
+		file, err = f.load(ctx, id, col)
+		return
+	}); err != nil {
+		return FileRecord{}, err
+	}
+
+	return file, nil
+}
+
+// SaveContext saves a FileRecord to storage, giving up once ctx is done
+func (f FileRecord) SaveContext(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return f.save(ctx)
+	})
+}
+
+// CompactPeerListContext returns packed byte arrays of IPv4 and IPv6 peers, giving up once ctx is done
+func (f FileRecord) CompactPeerListContext(ctx context.Context, numwant int, req PeerRequest) ([]byte, []byte, error) {
+	var peers, peers6 []byte
+	if err := withContext(ctx, func() (err error) {
+		peers, peers6, err = f.compactPeerList(ctx, numwant, req)
+		return
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return peers, peers6, nil
+}
+
+// PeerListContext returns a list of peers on this torrent, giving up once ctx is done
+func (f FileRecord) PeerListContext(ctx context.Context, numwant int, req PeerRequest) ([]Peer, error) {
+	var peers []Peer
+	if err := withContext(ctx, func() (err error) {
+		peers, err = f.peerList(ctx, numwant, req)
+		return
+	}); err != nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+// CompletedContext returns the number of completions on this file, giving up once ctx is done
+func (f FileRecord) CompletedContext(ctx context.Context) (int, error) {
+	return f.countContext(ctx, f.completed)
+}
+
+// SeedersContext returns the number of seeders on this file, giving up once ctx is done
+func (f FileRecord) SeedersContext(ctx context.Context) (int, error) {
+	return f.countContext(ctx, f.seeders)
+}
+
+// LeechersContext returns the number of leechers on this file, giving up once ctx is done
+func (f FileRecord) LeechersContext(ctx context.Context) (int, error) {
+	return f.countContext(ctx, f.leechers)
+}
+
+// countContext performs a count on this file, giving up once ctx is done
+func (f FileRecord) countContext(ctx context.Context, count func(context.Context) (int, error)) (int, error) {
+	var n int
+	if err := withContext(ctx, func() (err error) {
+		n, err = count(ctx)
+		return
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -20,8 +20,12 @@ func TestFileRecord(t *testing.T) {
 
 	// Generate mock FileRecord
 	file := FileRecord{
-		InfoHash: "deadbeef",
-		Verified: true,
+		InfoHash:    "deadbeef",
+		Verified:    true,
+		Name:        "goat",
+		Size:        1024,
+		FileCount:   1,
+		PieceLength: 16384,
 	}
 
 	// Save mock file
@@ -35,6 +39,11 @@ func TestFileRecord(t *testing.T) {
 		t.Fatalf("Failed to load mock file: %s", err.Error())
 	}
 
+	// Verify metadata was stored
+	if file.Name != "goat" || file.Size != 1024 || file.FileCount != 1 || file.PieceLength != 16384 {
+		t.Fatalf("Mock file metadata does not match: %v", file)
+	}
+
 	// Verify completed is functional
 	if _, err := file.Completed(); err != nil {
 		t.Fatalf("Failed to fetch file completed")
@@ -1,5 +1,9 @@
 package data
 
+import (
+	"code.google.com/p/go.net/context"
+)
+
 // FileUserRecord represents a file tracked by tracker
 type FileUserRecord struct {
 	FileID     int    `db:"file_id" json:"fileId"`
@@ -41,8 +45,13 @@ func (f FileUserRecord) Delete() error {
 
 // Load FileUserRecord from storage
 func (f FileUserRecord) Load(fileID int, userID int, ip string) (FileUserRecord, error) {
+	return f.load(context.Background(), fileID, userID, ip)
+}
+
+// load implements Load, binding ctx to the queries of backends which can cancel them
+func (f FileUserRecord) load(ctx context.Context, fileID int, userID int, ip string) (FileUserRecord, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return FileUserRecord{}, err
 	}
@@ -105,3 +114,16 @@ func (f FileUserRecordRepository) Select(id interface{}, col string) ([]FileUser
 
 	return fileUsers, nil
 }
+
+// LoadContext loads a FileUserRecord from storage, giving up once ctx is done
+func (f FileUserRecord) LoadContext(ctx context.Context, fileID int, userID int, ip string) (FileUserRecord, error) {
+	var fileUser FileUserRecord
+	if err := withContext(ctx, func() (err error) {
+		fileUser, err = f.load(ctx, fileID, userID, ip)
+		return
+	}); err != nil {
+		return FileUserRecord{}, err
+	}
+
+	return fileUser, nil
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+)
+
+// retentionBatchSize is the number of expired logs archived and deleted at once
+const retentionBatchSize = 1000
+
+// LogArchive is a gzip-compressed, newline-delimited JSON file, to which expired logs are archived
+type LogArchive struct {
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// NewLogArchive creates a LogArchive at the specified path
+func NewLogArchive(path string) (*LogArchive, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	return &LogArchive{file, gz, json.NewEncoder(gz)}, nil
+}
+
+// Write appends a single record to the archive, as one line of JSON
+func (a *LogArchive) Write(v interface{}) error {
+	return a.enc.Encode(v)
+}
+
+// Flush ensures all records written so far have reached the disk
+func (a *LogArchive) Flush() error {
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+
+	return a.file.Sync()
+}
+
+// Close finishes the archive, and closes its file
+func (a *LogArchive) Close() error {
+	if err := a.gz.Close(); err != nil {
+		return err
+	}
+
+	return a.file.Close()
+}
+
+// ArchiveAnnounceLogs writes AnnounceLogs logged before the specified time to an archive, and then deletes
+// them from storage, in batches.  Each batch is flushed to disk before it is deleted.
+func ArchiveAnnounceLogs(before int64, archive *LogArchive) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	total := 0
+	for {
+		// Retrieve next batch of expired logs
+		logs, err := db.GetExpiredAnnounceLogs(before, retentionBatchSize)
+		if err != nil || len(logs) == 0 {
+			return total, err
+		}
+
+		// Archive batch, keeping track of IDs to delete
+		ids := make([]int, len(logs))
+		for i, l := range logs {
+			if err := archive.Write(l); err != nil {
+				return total, err
+			}
+
+			ids[i] = l.ID
+		}
+
+		if err := archive.Flush(); err != nil {
+			return total, err
+		}
+
+		// Batch safely archived, so delete it
+		if err := db.DeleteAnnounceLogs(ids); err != nil {
+			return total, err
+		}
+
+		total += len(logs)
+	}
+}
+
+// ArchiveScrapeLogs writes ScrapeLogs logged before the specified time to an archive, and then deletes
+// them from storage, in batches.  Each batch is flushed to disk before it is deleted.
+func ArchiveScrapeLogs(before int64, archive *LogArchive) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	total := 0
+	for {
+		// Retrieve next batch of expired logs
+		logs, err := db.GetExpiredScrapeLogs(before, retentionBatchSize)
+		if err != nil || len(logs) == 0 {
+			return total, err
+		}
+
+		// Archive batch, keeping track of IDs to delete
+		ids := make([]int, len(logs))
+		for i, l := range logs {
+			if err := archive.Write(l); err != nil {
+				return total, err
+			}
+
+			ids[i] = l.ID
+		}
+
+		if err := archive.Flush(); err != nil {
+			return total, err
+		}
+
+		// Batch safely archived, so delete it
+		if err := db.DeleteScrapeLogs(ids); err != nil {
+			return total, err
+		}
+
+		total += len(logs)
+	}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestArchiveAnnounceLogs verifies that expired announce logs are archived to disk, and then deleted
+func TestArchiveAnnounceLogs(t *testing.T) {
+	log.Println("TestArchiveAnnounceLogs()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Create a temporary archive location
+	dir, err := ioutil.TempDir("", "goat")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/announce_log.ndjson.gz"
+
+	// Save an expired announce log
+	expired := time.Now().AddDate(0, 0, -60).Unix()
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	if err := db.SaveAnnounceLogs([]AnnounceLog{{InfoHash: "retention", IP: "127.0.0.1", Port: 5000, Time: expired}}); err != nil {
+		t.Fatalf("Failed to save announce log: %s", err.Error())
+	}
+
+	// Archive all logs older than 30 days
+	archive, err := NewLogArchive(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %s", err.Error())
+	}
+
+	count, err := ArchiveAnnounceLogs(time.Now().AddDate(0, 0, -30).Unix(), archive)
+	if err != nil {
+		t.Fatalf("Failed to archive announce logs: %s", err.Error())
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %s", err.Error())
+	}
+
+	if count < 1 {
+		t.Fatalf("Archived, expected at least 1, got %d", count)
+	}
+
+	// Verify log was deleted
+	announce, err := new(AnnounceLog).Load("retention", "info_hash")
+	if err != nil || announce != (AnnounceLog{}) {
+		t.Fatalf("Archived announce log was not deleted: %v", announce)
+	}
+
+	// Verify archive contains one line of JSON per log
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err.Error())
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to decompress archive: %s", err.Error())
+	}
+
+	found := false
+	lines := 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		a := AnnounceLog{}
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			t.Fatalf("Failed to decode archived log: %s", err.Error())
+		}
+
+		if a.InfoHash == "retention" && a.Time == expired {
+			found = true
+		}
+		lines++
+	}
+
+	if lines != count || !found {
+		t.Fatalf("Archive does not match, %d lines for %d logs, found: %v", lines, count, found)
+	}
+}
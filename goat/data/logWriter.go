@@ -0,0 +1,228 @@
+package data
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+const (
+	// logQueueSize is the maximum number of logs of each type waiting to be written, before new logs are dropped
+	logQueueSize = 4096
+
+	// logBatchSize is the maximum number of logs written to storage in a single insert
+	logBatchSize = 256
+
+	// logFlushInterval is the maximum amount of time a log waits in the queue before being written
+	logFlushInterval = 1 * time.Second
+)
+
+var (
+	// logw is the running log writer, or nil if logs are saved immediately
+	logw *logWriter
+
+	// logwMutex guards starting and stopping of the log writer, so logs are never queued to a stopped writer
+	logwMutex sync.RWMutex
+)
+
+// logWriter queues announce and scrape logs in memory, and writes them to storage in batches
+type logWriter struct {
+	announces chan AnnounceLog
+	scrapes   chan ScrapeLog
+	stop      chan bool
+	done      chan bool
+}
+
+// StartLogWriter starts the background log writer, which batches announce and scrape logs
+func StartLogWriter() {
+	logwMutex.Lock()
+	defer logwMutex.Unlock()
+
+	if logw != nil {
+		return
+	}
+
+	logw = &logWriter{
+		announces: make(chan AnnounceLog, logQueueSize),
+		scrapes:   make(chan ScrapeLog, logQueueSize),
+		stop:      make(chan bool),
+		done:      make(chan bool),
+	}
+	go logw.run()
+}
+
+// StopLogWriter stops the background log writer, blocking until all queued logs are written
+func StopLogWriter() {
+	logwMutex.Lock()
+	defer logwMutex.Unlock()
+
+	if logw == nil {
+		return
+	}
+
+	close(logw.stop)
+	<-logw.done
+	logw = nil
+}
+
+// QueueAnnounceLog queues an AnnounceLog to be written in the next batch, or saves it immediately
+// if the log writer is not running.  If the queue is full, the log is dropped and counted.
+func QueueAnnounceLog(a AnnounceLog) error {
+	// Capture announce time now, rather than when the batch is written
+	if a.Time == 0 {
+		a.Time = time.Now().Unix()
+	}
+
+	logwMutex.RLock()
+	defer logwMutex.RUnlock()
+
+	// Log writer not running, save immediately
+	if logw == nil {
+		return a.Save()
+	}
+
+	select {
+	case logw.announces <- a:
+		atomic.AddInt64(&common.Static.Logs.Queued, 1)
+	default:
+		atomic.AddInt64(&common.Static.Logs.Dropped, 1)
+	}
+
+	return nil
+}
+
+// QueueScrapeLog queues a ScrapeLog to be written in the next batch, or saves it immediately
+// if the log writer is not running.  If the queue is full, the log is dropped and counted.
+func QueueScrapeLog(s ScrapeLog) error {
+	// Capture scrape time now, rather than when the batch is written
+	if s.Time == 0 {
+		s.Time = time.Now().Unix()
+	}
+
+	logwMutex.RLock()
+	defer logwMutex.RUnlock()
+
+	// Log writer not running, save immediately
+	if logw == nil {
+		return s.Save()
+	}
+
+	select {
+	case logw.scrapes <- s:
+		atomic.AddInt64(&common.Static.Logs.Queued, 1)
+	default:
+		atomic.AddInt64(&common.Static.Logs.Dropped, 1)
+	}
+
+	return nil
+}
+
+// run collects queued logs, writing them whenever a batch fills or the flush interval passes
+func (w *logWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	announces := make([]AnnounceLog, 0, logBatchSize)
+	scrapes := make([]ScrapeLog, 0, logBatchSize)
+
+	for {
+		select {
+		case a := <-w.announces:
+			announces = append(announces, a)
+			if len(announces) >= logBatchSize {
+				announces = writeAnnounceLogs(announces)
+			}
+		case s := <-w.scrapes:
+			scrapes = append(scrapes, s)
+			if len(scrapes) >= logBatchSize {
+				scrapes = writeScrapeLogs(scrapes)
+			}
+		case <-ticker.C:
+			announces = writeAnnounceLogs(announces)
+			scrapes = writeScrapeLogs(scrapes)
+		case <-w.stop:
+			// No more logs can be queued, so drain whatever remains and write it out
+			for {
+				select {
+				case a := <-w.announces:
+					announces = append(announces, a)
+				case s := <-w.scrapes:
+					scrapes = append(scrapes, s)
+				default:
+					log.Printf("logWriter: draining %d announce, %d scrape logs", len(announces), len(scrapes))
+					writeAnnounceLogs(announces)
+					writeScrapeLogs(scrapes)
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeAnnounceLogs writes AnnounceLogs to storage in batches, and returns the emptied slice for reuse
+func writeAnnounceLogs(logs []AnnounceLog) []AnnounceLog {
+	pending := logs
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > logBatchSize {
+			n = logBatchSize
+		}
+
+		// Logs which cannot be written are counted as dropped
+		if err := saveLogBatch(func(db dbModel) error { return db.SaveAnnounceLogs(pending[:n]) }); err != nil {
+			log.Println(err.Error())
+			atomic.AddInt64(&common.Static.Logs.Dropped, int64(n))
+		} else {
+			atomic.AddInt64(&common.Static.Logs.Written, int64(n))
+		}
+
+		pending = pending[n:]
+	}
+
+	return logs[:0]
+}
+
+// writeScrapeLogs writes ScrapeLogs to storage in batches, and returns the emptied slice for reuse
+func writeScrapeLogs(logs []ScrapeLog) []ScrapeLog {
+	pending := logs
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > logBatchSize {
+			n = logBatchSize
+		}
+
+		// Logs which cannot be written are counted as dropped
+		if err := saveLogBatch(func(db dbModel) error { return db.SaveScrapeLogs(pending[:n]) }); err != nil {
+			log.Println(err.Error())
+			atomic.AddInt64(&common.Static.Logs.Dropped, int64(n))
+		} else {
+			atomic.AddInt64(&common.Static.Logs.Written, int64(n))
+		}
+
+		pending = pending[n:]
+	}
+
+	return logs[:0]
+}
+
+// saveLogBatch opens a database connection, and performs a batch save using it
+func saveLogBatch(save func(dbModel) error) error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save batch
+	if err := save(db); err != nil {
+		return err
+	}
+
+	// Close database connection
+	return db.Close()
+}
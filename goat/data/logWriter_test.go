@@ -0,0 +1,79 @@
+package data
+
+import (
+	"log"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestLogWriter verifies that queued logs are written in batches, and drained on shutdown
+func TestLogWriter(t *testing.T) {
+	log.Println("TestLogWriter()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Start log writer, and queue more logs than fit in a single batch
+	StartLogWriter()
+	written := atomic.LoadInt64(&common.Static.Logs.Written)
+
+	count := logBatchSize + 1
+	for i := 0; i < count; i++ {
+		if err := QueueAnnounceLog(AnnounceLog{InfoHash: "logwriter", IP: "127.0.0.1", Port: 5000}); err != nil {
+			t.Fatalf("Failed to queue announce log: %s", err.Error())
+		}
+	}
+	if err := QueueScrapeLog(ScrapeLog{InfoHash: "logwriter", IP: "127.0.0.1"}); err != nil {
+		t.Fatalf("Failed to queue scrape log: %s", err.Error())
+	}
+
+	// Stopping the writer must drain all queued logs
+	StopLogWriter()
+	if w := atomic.LoadInt64(&common.Static.Logs.Written) - written; w != int64(count+1) {
+		t.Fatalf("Written, expected %d, got %d", count+1, w)
+	}
+
+	// Verify logs reached storage, with announce time preserved
+	announce, err := new(AnnounceLog).Load("logwriter", "info_hash")
+	if err != nil || announce.InfoHash != "logwriter" || announce.Time == 0 {
+		t.Fatalf("Failed to load written announce log: %v", announce)
+	}
+
+	scrape, err := new(ScrapeLog).Load("logwriter", "info_hash")
+	if err != nil || scrape.InfoHash != "logwriter" {
+		t.Fatalf("Failed to load written scrape log: %v", scrape)
+	}
+
+	// Clean up written logs
+	for i := 0; i < count && announce.ID != 0; i++ {
+		if err := announce.Delete(); err != nil {
+			t.Fatalf("Failed to delete announce log: %s", err.Error())
+		}
+		announce, _ = new(AnnounceLog).Load("logwriter", "info_hash")
+	}
+	if err := scrape.Delete(); err != nil {
+		t.Fatalf("Failed to delete scrape log: %s", err.Error())
+	}
+
+	// Logs queued to a full writer are dropped, not blocked on
+	logwMutex.Lock()
+	logw = &logWriter{announces: make(chan AnnounceLog, 1)}
+	logwMutex.Unlock()
+
+	dropped := atomic.LoadInt64(&common.Static.Logs.Dropped)
+	QueueAnnounceLog(AnnounceLog{InfoHash: "logwriter"})
+	QueueAnnounceLog(AnnounceLog{InfoHash: "logwriter"})
+	if d := atomic.LoadInt64(&common.Static.Logs.Dropped) - dropped; d != 1 {
+		t.Fatalf("Dropped, expected 1, got %d", d)
+	}
+
+	logwMutex.Lock()
+	logw = nil
+	logwMutex.Unlock()
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrSchemaNewer is returned when the database schema is newer than this version of goat understands
+var ErrSchemaNewer = errors.New("data: database schema is newer than this version of goat")
+
+// migration represents a single, versioned change to the database schema
+type migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// migrator is implemented by database backends which store a versioned schema
+type migrator interface {
+	SchemaVersion() (int, error)
+	Migrate(migration) error
+}
+
+// dbMigrations is the ordered list of schema migrations for the database backend in use,
+// set by backends which implement migrator
+var dbMigrations []migration
+
+// LatestSchemaVersion returns the newest schema version known to this version of goat
+func LatestSchemaVersion() int {
+	if len(dbMigrations) == 0 {
+		return 0
+	}
+
+	return dbMigrations[len(dbMigrations)-1].Version
+}
+
+// Migrate brings the database schema up to date, applying any pending migrations in order,
+// and returns the resulting schema version
+func Migrate() (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+
+	// Backends without a schema have nothing to migrate
+	m, ok := db.(migrator)
+	if !ok {
+		return LatestSchemaVersion(), db.Close()
+	}
+
+	// Check current schema version
+	version, err := m.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	// Refuse to touch a schema created by a newer version of goat
+	if version > LatestSchemaVersion() {
+		return version, ErrSchemaNewer
+	}
+
+	// Apply all migrations newer than current schema
+	for _, mg := range dbMigrations {
+		if mg.Version <= version {
+			continue
+		}
+
+		log.Printf("migrate: applying schema version %d: %s", mg.Version, mg.Description)
+		if err := m.Migrate(mg); err != nil {
+			return version, err
+		}
+
+		version = mg.Version
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return version, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,203 @@
+// +build !ql,!memory,!postgres
+
+package data
+
+// init registers the MySQL schema migrations
+func init() {
+	dbMigrations = mysqlMigrations
+}
+
+// mysqlMigrations contains all MySQL schema migrations, ordered by version
+// NOTE: version 1 matches the schema files in res/mysql/, so databases created from them are adopted as-is
+var mysqlMigrations = []migration{
+	{1, "initial schema", []string{
+		"CREATE TABLE IF NOT EXISTS announce_log (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", info_hash varchar(40) NOT NULL" +
+			", passkey char(40) NOT NULL" +
+			", `key` char(8) NOT NULL" +
+			", ip varchar(15) NOT NULL" +
+			", port int(11) NOT NULL" +
+			", udp tinyint(1) NOT NULL" +
+			", uploaded bigint unsigned NOT NULL" +
+			", downloaded bigint unsigned NOT NULL" +
+			", `left` bigint unsigned NOT NULL" +
+			", event varchar(10) NOT NULL" +
+			", client varchar(50) NOT NULL" +
+			", `time` int(11) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS api_keys (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", user_id int(11) NOT NULL" +
+			", pubkey char(40) NOT NULL" +
+			", secret char(40) NOT NULL" +
+			", expire int(11) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			", UNIQUE KEY (pubkey)" +
+			", UNIQUE KEY (secret)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS files (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", info_hash varchar(40) NOT NULL" +
+			", verified tinyint(1) NOT NULL" +
+			", create_time int(11) NOT NULL" +
+			", update_time int(11) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			", UNIQUE KEY (info_hash)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS files_users (" +
+			"file_id int(11) NOT NULL" +
+			", user_id int(11) NOT NULL" +
+			", ip varchar(15) NOT NULL" +
+			", active tinyint(1) NOT NULL" +
+			", completed tinyint(1) NOT NULL" +
+			", announced int(11) NOT NULL" +
+			", uploaded bigint unsigned NOT NULL" +
+			", downloaded bigint unsigned NOT NULL" +
+			", `left` bigint unsigned NOT NULL" +
+			", `time` int(11) NOT NULL" +
+			", UNIQUE KEY (file_id, user_id, ip)" +
+			", KEY (file_id)" +
+			", KEY (ip)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS scrape_log (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", info_hash char(40) NOT NULL" +
+			", passkey char(40) NOT NULL" +
+			", ip varchar(15) NOT NULL" +
+			", `time` int(11) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS users (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", username varchar(20) NOT NULL" +
+			", password char(60) NOT NULL" +
+			", passkey char(40) NOT NULL" +
+			", torrent_limit int(11) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			", UNIQUE KEY (username)" +
+			", UNIQUE KEY (password)" +
+			", UNIQUE KEY (passkey)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS whitelist (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", client varchar(50) NOT NULL" +
+			", approved tinyint(1) NOT NULL" +
+			", PRIMARY KEY (id)" +
+			", UNIQUE KEY (client)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+	}},
+	{2, "index log times for retention", []string{
+		"CREATE INDEX announce_log_time ON announce_log (`time`)",
+		"CREATE INDEX scrape_log_time ON scrape_log (`time`)",
+	}},
+	{3, "widen ip columns for IPv6", []string{
+		"ALTER TABLE announce_log MODIFY ip varchar(45) NOT NULL",
+		"ALTER TABLE files_users MODIFY ip varchar(45) NOT NULL",
+		"ALTER TABLE scrape_log MODIFY ip varchar(45) NOT NULL",
+	}},
+	{4, "record peer_id with announces", []string{
+		"ALTER TABLE announce_log ADD peer_id varchar(40) NOT NULL DEFAULT '' AFTER info_hash",
+	}},
+	{5, "track active peers in peers table", []string{
+		"CREATE TABLE IF NOT EXISTS peers (" +
+			"info_hash varchar(40) NOT NULL" +
+			", peer_id varchar(40) NOT NULL" +
+			", ipv6 tinyint(1) NOT NULL" +
+			", user_id int(11) NOT NULL" +
+			", ip varchar(45) NOT NULL" +
+			", port int(11) NOT NULL" +
+			", `left` bigint unsigned NOT NULL" +
+			", `time` int(11) NOT NULL" +
+			", PRIMARY KEY (info_hash, peer_id, ipv6)" +
+			", KEY (info_hash, `time`)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+	}},
+	{6, "add client_rules table for peer_id rules", []string{
+		"CREATE TABLE IF NOT EXISTS client_rules (" +
+			"id int(11) NOT NULL AUTO_INCREMENT" +
+			", prefix varchar(20) NOT NULL" +
+			", min_version varchar(5) NOT NULL DEFAULT ''" +
+			", max_version varchar(5) NOT NULL DEFAULT ''" +
+			", blacklist tinyint(1) NOT NULL" +
+			", description varchar(255) NOT NULL DEFAULT ''" +
+			", PRIMARY KEY (id)" +
+			", UNIQUE KEY (prefix, min_version, max_version)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+	}},
+	{7, "store torrent metadata on files", []string{
+		"ALTER TABLE files ADD name varchar(255) NOT NULL DEFAULT '' AFTER verified" +
+			", ADD size bigint unsigned NOT NULL DEFAULT 0 AFTER name" +
+			", ADD file_count int(11) NOT NULL DEFAULT 0 AFTER size" +
+			", ADD piece_length int(11) NOT NULL DEFAULT 0 AFTER file_count",
+	}},
+	{8, "store torrent metainfo", []string{
+		"CREATE TABLE IF NOT EXISTS torrents (" +
+			"info_hash varchar(40) NOT NULL" +
+			", metainfo mediumblob NOT NULL" +
+			", PRIMARY KEY (info_hash)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+	}},
+	{9, "track traffic per announce session", []string{
+		"CREATE TABLE IF NOT EXISTS sessions (" +
+			"info_hash varchar(40) NOT NULL" +
+			", peer_id varchar(40) NOT NULL" +
+			", `key` varchar(40) NOT NULL" +
+			", user_id int(11) NOT NULL" +
+			", uploaded bigint unsigned NOT NULL" +
+			", downloaded bigint unsigned NOT NULL" +
+			", `time` int(11) NOT NULL" +
+			", PRIMARY KEY (info_hash, peer_id, `key`)" +
+			", KEY (info_hash, `time`)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"CREATE TABLE IF NOT EXISTS user_totals (" +
+			"user_id int(11) NOT NULL" +
+			", uploaded bigint unsigned NOT NULL" +
+			", downloaded bigint unsigned NOT NULL" +
+			", PRIMARY KEY (user_id)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin",
+		"INSERT INTO user_totals (user_id, uploaded, downloaded) " +
+			"SELECT user_id, SUM(uploaded), SUM(downloaded) FROM files_users GROUP BY user_id",
+	}},
+}
+
+// SchemaVersion returns the current version of the MySQL schema, creating the version table if needed
+func (db *dbw) SchemaVersion() (int, error) {
+	query := "CREATE TABLE IF NOT EXISTS schema_version (" +
+		"version int(11) NOT NULL" +
+		", description varchar(100) NOT NULL" +
+		", `time` int(11) NOT NULL" +
+		", PRIMARY KEY (version)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin"
+	if _, err := db.Exec(query); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, err
+}
+
+// Migrate applies a single migration to the MySQL schema, and records its version
+// NOTE: MySQL implicitly commits DDL statements, so a failed migration may be partially applied
+func (db *dbw) Migrate(m migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range m.Statements {
+		if _, err := tx.Exec(s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_version VALUES (?, ?, UNIX_TIMESTAMP())", m.Version, m.Description); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
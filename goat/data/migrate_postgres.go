@@ -0,0 +1,201 @@
+// +build postgres
+
+package data
+
+// init registers the PostgreSQL schema migrations
+func init() {
+	dbMigrations = pgMigrations
+}
+
+// pgMigrations contains all PostgreSQL schema migrations, ordered by version
+// NOTE: version 1 matches the schema files in res/postgres/, so databases created from them are adopted as-is
+var pgMigrations = []migration{
+	{1, "initial schema", []string{
+		`CREATE TABLE IF NOT EXISTS announce_log (
+			id serial NOT NULL
+			, info_hash varchar(40) NOT NULL
+			, passkey varchar(40) NOT NULL
+			, "key" varchar(8) NOT NULL
+			, ip varchar(15) NOT NULL
+			, port integer NOT NULL
+			, udp boolean NOT NULL
+			, uploaded bigint NOT NULL
+			, downloaded bigint NOT NULL
+			, "left" bigint NOT NULL
+			, event varchar(10) NOT NULL
+			, client varchar(50) NOT NULL
+			, "time" integer NOT NULL
+			, PRIMARY KEY (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id serial NOT NULL
+			, user_id integer NOT NULL
+			, pubkey varchar(40) NOT NULL
+			, secret varchar(40) NOT NULL
+			, expire integer NOT NULL
+			, PRIMARY KEY (id)
+			, UNIQUE (pubkey)
+			, UNIQUE (secret)
+		)`,
+		`CREATE TABLE IF NOT EXISTS files (
+			id serial NOT NULL
+			, info_hash varchar(40) NOT NULL
+			, verified boolean NOT NULL
+			, create_time integer NOT NULL
+			, update_time integer NOT NULL
+			, PRIMARY KEY (id)
+			, UNIQUE (info_hash)
+		)`,
+		`CREATE TABLE IF NOT EXISTS files_users (
+			file_id integer NOT NULL
+			, user_id integer NOT NULL
+			, ip varchar(15) NOT NULL
+			, active boolean NOT NULL
+			, completed boolean NOT NULL
+			, announced integer NOT NULL
+			, uploaded bigint NOT NULL
+			, downloaded bigint NOT NULL
+			, "left" bigint NOT NULL
+			, "time" integer NOT NULL
+			, UNIQUE (file_id, user_id, ip)
+		)`,
+		`CREATE INDEX IF NOT EXISTS files_users_ip ON files_users (ip)`,
+		`CREATE TABLE IF NOT EXISTS scrape_log (
+			id serial NOT NULL
+			, info_hash varchar(40) NOT NULL
+			, passkey varchar(40) NOT NULL
+			, ip varchar(15) NOT NULL
+			, "time" integer NOT NULL
+			, PRIMARY KEY (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id serial NOT NULL
+			, username varchar(20) NOT NULL
+			, password varchar(60) NOT NULL
+			, passkey varchar(40) NOT NULL
+			, torrent_limit integer NOT NULL
+			, PRIMARY KEY (id)
+			, UNIQUE (username)
+			, UNIQUE (password)
+			, UNIQUE (passkey)
+		)`,
+		`CREATE TABLE IF NOT EXISTS whitelist (
+			id serial NOT NULL
+			, client varchar(50) NOT NULL
+			, approved boolean NOT NULL
+			, PRIMARY KEY (id)
+			, UNIQUE (client)
+		)`,
+	}},
+	{2, "index log times for retention", []string{
+		`CREATE INDEX IF NOT EXISTS announce_log_time ON announce_log ("time")`,
+		`CREATE INDEX IF NOT EXISTS scrape_log_time ON scrape_log ("time")`,
+	}},
+	{3, "widen ip columns for IPv6", []string{
+		`ALTER TABLE announce_log ALTER COLUMN ip TYPE varchar(45)`,
+		`ALTER TABLE files_users ALTER COLUMN ip TYPE varchar(45)`,
+		`ALTER TABLE scrape_log ALTER COLUMN ip TYPE varchar(45)`,
+	}},
+	{4, "record peer_id with announces", []string{
+		`ALTER TABLE announce_log ADD COLUMN peer_id varchar(40) NOT NULL DEFAULT ''`,
+	}},
+	{5, "track active peers in peers table", []string{
+		`CREATE TABLE IF NOT EXISTS peers (
+			info_hash varchar(40) NOT NULL
+			, peer_id varchar(40) NOT NULL
+			, ipv6 boolean NOT NULL
+			, user_id integer NOT NULL
+			, ip varchar(45) NOT NULL
+			, port integer NOT NULL
+			, "left" bigint NOT NULL
+			, "time" integer NOT NULL
+			, PRIMARY KEY (info_hash, peer_id, ipv6)
+		)`,
+		`CREATE INDEX IF NOT EXISTS peers_info_hash_time ON peers (info_hash, "time")`,
+	}},
+	{6, "add client_rules table for peer_id rules", []string{
+		`CREATE TABLE IF NOT EXISTS client_rules (
+			id serial NOT NULL
+			, prefix varchar(20) NOT NULL
+			, min_version varchar(5) NOT NULL DEFAULT ''
+			, max_version varchar(5) NOT NULL DEFAULT ''
+			, blacklist boolean NOT NULL
+			, description varchar(255) NOT NULL DEFAULT ''
+			, PRIMARY KEY (id)
+			, UNIQUE (prefix, min_version, max_version)
+		)`,
+	}},
+	{7, "store torrent metadata on files", []string{
+		`ALTER TABLE files ADD COLUMN name varchar(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN size bigint NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN file_count integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN piece_length integer NOT NULL DEFAULT 0`,
+	}},
+	{8, "store torrent metainfo", []string{
+		`CREATE TABLE IF NOT EXISTS torrents (
+			info_hash varchar(40) NOT NULL
+			, metainfo bytea NOT NULL
+			, PRIMARY KEY (info_hash)
+		)`,
+	}},
+	{9, "track traffic per announce session", []string{
+		`CREATE TABLE IF NOT EXISTS sessions (
+			info_hash varchar(40) NOT NULL
+			, peer_id varchar(40) NOT NULL
+			, "key" varchar(40) NOT NULL
+			, user_id integer NOT NULL
+			, uploaded bigint NOT NULL
+			, downloaded bigint NOT NULL
+			, "time" integer NOT NULL
+			, PRIMARY KEY (info_hash, peer_id, "key")
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_info_hash_time ON sessions (info_hash, "time")`,
+		`CREATE TABLE IF NOT EXISTS user_totals (
+			user_id integer NOT NULL
+			, uploaded bigint NOT NULL
+			, downloaded bigint NOT NULL
+			, PRIMARY KEY (user_id)
+		)`,
+		`INSERT INTO user_totals (user_id, uploaded, downloaded)
+			SELECT user_id, SUM(uploaded), SUM(downloaded) FROM files_users GROUP BY user_id`,
+	}},
+}
+
+// SchemaVersion returns the current version of the PostgreSQL schema, creating the version table if needed
+func (db *pgw) SchemaVersion() (int, error) {
+	query := `CREATE TABLE IF NOT EXISTS schema_version (
+		version integer NOT NULL
+		, description varchar(100) NOT NULL
+		, "time" integer NOT NULL
+		, PRIMARY KEY (version)
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, err
+}
+
+// Migrate applies a single migration to the PostgreSQL schema, and records its version
+func (db *pgw) Migrate(m migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range m.Statements {
+		if _, err := tx.Exec(s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_version VALUES ($1, $2, "+pgNow+")", m.Version, m.Description); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
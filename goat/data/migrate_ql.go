@@ -0,0 +1,187 @@
+// +build ql
+
+package data
+
+import (
+	"time"
+
+	"github.com/cznic/ql"
+)
+
+// init registers the ql schema migrations
+func init() {
+	dbMigrations = qlMigrations
+}
+
+// qlMigrations contains all ql schema migrations, ordered by version
+// NOTE: version 1 matches the schema files in res/ql/, so databases created from them are adopted as-is
+var qlMigrations = []migration{
+	{1, "initial schema", []string{
+		`CREATE TABLE IF NOT EXISTS announce_log (
+			info_hash  string,
+			passkey    string,
+			key        string,
+			ip         string,
+			port       int32,
+			udp        bool,
+			uploaded   int64,
+			downloaded int64,
+			left       int64,
+			event      string,
+			client     string,
+			ts         time
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			user_id int64,
+			pubkey string,
+			secret string,
+			expire int64
+		)`,
+		`CREATE TABLE IF NOT EXISTS files (
+			info_hash   string,
+			verified    bool,
+			create_time time,
+			update_time time
+		)`,
+		`CREATE TABLE IF NOT EXISTS files_users (
+			file_id    int64,
+			user_id    int64,
+			ip         string,
+			active     bool,
+			completed  bool,
+			announced  int64,
+			uploaded   int64,
+			downloaded int64,
+			left       int64,
+			ts         time
+		)`,
+		`CREATE TABLE IF NOT EXISTS scrape_log (
+			info_hash string,
+			passkey   string,
+			ip        string,
+			ts        time
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			username      string,
+			password      string,
+			passkey       string,
+			torrent_limit int
+		)`,
+		`CREATE TABLE IF NOT EXISTS whitelist (
+			client   string,
+			approved bool
+		)`,
+	}},
+	{2, "index log times for retention", []string{
+		`CREATE INDEX IF NOT EXISTS announce_log_ts ON announce_log (ts)`,
+		`CREATE INDEX IF NOT EXISTS scrape_log_ts ON scrape_log (ts)`,
+	}},
+	// ql strings are unbounded, so IPv6 addresses need no schema change, but the version is kept in step
+	{3, "widen ip columns for IPv6", []string{}},
+	{4, "record peer_id with announces", []string{
+		`ALTER TABLE announce_log ADD peer_id string`,
+		`UPDATE announce_log peer_id = ""`,
+	}},
+	{5, "track active peers in peers table", []string{
+		`CREATE TABLE IF NOT EXISTS peers (
+			info_hash string,
+			peer_id   string,
+			ipv6      bool,
+			user_id   int64,
+			ip        string,
+			port      int32,
+			left      int64,
+			ts        time
+		)`,
+		`CREATE INDEX IF NOT EXISTS peers_info_hash ON peers (info_hash)`,
+	}},
+	{6, "add client_rules table for peer_id rules", []string{
+		`CREATE TABLE IF NOT EXISTS client_rules (
+			prefix      string,
+			min_version string,
+			max_version string,
+			blacklist   bool,
+			description string
+		)`,
+	}},
+	{7, "store torrent metadata on files", []string{
+		`ALTER TABLE files ADD name string`,
+		`ALTER TABLE files ADD size int64`,
+		`ALTER TABLE files ADD file_count int64`,
+		`ALTER TABLE files ADD piece_length int64`,
+		`UPDATE files name = "", size = int64(0), file_count = int64(0), piece_length = int64(0)`,
+	}},
+	{8, "store torrent metainfo", []string{
+		`CREATE TABLE IF NOT EXISTS torrents (
+			info_hash string,
+			metainfo  blob
+		)`,
+		`CREATE INDEX IF NOT EXISTS torrents_info_hash ON torrents (info_hash)`,
+	}},
+	{9, "track traffic per announce session", []string{
+		`CREATE TABLE IF NOT EXISTS sessions (
+			info_hash  string,
+			peer_id    string,
+			key        string,
+			user_id    int64,
+			uploaded   int64,
+			downloaded int64,
+			ts         time
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_info_hash ON sessions (info_hash)`,
+		`CREATE TABLE IF NOT EXISTS user_totals (
+			user_id    int64,
+			uploaded   int64,
+			downloaded int64
+		)`,
+		`CREATE INDEX IF NOT EXISTS user_totals_user_id ON user_totals (user_id)`,
+		`INSERT INTO user_totals SELECT user_id, sum(uploaded), sum(downloaded) FROM files_users GROUP BY user_id`,
+	}},
+}
+
+// SchemaVersion returns the current version of the ql schema, creating the version table if needed
+func (db *qlw) SchemaVersion() (int, error) {
+	tx := db.NewTransaction()
+	if _, _, err := tx.Run("CREATE TABLE IF NOT EXISTS schema_version (version int64, description string, ts time);"); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	rs, _, err := db.Run(ql.NewRWCtx(), "SELECT max(version) FROM schema_version;")
+	if err != nil || len(rs) < 1 {
+		return 0, err
+	}
+
+	// max() yields NULL on an empty table, meaning no migrations have been applied
+	var version int
+	err = rs[len(rs)-1].Do(false, func(data []interface{}) (bool, error) {
+		if v, ok := data[0].(int64); ok {
+			version = int(v)
+		}
+
+		return false, nil
+	})
+
+	return version, err
+}
+
+// Migrate applies a single migration to the ql schema, and records its version
+func (db *qlw) Migrate(m migration) error {
+	tx := db.NewTransaction()
+	for _, s := range m.Statements {
+		if _, _, err := tx.Run(s + ";"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, _, err := tx.Run("INSERT INTO schema_version VALUES ($1, $2, $3);", int64(m.Version), m.Description, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestMigrate verifies that schema migrations are applied, and that a newer schema is refused
+func TestMigrate(t *testing.T) {
+	log.Println("TestMigrate()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Bring schema up to date
+	version, err := Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate schema: %s", err.Error())
+	}
+
+	if version != LatestSchemaVersion() {
+		t.Fatalf("Schema version, expected %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	// Migrating again should be a no-op
+	if version, err = Migrate(); err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("Failed to re-run migrations: %v (version %d)", err, version)
+	}
+
+	// Backends without a schema cannot be newer than goat
+	db, err := DBConnect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	if _, ok := db.(migrator); !ok {
+		return
+	}
+
+	// Pretend this binary knows of no migrations, so the existing schema appears newer
+	migrations := dbMigrations
+	dbMigrations = nil
+	defer func() {
+		dbMigrations = migrations
+	}()
+
+	if _, err := Migrate(); err != ErrSchemaNewer {
+		t.Fatalf("Expected ErrSchemaNewer, got: %v", err)
+	}
+}
@@ -7,13 +7,27 @@ import (
 	"net"
 )
 
-// Peer represents an IP and port peer, used as part of the peer list
+// ErrPeerIP is returned when a peer's IP is not a valid IPv4 or IPv6 address
+var ErrPeerIP = errors.New("data: peer IP is not a valid IPv4 or IPv6 address")
+
+// Peer represents an IP and port peer, used as part of the peer list.  PeerID is the hex encoded
+// peer_id the peer last announced with, which is only sent in non-compact peer lists.  Seeder is
+// set if the peer has announced with nothing left to download, and is used to select peer lists.
 type Peer struct {
-	IP   string
-	Port uint16
+	IP     string
+	Port   uint16
+	PeerID string `db:"peer_id"`
+	Seeder bool
+}
+
+// IPv6 reports whether this peer has an IPv6 address, and so is packed into 18 bytes rather than 6
+func (p Peer) IPv6() bool {
+	ip := net.ParseIP(p.IP)
+	return ip != nil && ip.To4() == nil
 }
 
-// MarshalBinary creates a packed byte array from a peer
+// MarshalBinary creates a packed byte array from a peer, which is 6 bytes for an IPv4 peer,
+// or 18 bytes for an IPv6 peer (BEP 7)
 func (p Peer) MarshalBinary() ([]byte, error) {
 	res := bytes.NewBuffer(make([]byte, 0))
 
@@ -22,8 +36,19 @@ func (p Peer) MarshalBinary() ([]byte, error) {
 		return nil, nil
 	}
 
-	// IP (uint32)
-	if err := binary.Write(res, binary.BigEndian, binary.BigEndian.Uint32((net.ParseIP(p.IP).To4()))); err != nil {
+	// Check for valid IP
+	ip := net.ParseIP(p.IP)
+	if ip == nil {
+		return nil, ErrPeerIP
+	}
+
+	// IPv4 addresses are packed as 4 bytes, and IPv6 addresses as 16 bytes
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	// IP ([4]byte or [16]byte)
+	if _, err := res.Write(ip); err != nil {
 		return nil, err
 	}
 
@@ -35,7 +60,8 @@ func (p Peer) MarshalBinary() ([]byte, error) {
 	return res.Bytes(), nil
 }
 
-// UnmarshalBinary creates a Peer from a packed byte array
+// UnmarshalBinary creates a Peer from a packed byte array, which is treated as an IPv6 peer
+// if it is 18 bytes long, or an IPv4 peer otherwise
 func (p *Peer) UnmarshalBinary(buf []byte) (err error) {
 	// Set up recovery function to catch a panic as an error
 	// This will run if we attempt to access an out of bounds index
@@ -45,6 +71,13 @@ func (p *Peer) UnmarshalBinary(buf []byte) (err error) {
 		}
 	}()
 
+	// IPv6 ([16]byte -> string)
+	if len(buf) == 18 {
+		p.IP = net.IP(buf[0:16]).String()
+		p.Port = binary.BigEndian.Uint16(buf[16:18])
+		return nil
+	}
+
 	// IP (uint32 -> string)
 	p.IP = net.IPv4(buf[0], buf[1], buf[2], buf[3]).String()
 
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"net"
+)
+
+// PeerRecord represents a peer active on a file, keyed by info hash and peer_id.  A dual-stack peer which
+// reports an alternate address (BEP 7) has one record for each address family.
+type PeerRecord struct {
+	InfoHash string `db:"info_hash" json:"infoHash"`
+	PeerID   string `db:"peer_id" json:"peerId"`
+	IPv6     bool   `json:"ipv6"`
+	UserID   int    `db:"user_id" json:"userId"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Left     int64  `json:"left"`
+	Time     int64  `json:"time"`
+}
+
+// FromAnnounceLog creates a PeerRecord from an announce made by a user
+func (p *PeerRecord) FromAnnounceLog(a AnnounceLog, userID int) {
+	p.InfoHash = a.InfoHash
+	p.PeerID = a.PeerID
+	p.UserID = userID
+	p.IP = a.IP
+	p.Port = a.Port
+	p.Left = a.Left
+
+	// Address family is part of the key, so a peer's alternate address does not replace its primary one
+	ip := net.ParseIP(a.IP)
+	p.IPv6 = ip != nil && ip.To4() == nil
+}
+
+// Delete PeerRecord from storage, for all of the peer's address families
+func (p PeerRecord) Delete() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete PeerRecord
+	if err = db.DeletePeerRecord(p.InfoHash, p.PeerID); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Save PeerRecord to storage, replacing any existing record for this peer and address family
+func (p PeerRecord) Save() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save PeerRecord
+	if err := db.SavePeerRecord(p); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestPeerRecord verifies that PeerRecord creation, save, and delete work properly
+func TestPeerRecord(t *testing.T) {
+	log.Println("TestPeerRecord()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate mock PeerRecords from an IPv4 announce and its IPv6 alternate address
+	peer := new(PeerRecord)
+	peer.FromAnnounceLog(AnnounceLog{
+		InfoHash: "6465616462656566000000000000000000000001",
+		PeerID:   "2d676f617430312d6162636465666768696a6b6c",
+		IP:       "10.1.1.1",
+		Port:     6881,
+		Left:     0,
+	}, 1)
+	if peer.IPv6 {
+		t.Fatalf("PeerRecord IPv6 flag set on IPv4 address")
+	}
+
+	peer6 := *peer
+	peer6.FromAnnounceLog(AnnounceLog{
+		InfoHash: peer.InfoHash,
+		PeerID:   peer.PeerID,
+		IP:       "2001:db8::1",
+		Port:     6881,
+		Left:     0,
+	}, 1)
+	if !peer6.IPv6 {
+		t.Fatalf("PeerRecord IPv6 flag not set on IPv6 address")
+	}
+
+	// Save mock peers
+	if err := peer.Save(); err != nil {
+		t.Fatalf("Failed to save mock peer: %s", err.Error())
+	}
+	if err := peer6.Save(); err != nil {
+		t.Fatalf("Failed to save mock peer: %s", err.Error())
+	}
+
+	// Both address families are returned in the file's peer list
+	file := FileRecord{InfoHash: peer.InfoHash}
+	peers, err := file.PeerList(50, PeerRequest{})
+	if err != nil {
+		t.Fatalf("Failed to load peer list: %s", err.Error())
+	}
+	if len(peers) != 2 {
+		t.Fatalf("Peer list, expected 2 peers, got %v", peers)
+	}
+	for _, p := range peers {
+		if !p.Seeder || p.Port != 6881 {
+			t.Fatalf("Peer list contains unexpected peer: %v", p)
+		}
+	}
+
+	// Delete mock peers, which removes both address families
+	if err := peer.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock peer: %s", err.Error())
+	}
+	if peers, err := file.PeerList(50, PeerRequest{}); err != nil || len(peers) != 0 {
+		t.Fatalf("Peer list, expected no peers, got %v", peers)
+	}
+}
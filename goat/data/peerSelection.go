@@ -0,0 +1,236 @@
+package data
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// PeerRequest describes the peer which requested a peer list, so that the list can be selected for it.
+// PeerID is hex encoded, in the same manner as an AnnounceLog.
+type PeerRequest struct {
+	IP     string
+	Port   uint16
+	PeerID string
+	Seeder bool
+}
+
+// peerQuery selects the peers on a file from which a peer list is drawn.  Peers are filtered by storage,
+// so that a peer list is filled from the whole swarm, rather than from a sample of it which was filtered later.
+type peerQuery struct {
+	// PeerID, IP, and Port identify a requester which is left out of the list.  An empty PeerID or IP
+	// matches no peer.
+	PeerID string
+	IP     string
+	Port   int
+
+	// Leechers leaves seeders out of the list
+	Leechers bool
+
+	// Stable orders peers by peer_id and address, rather than most recent first, so that an offset selects
+	// the same peers from one query to the next
+	Stable bool
+
+	Offset int
+	Limit  int
+}
+
+// excludes reports whether a peer is left out of the list by this query
+func (q peerQuery) excludes(p Peer) bool {
+	if q.Leechers && p.Seeder {
+		return true
+	}
+
+	// The requester is matched by its peer_id or address
+	if q.PeerID != "" && p.PeerID == q.PeerID {
+		return true
+	}
+
+	return q.IP != "" && p.IP == q.IP && int(p.Port) == q.Port
+}
+
+// newPeerQuery creates a peerQuery for a requester, using the peer filters enabled in configuration
+func newPeerQuery(req PeerRequest) peerQuery {
+	conf := common.Static.Config.Peers
+	q := peerQuery{}
+
+	// The requester does not need to be told about itself
+	if conf.ExcludeRequester {
+		q.PeerID = req.PeerID
+		q.IP = req.IP
+		q.Port = int(req.Port)
+	}
+
+	// Seeders have nothing to gain from other seeders
+	q.Leechers = conf.SeedersGetLeechers && req.Seeder
+
+	return q
+}
+
+// peerRandom is the source used to shuffle peer lists.  math/rand sources are not safe for concurrent use,
+// so it is guarded by a mutex.
+var peerRandom = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// peerRotation holds the offset at which each file's next rotated peer list begins
+var peerRotation = struct {
+	sync.Mutex
+	offsets map[string]int
+}{offsets: map[string]int{}}
+
+// loadPeers loads up to numwant peers for the requesting peer, using the peer filters and order enabled in
+// configuration.  count and load count and load the peers matching a query, from the database or a swarm store.
+func loadPeers(infoHash string, req PeerRequest, numwant int, count func(peerQuery) (int, error), load func(peerQuery) ([]Peer, error)) ([]Peer, error) {
+	q := newPeerQuery(req)
+	q.Limit = numwant
+
+	// Unless peers are handed out in turn or at random, the most recently announced peers are used
+	order := common.Static.Config.Peers.Order
+	if order != "random" && order != "rotate" {
+		return load(q)
+	}
+
+	total, err := count(q)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return []Peer{}, nil
+	}
+
+	// Begin at a random peer, or where the previous rotated peer list on this file ended
+	start := 0
+	if order == "random" {
+		peerRandom.Lock()
+		start = peerRandom.Intn(total)
+		peerRandom.Unlock()
+	} else {
+		start = rotationOffset(infoHash, total, numwant)
+	}
+
+	q.Stable = true
+	return wrapPeers(q, total, start, load)
+}
+
+// wrapPeers loads up to q.Limit of total peers beginning with the peer at start, wrapping around to the first
+// peer once the last has been passed, so that any peer in the swarm may be chosen
+func wrapPeers(q peerQuery, total int, start int, load func(peerQuery) ([]Peer, error)) ([]Peer, error) {
+	limit := q.Limit
+	if limit > total {
+		limit = total
+	}
+
+	q.Offset, q.Limit = start, limit
+	peers, err := load(q)
+	if err != nil || len(peers) >= limit || start == 0 {
+		return peers, err
+	}
+
+	// Fill the rest of the list from the first peers, without reaching those already loaded
+	q.Offset, q.Limit = 0, limit-len(peers)
+	if q.Limit > start {
+		q.Limit = start
+	}
+
+	more, err := load(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(peers, more...), nil
+}
+
+// selectPeers orders up to numwant peers loaded for the requesting peer, using the peer selection strategies
+// enabled in configuration
+func selectPeers(peers []Peer, req PeerRequest, numwant int) []Peer {
+	conf := common.Static.Config.Peers
+
+	// Shuffle peers loaded at random, so that the list does not always follow the stored order
+	if conf.Order == "random" {
+		shufflePeers(peers)
+	}
+
+	// Move peers in the same subnet as the requester to the front, keeping their order otherwise
+	if conf.PreferSubnet {
+		peers = subnetPeers(peers, req.IP, conf.SubnetIPv4, conf.SubnetIPv6)
+	}
+
+	if len(peers) > numwant {
+		peers = peers[:numwant]
+	}
+
+	return peers
+}
+
+// shufflePeers randomises the order of a list of peers, in place
+func shufflePeers(peers []Peer) {
+	peerRandom.Lock()
+	defer peerRandom.Unlock()
+
+	for i := len(peers) - 1; i > 0; i-- {
+		j := peerRandom.Intn(i + 1)
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+}
+
+// rotationOffset returns the offset at which the next rotated peer list on a file of total peers begins,
+// and advances it past the numwant peers handed out, so that each peer is handed out in turn
+func rotationOffset(infoHash string, total int, numwant int) int {
+	peerRotation.Lock()
+	defer peerRotation.Unlock()
+
+	offset := peerRotation.offsets[infoHash] % total
+	peerRotation.offsets[infoHash] = (offset + numwant) % total
+
+	return offset
+}
+
+// forgetRotation discards the rotation offset of a file, once its swarm is empty
+func forgetRotation(infoHash string) {
+	peerRotation.Lock()
+	delete(peerRotation.offsets, infoHash)
+	peerRotation.Unlock()
+}
+
+// subnetPeers stably moves peers which share a subnet with ip to the front of a list of peers.  Subnets
+// are bits4 long for IPv4 and bits6 long for IPv6, defaulting to /24 and /48.
+func subnetPeers(peers []Peer, ip string, bits4 int, bits6 int) []Peer {
+	reqIP := net.ParseIP(ip)
+	if reqIP == nil {
+		return peers
+	}
+
+	if bits4 <= 0 {
+		bits4 = 24
+	}
+	if bits6 <= 0 {
+		bits6 = 48
+	}
+
+	// Choose the subnet mask for the requester's address family
+	mask := net.CIDRMask(bits6, 8*net.IPv6len)
+	if reqIP.To4() != nil {
+		reqIP = reqIP.To4()
+		mask = net.CIDRMask(bits4, 8*net.IPv4len)
+	}
+	subnet := net.IPNet{IP: reqIP.Mask(mask), Mask: mask}
+
+	near := make([]Peer, 0, len(peers))
+	far := make([]Peer, 0, len(peers))
+	for _, p := range peers {
+		// Peers of the other address family never match the subnet
+		peerIP := net.ParseIP(p.IP)
+		if peerIP != nil && (peerIP.To4() != nil) == (reqIP.To4() != nil) && subnet.Contains(peerIP) {
+			near = append(near, p)
+		} else {
+			far = append(far, p)
+		}
+	}
+
+	return append(near, far...)
+}
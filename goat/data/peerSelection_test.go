@@ -0,0 +1,191 @@
+package data
+
+import (
+	"log"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestSelectPeers verifies that peer lists are selected for the requesting peer properly
+func TestSelectPeers(t *testing.T) {
+	log.Println("TestSelectPeers()")
+
+	// Mock peers loaded for a requester, including an IPv6 leecher
+	candidates := []Peer{
+		{IP: "10.0.0.1", Port: 6881, PeerID: "aa", Seeder: true},
+		{IP: "192.168.1.2", Port: 6882, PeerID: "bb", Seeder: true},
+		{IP: "192.168.1.3", Port: 6883, PeerID: "cc"},
+		{IP: "10.0.0.4", Port: 6884, PeerID: "dd"},
+		{IP: "2001:db8::5", Port: 6885, PeerID: "ee"},
+	}
+	leecher := PeerRequest{IP: "10.0.0.9", Port: 7000, PeerID: "ff"}
+
+	var tests = []struct {
+		conf    string
+		req     PeerRequest
+		numwant int
+		result  []string
+	}{
+		// No strategies, so peers are returned as loaded
+		{"", leecher, 50, []string{"aa", "bb", "cc", "dd", "ee"}},
+		{"", leecher, 2, []string{"aa", "bb"}},
+		// Peers in the requester's subnet are preferred, keeping their order otherwise
+		{"subnet", leecher, 50, []string{"aa", "dd", "bb", "cc", "ee"}},
+		{"subnet", PeerRequest{IP: "2001:db8::1"}, 2, []string{"ee", "aa"}},
+	}
+
+	// Restore peer configuration once done
+	original := common.Static.Config.Peers
+	defer func() {
+		common.Static.Config.Peers = original
+	}()
+
+	for i, test := range tests {
+		// Enable only the strategy under test
+		common.Static.Config.Peers.Order = ""
+		common.Static.Config.Peers.PreferSubnet = test.conf == "subnet"
+
+		peers := selectPeers(append([]Peer(nil), candidates...), test.req, test.numwant)
+		if len(peers) != len(test.result) {
+			t.Fatalf("[%02d] Peer list, expected %v, got %v", i, test.result, peers)
+		}
+		for j, p := range peers {
+			if p.PeerID != test.result[j] {
+				t.Fatalf("[%02d] Peer list, expected %v, got %v", i, test.result, peers)
+			}
+		}
+	}
+
+	// Random peer lists contain the same peers, in any order
+	common.Static.Config.Peers.PreferSubnet = false
+	common.Static.Config.Peers.Order = "random"
+	if peers := selectPeers(append([]Peer(nil), candidates...), leecher, 50); len(peers) != len(candidates) {
+		t.Fatalf("Random peer list, expected %d peers, got %d", len(candidates), len(peers))
+	}
+}
+
+// TestLoadPeers verifies that peer lists are loaded using the configured filters, and that rotated and
+// random peer lists are drawn from the whole swarm
+func TestLoadPeers(t *testing.T) {
+	log.Println("TestLoadPeers()")
+
+	// Mock swarm containing the requester, another seeder, and three leechers
+	swarm := []Peer{
+		{IP: "10.0.0.1", Port: 6881, PeerID: "aa", Seeder: true},
+		{IP: "192.168.1.2", Port: 6882, PeerID: "bb", Seeder: true},
+		{IP: "192.168.1.3", Port: 6883, PeerID: "cc"},
+		{IP: "10.0.0.4", Port: 6884, PeerID: "dd"},
+		{IP: "2001:db8::5", Port: 6885, PeerID: "ee"},
+	}
+	seeder := PeerRequest{IP: "10.0.0.1", Port: 6881, PeerID: "aa", Seeder: true}
+	leecher := PeerRequest{IP: "10.0.0.9", Port: 7000, PeerID: "ff"}
+
+	// Mock storage, which applies queries to the swarm
+	filter := func(q peerQuery) []Peer {
+		peers := make([]Peer, 0)
+		for _, p := range swarm {
+			if !q.excludes(p) {
+				peers = append(peers, p)
+			}
+		}
+
+		return peers
+	}
+	count := func(q peerQuery) (int, error) {
+		return len(filter(q)), nil
+	}
+	load := func(q peerQuery) ([]Peer, error) {
+		peers := filter(q)
+		if q.Offset >= len(peers) {
+			return []Peer{}, nil
+		}
+		peers = peers[q.Offset:]
+		if len(peers) > q.Limit {
+			peers = peers[:q.Limit]
+		}
+
+		return peers, nil
+	}
+
+	ids := func(peers []Peer) string {
+		s := ""
+		for _, p := range peers {
+			s += p.PeerID
+		}
+
+		return s
+	}
+
+	var tests = []struct {
+		conf    string
+		req     PeerRequest
+		numwant int
+		result  string
+	}{
+		// No filters, so peers are loaded as stored
+		{"", seeder, 50, "aabbccddee"},
+		{"", seeder, 2, "aabb"},
+		// Requester is excluded by peer_id or address
+		{"exclude", seeder, 50, "bbccddee"},
+		{"exclude", PeerRequest{IP: "10.0.0.1", Port: 6881}, 50, "bbccddee"},
+		// Seeders only receive leechers, even when leechers are not among the first peers stored
+		{"seeders", seeder, 2, "ccdd"},
+		{"seeders", leecher, 50, "aabbccddee"},
+	}
+
+	// Restore peer configuration once done
+	original := common.Static.Config.Peers
+	defer func() {
+		common.Static.Config.Peers = original
+	}()
+
+	for i, test := range tests {
+		// Enable only the filter under test
+		common.Static.Config.Peers.Order = ""
+		common.Static.Config.Peers.ExcludeRequester = test.conf == "exclude"
+		common.Static.Config.Peers.SeedersGetLeechers = test.conf == "seeders"
+
+		peers, err := loadPeers("loadpeers", test.req, test.numwant, count, load)
+		if err != nil || ids(peers) != test.result {
+			t.Fatalf("[%02d] Peer list, expected %s, got %s", i, test.result, ids(peers))
+		}
+	}
+
+	// Rotated peer lists hand out each peer in turn, wrapping around the swarm
+	common.Static.Config.Peers.ExcludeRequester = false
+	common.Static.Config.Peers.SeedersGetLeechers = false
+	common.Static.Config.Peers.Order = "rotate"
+	for i, result := range []string{"aabb", "ccdd", "eeaa", "bbcc"} {
+		peers, err := loadPeers("loadpeers", leecher, 2, count, load)
+		if err != nil || ids(peers) != result {
+			t.Fatalf("[%02d] Rotated peer list, expected %s, got %s", i, result, ids(peers))
+		}
+	}
+
+	// Rotation begins again once the swarm is forgotten
+	forgetRotation("loadpeers")
+	if peers, _ := loadPeers("loadpeers", leecher, 2, count, load); ids(peers) != "aabb" {
+		t.Fatalf("Rotated peer list not reset, got %s", ids(peers))
+	}
+
+	// Random peer lists contain as many distinct peers as the swarm allows
+	common.Static.Config.Peers.Order = "random"
+	for i := 0; i < 10; i++ {
+		peers, err := loadPeers("loadpeers", leecher, 4, count, load)
+		if err != nil || len(peers) != 4 {
+			t.Fatalf("Random peer list, expected 4 peers, got %v", peers)
+		}
+
+		seen := map[string]bool{}
+		for _, p := range peers {
+			if seen[p.PeerID] {
+				t.Fatalf("Random peer list contains duplicate peer: %v", peers)
+			}
+			seen[p.PeerID] = true
+		}
+	}
+	if peers, _ := loadPeers("loadpeers", leecher, 50, count, load); len(peers) != len(swarm) {
+		t.Fatalf("Random peer list, expected %d peers, got %d", len(swarm), len(peers))
+	}
+}
@@ -32,3 +32,49 @@ func TestPeer(t *testing.T) {
 		t.Fatalf("Peer results do not match")
 	}
 }
+
+// TestPeerIPv6 verifies that IPv6 peers are packed into 18 bytes, and that invalid IPs are rejected
+func TestPeerIPv6(t *testing.T) {
+	log.Println("TestPeerIPv6()")
+
+	// Generate mock peer
+	peer := Peer{
+		IP:   "2001:db8::1",
+		Port: 8080,
+	}
+
+	if !peer.IPv6() {
+		t.Fatalf("Peer not detected as IPv6")
+	}
+
+	// Marshal to binary representation
+	out, err := peer.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal peer to binary: %s", err.Error())
+	}
+
+	if len(out) != 18 {
+		t.Fatalf("IPv6 peer packed into %d bytes, expected 18", len(out))
+	}
+
+	// Unmarshal peer from binary representation
+	peer2 := new(Peer)
+	if err := peer2.UnmarshalBinary(out); err != nil {
+		t.Fatalf("Failed to unmarshal peer from binary: %s", err.Error())
+	}
+
+	// Verify peers are identical
+	if peer.IP != peer2.IP || peer.Port != peer2.Port {
+		t.Fatalf("Peer results do not match: %v != %v", peer, peer2)
+	}
+
+	// IPv4-mapped IPv6 addresses are packed as IPv4
+	if out, _ := (Peer{IP: "::ffff:127.0.0.1", Port: 8080}).MarshalBinary(); len(out) != 6 {
+		t.Fatalf("IPv4-mapped peer packed into %d bytes, expected 6", len(out))
+	}
+
+	// Hostnames cannot be packed
+	if _, err := (Peer{IP: "example.com", Port: 8080}).MarshalBinary(); err != ErrPeerIP {
+		t.Fatalf("Invalid peer IP not rejected: %v", err)
+	}
+}
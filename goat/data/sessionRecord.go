@@ -0,0 +1,146 @@
+package data
+
+import (
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// sessionExpiry is the time after which a session which has not announced is forgotten.  Clients announce far
+// more often than this, so an expired session has almost certainly been closed without a "stopped" event.
+const sessionExpiry = 24 * time.Hour
+
+// SessionRecord represents an announce session, the period between a client's "started" and "stopped" events
+// on a file.  Clients report upload and download totals for the current session only, so the session's last
+// reported totals are stored, in order to account only the traffic reported since the previous announce.
+// Sessions are keyed by info hash, peer_id, and the client's announce key.
+type SessionRecord struct {
+	InfoHash   string `db:"info_hash" json:"infoHash"`
+	PeerID     string `db:"peer_id" json:"peerId"`
+	Key        string `json:"key"`
+	UserID     int    `db:"user_id" json:"userId"`
+	Uploaded   int64  `json:"uploaded"`
+	Downloaded int64  `json:"downloaded"`
+	Time       int64  `json:"time"`
+}
+
+// Delta returns the upload and download traffic reported since this session's previous announce.  A total lower
+// than the previous one means the client restarted without sending a "started" event, so its counters began
+// again from zero, and the whole of the reported total is new traffic.
+func (s SessionRecord) Delta(uploaded int64, downloaded int64) (int64, int64) {
+	return sessionDelta(s.Uploaded, uploaded), sessionDelta(s.Downloaded, downloaded)
+}
+
+// sessionDelta returns the traffic between a previously reported total and the current one
+func sessionDelta(previous int64, current int64) int64 {
+	if current < previous {
+		return current
+	}
+
+	return current - previous
+}
+
+// Delete SessionRecord from storage
+func (s SessionRecord) Delete() error {
+	return s.delete(context.Background())
+}
+
+// delete implements Delete, binding ctx to the queries of backends which can cancel them
+func (s SessionRecord) delete(ctx context.Context) error {
+	// Open database connection
+	db, err := dbConnect(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Delete SessionRecord
+	if err = db.DeleteSessionRecord(s.InfoHash, s.PeerID, s.Key); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Load SessionRecord from storage, using an info hash, peer_id, and announce key
+func (s SessionRecord) Load(infoHash string, peerID string, key string) (SessionRecord, error) {
+	return s.load(context.Background(), infoHash, peerID, key)
+}
+
+// load implements Load, binding ctx to the queries of backends which can cancel them
+func (s SessionRecord) load(ctx context.Context, infoHash string, peerID string, key string) (SessionRecord, error) {
+	// Open database connection
+	db, err := dbConnect(ctx)
+	if err != nil {
+		return SessionRecord{}, err
+	}
+
+	// Load SessionRecord using its key
+	if s, err = db.LoadSessionRecord(infoHash, peerID, key); err != nil {
+		return SessionRecord{}, err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return SessionRecord{}, err
+	}
+
+	return s, nil
+}
+
+// Save SessionRecord to storage, replacing any existing record for the same session
+func (s SessionRecord) Save() error {
+	return s.save(context.Background())
+}
+
+// save implements Save, binding ctx to the queries of backends which can cancel them
+func (s SessionRecord) save(ctx context.Context) error {
+	// Open database connection
+	db, err := dbConnect(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Save SessionRecord
+	if err := db.SaveSessionRecord(s); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteContext deletes a SessionRecord from storage, giving up once ctx is done
+func (s SessionRecord) DeleteContext(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return s.delete(ctx)
+	})
+}
+
+// LoadContext loads a SessionRecord from storage, giving up once ctx is done
+func (s SessionRecord) LoadContext(ctx context.Context, infoHash string, peerID string, key string) (SessionRecord, error) {
+	var session SessionRecord
+	if err := withContext(ctx, func() (err error) {
+		session, err = s.load(ctx, infoHash, peerID, key)
+		return
+	}); err != nil {
+		return SessionRecord{}, err
+	}
+
+	return session, nil
+}
+
+// SaveContext saves a SessionRecord to storage, giving up once ctx is done
+func (s SessionRecord) SaveContext(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return s.save(ctx)
+	})
+}
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"log"
+	"testing"
+)
+
+// TestSessionRecordDelta verifies that session traffic is measured against the previously reported totals,
+// and that totals which went backwards are counted from zero
+func TestSessionRecordDelta(t *testing.T) {
+	log.Println("TestSessionRecordDelta()")
+
+	session := SessionRecord{Uploaded: 1024, Downloaded: 4096}
+
+	var tests = []struct {
+		uploaded   int64
+		downloaded int64
+		deltaUp    int64
+		deltaDown  int64
+	}{
+		// No new traffic
+		{1024, 4096, 0, 0},
+		// New traffic in both directions
+		{2048, 8192, 1024, 4096},
+		// Client restarted, so both totals began again from zero
+		{512, 256, 512, 256},
+		// Downloaded total went backwards, so only it is counted from zero
+		{2048, 0, 1024, 0},
+	}
+
+	for i, test := range tests {
+		uploaded, downloaded := session.Delta(test.uploaded, test.downloaded)
+		if uploaded != test.deltaUp || downloaded != test.deltaDown {
+			t.Fatalf("[%02d] Unexpected delta, expected %d/%d, got %d/%d", i, test.deltaUp, test.deltaDown, uploaded, downloaded)
+		}
+	}
+}
@@ -0,0 +1,574 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+)
+
+const (
+	// stateFormat identifies a goat state archive
+	stateFormat = "goat-state"
+
+	// stateVersion is the newest state archive version understood by this version of goat.  Version 2 adds
+	// client rules, version 3 adds torrent metainfo, and version 4 adds users' lifetime traffic totals.
+	stateVersion = 4
+)
+
+var (
+	// ErrStateFormat is returned when an import is attempted using a file which is not a goat state archive
+	ErrStateFormat = errors.New("data: input is not a goat state archive")
+
+	// ErrStateVersion is returned when a state archive is newer than this version of goat understands
+	ErrStateVersion = errors.New("data: state archive version is newer than this version of goat")
+
+	// ErrStateDuplicate is returned when a state archive contains a username, passkey, or info_hash which
+	// already belongs to a different record, either in the archive or in storage
+	ErrStateDuplicate = errors.New("data: state archive conflicts on username, passkey, or info_hash")
+)
+
+// Tracker state is exported as newline-delimited JSON.  The first line is a stateHeader, and each
+// following line is a stateEntry.  Records refer to each other using usernames and info hashes rather
+// than IDs, because IDs are assigned by each backend and will not survive a move between them.
+
+// stateHeader is the first line of a state archive, identifying its format and version
+type stateHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// stateEntry is a single record in a state archive, with exactly one of its record fields set
+type stateEntry struct {
+	Type       string           `json:"type"`
+	User       *stateUser       `json:"user,omitempty"`
+	File       *stateFile       `json:"file,omitempty"`
+	Torrent    *stateTorrent    `json:"torrent,omitempty"`
+	Whitelist  *stateWhitelist  `json:"whitelist,omitempty"`
+	ClientRule *stateClientRule `json:"clientRule,omitempty"`
+	FileUser   *stateFileUser   `json:"fileUser,omitempty"`
+	APIKey     *stateAPIKey     `json:"apiKey,omitempty"`
+}
+
+// stateUser is a UserRecord in a state archive
+type stateUser struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Passkey      string `json:"passkey"`
+	TorrentLimit int    `json:"torrentLimit"`
+	Uploaded     int64  `json:"uploaded,omitempty"`
+	Downloaded   int64  `json:"downloaded,omitempty"`
+}
+
+// stateFile is a FileRecord in a state archive
+type stateFile struct {
+	InfoHash    string `json:"infoHash"`
+	Verified    bool   `json:"verified"`
+	Name        string `json:"name,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	FileCount   int    `json:"fileCount,omitempty"`
+	PieceLength int64  `json:"pieceLength,omitempty"`
+}
+
+// stateTorrent is a TorrentRecord in a state archive
+type stateTorrent struct {
+	InfoHash string `json:"infoHash"`
+	Metainfo []byte `json:"metainfo"`
+}
+
+// stateWhitelist is a WhitelistRecord in a state archive
+type stateWhitelist struct {
+	Client   string `json:"client"`
+	Approved bool   `json:"approved"`
+}
+
+// stateClientRule is a ClientRuleRecord in a state archive
+type stateClientRule struct {
+	Prefix      string `json:"prefix"`
+	MinVersion  string `json:"minVersion"`
+	MaxVersion  string `json:"maxVersion"`
+	Blacklist   bool   `json:"blacklist"`
+	Description string `json:"description"`
+}
+
+// stateFileUser is a FileUserRecord in a state archive, where an empty username is an anonymous user
+type stateFileUser struct {
+	InfoHash   string `json:"infoHash"`
+	Username   string `json:"username"`
+	IP         string `json:"ip"`
+	Active     bool   `json:"active"`
+	Completed  bool   `json:"completed"`
+	Announced  int    `json:"announced"`
+	Uploaded   int64  `json:"uploaded"`
+	Downloaded int64  `json:"downloaded"`
+	Left       int64  `json:"left"`
+}
+
+// stateAPIKey is an APIKey in a state archive
+type stateAPIKey struct {
+	Username string `json:"username"`
+	Pubkey   string `json:"pubkey"`
+	Secret   string `json:"secret"`
+	Expire   int64  `json:"expire"`
+}
+
+// ExportState writes all users, files, torrent metainfo, whitelist entries, client rules, file/user
+// relationships, and API keys to a state archive, returning the number of records written
+func ExportState(w io.Writer) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(stateHeader{stateFormat, stateVersion}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	write := func(e stateEntry) error {
+		count++
+		return enc.Encode(e)
+	}
+
+	// Users, keeping track of usernames so other records may refer to them
+	users, err := db.GetAllUserRecords()
+	if err != nil {
+		return count, err
+	}
+
+	usernames := map[int]string{}
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+
+		uploaded, err := db.GetUserUploaded(u.ID)
+		if err != nil {
+			return count, err
+		}
+		downloaded, err := db.GetUserDownloaded(u.ID)
+		if err != nil {
+			return count, err
+		}
+
+		if err := write(stateEntry{Type: "user", User: &stateUser{u.Username, u.Password, u.Passkey, u.TorrentLimit, uploaded, downloaded}}); err != nil {
+			return count, err
+		}
+	}
+
+	// Files
+	files, err := db.GetAllFileRecords()
+	if err != nil {
+		return count, err
+	}
+
+	for _, f := range files {
+		if err := write(stateEntry{Type: "file", File: &stateFile{f.InfoHash, f.Verified, f.Name, f.Size, f.FileCount, f.PieceLength}}); err != nil {
+			return count, err
+		}
+	}
+
+	// Torrent metainfo
+	torrents, err := db.GetAllTorrentRecords()
+	if err != nil {
+		return count, err
+	}
+
+	for _, t := range torrents {
+		if err := write(stateEntry{Type: "torrent", Torrent: &stateTorrent{t.InfoHash, t.Metainfo}}); err != nil {
+			return count, err
+		}
+	}
+
+	// Whitelist
+	whitelist, err := db.GetAllWhitelistRecords()
+	if err != nil {
+		return count, err
+	}
+
+	for _, wl := range whitelist {
+		if err := write(stateEntry{Type: "whitelist", Whitelist: &stateWhitelist{wl.Client, wl.Approved}}); err != nil {
+			return count, err
+		}
+	}
+
+	// Client rules
+	rules, err := db.GetAllClientRuleRecords()
+	if err != nil {
+		return count, err
+	}
+
+	for _, c := range rules {
+		if err := write(stateEntry{Type: "clientRule", ClientRule: &stateClientRule{c.Prefix, c.MinVersion, c.MaxVersion, c.Blacklist, c.Description}}); err != nil {
+			return count, err
+		}
+	}
+
+	// File/user relationships, for each file
+	for _, f := range files {
+		fileUsers, err := db.LoadFileUserRepository(f.ID, "file_id")
+		if err != nil {
+			return count, err
+		}
+
+		for _, fu := range fileUsers {
+			// Anonymous relationships are kept, but those belonging to deleted users are not
+			username, ok := usernames[fu.UserID]
+			if !ok && fu.UserID != 0 {
+				log.Printf("export: skipping file/user for missing user ID %d on file %s", fu.UserID, f.InfoHash)
+				continue
+			}
+
+			entry := stateFileUser{f.InfoHash, username, fu.IP, fu.Active, fu.Completed, fu.Announced, fu.Uploaded, fu.Downloaded, fu.Left}
+			if err := write(stateEntry{Type: "fileUser", FileUser: &entry}); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	// API keys
+	keys, err := db.GetAllAPIKeys()
+	if err != nil {
+		return count, err
+	}
+
+	for _, k := range keys {
+		username, ok := usernames[k.UserID]
+		if !ok {
+			log.Printf("export: skipping API key for missing user ID %d", k.UserID)
+			continue
+		}
+
+		if err := write(stateEntry{Type: "apiKey", APIKey: &stateAPIKey{username, k.Pubkey, k.Secret, k.Expire}}); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// ImportState reads a state archive into storage, returning the number of records imported.  The whole
+// archive is checked before anything is written, and records which already exist are skipped, so an
+// import may safely be repeated.  Any username, passkey, or info_hash which is duplicated within the
+// archive, or which already belongs to a different record in storage, causes the import to be rejected.
+func ImportState(r io.Reader) (int, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	// Read and verify archive
+	entries, err := readState(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Check archive for duplicates, and against existing records
+	if err := checkState(db, entries); err != nil {
+		return 0, err
+	}
+
+	// Load existing client rules, which are identified by prefix and version range rather than by one column
+	rules, err := db.GetAllClientRuleRecords()
+	if err != nil {
+		return 0, err
+	}
+
+	// Write records, in an order which ensures records exist before others refer to them
+	userIDs := map[string]int{"": 0}
+	fileIDs := map[string]int{}
+	count := 0
+
+	for _, e := range entries {
+		switch e.Type {
+		case "user":
+			u := e.User
+			user, err := db.LoadUserRecord(u.Username, "username")
+			if err != nil {
+				return count, err
+			}
+
+			// Only new users are saved, existing users were checked to match
+			if user == (UserRecord{}) {
+				if err := db.SaveUserRecord(UserRecord{Username: u.Username, Password: u.Password, Passkey: u.Passkey, TorrentLimit: u.TorrentLimit}); err != nil {
+					return count, err
+				}
+
+				if user, err = db.LoadUserRecord(u.Username, "username"); err != nil {
+					return count, err
+				}
+
+				if u.Uploaded > 0 || u.Downloaded > 0 {
+					if err := db.AddUserTraffic(user.ID, u.Uploaded, u.Downloaded); err != nil {
+						return count, err
+					}
+				}
+				count++
+			}
+
+			userIDs[u.Username] = user.ID
+		case "file":
+			f := e.File
+			file, err := db.LoadFileRecord(f.InfoHash, "info_hash")
+			if err != nil {
+				return count, err
+			}
+
+			if file == (FileRecord{}) {
+				if err := db.SaveFileRecord(FileRecord{
+					InfoHash:    f.InfoHash,
+					Verified:    f.Verified,
+					Name:        f.Name,
+					Size:        f.Size,
+					FileCount:   f.FileCount,
+					PieceLength: f.PieceLength,
+				}); err != nil {
+					return count, err
+				}
+
+				if file, err = db.LoadFileRecord(f.InfoHash, "info_hash"); err != nil {
+					return count, err
+				}
+				count++
+			}
+
+			fileIDs[f.InfoHash] = file.ID
+		case "torrent":
+			t := e.Torrent
+			torrent, err := db.LoadTorrentRecord(t.InfoHash, "info_hash")
+			if err != nil {
+				return count, err
+			}
+
+			if torrent.InfoHash == "" {
+				if err := db.SaveTorrentRecord(TorrentRecord{InfoHash: t.InfoHash, Metainfo: t.Metainfo}); err != nil {
+					return count, err
+				}
+				count++
+			}
+		case "whitelist":
+			w := e.Whitelist
+			whitelist, err := db.LoadWhitelistRecord(w.Client, "client")
+			if err != nil {
+				return count, err
+			}
+
+			if whitelist == (WhitelistRecord{}) {
+				if err := db.SaveWhitelistRecord(WhitelistRecord{Client: w.Client, Approved: w.Approved}); err != nil {
+					return count, err
+				}
+				count++
+			}
+		case "clientRule":
+			c := e.ClientRule
+			rule := ClientRuleRecord{
+				Prefix:      c.Prefix,
+				MinVersion:  c.MinVersion,
+				MaxVersion:  c.MaxVersion,
+				Blacklist:   c.Blacklist,
+				Description: c.Description,
+			}
+
+			// Only new rules are saved, so an existing rule with the same prefix and version range is kept
+			exists := false
+			for _, r := range rules {
+				if r.Prefix == rule.Prefix && r.MinVersion == rule.MinVersion && r.MaxVersion == rule.MaxVersion {
+					exists = true
+					break
+				}
+			}
+
+			if !exists {
+				if err := db.SaveClientRuleRecord(rule); err != nil {
+					return count, err
+				}
+				invalidateClientRules()
+
+				rules = append(rules, rule)
+				count++
+			}
+		case "fileUser":
+			fu := e.FileUser
+			fileUser := FileUserRecord{
+				FileID:     fileIDs[fu.InfoHash],
+				UserID:     userIDs[fu.Username],
+				IP:         fu.IP,
+				Active:     fu.Active,
+				Completed:  fu.Completed,
+				Announced:  fu.Announced,
+				Uploaded:   fu.Uploaded,
+				Downloaded: fu.Downloaded,
+				Left:       fu.Left,
+			}
+
+			existing, err := db.LoadFileUserRecord(fileUser.FileID, fileUser.UserID, fileUser.IP)
+			if err != nil {
+				return count, err
+			}
+
+			// Only new relationships are saved, so live traffic is not rolled back to the archive's values
+			if existing == (FileUserRecord{}) {
+				if err := db.SaveFileUserRecord(fileUser); err != nil {
+					return count, err
+				}
+				count++
+			}
+		case "apiKey":
+			k := e.APIKey
+			key, err := db.LoadAPIKey(k.Pubkey, "pubkey")
+			if err != nil {
+				return count, err
+			}
+
+			if key == (APIKey{}) {
+				if err := db.SaveAPIKey(APIKey{UserID: userIDs[k.Username], Pubkey: k.Pubkey, Secret: k.Secret, Expire: k.Expire}); err != nil {
+					return count, err
+				}
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// readState reads and verifies all entries in a state archive, ordering them so that users and files
+// come before the records which refer to them
+func readState(r io.Reader) ([]stateEntry, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	// Verify header
+	header := stateHeader{}
+	if err := dec.Decode(&header); err != nil || header.Format != stateFormat {
+		return nil, ErrStateFormat
+	}
+
+	if header.Version > stateVersion {
+		return nil, ErrStateVersion
+	}
+
+	// Read all entries, grouped by type
+	order := []string{"user", "file", "torrent", "whitelist", "clientRule", "fileUser", "apiKey"}
+	groups := map[string][]stateEntry{}
+	for {
+		e := stateEntry{}
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, err
+		}
+
+		// Entry must have the record its type names
+		ok := false
+		switch e.Type {
+		case "user":
+			ok = e.User != nil
+		case "file":
+			ok = e.File != nil
+		case "torrent":
+			ok = e.Torrent != nil
+		case "whitelist":
+			ok = e.Whitelist != nil
+		case "clientRule":
+			ok = e.ClientRule != nil
+		case "fileUser":
+			ok = e.FileUser != nil
+		case "apiKey":
+			ok = e.APIKey != nil
+		}
+		if !ok {
+			return nil, ErrStateFormat
+		}
+
+		groups[e.Type] = append(groups[e.Type], e)
+	}
+
+	// Archives older than version 4 carry no lifetime totals, so users begin with the sum of their file/user records
+	if header.Version < 4 {
+		totals := map[string]stateUser{}
+		for _, e := range groups["fileUser"] {
+			t := totals[e.FileUser.Username]
+			t.Uploaded += e.FileUser.Uploaded
+			t.Downloaded += e.FileUser.Downloaded
+			totals[e.FileUser.Username] = t
+		}
+
+		for _, e := range groups["user"] {
+			e.User.Uploaded = totals[e.User.Username].Uploaded
+			e.User.Downloaded = totals[e.User.Username].Downloaded
+		}
+	}
+
+	entries := make([]stateEntry, 0)
+	for _, t := range order {
+		entries = append(entries, groups[t]...)
+	}
+
+	return entries, nil
+}
+
+// checkState verifies that no username, passkey, or info_hash in a state archive is duplicated,
+// either within the archive, or by a different record already in storage.  It also verifies that
+// every reference to a user or file can be resolved.
+func checkState(db dbModel, entries []stateEntry) error {
+	usernames := map[string]bool{"": true}
+	passkeys := map[string]bool{}
+	infoHashes := map[string]bool{}
+
+	for _, e := range entries {
+		switch e.Type {
+		case "user":
+			u := e.User
+			if usernames[u.Username] || passkeys[u.Passkey] {
+				log.Printf("import: duplicate user in archive: %s", u.Username)
+				return ErrStateDuplicate
+			}
+			usernames[u.Username] = true
+			passkeys[u.Passkey] = true
+
+			// Users already in storage must be the same user, matching on both username and passkey
+			byName, err := db.LoadUserRecord(u.Username, "username")
+			if err != nil {
+				return err
+			}
+			byKey, err := db.LoadUserRecord(u.Passkey, "passkey")
+			if err != nil {
+				return err
+			}
+
+			if byName.ID != byKey.ID {
+				log.Printf("import: user %s conflicts with an existing user", u.Username)
+				return ErrStateDuplicate
+			}
+		case "file":
+			if infoHashes[e.File.InfoHash] {
+				log.Printf("import: duplicate file in archive: %s", e.File.InfoHash)
+				return ErrStateDuplicate
+			}
+			infoHashes[e.File.InfoHash] = true
+		case "torrent":
+			if !infoHashes[e.Torrent.InfoHash] {
+				log.Printf("import: torrent refers to unknown file %s", e.Torrent.InfoHash)
+				return ErrStateFormat
+			}
+		case "fileUser":
+			if !infoHashes[e.FileUser.InfoHash] || !usernames[e.FileUser.Username] {
+				log.Printf("import: file/user refers to unknown file %s or user %s", e.FileUser.InfoHash, e.FileUser.Username)
+				return ErrStateFormat
+			}
+		case "apiKey":
+			if e.APIKey.Username == "" || !usernames[e.APIKey.Username] {
+				log.Printf("import: API key refers to unknown user %s", e.APIKey.Username)
+				return ErrStateFormat
+			}
+		}
+	}
+
+	return nil
+}
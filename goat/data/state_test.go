@@ -0,0 +1,233 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// TestExportImportState verifies that tracker state survives an export, removal, and import, and that
+// repeating an import changes nothing
+func TestExportImportState(t *testing.T) {
+	log.Println("TestExportImportState()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate unique mock records
+	now := time.Now().UnixNano()
+	username := fmt.Sprintf("s%d", now%1000000000000000)
+	hash := fmt.Sprintf("%040x", now)
+
+	user := UserRecord{Username: username, Password: hash, Passkey: hash, TorrentLimit: 10}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+	if user, err = user.Load(username, "username"); err != nil {
+		t.Fatalf("Failed to load mock user: %s", err.Error())
+	}
+	if err := user.AddTraffic(4096, 2048); err != nil {
+		t.Fatalf("Failed to add mock user traffic: %s", err.Error())
+	}
+
+	file := FileRecord{InfoHash: hash, Verified: true, Name: "goat", Size: 1024, FileCount: 1, PieceLength: 16384}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	if file, err = file.Load(hash, "info_hash"); err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	torrent := TorrentRecord{InfoHash: hash, Metainfo: []byte("d4:infod4:name4:goatee\x00\xff")}
+	if err := torrent.Save(); err != nil {
+		t.Fatalf("Failed to save mock torrent: %s", err.Error())
+	}
+
+	fileUser := FileUserRecord{FileID: file.ID, UserID: user.ID, IP: "10.1.0.1", Active: true, Completed: true, Uploaded: 1024}
+	if err := fileUser.Save(); err != nil {
+		t.Fatalf("Failed to save mock file user: %s", err.Error())
+	}
+
+	key := APIKey{UserID: user.ID, Pubkey: hash, Secret: hash, Expire: 1000}
+	if err := key.Save(); err != nil {
+		t.Fatalf("Failed to save mock API key: %s", err.Error())
+	}
+
+	rule := ClientRuleRecord{Prefix: "-S" + username[:5], MinVersion: "1000", Blacklist: true, Description: "state"}
+	if err := rule.Save(); err != nil {
+		t.Fatalf("Failed to save mock client rule: %s", err.Error())
+	}
+	if rule, err = rule.Load(rule.Prefix, "prefix"); err != nil {
+		t.Fatalf("Failed to load mock client rule: %s", err.Error())
+	}
+
+	// Export state
+	buf := bytes.NewBuffer(nil)
+	if _, err := ExportState(buf); err != nil {
+		t.Fatalf("Failed to export state: %s", err.Error())
+	}
+	archive := buf.String()
+
+	// Traffic accounted after the export must survive an import of the older archive
+	fileUser.Uploaded = 2048
+	if err := fileUser.Save(); err != nil {
+		t.Fatalf("Failed to update mock file user: %s", err.Error())
+	}
+
+	// Importing into storage which already holds the same records changes nothing
+	count, err := ImportState(strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Failed to import state over itself: %s", err.Error())
+	}
+	if count != 0 {
+		t.Fatalf("Import over itself reported %d records imported, expected 0", count)
+	}
+
+	fileUsers, err := new(FileUserRecordRepository).Select(file.ID, "file_id")
+	if err != nil || len(fileUsers) != 1 {
+		t.Fatalf("Import over itself duplicated file users: %v", fileUsers)
+	}
+	if fileUsers[0].Uploaded != 2048 {
+		t.Fatalf("Import over itself rolled back file user traffic: %v", fileUsers[0])
+	}
+
+	// Remove records, as if moving to an empty backend
+	if err := fileUser.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file user: %s", err.Error())
+	}
+	if err := key.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock API key: %s", err.Error())
+	}
+	if err := rule.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock client rule: %s", err.Error())
+	}
+	if err := torrent.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock torrent: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+	if err := user.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock user: %s", err.Error())
+	}
+
+	// Import state twice, verifying records are restored once
+	for i := 0; i < 2; i++ {
+		if _, err := ImportState(strings.NewReader(archive)); err != nil {
+			t.Fatalf("Failed to import state: %s", err.Error())
+		}
+	}
+
+	user2, err := new(UserRecord).Load(username, "username")
+	if err != nil || user2.Passkey != hash || user2.TorrentLimit != 10 {
+		t.Fatalf("User not restored: %v", user2)
+	}
+
+	// Lifetime totals are restored once, however many times the archive is imported
+	if uploaded, err := user2.Uploaded(); err != nil || uploaded != 4096 {
+		t.Fatalf("User upload not restored: %d", uploaded)
+	}
+	if downloaded, err := user2.Downloaded(); err != nil || downloaded != 2048 {
+		t.Fatalf("User download not restored: %d", downloaded)
+	}
+
+	file2, err := new(FileRecord).Load(hash, "info_hash")
+	if err != nil || !file2.Verified || file2.Name != "goat" || file2.PieceLength != 16384 {
+		t.Fatalf("File not restored: %v", file2)
+	}
+
+	torrent2, err := new(TorrentRecord).Load(hash, "info_hash")
+	if err != nil || !bytes.Equal(torrent2.Metainfo, torrent.Metainfo) {
+		t.Fatalf("Torrent not restored: %v", torrent2)
+	}
+
+	// Relationships refer to the restored records
+	fileUsers, err = new(FileUserRecordRepository).Select(file2.ID, "file_id")
+	if err != nil || len(fileUsers) != 1 || fileUsers[0].UserID != user2.ID || fileUsers[0].Uploaded != 1024 {
+		t.Fatalf("File user not restored: %v", fileUsers)
+	}
+
+	key2, err := new(APIKey).Load(hash, "pubkey")
+	if err != nil || key2.UserID != user2.ID || key2.Expire != 1000 {
+		t.Fatalf("API key not restored: %v", key2)
+	}
+
+	rule2, err := new(ClientRuleRecord).Load(rule.Prefix, "prefix")
+	if err != nil || rule2.MinVersion != "1000" || !rule2.Blacklist || rule2.Description != "state" {
+		t.Fatalf("Client rule not restored: %v", rule2)
+	}
+
+	// A different user claiming an existing passkey is rejected
+	conflict := strings.Replace(archive, `"username":"`+username+`"`, `"username":"x`+username+`"`, -1)
+	if _, err := ImportState(strings.NewReader(conflict)); err != ErrStateDuplicate {
+		t.Fatalf("Import of conflicting user not rejected: %v", err)
+	}
+
+	// Clean up restored records
+	if err := fileUsers[0].Delete(); err != nil {
+		t.Fatalf("Failed to delete restored file user: %s", err.Error())
+	}
+	if err := key2.Delete(); err != nil {
+		t.Fatalf("Failed to delete restored API key: %s", err.Error())
+	}
+	if err := rule2.Delete(); err != nil {
+		t.Fatalf("Failed to delete restored client rule: %s", err.Error())
+	}
+	if err := torrent2.Delete(); err != nil {
+		t.Fatalf("Failed to delete restored torrent: %s", err.Error())
+	}
+	if err := file2.Delete(); err != nil {
+		t.Fatalf("Failed to delete restored file: %s", err.Error())
+	}
+	if err := user2.Delete(); err != nil {
+		t.Fatalf("Failed to delete restored user: %s", err.Error())
+	}
+}
+
+// TestImportStateInvalid verifies that malformed, newer, and internally duplicated archives are rejected
+func TestImportStateInvalid(t *testing.T) {
+	log.Println("TestImportStateInvalid()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	user := `{"type":"user","user":{"username":"dup","password":"a","passkey":"%s","torrentLimit":1}}`
+	file := `{"type":"file","file":{"infoHash":"dup","verified":true}}`
+
+	var tests = []struct {
+		archive string
+		err     error
+	}{
+		// Not an archive
+		{`{"hello":"world"}`, ErrStateFormat},
+		// Newer archive
+		{`{"format":"goat-state","version":999}`, ErrStateVersion},
+		// Entry missing its record
+		{`{"format":"goat-state","version":1}` + "\n" + `{"type":"user"}`, ErrStateFormat},
+		// Duplicate username
+		{`{"format":"goat-state","version":1}` + "\n" + fmt.Sprintf(user, "a") + "\n" + fmt.Sprintf(user, "b"), ErrStateDuplicate},
+		// Duplicate info_hash
+		{`{"format":"goat-state","version":1}` + "\n" + file + "\n" + file, ErrStateDuplicate},
+		// Torrent for unknown file
+		{`{"format":"goat-state","version":3}` + "\n" + `{"type":"torrent","torrent":{"infoHash":"none","metainfo":""}}`, ErrStateFormat},
+	}
+
+	for i, test := range tests {
+		if _, err := ImportState(strings.NewReader(test.archive)); err != test.err {
+			t.Fatalf("[%02d] Import returned %v, expected %v", i, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// swarmStore defines a store which tracks the active peers on each file, in place of the database
+type swarmStore interface {
+	Announce(string, AnnounceLog) error
+	Close() error
+	CountSeeders(string, time.Duration) (int, error)
+	CountLeechers(string, time.Duration) (int, error)
+	CountPeers(string, peerQuery, time.Duration) (int, error)
+	PeerList(string, peerQuery, time.Duration) ([]Peer, error)
+	Ping() error
+	ReapPeers(string, time.Duration) (int, error)
+}
+
+var (
+	// swarm is the swarm store in use, or nil if peers are tracked using the database
+	swarm swarmStore
+
+	// swarmOnce ensures the swarm store is only created once, after configuration is loaded
+	swarmOnce sync.Once
+)
+
+// swarmConnect returns the configured swarm store, or nil if none is enabled
+func swarmConnect() swarmStore {
+	swarmOnce.Do(func() {
+		// Use Redis to track peers, if enabled
+		if conf := common.Static.Config.Redis; conf.Enabled {
+			swarm = newRedisSwarm(conf.Host, conf.Password)
+		}
+	})
+
+	return swarm
+}
+
+// swarmWindow returns the duration in which a peer must have announced to be considered active
+func swarmWindow() time.Duration {
+	return time.Duration(int64(common.Static.Config.Interval)) * time.Second
+}
+
+// SwarmPing verifies that the swarm store is reachable, if one is enabled
+func SwarmPing() bool {
+	// No swarm store, nothing to check
+	s := swarmConnect()
+	if s == nil {
+		return true
+	}
+
+	return s.Ping() == nil
+}
+
+// SwarmClose closes the swarm store, if one is enabled
+func SwarmClose() error {
+	// No swarm store, nothing to close
+	s := swarmConnect()
+	if s == nil {
+		return nil
+	}
+
+	return s.Close()
+}
@@ -0,0 +1,272 @@
+package data
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisSwarm is a swarmStore which keeps peers in Redis sorted sets, scored by their last announce time.
+// Peers are keyed by peer_id, and the address each last announced from is kept in a hash alongside.
+type redisSwarm struct {
+	pool *redis.Pool
+}
+
+// newRedisSwarm creates a redisSwarm, using a connection pool to the specified Redis host
+func newRedisSwarm(host string, password string) *redisSwarm {
+	return &redisSwarm{
+		pool: &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				c, err := redis.Dial("tcp", host)
+				if err != nil {
+					return nil, err
+				}
+
+				// Authenticate, if a password is configured
+				if password != "" {
+					if _, err := c.Do("AUTH", password); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
+
+				return c, nil
+			},
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				_, err := c.Do("PING")
+				return err
+			},
+		},
+	}
+}
+
+// redisSwarmKey generates the key of the sorted set holding a file's seeders or leechers
+func redisSwarmKey(infoHash string, set string) string {
+	return "goat:swarm:" + infoHash + ":" + set
+}
+
+// redisSwarmSince generates the minimum score of a peer which announced within the window
+func redisSwarmSince(window time.Duration) int64 {
+	return time.Now().Add(-window).Unix()
+}
+
+// Announce records a peer's announce, moving it between seeders and leechers as needed
+func (r *redisSwarm) Announce(infoHash string, a AnnounceLog) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// Peers which did not report a peer_id cannot be told apart, so they are not tracked
+	if a.PeerID == "" {
+		return nil
+	}
+
+	// Peers are stored by peer_id, scored by announce time
+	member := a.PeerID
+	now := time.Now().Unix()
+	seeders := redisSwarmKey(infoHash, "seeders")
+	leechers := redisSwarmKey(infoHash, "leechers")
+	addrs := redisSwarmKey(infoHash, "addrs")
+
+	// Apply all changes atomically
+	conn.Send("MULTI")
+	if a.Event == "stopped" {
+		// Peer stopped, remove it from the swarm entirely
+		conn.Send("ZREM", seeders, member)
+		conn.Send("ZREM", leechers, member)
+		conn.Send("HDEL", addrs, member)
+	} else if a.Left == 0 {
+		// Peer has the whole file, so it is a seeder
+		conn.Send("ZADD", seeders, now, member)
+		conn.Send("ZREM", leechers, member)
+	} else {
+		// Else, peer is still leeching
+		conn.Send("ZADD", leechers, now, member)
+		conn.Send("ZREM", seeders, member)
+	}
+
+	// Keep the host:port this peer last announced from, so a peer which changes port is only listed once
+	if a.Event != "stopped" {
+		conn.Send("HSET", addrs, member, net.JoinHostPort(a.IP, strconv.Itoa(a.Port)))
+	}
+
+	// Let swarms of abandoned files expire on their own, well after their peers would be reaped
+	ttl := int64(2 * swarmWindow() / time.Second)
+	conn.Send("EXPIRE", seeders, ttl)
+	conn.Send("EXPIRE", leechers, ttl)
+	conn.Send("EXPIRE", addrs, ttl)
+
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Close closes all connections in the Redis pool
+func (r *redisSwarm) Close() error {
+	return r.pool.Close()
+}
+
+// CountSeeders returns the number of seeders which announced on a file within the window
+func (r *redisSwarm) CountSeeders(infoHash string, window time.Duration) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("ZCOUNT", redisSwarmKey(infoHash, "seeders"), redisSwarmSince(window), "+inf"))
+}
+
+// CountLeechers returns the number of leechers which announced on a file within the window
+func (r *redisSwarm) CountLeechers(infoHash string, window time.Duration) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("ZCOUNT", redisSwarmKey(infoHash, "leechers"), redisSwarmSince(window), "+inf"))
+}
+
+// redisPeerSets returns the sets from which a peerQuery lists peers, seeders first
+func redisPeerSets(q peerQuery) []string {
+	if q.Leechers {
+		return []string{"leechers"}
+	}
+
+	return []string{"seeders", "leechers"}
+}
+
+// CountPeers counts the peers on a file which announced within the window, from the sets a peerQuery lists.
+// Members are not looked up, so the count includes the requester and unreachable peers, which are only
+// left out once listed.
+func (r *redisSwarm) CountPeers(infoHash string, q peerQuery, window time.Duration) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	count := 0
+	for _, set := range redisPeerSets(q) {
+		n, err := redis.Int(conn.Do("ZCOUNT", redisSwarmKey(infoHash, set), redisSwarmSince(window), "+inf"))
+		if err != nil {
+			return 0, err
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// PeerList returns up to q.Limit peers which announced on a file within the window, and which match a
+// peerQuery.  Peers are listed seeders first, each most recent first, and q.Offset counts across both sets.
+// Redis orders peers by announce time only, so a stable order is not available.
+func (r *redisSwarm) PeerList(infoHash string, q peerQuery, window time.Duration) ([]Peer, error) {
+	peers := make([]Peer, 0)
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	since := redisSwarmSince(window)
+	offset := q.Offset
+	for _, set := range redisPeerSets(q) {
+		key := redisSwarmKey(infoHash, set)
+
+		// Load pages of members until the list is full, or the set is exhausted
+		for len(peers) < q.Limit {
+			members, err := redis.Strings(conn.Do("ZREVRANGEBYSCORE", key, "+inf", since, "LIMIT", offset, q.Limit-len(peers)))
+			if err != nil {
+				return peers, err
+			}
+
+			if len(members) == 0 {
+				break
+			}
+			offset += len(members)
+
+			// Look up the address of each member
+			args := redis.Args{}.Add(redisSwarmKey(infoHash, "addrs")).AddFlat(members)
+			addrs, err := redis.Strings(conn.Do("HMGET", args...))
+			if err != nil {
+				return peers, err
+			}
+
+			// Split each address into its IP and port, leaving out unreachable and excluded peers
+			for i, m := range members {
+				// Address already removed by a concurrent stop or reap
+				if addrs[i] == "" {
+					continue
+				}
+
+				host, port, err := net.SplitHostPort(addrs[i])
+				if err != nil {
+					return peers, err
+				}
+
+				p, err := strconv.ParseUint(port, 10, 16)
+				if err != nil {
+					return peers, err
+				}
+
+				peer := Peer{IP: host, Port: uint16(p), PeerID: m, Seeder: set == "seeders"}
+				if peer.Port != 0 && !q.excludes(peer) {
+					peers = append(peers, peer)
+				}
+			}
+		}
+
+		if len(peers) >= q.Limit {
+			break
+		}
+
+		// The next set begins once the members of this one have been passed
+		n, err := redis.Int(conn.Do("ZCOUNT", key, since, "+inf"))
+		if err != nil {
+			return peers, err
+		}
+
+		if offset -= n; offset < 0 {
+			offset = 0
+		}
+	}
+
+	return peers, nil
+}
+
+// Ping verifies that Redis is reachable
+func (r *redisSwarm) Ping() error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+// ReapPeers removes peers which have not announced on a file within the window, returning the number removed
+func (r *redisSwarm) ReapPeers(infoHash string, window time.Duration) (int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// Remove all peers scored strictly before the window
+	until := "(" + strconv.FormatInt(redisSwarmSince(window), 10)
+
+	count := 0
+	for _, set := range []string{"seeders", "leechers"} {
+		// Forget the addresses of peers about to be removed
+		members, err := redis.Strings(conn.Do("ZRANGEBYSCORE", redisSwarmKey(infoHash, set), "-inf", until))
+		if err != nil {
+			return count, err
+		}
+
+		if len(members) > 0 {
+			args := redis.Args{}.Add(redisSwarmKey(infoHash, "addrs")).AddFlat(members)
+			if _, err := conn.Do("HDEL", args...); err != nil {
+				return count, err
+			}
+		}
+
+		n, err := redis.Int(conn.Do("ZREMRANGEBYSCORE", redisSwarmKey(infoHash, set), "-inf", until))
+		if err != nil {
+			return count, err
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
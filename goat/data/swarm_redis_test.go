@@ -0,0 +1,102 @@
+package data
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+// TestRedisSwarm verifies that peers are tracked, counted, listed, and reaped by the Redis swarm store
+func TestRedisSwarm(t *testing.T) {
+	log.Println("TestRedisSwarm()")
+
+	// Connect to a local Redis server, skipping if one is not running
+	s := newRedisSwarm("localhost:6379", "")
+	defer s.Close()
+	if err := s.Ping(); err != nil {
+		t.Skipf("Skipping, Redis unavailable: %s", err.Error())
+	}
+
+	// Use a unique info hash, so existing data is not disturbed
+	infoHash := "goat-test-" + time.Now().Format("20060102150405.000000000")
+	window := time.Hour
+
+	// Announce one seeder and one leecher
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.8.8", Port: 6881, Left: 0, PeerID: "aa"}); err != nil {
+		t.Fatalf("Failed to announce seeder: %s", err.Error())
+	}
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.4.4", Port: 6882, Left: 1024, PeerID: "bb"}); err != nil {
+		t.Fatalf("Failed to announce leecher: %s", err.Error())
+	}
+
+	// Verify counts
+	seeders, err := s.CountSeeders(infoHash, window)
+	if err != nil || seeders != 1 {
+		t.Fatalf("Seeders, expected 1, got %d", seeders)
+	}
+	leechers, err := s.CountLeechers(infoHash, window)
+	if err != nil || leechers != 1 {
+		t.Fatalf("Leechers, expected 1, got %d", leechers)
+	}
+
+	// Verify peer list, which lists seeders first, along with their peer_ids
+	peers, err := s.PeerList(infoHash, peerQuery{Limit: 50}, window)
+	if err != nil {
+		t.Fatalf("Failed to retrieve peer list: %s", err.Error())
+	}
+	if len(peers) != 2 || peers[0] != (Peer{"8.8.8.8", 6881, "aa", true}) || peers[1] != (Peer{"8.8.4.4", 6882, "bb", false}) {
+		t.Fatalf("Peer list does not match: %v", peers)
+	}
+
+	// Verify peers may be counted, and seeders and the requester left out
+	if count, err := s.CountPeers(infoHash, peerQuery{}, window); err != nil || count != 2 {
+		t.Fatalf("Peers, expected 2, got %d", count)
+	}
+	peers, err = s.PeerList(infoHash, peerQuery{Leechers: true, Limit: 50}, window)
+	if err != nil || len(peers) != 1 || peers[0].PeerID != "bb" {
+		t.Fatalf("Leecher peer list does not match: %v", peers)
+	}
+	peers, err = s.PeerList(infoHash, peerQuery{PeerID: "aa", Offset: 0, Limit: 50}, window)
+	if err != nil || len(peers) != 1 || peers[0].PeerID != "bb" {
+		t.Fatalf("Peer list excluding requester does not match: %v", peers)
+	}
+
+	// Leecher changes port, and should only be listed at its new address
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.4.4", Port: 6883, Left: 1024, PeerID: "bb"}); err != nil {
+		t.Fatalf("Failed to announce port change: %s", err.Error())
+	}
+	peers, err = s.PeerList(infoHash, peerQuery{Leechers: true, Limit: 50}, window)
+	if err != nil || len(peers) != 1 || peers[0] != (Peer{"8.8.4.4", 6883, "bb", false}) {
+		t.Fatalf("Peer list after port change does not match: %v", peers)
+	}
+
+	// Peers without a peer_id are not tracked
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.4.4", Port: 6884, Left: 1024}); err != nil {
+		t.Fatalf("Failed to announce without peer_id: %s", err.Error())
+	}
+	if leechers, _ = s.CountLeechers(infoHash, window); leechers != 1 {
+		t.Fatalf("Leechers, expected 1, got %d", leechers)
+	}
+
+	// Leecher completes, and should move to seeders
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.4.4", Port: 6883, Left: 0, PeerID: "bb", Event: "completed"}); err != nil {
+		t.Fatalf("Failed to announce completion: %s", err.Error())
+	}
+	if seeders, _ = s.CountSeeders(infoHash, window); seeders != 2 {
+		t.Fatalf("Seeders, expected 2, got %d", seeders)
+	}
+
+	// Seeder stops from a new address, and should still be removed
+	if err := s.Announce(infoHash, AnnounceLog{IP: "8.8.8.8", Port: 6891, PeerID: "aa", Event: "stopped"}); err != nil {
+		t.Fatalf("Failed to announce stop: %s", err.Error())
+	}
+	if seeders, _ = s.CountSeeders(infoHash, window); seeders != 1 {
+		t.Fatalf("Seeders, expected 1, got %d", seeders)
+	}
+
+	// Reap using a window which ends in the future, so all remaining peers are reaped
+	count, err := s.ReapPeers(infoHash, -window)
+	if err != nil || count != 1 {
+		t.Fatalf("Reaped, expected 1, got %d", count)
+	}
+}
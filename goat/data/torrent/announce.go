@@ -0,0 +1,82 @@
+package torrent
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+)
+
+// WithAnnounce re-encodes a bencoded .torrent file with a new announce URL, and an optional announce-list of
+// tiers of announce URLs (BEP 12), replacing any the file already carried.  Every other key, including the
+// info dictionary, is copied exactly as it was encoded, so that the info hash is unchanged.
+func WithAnnounce(buf []byte, announce string, announceList [][]string) ([]byte, error) {
+	// Split top-level dictionary into its keys and encoded values
+	d := &decoder{buf: buf}
+	if len(buf) == 0 || buf[0] != 'd' {
+		return nil, ErrMetainfo
+	}
+	d.pos++
+
+	values := map[string][]byte{}
+	for d.pos < len(buf) && buf[d.pos] != 'e' {
+		key, err := d.decodeString()
+		if err != nil {
+			return nil, err
+		}
+
+		start := d.pos
+		if _, err := d.decode(1); err != nil {
+			return nil, err
+		}
+
+		values[key] = buf[start:d.pos]
+	}
+
+	// Nothing may follow the top-level dictionary
+	if d.pos != len(buf)-1 {
+		return nil, ErrBencode
+	}
+
+	if _, ok := values["info"]; !ok {
+		return nil, ErrMetainfo
+	}
+
+	// Replace announce URLs
+	values["announce"] = encodeString(announce)
+	delete(values, "announce-list")
+
+	if len(announceList) > 0 {
+		list := bytes.NewBuffer([]byte("l"))
+		for _, tier := range announceList {
+			list.WriteByte('l')
+			for _, url := range tier {
+				list.Write(encodeString(url))
+			}
+			list.WriteByte('e')
+		}
+		list.WriteByte('e')
+
+		values["announce-list"] = list.Bytes()
+	}
+
+	// Dictionary keys must be encoded in sorted order
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := bytes.NewBuffer([]byte("d"))
+	for _, k := range keys {
+		out.Write(encodeString(k))
+		out.Write(values[k])
+	}
+	out.WriteByte('e')
+
+	return out.Bytes(), nil
+}
+
+// encodeString bencodes a single string
+func encodeString(s string) []byte {
+	return []byte(strconv.Itoa(len(s)) + ":" + s)
+}
@@ -0,0 +1,62 @@
+package torrent
+
+import (
+	"log"
+	"testing"
+)
+
+// TestWithAnnounce verifies that announce URLs are replaced, leaving the info dictionary untouched
+func TestWithAnnounce(t *testing.T) {
+	log.Println("TestWithAnnounce()")
+
+	var tests = []struct {
+		torrent      string
+		announce     string
+		announceList [][]string
+		out          string
+		err          error
+	}{
+		// Announce replaced, other keys kept in sorted order
+		{"d8:announce3:old7:comment4:goat4:info" + testInfo + "e", "http://goat/abc/announce", nil,
+			"d8:announce24:http://goat/abc/announce7:comment4:goat4:info" + testInfo + "e", nil},
+		// Announce added, and announce-list replaced
+		{"d13:announce-listll3:oldee4:info" + testInfo + "e", "http://goat/abc/announce",
+			[][]string{{"http://goat/abc/announce"}, {"udp://goat/abc/announce"}},
+			"d8:announce24:http://goat/abc/announce13:announce-listll24:http://goat/abc/announceel23:udp://goat/abc/announceee4:info" + testInfo + "e", nil},
+		// Announce-list removed when none is given
+		{"d13:announce-listll3:oldee4:info" + testInfo + "e", "a", nil, "d8:announce1:a4:info" + testInfo + "e", nil},
+		// Missing info dictionary
+		{"d8:announce3:olde", "a", nil, "", ErrMetainfo},
+		// Not a dictionary
+		{"l4:infoe", "a", nil, "", ErrMetainfo},
+		// Trailing data
+		{"d4:info" + testInfo + "ee", "a", nil, "", ErrBencode},
+		// Truncated file
+		{"d4:info" + testInfo, "a", nil, "", ErrBencode},
+	}
+
+	for i, test := range tests {
+		out, err := WithAnnounce([]byte(test.torrent), test.announce, test.announceList)
+		if err != test.err {
+			t.Fatalf("[%02d] unexpected error: %v != %v", i, err, test.err)
+		}
+
+		if string(out) != test.out {
+			t.Fatalf("[%02d] unexpected output:\n- %s\n- %s", i, string(out), test.out)
+		}
+	}
+
+	// Info hash is unchanged
+	before, after := new(Metainfo), new(Metainfo)
+	out, _ := WithAnnounce([]byte(tests[0].torrent), "a", nil)
+	if err := before.UnmarshalBinary([]byte(tests[0].torrent)); err != nil {
+		t.Fatalf("Failed to unmarshal Metainfo: %s", err.Error())
+	}
+	if err := after.UnmarshalBinary(out); err != nil {
+		t.Fatalf("Failed to unmarshal Metainfo: %s", err.Error())
+	}
+
+	if before.InfoHash != after.InfoHash {
+		t.Fatalf("Info hash changed: %s != %s", before.InfoHash, after.InfoHash)
+	}
+}
@@ -0,0 +1,166 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// maxDepth is the deepest nesting of lists and dictionaries which will be decoded, so that a malicious
+// metainfo file cannot exhaust the stack
+const maxDepth = 64
+
+// ErrBencode is returned when a value is not valid bencode
+var ErrBencode = errors.New("torrent: invalid bencode")
+
+// decoder decodes bencoded values from a byte array, recording the span of the first top-level dictionary
+// value with key spanKey, so that its raw bytes may be used exactly as they were encoded
+type decoder struct {
+	buf     []byte
+	pos     int
+	spanKey string
+
+	spanStart int
+	spanEnd   int
+}
+
+// decode decodes a single bencoded value, which may be an int64, a string, a []interface{}, or a
+// map[string]interface{}
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if d.pos >= len(d.buf) || depth > maxDepth {
+		return nil, ErrBencode
+	}
+
+	switch c := d.buf[d.pos]; {
+	// Integer, i<digits>e
+	case c == 'i':
+		d.pos++
+		end := bytes.IndexByte(d.buf[d.pos:], 'e')
+		if end == -1 {
+			return nil, ErrBencode
+		}
+
+		digits := string(d.buf[d.pos : d.pos+end])
+		d.pos += end + 1
+
+		if !canonicalInt(digits, true) {
+			return nil, ErrBencode
+		}
+
+		i, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return nil, ErrBencode
+		}
+
+		return i, nil
+	// String, <length>:<bytes>
+	case c >= '0' && c <= '9':
+		return d.decodeString()
+	// List, l<values>e
+	case c == 'l':
+		d.pos++
+		list := make([]interface{}, 0)
+		for d.pos < len(d.buf) && d.buf[d.pos] != 'e' {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+
+		if d.pos >= len(d.buf) {
+			return nil, ErrBencode
+		}
+		d.pos++
+
+		return list, nil
+	// Dictionary, d<string key><value>e, whose keys must be unique and sorted
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]interface{})
+		prev := ""
+		for d.pos < len(d.buf) && d.buf[d.pos] != 'e' {
+			key, err := d.decodeString()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(dict) > 0 && key <= prev {
+				return nil, ErrBencode
+			}
+			prev = key
+
+			start := d.pos
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			// Record span of the requested top-level value
+			if depth == 0 && key == d.spanKey && d.spanEnd == 0 {
+				d.spanStart, d.spanEnd = start, d.pos
+			}
+
+			dict[key] = v
+		}
+
+		if d.pos >= len(d.buf) {
+			return nil, ErrBencode
+		}
+		d.pos++
+
+		return dict, nil
+	}
+
+	return nil, ErrBencode
+}
+
+// decodeString decodes a single bencoded string
+func (d *decoder) decodeString() (string, error) {
+	colon := bytes.IndexByte(d.buf[d.pos:], ':')
+	if colon == -1 {
+		return "", ErrBencode
+	}
+
+	digits := string(d.buf[d.pos : d.pos+colon])
+	if !canonicalInt(digits, false) {
+		return "", ErrBencode
+	}
+
+	length, err := strconv.Atoi(digits)
+	if err != nil {
+		return "", ErrBencode
+	}
+
+	start := d.pos + colon + 1
+	if length > len(d.buf)-start {
+		return "", ErrBencode
+	}
+
+	d.pos = start + length
+	return string(d.buf[start:d.pos]), nil
+}
+
+// canonicalInt reports whether digits are a bencoded integer in its only valid form, with no sign other than a
+// leading minus, if signed, and no leading zeros or negative zero
+func canonicalInt(digits string, signed bool) bool {
+	if signed && len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+		if digits == "0" {
+			return false
+		}
+	}
+
+	if digits == "" || (len(digits) > 1 && digits[0] == '0') {
+		return false
+	}
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
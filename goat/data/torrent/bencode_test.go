@@ -0,0 +1,58 @@
+package torrent
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestDecoder verifies that bencoded values are decoded properly
+func TestDecoder(t *testing.T) {
+	log.Println("TestDecoder()")
+
+	var tests = []struct {
+		in  string
+		err error
+	}{
+		{"i42e", nil},
+		{"i-42e", nil},
+		{"4:goat", nil},
+		{"0:", nil},
+		{"li1e4:goate", nil},
+		{"d1:ai1e1:bl1:cee", nil},
+		// Invalid integers
+		{"i042e", ErrBencode},
+		{"i-0e", ErrBencode},
+		{"iabce", ErrBencode},
+		{"i42", ErrBencode},
+		{"i+42e", ErrBencode},
+		{"i-e", ErrBencode},
+		{"ie", ErrBencode},
+		// Invalid strings
+		{"5:goat", ErrBencode},
+		{"-1:", ErrBencode},
+		{"04:goat", ErrBencode},
+		{"l+4:goate", ErrBencode},
+		{"d+1:ai1ee", ErrBencode},
+		// Unterminated list and dictionary
+		{"li1e", ErrBencode},
+		{"d1:a", ErrBencode},
+		// Non-string dictionary key
+		{"di1ei1ee", ErrBencode},
+		// Duplicate and unsorted dictionary keys
+		{"d1:ai1e1:ai2ee", ErrBencode},
+		{"d1:bi1e1:ai2ee", ErrBencode},
+		{"d0:i1e1:ai2ee", nil},
+		// Unknown type
+		{"x", ErrBencode},
+		// Nesting too deep
+		{strings.Repeat("l", maxDepth+2) + strings.Repeat("e", maxDepth+2), ErrBencode},
+	}
+
+	for i, test := range tests {
+		d := &decoder{buf: []byte(test.in)}
+		if _, err := d.decode(0); err != test.err {
+			t.Fatalf("[%02d] unexpected error: %v != %v", i, err, test.err)
+		}
+	}
+}
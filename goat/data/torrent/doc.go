@@ -0,0 +1,4 @@
+/*
+Package torrent provides the .torrent metainfo data structures and methods for the goat BitTorrent tracker.
+*/
+package torrent
@@ -0,0 +1,104 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrMetainfo is returned when a bencoded value is not a valid .torrent metainfo dictionary
+var ErrMetainfo = errors.New("torrent: invalid metainfo")
+
+// Metainfo represents the tracker-relevant contents of a .torrent file
+type Metainfo struct {
+	// InfoHash is the hex-encoded SHA-1 hash of the bencoded info dictionary
+	InfoHash string
+	// Info is the bencoded info dictionary, exactly as it appeared in the .torrent file
+	Info []byte
+
+	Name        string
+	Size        int64
+	Files       int
+	PieceLength int64
+}
+
+// UnmarshalBinary parses a bencoded .torrent file into a Metainfo struct, verifying that its info dictionary
+// describes either a single file or a list of files
+func (m *Metainfo) UnmarshalBinary(buf []byte) error {
+	// Decode the file, recording the position of the info dictionary
+	d := &decoder{buf: buf, spanKey: "info"}
+	v, err := d.decode(0)
+	if err != nil {
+		return err
+	}
+
+	// Nothing may follow the top-level dictionary
+	if d.pos != len(buf) {
+		return ErrBencode
+	}
+
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return ErrMetainfo
+	}
+
+	info, ok := meta["info"].(map[string]interface{})
+	if !ok {
+		return ErrMetainfo
+	}
+
+	// Name and piece length are required
+	if m.Name, ok = info["name"].(string); !ok || m.Name == "" {
+		return ErrMetainfo
+	}
+	if m.PieceLength, ok = info["piece length"].(int64); !ok || m.PieceLength <= 0 {
+		return ErrMetainfo
+	}
+
+	// Pieces are a concatenation of 20 byte SHA-1 hashes
+	if pieces, ok := info["pieces"].(string); !ok || len(pieces)%sha1.Size != 0 {
+		return ErrMetainfo
+	}
+
+	// Single file torrents carry a length, and multiple file torrents a list of files
+	if length, ok := info["length"].(int64); ok {
+		if length < 0 {
+			return ErrMetainfo
+		}
+
+		m.Size = length
+		m.Files = 1
+	} else {
+		files, ok := info["files"].([]interface{})
+		if !ok || len(files) == 0 {
+			return ErrMetainfo
+		}
+
+		m.Size = 0
+		for _, f := range files {
+			file, ok := f.(map[string]interface{})
+			if !ok {
+				return ErrMetainfo
+			}
+
+			length, ok := file["length"].(int64)
+			if !ok || length < 0 {
+				return ErrMetainfo
+			}
+			if path, ok := file["path"].([]interface{}); !ok || len(path) == 0 {
+				return ErrMetainfo
+			}
+
+			m.Size += length
+		}
+
+		m.Files = len(files)
+	}
+
+	// Hash the info dictionary exactly as it was encoded
+	m.Info = buf[d.spanStart:d.spanEnd]
+	hash := sha1.Sum(m.Info)
+	m.InfoHash = hex.EncodeToString(hash[:])
+
+	return nil
+}
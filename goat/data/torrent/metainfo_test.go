@@ -0,0 +1,86 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"strings"
+	"testing"
+)
+
+// testInfo is a bencoded single file info dictionary, with one piece
+var testInfo = "d6:lengthi1024e4:name8:goat.txt12:piece lengthi16384e6:pieces20:" + strings.Repeat("a", 20) + "e"
+
+// TestMetainfo verifies that Metainfo binary unmarshal works properly
+func TestMetainfo(t *testing.T) {
+	log.Println("TestMetainfo()")
+
+	pieces := "6:pieces20:" + strings.Repeat("a", 20)
+
+	var tests = []struct {
+		torrent     string
+		name        string
+		size        int64
+		files       int
+		pieceLength int64
+		err         error
+	}{
+		// Single file torrent
+		{"d8:announce14:http://tracker4:info" + testInfo + "e", "goat.txt", 1024, 1, 16384, nil},
+		// Multiple file torrent
+		{"d4:infod5:filesld6:lengthi100e4:pathl1:aeed6:lengthi200e4:pathl1:b1:ceee4:name4:goat12:piece lengthi16384e" + pieces + "ee",
+			"goat", 300, 2, 16384, nil},
+		// Not a dictionary
+		{"le", "", 0, 0, 0, ErrMetainfo},
+		// Missing info dictionary
+		{"d8:announce14:http://trackere", "", 0, 0, 0, ErrMetainfo},
+		// Missing name
+		{"d4:infod6:lengthi1024e12:piece lengthi16384e" + pieces + "ee", "", 0, 0, 0, ErrMetainfo},
+		// Invalid piece length
+		{"d4:infod6:lengthi1024e4:name4:goat12:piece lengthi0e" + pieces + "ee", "", 0, 0, 0, ErrMetainfo},
+		// Truncated pieces
+		{"d4:infod6:lengthi1024e4:name4:goat12:piece lengthi16384e6:pieces3:abcee", "", 0, 0, 0, ErrMetainfo},
+		// Neither length nor files
+		{"d4:infod4:name4:goat12:piece lengthi16384e" + pieces + "ee", "", 0, 0, 0, ErrMetainfo},
+		// File missing its path
+		{"d4:infod5:filesld6:lengthi100eee4:name4:goat12:piece lengthi16384e" + pieces + "ee", "", 0, 0, 0, ErrMetainfo},
+		// Trailing data
+		{"d4:info" + testInfo + "ee", "", 0, 0, 0, ErrBencode},
+		// Truncated file
+		{"d4:info" + testInfo, "", 0, 0, 0, ErrBencode},
+	}
+
+	for i, test := range tests {
+		m := new(Metainfo)
+		err := m.UnmarshalBinary([]byte(test.torrent))
+		if err != test.err {
+			t.Fatalf("[%02d] unexpected error: %v != %v", i, err, test.err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if m.Name != test.name || m.Size != test.size || m.Files != test.files || m.PieceLength != test.pieceLength {
+			t.Fatalf("[%02d] unexpected metainfo: %s %d %d %d", i, m.Name, m.Size, m.Files, m.PieceLength)
+		}
+	}
+}
+
+// TestMetainfoInfoHash verifies that the info hash is computed over the info dictionary, exactly as it was encoded
+func TestMetainfoInfoHash(t *testing.T) {
+	log.Println("TestMetainfoInfoHash()")
+
+	m := new(Metainfo)
+	if err := m.UnmarshalBinary([]byte("d8:announce14:http://tracker7:comment4:goat4:info" + testInfo + "e")); err != nil {
+		t.Fatalf("Failed to unmarshal Metainfo: %s", err.Error())
+	}
+
+	if string(m.Info) != testInfo {
+		t.Fatalf("Metainfo info dictionary does not match: %s", string(m.Info))
+	}
+
+	hash := sha1.Sum([]byte(testInfo))
+	if m.InfoHash != hex.EncodeToString(hash[:]) {
+		t.Fatalf("Metainfo info hash does not match: %s", m.InfoHash)
+	}
+}
@@ -0,0 +1,70 @@
+package data
+
+// TorrentRecord represents the original metainfo of a .torrent file registered with the tracker, so that it may
+// be served to users with their own announce URL
+type TorrentRecord struct {
+	InfoHash string `db:"info_hash" json:"infoHash"`
+	Metainfo []byte `json:"metainfo"`
+}
+
+// Delete TorrentRecord from storage
+func (t TorrentRecord) Delete() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Delete TorrentRecord
+	if err = db.DeleteTorrentRecord(t.InfoHash, "info_hash"); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Load TorrentRecord from storage
+func (t TorrentRecord) Load(id interface{}, col string) (TorrentRecord, error) {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return TorrentRecord{}, err
+	}
+
+	// Load TorrentRecord using specified column
+	if t, err = db.LoadTorrentRecord(id, col); err != nil {
+		return TorrentRecord{}, err
+	}
+
+	if err := db.Close(); err != nil {
+		return TorrentRecord{}, err
+	}
+
+	return t, nil
+}
+
+// Save TorrentRecord to storage, replacing any existing metainfo for the same info hash
+func (t TorrentRecord) Save() error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Save TorrentRecord
+	if err := db.SaveTorrentRecord(t); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
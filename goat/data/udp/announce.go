@@ -5,8 +5,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/mdlayher/goat/goat/data"
 )
@@ -26,8 +28,18 @@ type AnnounceRequest struct {
 	Key        uint32
 	Numwant    uint32
 	Port       uint16
+
+	// URLData is the path and query of the announce URL, sent using the URLData option (BEP 41)
+	URLData string
 }
 
+// UDP announce request options (BEP 41)
+const (
+	optionEndOfOptions = 0x0
+	optionNOP          = 0x1
+	optionURLData      = 0x2
+)
+
 // UnmarshalBinary creates a AnnounceRequest from a packed byte array
 func (u *AnnounceRequest) UnmarshalBinary(buf []byte) (err error) {
 	// Set up recovery function to catch a panic as an error
@@ -91,6 +103,38 @@ func (u *AnnounceRequest) UnmarshalBinary(buf []byte) (err error) {
 	// Port (uint16)
 	u.Port = binary.BigEndian.Uint16(buf[96:98])
 
+	// Options (BEP 41), which follow the fixed length request
+	return u.unmarshalOptions(buf[98:])
+}
+
+// unmarshalOptions parses the options which may follow an announce request.  Multiple URLData options are
+// concatenated, and parsing stops at EndOfOptions, or at the end of the buffer.
+func (u *AnnounceRequest) unmarshalOptions(buf []byte) error {
+	urlData := bytes.NewBuffer(nil)
+
+	for i := 0; i < len(buf); {
+		switch buf[i] {
+		case optionEndOfOptions:
+			u.URLData = urlData.String()
+			return nil
+		case optionNOP:
+			i++
+		default:
+			// All other options carry a length (1 byte), followed by data
+			if i+1 >= len(buf) || i+2+int(buf[i+1]) > len(buf) {
+				return errors.New("truncated option in AnnounceRequest")
+			}
+
+			// Unknown options are skipped
+			if buf[i] == optionURLData {
+				urlData.Write(buf[i+2 : i+2+int(buf[i+1])])
+			}
+
+			i += 2 + int(buf[i+1])
+		}
+	}
+
+	u.URLData = urlData.String()
 	return nil
 }
 
@@ -175,9 +219,41 @@ func (u AnnounceRequest) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
+	// URLData, split into options of up to 255 bytes each, followed by EndOfOptions
+	if u.URLData != "" {
+		for data := []byte(u.URLData); len(data) > 0; {
+			n := len(data)
+			if n > 255 {
+				n = 255
+			}
+
+			res.Write([]byte{optionURLData, byte(n)})
+			res.Write(data[:n])
+			data = data[n:]
+		}
+
+		res.WriteByte(optionEndOfOptions)
+	}
+
 	return res.Bytes(), nil
 }
 
+// Passkey returns the passkey from a URLData path of the form /passkey/announce, or an empty string
+// if no passkey is present
+func (u AnnounceRequest) Passkey() string {
+	path, err := url.Parse(u.URLData)
+	if err != nil {
+		return ""
+	}
+
+	urlArr := strings.Split(path.Path, "/")
+	if len(urlArr) != 3 || urlArr[2] != "announce" {
+		return ""
+	}
+
+	return urlArr[1]
+}
+
 // ToValues creates a url.Values struct from a AnnounceRequest
 func (u AnnounceRequest) ToValues() url.Values {
 	// Initialize query map
@@ -186,6 +262,7 @@ func (u AnnounceRequest) ToValues() url.Values {
 
 	// Copy all fields into query map
 	query.Set("info_hash", string(u.InfoHash))
+	query.Set("peer_id", string(u.PeerID))
 
 	// Integer fields
 	query.Set("downloaded", strconv.FormatUint(u.Downloaded, 10))
@@ -204,8 +281,12 @@ func (u AnnounceRequest) ToValues() url.Values {
 		query.Set("event", "stopped")
 	}
 
-	// IP
-	query.Set("ip", strconv.FormatUint(uint64(u.IP), 10))
+	// IP, where 0 indicates the client's source address should be used
+	if u.IP == 0 {
+		query.Set("ip", "0")
+	} else {
+		query.Set("ip", net.IPv4(byte(u.IP>>24), byte(u.IP>>16), byte(u.IP>>8), byte(u.IP)).String())
+	}
 
 	// Key
 	query.Set("key", strconv.FormatUint(uint64(u.Key), 10))
@@ -228,6 +309,10 @@ type AnnounceResponse struct {
 	Leechers uint32
 	Seeders  uint32
 	PeerList []data.Peer
+
+	// IPv6 is set when the response is sent to a client connected over IPv6, in which case the
+	// peer list contains only 18 byte IPv6 peers, rather than 6 byte IPv4 peers (BEP 15)
+	IPv6 bool
 }
 
 // UnmarshalBinary creates a AnnounceResponse from a packed byte array
@@ -261,6 +346,12 @@ func (u *AnnounceResponse) UnmarshalBinary(buf []byte) (err error) {
 	// Peer List
 	u.PeerList = make([]data.Peer, 0)
 
+	// Size of each peer depends on address family
+	size := 6
+	if u.IPv6 {
+		size = 18
+	}
+
 	// Iterate peers buffer
 	i := 20
 	for {
@@ -271,13 +362,13 @@ func (u *AnnounceResponse) UnmarshalBinary(buf []byte) (err error) {
 
 		// Parse peer
 		peer := new(data.Peer)
-		if err := peer.UnmarshalBinary(buf[i : i+6]); err != nil {
+		if err := peer.UnmarshalBinary(buf[i : i+size]); err != nil {
 			return err
 		}
 
 		// Append peer
 		u.PeerList = append(u.PeerList[:], *peer)
-		i += 6
+		i += size
 	}
 
 	return nil
@@ -318,6 +409,11 @@ func (u AnnounceResponse) MarshalBinary() ([]byte, error) {
 
 	// PeerList, []Peer, iterated and compressed to compact format
 	for _, peer := range u.PeerList {
+		// Only peers of the client's address family may be sent
+		if peer.IPv6() != u.IPv6 {
+			continue
+		}
+
 		// Marshal peer to binary
 		buf, err := peer.MarshalBinary()
 		if err != nil {
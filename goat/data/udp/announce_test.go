@@ -3,6 +3,7 @@ package udp
 import (
 	"bytes"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/mdlayher/goat/goat/data"
@@ -55,6 +56,57 @@ func TestAnnounceRequest(t *testing.T) {
 	}
 }
 
+// TestAnnounceRequestURLData verifies that AnnounceRequest options carry URLData and its passkey (BEP 41)
+func TestAnnounceRequestURLData(t *testing.T) {
+	log.Println("TestAnnounceRequestURLData()")
+
+	// Generate mock AnnounceRequest, with URLData longer than a single option
+	passkey := strings.Repeat("a", 300)
+	announce := AnnounceRequest{
+		ConnID:   1,
+		Action:   1,
+		TransID:  1,
+		InfoHash: []byte("abcdef0123456789abcd"),
+		PeerID:   []byte("abcdef0123456789abcd"),
+		URLData:  "/" + passkey + "/announce?foo=bar",
+	}
+
+	// Marshal to binary representation
+	out, err := announce.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal AnnounceRequest to binary: %s", err.Error())
+	}
+
+	// Insert a NOP option, and trailing garbage after EndOfOptions, which must be ignored
+	out = append(append(append(out[:98:98], 0x1), out[98:]...), 0xff, 0xff)
+
+	// Unmarshal announce from binary representation
+	announce2 := new(AnnounceRequest)
+	if err := announce2.UnmarshalBinary(out); err != nil {
+		t.Fatalf("Failed to unmarshal AnnounceRequest from binary: %s", err.Error())
+	}
+
+	// Verify URLData and passkey
+	if announce2.URLData != announce.URLData {
+		t.Fatalf("AnnounceRequest URLData, expected %s, got %s", announce.URLData, announce2.URLData)
+	}
+	if announce2.Passkey() != passkey {
+		t.Fatalf("AnnounceRequest passkey, expected %s, got %s", passkey, announce2.Passkey())
+	}
+
+	// Verify truncated options are rejected
+	if err := announce2.UnmarshalBinary(out[:98+5]); err == nil {
+		t.Fatalf("AnnounceRequest with truncated option was not rejected")
+	}
+
+	// Verify requests without a passkey path report no passkey
+	for _, u := range []string{"", "/announce", "/a/b/announce", "/passkey/scrape"} {
+		if passkey := (AnnounceRequest{URLData: u}).Passkey(); passkey != "" {
+			t.Fatalf("AnnounceRequest URLData %s, expected no passkey, got %s", u, passkey)
+		}
+	}
+}
+
 // TestAnnounceResponse verifies that AnnounceResponse binary marshal and unmarshal work properly
 func TestAnnounceResponse(t *testing.T) {
 	log.Println("TestAnnounceResponse()")
@@ -66,7 +118,7 @@ func TestAnnounceResponse(t *testing.T) {
 		Interval: 3600,
 		Leechers: 1,
 		Seeders:  1,
-		PeerList: []data.Peer{data.Peer{"127.0.0.1", 8080}, data.Peer{"192.168.1.1", 4040}},
+		PeerList: []data.Peer{data.Peer{IP: "127.0.0.1", Port: 8080}, data.Peer{IP: "192.168.1.1", Port: 4040}},
 	}
 
 	// Marshal to binary representation
@@ -86,3 +138,41 @@ func TestAnnounceResponse(t *testing.T) {
 		t.Fatalf("AnnounceResponse results do not match")
 	}
 }
+
+// TestAnnounceResponseIPv6 verifies that AnnounceResponse carries 18 byte IPv6 peers when sent over IPv6
+func TestAnnounceResponseIPv6(t *testing.T) {
+	log.Println("TestAnnounceResponseIPv6()")
+
+	// Generate mock AnnounceResponse, including an IPv4 peer which must be omitted
+	announce := AnnounceResponse{
+		Action:   1,
+		TransID:  1,
+		Interval: 3600,
+		Leechers: 1,
+		Seeders:  1,
+		PeerList: []data.Peer{data.Peer{IP: "2001:db8::1", Port: 8080}, data.Peer{IP: "127.0.0.1", Port: 4040}},
+		IPv6:     true,
+	}
+
+	// Marshal to binary representation
+	out, err := announce.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal AnnounceResponse to binary: %s", err.Error())
+	}
+
+	// Header plus a single IPv6 peer
+	if len(out) != 20+18 {
+		t.Fatalf("AnnounceResponse has unexpected length: %d", len(out))
+	}
+
+	// Unmarshal announce from binary representation
+	announce2 := &AnnounceResponse{IPv6: true}
+	if err := announce2.UnmarshalBinary(out); err != nil {
+		t.Fatalf("Failed to unmarshal AnnounceResponse from binary: %s", err.Error())
+	}
+
+	// Verify peer is identical
+	if len(announce2.PeerList) != 1 || announce2.PeerList[0].IP != "2001:db8::1" || announce2.PeerList[0].Port != 8080 {
+		t.Fatalf("AnnounceResponse results do not match: %v", announce2.PeerList)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"code.google.com/p/go.crypto/bcrypt"
+	"code.google.com/p/go.net/context"
 	"github.com/mdlayher/goat/goat/common"
 )
 
@@ -106,8 +107,13 @@ func (u UserRecord) Save() error {
 
 // Load UserRecord from storage
 func (u UserRecord) Load(id interface{}, col string) (UserRecord, error) {
+	return u.load(context.Background(), id, col)
+}
+
+// load implements Load, binding ctx to the queries of backends which can cancel them
+func (u UserRecord) load(ctx context.Context, id interface{}, col string) (UserRecord, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return UserRecord{}, err
 	}
@@ -170,10 +176,36 @@ func (u UserRecord) Downloaded() (int64, error) {
 	return downloaded, nil
 }
 
+// AddTraffic adds uploaded and downloaded bytes to this user's lifetime totals
+func (u UserRecord) AddTraffic(uploaded int64, downloaded int64) error {
+	// Open database connection
+	db, err := DBConnect()
+	if err != nil {
+		return err
+	}
+
+	// Add traffic to user's totals
+	if err := db.AddUserTraffic(u.ID, uploaded, downloaded); err != nil {
+		return err
+	}
+
+	// Close database connection
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Seeding counts the number of torrents this user is seeding
 func (u UserRecord) Seeding() (int, error) {
+	return u.seeding(context.Background())
+}
+
+// seeding implements Seeding, binding ctx to the queries of backends which can cancel them
+func (u UserRecord) seeding(ctx context.Context) (int, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -194,8 +226,13 @@ func (u UserRecord) Seeding() (int, error) {
 
 // Leeching counts the number of torrents this user is leeching
 func (u UserRecord) Leeching() (int, error) {
+	return u.leeching(context.Background())
+}
+
+// leeching implements Leeching, binding ctx to the queries of backends which can cancel them
+func (u UserRecord) leeching(ctx context.Context) (int, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -237,3 +274,42 @@ func (u UserRecordRepository) All() ([]UserRecord, error) {
 
 	return users, nil
 }
+
+// LoadContext loads a UserRecord from storage, giving up once ctx is done
+func (u UserRecord) LoadContext(ctx context.Context, id interface{}, col string) (UserRecord, error) {
+	var user UserRecord
+	if err := withContext(ctx, func() (err error) {
+		user, err = u.load(ctx, id, col)
+		return
+	}); err != nil {
+		return UserRecord{}, err
+	}
+
+	return user, nil
+}
+
+// SeedingContext counts the number of torrents this user is seeding, giving up once ctx is done
+func (u UserRecord) SeedingContext(ctx context.Context) (int, error) {
+	var seeding int
+	if err := withContext(ctx, func() (err error) {
+		seeding, err = u.seeding(ctx)
+		return
+	}); err != nil {
+		return 0, err
+	}
+
+	return seeding, nil
+}
+
+// LeechingContext counts the number of torrents this user is leeching, giving up once ctx is done
+func (u UserRecord) LeechingContext(ctx context.Context) (int, error) {
+	var leeching int
+	if err := withContext(ctx, func() (err error) {
+		leeching, err = u.leeching(ctx)
+		return
+	}); err != nil {
+		return 0, err
+	}
+
+	return leeching, nil
+}
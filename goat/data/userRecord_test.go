@@ -45,6 +45,20 @@ func TestUserRecord(t *testing.T) {
 		t.Fatalf("user.Passkey, expected %s, got %s", user.Passkey, user2.Passkey)
 	}
 
+	// Verify traffic is added to user's lifetime totals
+	for _, traffic := range [][2]int64{{1024, 0}, {2048, 512}} {
+		if err := user2.AddTraffic(traffic[0], traffic[1]); err != nil {
+			t.Fatalf("Failed to add traffic to UserRecord: %s", err.Error())
+		}
+	}
+
+	if uploaded, err := user2.Uploaded(); uploaded != 3072 || err != nil {
+		t.Fatalf("user.Uploaded(), expected 3072, got %d", uploaded)
+	}
+	if downloaded, err := user2.Downloaded(); downloaded != 512 || err != nil {
+		t.Fatalf("user.Downloaded(), expected 512, got %d", downloaded)
+	}
+
 	// Verify user can be deleted
 	if err := user2.Delete(); err != nil {
 		t.Fatalf("Failed to delete UserRecord: %s", err.Error())
@@ -1,5 +1,9 @@
 package data
 
+import (
+	"code.google.com/p/go.net/context"
+)
+
 // WhitelistRecord represents a whitelist entry
 type WhitelistRecord struct {
 	ID       int
@@ -30,8 +34,13 @@ func (w WhitelistRecord) Delete() error {
 
 // Load WhitelistRecord from storage
 func (w WhitelistRecord) Load(id interface{}, col string) (WhitelistRecord, error) {
+	return w.load(context.Background(), id, col)
+}
+
+// load implements Load, binding ctx to the queries of backends which can cancel them
+func (w WhitelistRecord) load(ctx context.Context, id interface{}, col string) (WhitelistRecord, error) {
 	// Open database connection
-	db, err := DBConnect()
+	db, err := dbConnect(ctx)
 	if err != nil {
 		return WhitelistRecord{}, err
 	}
@@ -68,3 +77,16 @@ func (w WhitelistRecord) Save() error {
 
 	return nil
 }
+
+// LoadContext loads a WhitelistRecord from storage, giving up once ctx is done
+func (w WhitelistRecord) LoadContext(ctx context.Context, id interface{}, col string) (WhitelistRecord, error) {
+	var whitelist WhitelistRecord
+	if err := withContext(ctx, func() (err error) {
+		whitelist, err = w.load(ctx, id, col)
+		return
+	}); err != nil {
+		return WhitelistRecord{}, err
+	}
+
+	return whitelist, nil
+}
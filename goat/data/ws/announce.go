@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+// Request represents an announce or scrape message sent by a WebTorrent client.  Answers to offers are sent
+// as announces with Answer, ToPeerID, and OfferID set, and no statistics.
+type Request struct {
+	Action     string           `json:"action"`
+	InfoHash   *json.RawMessage `json:"info_hash"`
+	PeerID     string           `json:"peer_id"`
+	Numwant    int              `json:"numwant"`
+	Uploaded   int64            `json:"uploaded"`
+	Downloaded int64            `json:"downloaded"`
+	Left       *int64           `json:"left"`
+	Event      string           `json:"event"`
+	Offers     []Offer          `json:"offers"`
+	Answer     *json.RawMessage `json:"answer"`
+	ToPeerID   string           `json:"to_peer_id"`
+	OfferID    string           `json:"offer_id"`
+}
+
+// Offer represents a WebRTC offer, which the tracker relays to another peer in the swarm
+type Offer struct {
+	Offer   *json.RawMessage `json:"offer"`
+	OfferID string           `json:"offer_id"`
+}
+
+// InfoHashes returns the binary string info hashes of a request, which may be sent as a single string,
+// or as a list of strings when scraping multiple files
+func (r Request) InfoHashes() ([]string, error) {
+	if r.InfoHash == nil {
+		return nil, errors.New("info_hash is missing")
+	}
+
+	// Single info hash
+	var infoHash string
+	if err := json.Unmarshal(*r.InfoHash, &infoHash); err == nil {
+		return []string{infoHash}, nil
+	}
+
+	// List of info hashes
+	var infoHashes []string
+	if err := json.Unmarshal(*r.InfoHash, &infoHashes); err != nil {
+		return nil, errors.New("info_hash must be a string or list of strings")
+	}
+
+	return infoHashes, nil
+}
+
+// ToValues creates a url.Values struct from an announce Request
+func (r Request) ToValues() (url.Values, error) {
+	query := url.Values{}
+	query.Set("udp", "0")
+
+	// info_hash and peer_id, converted to raw bytes
+	infoHashes, err := r.InfoHashes()
+	if err != nil {
+		return nil, err
+	}
+	if len(infoHashes) != 1 {
+		return nil, errors.New("announce must contain exactly one info_hash")
+	}
+
+	infoHash, err := FromBinaryString(infoHashes[0])
+	if err != nil {
+		return nil, err
+	}
+	query.Set("info_hash", infoHash)
+
+	peerID, err := FromBinaryString(r.PeerID)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("peer_id", peerID)
+
+	// Peers are reached using WebRTC, so they have no port
+	query.Set("port", "0")
+
+	// Integer fields
+	query.Set("numwant", strconv.Itoa(r.Numwant))
+	query.Set("uploaded", strconv.FormatInt(r.Uploaded, 10))
+	query.Set("downloaded", strconv.FormatInt(r.Downloaded, 10))
+
+	// Left is unknown until a client has the torrent metadata, so such a client is counted as a leecher
+	if r.Left == nil {
+		query.Set("left", "1")
+	} else {
+		query.Set("left", strconv.FormatInt(*r.Left, 10))
+	}
+
+	// Event, which is already a string
+	query.Set("event", r.Event)
+
+	return query, nil
+}
+
+// AnnounceResponse represents a tracker announce response in the WebTorrent format
+type AnnounceResponse struct {
+	Action     string `json:"action"`
+	InfoHash   string `json:"info_hash"`
+	Interval   int    `json:"interval"`
+	Complete   int    `json:"complete"`
+	Incomplete int    `json:"incomplete"`
+}
+
+// RelayMessage represents an offer or answer relayed by the tracker from one peer to another
+type RelayMessage struct {
+	Action   string           `json:"action"`
+	InfoHash string           `json:"info_hash"`
+	PeerID   string           `json:"peer_id"`
+	Offer    *json.RawMessage `json:"offer,omitempty"`
+	Answer   *json.RawMessage `json:"answer,omitempty"`
+	OfferID  string           `json:"offer_id"`
+}
+
+// ErrorResponse represents a tracker error in the WebTorrent format.  Action and InfoHash allow the client
+// to match the error with its request.
+type ErrorResponse struct {
+	Action        string `json:"action"`
+	InfoHash      string `json:"info_hash,omitempty"`
+	FailureReason string `json:"failure reason"`
+}
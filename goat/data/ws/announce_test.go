@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"testing"
+)
+
+// TestBinaryString verifies that binary strings are converted to and from raw bytes properly
+func TestBinaryString(t *testing.T) {
+	log.Println("TestBinaryString()")
+
+	raw := "\x00\x7f\x80\xff"
+	s := ToBinaryString(raw)
+
+	// Binary strings survive a JSON round trip
+	buf, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Failed to marshal binary string: %s", err.Error())
+	}
+
+	var s2 string
+	if err := json.Unmarshal(buf, &s2); err != nil {
+		t.Fatalf("Failed to unmarshal binary string: %s", err.Error())
+	}
+
+	if out, err := FromBinaryString(s2); err != nil || out != raw {
+		t.Fatalf("Binary string, expected %q, got %q", raw, out)
+	}
+
+	// Characters larger than a byte are rejected
+	if _, err := FromBinaryString("Ā"); err != ErrBinaryString {
+		t.Fatalf("Invalid binary string was not rejected")
+	}
+}
+
+// TestRequest verifies that announce and scrape requests are converted to query maps properly
+func TestRequest(t *testing.T) {
+	log.Println("TestRequest()")
+
+	var tests = []struct {
+		msg        string
+		infoHashes []string
+		left       string
+	}{
+		// Single info hash, unknown left
+		{`{"action":"announce","info_hash":"abcdefghij0123456789","peer_id":"-WW0001-aaaaaaaaaaaa","left":null}`, []string{"abcdefghij0123456789"}, "1"},
+		// Single info hash, known left
+		{`{"action":"announce","info_hash":"abcdefghij0123456789","peer_id":"-WW0001-aaaaaaaaaaaa","left":0}`, []string{"abcdefghij0123456789"}, "0"},
+		// Multiple info hashes
+		{`{"action":"scrape","info_hash":["abcdefghij0123456789","9876543210jihgfedcba"]}`, []string{"abcdefghij0123456789", "9876543210jihgfedcba"}, ""},
+	}
+
+	for i, test := range tests {
+		var req Request
+		if err := json.Unmarshal([]byte(test.msg), &req); err != nil {
+			t.Fatalf("[%02d] Failed to unmarshal request: %s", i, err.Error())
+		}
+
+		// Scrape requests carry every info hash
+		if req.Action == "scrape" {
+			query, err := req.ScrapeValues()
+			if err != nil || len(query["info_hash"]) != len(test.infoHashes) {
+				t.Fatalf("[%02d] Unexpected scrape values: %v", i, query)
+			}
+
+			continue
+		}
+
+		// Announce requests carry a single info hash, and no port
+		query, err := req.ToValues()
+		if err != nil {
+			t.Fatalf("[%02d] Failed to convert request: %s", i, err.Error())
+		}
+
+		if query.Get("info_hash") != test.infoHashes[0] || query.Get("port") != "0" || query.Get("left") != test.left {
+			t.Fatalf("[%02d] Unexpected announce values: %v", i, query)
+		}
+	}
+
+	// Requests without an info hash are rejected
+	if _, err := (Request{Action: "announce"}).ToValues(); err == nil {
+		t.Fatalf("Request without info_hash was not rejected")
+	}
+}
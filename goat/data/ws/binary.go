@@ -0,0 +1,36 @@
+package ws
+
+import (
+	"errors"
+)
+
+// ErrBinaryString is returned when a binary string contains a character which is not a single byte
+var ErrBinaryString = errors.New("ws: binary string contains a character larger than one byte")
+
+// WebTorrent clients send binary values such as info_hash and peer_id as JSON strings, in which each
+// character holds a single byte.  Once decoded, these are UTF-8 strings, so they must be converted back
+// to raw bytes before use, and raw bytes must be converted to such strings before being sent.
+
+// FromBinaryString converts a binary string, as sent by a WebTorrent client, to raw bytes
+func FromBinaryString(s string) (string, error) {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return "", ErrBinaryString
+		}
+
+		buf = append(buf, byte(r))
+	}
+
+	return string(buf), nil
+}
+
+// ToBinaryString converts raw bytes to a binary string, to be sent to a WebTorrent client
+func ToBinaryString(b string) string {
+	runes := make([]rune, len(b))
+	for i := 0; i < len(b); i++ {
+		runes[i] = rune(b[i])
+	}
+
+	return string(runes)
+}
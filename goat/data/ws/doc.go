@@ -0,0 +1,4 @@
+/*
+Package ws provides the WebSocket (WebTorrent) data structures and methods for the goat BitTorrent tracker.
+*/
+package ws
@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"net/url"
+)
+
+// ScrapeValues creates a url.Values struct from a scrape Request
+func (r Request) ScrapeValues() (url.Values, error) {
+	query := url.Values{}
+	query.Set("udp", "0")
+
+	// info_hash values, converted to raw bytes
+	infoHashes, err := r.InfoHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range infoHashes {
+		infoHash, err := FromBinaryString(h)
+		if err != nil {
+			return nil, err
+		}
+
+		query.Add("info_hash", infoHash)
+	}
+
+	return query, nil
+}
+
+// ScrapeResponse represents a tracker scrape response in the WebTorrent format, keyed by binary string info hash
+type ScrapeResponse struct {
+	Action string                `json:"action"`
+	Files  map[string]ScrapeFile `json:"files"`
+}
+
+// ScrapeFile represents the scrape statistics of a single file
+type ScrapeFile struct {
+	Complete   int `json:"complete"`
+	Downloaded int `json:"downloaded"`
+	Incomplete int `json:"incomplete"`
+}
@@ -0,0 +1,80 @@
+package goat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/tracker"
+
+	"code.google.com/p/go.net/context"
+)
+
+// fullScrapeCache holds the most recently generated full scrape, so that a scrape of every torrent
+// need not be generated for each client which requests one
+type fullScrapeCache struct {
+	sync.Mutex
+	raw     []byte
+	gzipped []byte
+	expires time.Time
+}
+
+// fullScrape is the full scrape cache used by the HTTP router
+var fullScrape = new(fullScrapeCache)
+
+// Get returns the full scrape, both uncompressed and gzip-compressed, regenerating it if the
+// cached copy has expired
+func (c *fullScrapeCache) Get(now time.Time) ([]byte, []byte, error) {
+	// Hold the lock while generating, so that concurrent clients wait for a single full scrape,
+	// rather than each generating their own
+	c.Lock()
+	defer c.Unlock()
+
+	if c.raw != nil && now.Before(c.expires) {
+		return c.raw, c.gzipped, nil
+	}
+
+	// Load all files, of which only verified files may be scraped
+	files, err := new(data.FileRecordRepository).All()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verified := make([]data.FileRecord, 0)
+	for _, f := range files {
+		if f.Verified {
+			verified = append(verified[:], f)
+		}
+	}
+
+	log.Printf("scrape: [HTTP] full scrape of %d files", len(verified))
+
+	// NOTE: a full scrape is not bound by RequestTimeout, because generating one for a large tracker
+	// may legitimately take longer, and it is only generated once per cache period.  A scrape which could
+	// not be generated is never cached.
+	raw, err := tracker.HTTPTracker{}.FullScrape(context.Background(), verified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Compress the scrape, which is typically very large and very compressible
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(raw); err != nil {
+		return nil, nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	// Store scrape in cache for the configured period
+	c.raw = raw
+	c.gzipped = buf.Bytes()
+	c.expires = now.Add(time.Duration(common.Static.Config.Scrape.Cache) * time.Second)
+
+	return c.raw, c.gzipped, nil
+}
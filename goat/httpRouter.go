@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/mdlayher/goat/goat/api"
 	"github.com/mdlayher/goat/goat/common"
@@ -36,6 +37,16 @@ func handleHTTP(l net.Listener, sendChan chan bool, recvChan chan bool) {
 		log.Println("API functionality enabled")
 	}
 
+	// Log full scrape configuration
+	if common.Static.Config.Scrape.Full {
+		log.Println("Full scrape enabled")
+	}
+
+	// Log WebSocket configuration
+	if common.Static.Config.WebSocket {
+		log.Println("WebSocket (WebTorrent) tracker enabled")
+	}
+
 	// Serve HTTP requests
 	if err := http.Serve(l, nil); err != nil {
 		// Ignore connection closing error, caused by stopping listener
@@ -166,11 +177,43 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 
 	client := r.Header.Get("User-Agent")
 
-	// If configured, verify that torrent client is on whitelist
-	if common.Static.Config.Whitelist {
-		whitelist, err := new(data.WhitelistRecord).Load(client, "client")
+	// Bound all data calls made on behalf of this request by the configured timeout
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	// Parse querystring into a Values map
+	query := r.URL.Query()
+
+	// Apply client rules to announces, which identify the client by its peer_id
+	verdict, err := clientVerdict(ctx, query.Get("peer_id"))
+	if err != nil {
+		log.Println(err.Error())
+
+		// Database too slow to answer, so do not penalize the client
+		if err == data.ErrTimeout {
+			httpBusy(w, httpTracker)
+			return
+		}
+	}
+	if verdict == data.ClientBlacklisted {
+		if _, err := w.Write(httpTracker.Error("Your client is blacklisted")); err != nil {
+			log.Println(err.Error())
+		}
+
+		return
+	}
+
+	// If configured, verify that torrent client is on whitelist, unless a client rule already whitelists it
+	if common.Static.Config.Whitelist && verdict != data.ClientWhitelisted {
+		whitelist, err := new(data.WhitelistRecord).LoadContext(ctx, client, "client")
 		if err != nil {
 			log.Println(err.Error())
+
+			// Database too slow to answer, so do not penalize the client
+			if err == data.ErrTimeout {
+				httpBusy(w, httpTracker)
+				return
+			}
 		}
 
 		if whitelist == (data.WhitelistRecord{}) || !whitelist.Approved {
@@ -202,35 +245,39 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Parse querystring into a Values map
-	query := r.URL.Query()
-
-	// Check if IP was previously set
-	if query.Get("ip") == "" {
-		// If no IP set, detect and store it in query map
-		query.Set("ip", strings.Split(r.RemoteAddr, ":")[0])
-	}
+	// Detect client IP, which may be forwarded by a trusted proxy or set by the client, and store it in query map
+	query.Set("ip", httpClientIP(r, query))
 
 	// Put client in query map
 	query.Set("client", client)
 
-	// Check if server is configured for passkey announce
-	if common.Static.Config.Passkey && passkey == "" {
-		if _, err := w.Write(httpTracker.Error("No passkey found in announce URL")); err != nil {
-			log.Println(err.Error())
+	// WebTorrent clients upgrade to a WebSocket, on which announces and scrapes are sent as messages
+	if isWebSocket(r) {
+		if !common.Static.Config.WebSocket {
+			if _, err := w.Write(httpTracker.Error("WebSocket tracker is currently disabled")); err != nil {
+				log.Println(err.Error())
+			}
+
+			return
 		}
 
+		handleWebSocket(w, r, passkey, query.Get("ip"), client)
 		return
 	}
 
-	// Validate passkey if needed
-	user, err := new(data.UserRecord).Load(passkey, "passkey")
-	if err != nil || (common.Static.Config.Passkey && user == (data.UserRecord{})) {
-		if err != nil {
-			log.Println(err.Error())
-		}
+	// Validate passkey and torrent limit
+	user, msg, err := announceUser(ctx, passkey)
+	if err != nil {
+		log.Println(err.Error())
 
-		if _, err := w.Write(httpTracker.Error("Invalid passkey")); err != nil {
+		// Database too slow to answer, so do not penalize the client
+		if err == data.ErrTimeout {
+			httpBusy(w, httpTracker)
+			return
+		}
+	}
+	if msg != "" {
+		if _, err := w.Write(httpTracker.Error(msg)); err != nil {
 			log.Println(err.Error())
 		}
 
@@ -243,28 +290,6 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 	// Mark client as HTTP
 	query.Set("udp", "0")
 
-	// Get user's number of active torrents
-	seeding, err := user.Seeding()
-	leeching, err2 := user.Leeching()
-	if err != nil || err2 != nil {
-		if _, err := w.Write(httpTracker.Error("Failed to calculate active torrents")); err != nil {
-			log.Println(err.Error())
-		}
-
-		return
-	}
-
-	// Verify that client has not exceeded this user's torrent limit
-	activeSum := seeding + leeching
-	if user.TorrentLimit < activeSum {
-		msg := fmt.Sprintf("Exceeded active torrent limit: %d > %d", activeSum, user.TorrentLimit)
-		if _, err := w.Write(httpTracker.Error(msg)); err != nil {
-			log.Println(err.Error())
-		}
-
-		return
-	}
-
 	// Tracker announce
 	if url == "announce" {
 		// Validate required parameter input
@@ -297,22 +322,13 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Only allow compact announce
-		if query.Get("compact") == "" || query.Get("compact") != "1" {
-			if _, err := w.Write(httpTracker.Error("Your client does not support compact announce")); err != nil {
-				log.Println(err.Error())
-			}
-
-			return
-		}
-
 		// NOTE: currently, we do not bother using gzip to compress the tracker announce response
 		// This is done for two reasons:
 		// 1) Clients may or may not support gzip in the first place
 		// 2) gzip may actually make announce response larger, as per testing in What.CD's ocelot
 
 		// Perform tracker announce
-		if _, err := w.Write(tracker.Announce(httpTracker, user, query)); err != nil {
+		if _, err := w.Write(tracker.Announce(ctx, httpTracker, user, query)); err != nil {
 			log.Println(err.Error())
 		}
 
@@ -321,6 +337,33 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Tracker scrape
 	if url == "scrape" {
+		// If configured, a scrape without info_hash is a full scrape of every torrent
+		if query.Get("info_hash") == "" && common.Static.Config.Scrape.Full {
+			raw, gzipped, err := fullScrape.Get(time.Now())
+			if err != nil {
+				log.Println(err.Error())
+
+				if _, err := w.Write(httpTracker.Error(tracker.ErrScrapeFailure.Error())); err != nil {
+					log.Println(err.Error())
+				}
+
+				return
+			}
+
+			// Send the compressed scrape to clients which accept it
+			res := raw
+			if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				w.Header().Set("Content-Encoding", "gzip")
+				res = gzipped
+			}
+
+			if _, err := w.Write(res); err != nil {
+				log.Println(err.Error())
+			}
+
+			return
+		}
+
 		// Check for required parameter info_hash
 		if query.Get("info_hash") == "" {
 			if _, err := w.Write(httpTracker.Error("Missing required parameter: info_hash")); err != nil {
@@ -330,7 +373,7 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if _, err := w.Write(tracker.Scrape(httpTracker, query)); err != nil {
+		if _, err := w.Write(tracker.Scrape(ctx, httpTracker, query)); err != nil {
 			log.Println(err.Error())
 		}
 
@@ -339,3 +382,10 @@ func parseHTTP(w http.ResponseWriter, r *http.Request) {
 
 	return
 }
+
+// httpBusy reports to a client that the tracker could not answer its request in time
+func httpBusy(w http.ResponseWriter, httpTracker tracker.HTTPTracker) {
+	if _, err := w.Write(httpTracker.Error(tracker.ErrTrackerBusy.Error())); err != nil {
+		log.Println(err.Error())
+	}
+}
@@ -1,6 +1,9 @@
 package goat
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -82,3 +85,147 @@ func TestHTTPRouter(t *testing.T) {
 		t.Fatalf("Failed to delete mock file : %s %s", err.Error(), err2.Error())
 	}
 }
+
+// TestHTTPClientRules verifies that announces are whitelisted and blacklisted by their peer_id
+func TestHTTPClientRules(t *testing.T) {
+	log.Println("TestHTTPClientRules()")
+
+	// Load config, with client whitelist enabled
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Whitelist = true
+
+	// Generate and save mock client rules
+	whitelist := data.ClientRuleRecord{Prefix: "-GR", MinVersion: "0001"}
+	blacklist := data.ClientRuleRecord{Prefix: "-GR0009", Blacklist: true}
+	for _, rule := range []data.ClientRuleRecord{whitelist, blacklist} {
+		if err := rule.Save(); err != nil {
+			t.Fatalf("Failed to save mock client rule: %s", err.Error())
+		}
+	}
+
+	var tests = []struct {
+		peerID string
+		msg    string
+	}{
+		// Whitelisted by peer_id, despite an unknown User-Agent, so passkey is checked next
+		{"-GR0001-000000000000", "No passkey found in announce URL"},
+		// Blacklisted within a whitelisted client
+		{"-GR0009-000000000000", "Your client is blacklisted"},
+		// Unknown peer_id and User-Agent
+		{"-GR0000-000000000000", "Your client is not whitelisted"},
+		{"-ZZ0001-000000000000", "Your client is not whitelisted"},
+	}
+
+	for i, test := range tests {
+		// User-Agent resembles a browser, so it is not stored for approval
+		r, err := http.NewRequest("GET", "http://localhost:8080/announce?info_hash=deadbeef&port=5000&uploaded=0&downloaded=0&left=10&peer_id="+test.peerID, nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request")
+		}
+		r.Header.Set("User-Agent", "Mozilla/goat_rules_test")
+
+		w := httptest.NewRecorder()
+		parseHTTP(w, r)
+
+		if !bytes.Contains(w.Body.Bytes(), []byte(test.msg)) {
+			t.Fatalf("[%02d] Response, expected %q, got %q", i, test.msg, w.Body.String())
+		}
+	}
+
+	// Delete mock client rules
+	for _, rule := range []data.ClientRuleRecord{whitelist, blacklist} {
+		if rule, err = rule.Load(rule.Prefix, "prefix"); err != nil {
+			t.Fatalf("Failed to load mock client rule: %s", err.Error())
+		}
+		if err := rule.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock client rule: %s", err.Error())
+		}
+	}
+}
+
+// TestHTTPFullScrape verifies that a scrape without info_hash returns every verified torrent, when configured
+func TestHTTPFullScrape(t *testing.T) {
+	log.Println("TestHTTPFullScrape()")
+
+	// Load config, enabling full scrape without passkeys or whitelist
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.Passkey = false
+	common.Static.Config.Whitelist = false
+	common.Static.Config.Scrape.Full = true
+	common.Static.Config.Scrape.MinInterval = 900
+
+	// Generate mock verified and unverified files
+	verified := data.FileRecord{
+		InfoHash: "66756c6c73637261706530303030303030303030",
+		Verified: true,
+	}
+	unverified := data.FileRecord{
+		InfoHash: "66756c6c73637261706531313131313131313131",
+		Verified: false,
+	}
+	for _, f := range []data.FileRecord{verified, unverified} {
+		if err := f.Save(); err != nil {
+			t.Fatalf("Failed to save mock file: %s", err.Error())
+		}
+	}
+
+	// Request full scrape, accepting gzip
+	r, err := http.NewRequest("GET", "http://localhost:8080/scrape", nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request")
+	}
+	r.Header.Set("User-Agent", "goat_test")
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Full scrape was not gzip-compressed")
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to read gzip full scrape: %s", err.Error())
+	}
+	res, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to read gzip full scrape: %s", err.Error())
+	}
+	log.Println(string(res))
+
+	// Only the verified file is present, along with scrape flags
+	if !bytes.Contains(res, []byte(verified.InfoHash)) {
+		t.Fatalf("Full scrape missing verified file: %q", res)
+	}
+	if bytes.Contains(res, []byte(unverified.InfoHash)) {
+		t.Fatalf("Full scrape contains unverified file: %q", res)
+	}
+	if !bytes.Contains(res, []byte("20:min_request_intervali900e")) {
+		t.Fatalf("Full scrape missing min_request_interval flag: %q", res)
+	}
+
+	// A client which does not accept gzip receives the same, uncompressed scrape
+	r.Header.Del("Accept-Encoding")
+	w = httptest.NewRecorder()
+	parseHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "" || !bytes.Equal(w.Body.Bytes(), res) {
+		t.Fatalf("Uncompressed full scrape did not match: %q", w.Body.Bytes())
+	}
+
+	// Delete mock files
+	for _, f := range []data.FileRecord{verified, unverified} {
+		if err := f.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock file: %s", err.Error())
+		}
+	}
+}
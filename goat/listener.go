@@ -2,11 +2,16 @@ package goat
 
 import (
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
 )
 
 // Listen and handle HTTP (TCP) connections
@@ -18,6 +23,11 @@ func listenHTTP(sendChan chan bool, recvChan chan bool) {
 		panic(err)
 	}
 
+	// If configured, read PROXY protocol headers sent by trusted proxies
+	if common.Static.Config.Proxy.ProxyProtocol {
+		l = proxyListener{l}
+	}
+
 	// Send listener to handler
 	go handleHTTP(l, sendChan, recvChan)
 }
@@ -37,14 +47,19 @@ func listenHTTPS(sendChan chan bool, recvChan chan bool) {
 	}
 
 	// Listen on specified SSL port
-	l, err := tls.Listen("tcp", ":"+strconv.Itoa(common.Static.Config.SSL.Port), &sslConfig)
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(common.Static.Config.SSL.Port))
 	if err != nil {
 		log.Println("Cannot start HTTPS server, exiting now.")
 		panic(err)
 	}
 
+	// If configured, read PROXY protocol headers sent by trusted proxies, which precede the TLS handshake
+	if common.Static.Config.Proxy.ProxyProtocol {
+		l = proxyListener{l}
+	}
+
 	// Send listener to handler
-	go handleHTTP(l, sendChan, recvChan)
+	go handleHTTP(tls.NewListener(l, &sslConfig), sendChan, recvChan)
 }
 
 // Listen on specified UDP port, accept and handle connections
@@ -59,3 +74,51 @@ func listenUDP(sendChan chan bool, recvChan chan bool) {
 	// Send listener to handler
 	go handleUDP(l, sendChan, recvChan)
 }
+
+// requestContext creates a context for a single tracker request, which is cancelled once the configured
+// request timeout elapses, so that slow data calls cannot hang the client
+func requestContext() (context.Context, context.CancelFunc) {
+	// No timeout configured, only allow cancellation
+	if common.Static.Config.RequestTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), time.Duration(common.Static.Config.RequestTimeout)*time.Second)
+}
+
+// announceUser loads the user identified by a passkey, and verifies that they may announce.  On failure, a
+// message for the client is returned, along with any error which caused it.  data.ErrTimeout is returned
+// when the database is too slow to answer, in which case the client should not be penalized.
+func announceUser(ctx context.Context, passkey string) (data.UserRecord, string, error) {
+	// Check if server is configured for passkey announce
+	if common.Static.Config.Passkey && passkey == "" {
+		return data.UserRecord{}, "No passkey found in announce URL", nil
+	}
+
+	// Validate passkey if needed
+	user, err := new(data.UserRecord).LoadContext(ctx, passkey, "passkey")
+	if err != nil || (common.Static.Config.Passkey && user == (data.UserRecord{})) {
+		return data.UserRecord{}, "Invalid passkey", err
+	}
+
+	// Get user's number of active torrents
+	seeding, err := user.SeedingContext(ctx)
+	leeching, err2 := user.LeechingContext(ctx)
+	if err == data.ErrTimeout || err2 == data.ErrTimeout {
+		return data.UserRecord{}, "", data.ErrTimeout
+	}
+	if err != nil {
+		return data.UserRecord{}, "Failed to calculate active torrents", err
+	}
+	if err2 != nil {
+		return data.UserRecord{}, "Failed to calculate active torrents", err2
+	}
+
+	// Verify that client has not exceeded this user's torrent limit
+	activeSum := seeding + leeching
+	if user.TorrentLimit < activeSum {
+		return data.UserRecord{}, fmt.Sprintf("Exceeded active torrent limit: %d > %d", activeSum, user.TorrentLimit), nil
+	}
+
+	return user, "", nil
+}
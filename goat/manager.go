@@ -38,7 +38,7 @@ func Manager(killChan chan bool, exitChan chan int) {
 
 	// Load configuration
 	config, err := common.LoadConfig()
-	if config == (common.Conf{}) || err != nil {
+	if err != nil {
 		log.Println(err.Error())
 		panic("Cannot load configuration, panicking")
 	}
@@ -55,6 +55,28 @@ func Manager(killChan chan bool, exitChan chan int) {
 	}
 	log.Println("Database", data.DBName(), ": OK")
 
+	// Bring database schema up to date, refusing to start on a schema newer than this binary
+	version, err := data.Migrate()
+	if err != nil {
+		log.Println(err.Error())
+		panic(fmt.Errorf("cannot migrate database %s schema; panicking", data.DBName()))
+	}
+	log.Println("Database", data.DBName(), "schema version :", version)
+
+	// Start writing announce and scrape logs in batches
+	data.StartLogWriter()
+
+	// Attempt Redis connection, if enabled
+	if common.Static.Config.Redis.Enabled {
+		if !data.SwarmPing() {
+			panic("Cannot connect to Redis, panicking")
+		}
+		log.Println("Redis swarm store : OK")
+	}
+
+	// Parse trusted proxy subnets once, before any listener may consult them
+	loadTrustedProxies()
+
 	// Start cron manager
 	go cronManager()
 
@@ -116,8 +138,18 @@ func Manager(killChan chan bool, exitChan chan int) {
 				<-udpRecvChan
 			}
 
+			log.Println("Draining log writer")
+			data.StopLogWriter()
+
 			log.Println("Closing database:", data.DBName())
-			data.DBCloseFunc()
+			data.DBClose()
+
+			if common.Static.Config.Redis.Enabled {
+				log.Println("Closing Redis swarm store")
+				if err := data.SwarmClose(); err != nil {
+					log.Println(err.Error())
+				}
+			}
 
 			// Report that program should exit gracefully
 			exitChan <- 0
@@ -0,0 +1,236 @@
+package goat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is the time a trusted proxy has to send its PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV1MaxLength is the maximum length of a PROXY protocol version 1 header, including its CRLF
+const proxyV1MaxLength = 107
+
+// proxyV2Signature begins every PROXY protocol version 2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// PROXY protocol errors
+var (
+	// errProxyHeader is returned when a trusted proxy sends a missing or malformed PROXY protocol header
+	errProxyHeader = errors.New("proxy: invalid PROXY protocol header")
+)
+
+// proxyListener wraps a TCP listener, reading the PROXY protocol header sent by trusted proxies so that
+// connections report the address of the client behind the proxy
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connection.  The PROXY protocol header is read lazily by the
+// connection's first Read or RemoteAddr call, so that a slow proxy cannot block other connections.
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn is a connection which may begin with a PROXY protocol header
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once sync.Once
+	addr net.Addr
+	err  error
+}
+
+// header reads the PROXY protocol header, only once, if the connection is from a trusted proxy.  Other
+// connections are served as they are, so that clients cannot spoof their address with a header.
+func (c *proxyConn) header() {
+	c.once.Do(func() {
+		tcpAddr, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || !inNets(tcpAddr.IP, trustedNets) {
+			return
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.addr, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			log.Printf("proxy: %s: %s", tcpAddr.String(), c.err.Error())
+		}
+	})
+}
+
+// Read reads data from the connection, following the PROXY protocol header
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.header()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client, as reported by a trusted proxy
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.header()
+	if c.addr != nil {
+		return c.addr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol version 1 or 2 header from a stream, returning the source address
+// it carries.  A nil address is returned for headers which carry no address, such as health checks sent by
+// the proxy itself, in which case the connection's own address should be used.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Version 2 headers are binary, and begin with a fixed signature
+	if sig, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, errProxyHeader
+		}
+
+		body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, errProxyHeader
+		}
+
+		ip, port, err := proxyV2Address(header, body)
+		if err != nil || ip == nil {
+			return nil, err
+		}
+
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	}
+
+	// Version 1 headers are a single line of text
+	if sig, err := r.Peek(6); err != nil || string(sig) != "PROXY " {
+		return nil, errProxyHeader
+	}
+
+	line := make([]byte, 0, proxyV1MaxLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := r.ReadByte()
+		if err != nil || len(line) == proxyV1MaxLength {
+			return nil, errProxyHeader
+		}
+
+		line = append(line, b)
+	}
+
+	return proxyV1Address(string(line[:len(line)-2]))
+}
+
+// proxyV1Address parses the source address from a PROXY protocol version 1 header line, in the format
+// "PROXY TCP4 source destination sourceport destinationport"
+func proxyV1Address(line string) (net.Addr, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 {
+		return nil, errProxyHeader
+	}
+
+	// Proxy does not know the address, and any remaining fields are ignored
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+
+	// Verify address matches the protocol's address family
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errProxyHeader
+	}
+
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// proxyV2Address parses the source address from a PROXY protocol version 2 header and body.  A nil IP is
+// returned for LOCAL connections and unsupported address families.
+func proxyV2Address(header []byte, body []byte) (net.IP, int, error) {
+	// Upper four bits of the 13th byte are the version, and the lower four bits the command
+	if header[12]>>4 != 2 {
+		return nil, 0, errProxyHeader
+	}
+
+	switch header[12] & 0x0f {
+	// LOCAL, sent by the proxy on its own behalf
+	case 0:
+		return nil, 0, nil
+	// PROXY, sent on behalf of a client
+	case 1:
+	default:
+		return nil, 0, errProxyHeader
+	}
+
+	// Upper four bits of the 14th byte are the address family, followed by source and destination
+	// addresses, and source and destination ports
+	var length int
+	switch header[13] >> 4 {
+	// AF_INET
+	case 1:
+		length = net.IPv4len
+	// AF_INET6
+	case 2:
+		length = net.IPv6len
+	default:
+		return nil, 0, nil
+	}
+
+	if len(body) < 2*length+4 {
+		return nil, 0, errProxyHeader
+	}
+
+	ip := make(net.IP, length)
+	copy(ip, body[:length])
+	port := int(binary.BigEndian.Uint16(body[2*length : 2*length+2]))
+
+	return ip, port, nil
+}
+
+// parseProxyDatagram parses the PROXY protocol version 2 header at the start of a UDP datagram sent by a
+// trusted proxy, returning the source address it carries, and the payload following it.  A nil address is
+// returned for headers which carry no address.
+func parseProxyDatagram(buf []byte) (*net.UDPAddr, []byte, error) {
+	if len(buf) < 16 || !bytes.Equal(buf[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, nil, errProxyHeader
+	}
+
+	end := 16 + int(binary.BigEndian.Uint16(buf[14:16]))
+	if len(buf) < end {
+		return nil, nil, errProxyHeader
+	}
+
+	ip, port, err := proxyV2Address(buf[:16], buf[16:end])
+	if err != nil {
+		return nil, nil, err
+	}
+	if ip == nil {
+		return nil, buf[end:], nil
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, buf[end:], nil
+}
@@ -0,0 +1,134 @@
+package goat
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/common"
+)
+
+// proxyV2Header generates a PROXY protocol version 2 header, with the specified command and address family
+// byte, and address body
+func proxyV2Header(command byte, family byte, body []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, byte(len(body)>>8), byte(len(body)))
+	return append(header, body...)
+}
+
+// TestReadProxyHeader verifies that PROXY protocol version 1 and 2 headers are parsed properly
+func TestReadProxyHeader(t *testing.T) {
+	log.Println("TestReadProxyHeader()")
+
+	// IPv4 and IPv6 address bodies, with source port 6881 and destination port 80
+	body4 := []byte{198, 51, 100, 1, 10, 0, 0, 1, 0x1a, 0xe1, 0, 80}
+	body6 := append(append(net.ParseIP("2001:db8::5").To16(), net.ParseIP("2001:db8::1").To16()...), 0x1a, 0xe1, 0, 80)
+
+	var tests = []struct {
+		header []byte
+		addr   string
+		err    error
+	}{
+		// Version 1
+		{[]byte("PROXY TCP4 198.51.100.1 10.0.0.1 6881 80\r\n"), "198.51.100.1:6881", nil},
+		{[]byte("PROXY TCP6 2001:db8::5 2001:db8::1 6881 80\r\n"), "[2001:db8::5]:6881", nil},
+		{[]byte("PROXY UNKNOWN\r\n"), "", nil},
+		{[]byte("PROXY TCP4 2001:db8::5 10.0.0.1 6881 80\r\n"), "", errProxyHeader},
+		{[]byte("PROXY TCP4 198.51.100.1 10.0.0.1 http 80\r\n"), "", errProxyHeader},
+		{[]byte("PROXY TCP4 198.51.100.1 10.0.0.1 6881 80\n"), "", errProxyHeader},
+		{append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte("a"), 200)...), "", errProxyHeader},
+		// Version 2
+		{proxyV2Header(1, 0x11, body4), "198.51.100.1:6881", nil},
+		{proxyV2Header(1, 0x21, body6), "[2001:db8::5]:6881", nil},
+		{proxyV2Header(1, 0x11, append(body4, 0x03, 0x00, 0x01, 0xff)), "198.51.100.1:6881", nil},
+		{proxyV2Header(0, 0x00, nil), "", nil},
+		{proxyV2Header(1, 0x21, body4), "", errProxyHeader},
+		{proxyV2Header(1, 0x11, body4)[:20], "", errProxyHeader},
+		// No header
+		{[]byte("GET /announce HTTP/1.1\r\n"), "", errProxyHeader},
+	}
+
+	for i, test := range tests {
+		addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(append(test.header, "payload"...))))
+		if err != test.err {
+			t.Fatalf("[%02d] Unexpected error: %v", i, err)
+		}
+
+		if (addr == nil && test.addr != "") || (addr != nil && addr.String() != test.addr) {
+			t.Fatalf("[%02d] Address, expected %s, got %v", i, test.addr, addr)
+		}
+	}
+
+	// Datagrams carry a version 2 header, followed by their payload
+	addr, payload, err := parseProxyDatagram(append(proxyV2Header(1, 0x12, body4), "payload"...))
+	if err != nil || addr.String() != "198.51.100.1:6881" || string(payload) != "payload" {
+		t.Fatalf("Unexpected datagram: %v, %s, %v", addr, payload, err)
+	}
+	if _, _, err := parseProxyDatagram([]byte("payload")); err != errProxyHeader {
+		t.Fatalf("Datagram without header, expected %v, got %v", errProxyHeader, err)
+	}
+}
+
+// TestProxyListener verifies that connections from trusted proxies report the client's address, and that
+// other connections are served as they are
+func TestProxyListener(t *testing.T) {
+	log.Println("TestProxyListener()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	defer l.Close()
+	proxy := proxyListener{l}
+
+	// send connects to the listener, sends a message, and returns the remote address and data received
+	send := func(msg string) (string, string) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %s", err.Error())
+		}
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("Failed to write: %s", err.Error())
+		}
+		c.Close()
+
+		conn, err := proxy.Accept()
+		if err != nil {
+			t.Fatalf("Failed to accept: %s", err.Error())
+		}
+		defer conn.Close()
+
+		buf, _ := ioutil.ReadAll(conn)
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		return host, string(buf)
+	}
+
+	// Untrusted connections are not parsed
+	common.Static.Config.Proxy.Trusted = []string{}
+	loadTrustedProxies()
+	if host, buf := send("PROXY TCP4 198.51.100.1 10.0.0.1 6881 80\r\nhello"); host != "127.0.0.1" || buf != "PROXY TCP4 198.51.100.1 10.0.0.1 6881 80\r\nhello" {
+		t.Fatalf("Untrusted connection, unexpected address %s and data %q", host, buf)
+	}
+
+	// Trusted connections report the client's address, followed by the data
+	common.Static.Config.Proxy.Trusted = []string{"127.0.0.1"}
+	loadTrustedProxies()
+	if host, buf := send("PROXY TCP4 198.51.100.1 10.0.0.1 6881 80\r\nhello"); host != "198.51.100.1" || buf != "hello" {
+		t.Fatalf("Trusted connection, unexpected address %s and data %q", host, buf)
+	}
+
+	// Trusted connections without a header are rejected
+	if _, buf := send("hello"); buf != "" {
+		t.Fatalf("Trusted connection without header, unexpected data %q", buf)
+	}
+}
@@ -0,0 +1,76 @@
+package tracker
+
+import (
+	"hash/crc32"
+	"sync"
+
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
+)
+
+// maxSessionKey is the longest announce key stored with a session.  Longer keys are truncated, which still
+// tells sessions apart in practice, because clients generate keys at random.
+const maxSessionKey = 40
+
+// sessionLocks serialize the accounting of each announce session, so that concurrent announces in one session
+// are each measured against the totals stored by the other, and traffic is never accounted twice.  Sessions share
+// a fixed number of locks, chosen by hashing their identity, so no lock need be created or removed per session.
+var sessionLocks [256]sync.Mutex
+
+// sessionLock returns the lock which guards the session identified by info hash, peer_id, and key
+func sessionLock(infoHash string, peerID string, key string) *sync.Mutex {
+	sum := crc32.ChecksumIEEE([]byte(infoHash + "\x00" + peerID + "\x00" + key))
+	return &sessionLocks[sum%uint32(len(sessionLocks))]
+}
+
+// accountTraffic returns the upload and download traffic reported by an announce since the previous announce in
+// the same session, and records the totals the announce reported.  A "started" event begins a new session, and a
+// "stopped" event ends one.  An announce which does not belong to a known session, such as one whose session has
+// expired, begins a new session with no traffic accounted, because its totals may include traffic which was already
+// accounted to the session it belonged to.
+func accountTraffic(ctx context.Context, userID int, announce data.AnnounceLog) (int64, int64, error) {
+	key := announce.Key
+	if len(key) > maxSessionKey {
+		key = key[:maxSessionKey]
+	}
+
+	// Load, measure, and store the session as a single step
+	lock := sessionLock(announce.InfoHash, announce.PeerID, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Check for an existing session for this peer and key
+	session, err := new(data.SessionRecord).LoadContext(ctx, announce.InfoHash, announce.PeerID, key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Only continue a session belonging to this user, so that one user's traffic is never accounted against the
+	// totals another user reported
+	var uploaded, downloaded int64
+	if announce.Event != "started" && session.InfoHash != "" && session.UserID == userID {
+		uploaded, downloaded = session.Delta(announce.Uploaded, announce.Downloaded)
+	}
+
+	// Store the totals this announce reported, which the next announce in the session is measured against
+	session = data.SessionRecord{
+		InfoHash:   announce.InfoHash,
+		PeerID:     announce.PeerID,
+		Key:        key,
+		UserID:     userID,
+		Uploaded:   announce.Uploaded,
+		Downloaded: announce.Downloaded,
+	}
+
+	if announce.Event == "stopped" {
+		err = session.DeleteContext(ctx)
+	} else {
+		err = session.SaveContext(ctx)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uploaded, downloaded, nil
+}
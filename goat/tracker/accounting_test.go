@@ -0,0 +1,149 @@
+package tracker
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
+)
+
+// TestAccountTraffic verifies that only traffic reported since the previous announce in a session is accounted,
+// across restarts and started and stopped events
+func TestAccountTraffic(t *testing.T) {
+	log.Println("TestAccountTraffic()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate a unique session for this run
+	announce := data.AnnounceLog{
+		InfoHash: fmt.Sprintf("%040x", time.Now().UnixNano()),
+		PeerID:   "2d5452323833302d6162636465666768696a6b6c",
+		Key:      "deadbeef",
+	}
+
+	var tests = []struct {
+		userID     int
+		key        string
+		event      string
+		uploaded   int64
+		downloaded int64
+		deltaUp    int64
+		deltaDown  int64
+	}{
+		// Session begins with no traffic accounted
+		{1, "deadbeef", "started", 0, 0, 0, 0},
+		// Traffic since the previous announce
+		{1, "deadbeef", "", 1024, 4096, 1024, 4096},
+		{1, "deadbeef", "completed", 2048, 8192, 1024, 4096},
+		// Unchanged totals account nothing
+		{1, "deadbeef", "", 2048, 8192, 0, 0},
+		// Client restarted without a started event, so its totals began again from zero
+		{1, "deadbeef", "", 512, 0, 512, 0},
+		// Stopped event accounts its traffic, and ends the session
+		{1, "deadbeef", "stopped", 1536, 0, 1024, 0},
+		// Announce without a session cannot be measured, so it begins a new one
+		{1, "deadbeef", "", 4096, 4096, 0, 0},
+		{1, "deadbeef", "", 8192, 4096, 4096, 0},
+		// Started event begins a new session, even when one exists
+		{1, "deadbeef", "started", 16, 16, 0, 0},
+		// Another key is another session
+		{1, "cafebabe", "", 1024, 1024, 0, 0},
+		{1, "deadbeef", "", 32, 32, 16, 16},
+		// Another user does not continue this user's session
+		{2, "deadbeef", "", 64, 64, 0, 0},
+		{2, "deadbeef", "stopped", 128, 128, 64, 64},
+		{1, "cafebabe", "stopped", 2048, 1024, 1024, 0},
+	}
+
+	for i, test := range tests {
+		announce.Key = test.key
+		announce.Event = test.event
+		announce.Uploaded = test.uploaded
+		announce.Downloaded = test.downloaded
+
+		uploaded, downloaded, err := accountTraffic(context.Background(), test.userID, announce)
+		if err != nil {
+			t.Fatalf("[%02d] Failed to account traffic: %s", i, err.Error())
+		}
+
+		if uploaded != test.deltaUp || downloaded != test.deltaDown {
+			t.Fatalf("[%02d] Unexpected traffic, expected %d/%d, got %d/%d", i, test.deltaUp, test.deltaDown, uploaded, downloaded)
+		}
+	}
+
+	// Stopped events removed both sessions
+	for _, key := range []string{"deadbeef", "cafebabe"} {
+		session, err := new(data.SessionRecord).Load(announce.InfoHash, announce.PeerID, key)
+		if err != nil || session != (data.SessionRecord{}) {
+			t.Fatalf("Session %s not removed: %v %v", key, session, err)
+		}
+	}
+}
+
+// TestAccountTrafficConcurrent verifies that concurrent announces in one session account its traffic only once
+func TestAccountTrafficConcurrent(t *testing.T) {
+	log.Println("TestAccountTrafficConcurrent()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate a unique session for this run, and begin it
+	announce := data.AnnounceLog{
+		InfoHash: fmt.Sprintf("%040x", time.Now().UnixNano()),
+		PeerID:   "2d5452323833302d6162636465666768696a6b6c",
+		Key:      "deadbeef",
+		Event:    "started",
+	}
+	if _, _, err := accountTraffic(context.Background(), 1, announce); err != nil {
+		t.Fatalf("Failed to account traffic: %s", err.Error())
+	}
+
+	// Announce the same totals many times at once, of which only one may account them
+	announce.Event = ""
+	announce.Uploaded = 1024
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var total int64
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			uploaded, _, err := accountTraffic(context.Background(), 1, announce)
+			if err != nil {
+				t.Errorf("Failed to account traffic: %s", err.Error())
+			}
+
+			mutex.Lock()
+			total += uploaded
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if total != 1024 {
+		t.Fatalf("Unexpected traffic, expected 1024, got %d", total)
+	}
+
+	// End the session
+	announce.Event = "stopped"
+	if _, _, err := accountTraffic(context.Background(), 1, announce); err != nil {
+		t.Fatalf("Failed to account traffic: %s", err.Error())
+	}
+}
@@ -1,4 +1,4 @@
 /*
-Package tracker provides HTTP and UDP BitTorrent tracker logic for the goat BitTorrent tracker.
+Package tracker provides HTTP, UDP, and WebSocket (WebTorrent) BitTorrent tracker logic for the goat BitTorrent tracker.
 */
 package tracker
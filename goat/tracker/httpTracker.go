@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"bytes"
+	"encoding/hex"
 	"log"
 	"net/url"
 	"strconv"
@@ -10,6 +11,8 @@ import (
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 
+	"code.google.com/p/go.net/context"
+
 	// Import bencode library
 	bencode "code.google.com/p/bencode-go"
 )
@@ -25,10 +28,34 @@ type AnnounceResponse struct {
 	Interval    int    "interval"
 	MinInterval int    "min interval"
 	Peers       string "peers"
+	Peers6      string "peers6"
+}
+
+// dictAnnounceResponse defines the response structure of a non-compact HTTP tracker announce, in which
+// each peer is a dictionary (BEP 3)
+type dictAnnounceResponse struct {
+	Complete    int         "complete"
+	Incomplete  int         "incomplete"
+	Interval    int         "interval"
+	MinInterval int         "min interval"
+	Peers       interface{} "peers"
+}
+
+// dictPeer defines a single peer in a non-compact peer list
+type dictPeer struct {
+	IP     string "ip"
+	PeerID string "peer id"
+	Port   uint16 "port"
+}
+
+// dictPeerNoID defines a single peer in a non-compact peer list, when the client requested no_peer_id
+type dictPeerNoID struct {
+	IP   string "ip"
+	Port uint16 "port"
 }
 
 // Announce announces using HTTP format
-func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
+func (h HTTPTracker) Announce(ctx context.Context, query url.Values, file data.FileRecord) []byte {
 	// Generate response struct
 	announce := AnnounceResponse{
 		Interval:    common.Static.Config.Interval,
@@ -37,17 +64,22 @@ func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 
 	// Get seeders count on file
 	var err error
-	announce.Complete, err = file.Seeders()
+	announce.Complete, err = file.SeedersContext(ctx)
 	if err != nil {
 		log.Println(err.Error())
 	}
 
 	// Get leechers count on file
-	announce.Incomplete, err = file.Leechers()
+	announce.Incomplete, err = file.LeechersContext(ctx)
 	if err != nil {
 		log.Println(err.Error())
 	}
 
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return h.Error(ErrTrackerBusy.Error())
+	}
+
 	// Check for numwant parameter, return up to that number of peers
 	// Default is 50 per protocol
 	numwant := 50
@@ -59,6 +91,11 @@ func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 		}
 	}
 
+	// Clients which do not request a compact announce receive a dictionary peer list
+	if query.Get("compact") != "1" {
+		return h.dictAnnounce(ctx, announce, numwant, query.Get("no_peer_id") == "1", peerRequest(query), file)
+	}
+
 	// Marshal struct into bencode
 	buf := bytes.NewBuffer(make([]byte, 0))
 	if err := bencode.Marshal(buf, announce); err != nil {
@@ -66,24 +103,71 @@ func (h HTTPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 		return h.Error(ErrAnnounceFailure.Error())
 	}
 
-	// Generate compact peer list of length numwant
-	// Note: because we are HTTP, we can mark second parameter as 'true' to get a
-	// more accurate peer list
-	compactPeers, err := file.CompactPeerList(numwant, true)
+	// Generate compact peer lists of up to numwant peers
+	compactPeers, compactPeers6, err := file.CompactPeerListContext(ctx, numwant, peerRequest(query))
 	if err != nil {
 		log.Println(err.Error())
-		return h.Error(ErrPeerListFailure.Error())
+		return h.Error(failure(err, ErrPeerListFailure))
 	}
 
 	// Because the bencode marshaler does not handle compact, binary peer list conversion,
-	// we handle it manually here.
+	// we handle it manually here.  IPv4 peers are sent as "peers", and IPv6 peers as "peers6" (BEP 7).
 
-	// Get initial buffer, chop off 3 bytes: "0:e", append the actual list length with new colon
+	// Get initial buffer, chop off the empty peer lists: "0:6:peers60:e", append the actual list lengths
+	// with new colons
 	out := buf.Bytes()
-	out = append(out[0:len(out)-3], []byte(strconv.Itoa(len(compactPeers))+":")...)
+	out = append(out[0:len(out)-len("0:6:peers60:e")], []byte(strconv.Itoa(len(compactPeers))+":")...)
+	out = append(out, compactPeers...)
+	out = append(out, []byte("6:peers6"+strconv.Itoa(len(compactPeers6))+":")...)
+
+	// Append IPv6 peers list, terminate with an "e"
+	return append(append(out, compactPeers6...), byte('e'))
+}
+
+// dictAnnounce generates a non-compact announce response, containing a list of peer dictionaries
+// which include each peer's peer_id, unless noPeerID is set
+func (h HTTPTracker) dictAnnounce(ctx context.Context, announce AnnounceResponse, numwant int, noPeerID bool, req data.PeerRequest, file data.FileRecord) []byte {
+	res := dictAnnounceResponse{
+		Complete:    announce.Complete,
+		Incomplete:  announce.Incomplete,
+		Interval:    announce.Interval,
+		MinInterval: announce.MinInterval,
+	}
+
+	// Generate peer list of up to numwant peers
+	peers, err := file.PeerListContext(ctx, numwant, req)
+	if err != nil {
+		log.Println(err.Error())
+		return h.Error(failure(err, ErrPeerListFailure))
+	}
+
+	// Peer IDs are stored hex encoded, but are sent to clients as raw bytes
+	withID := make([]dictPeer, 0, len(peers))
+	withoutID := make([]dictPeerNoID, 0, len(peers))
+	for _, p := range peers {
+		peerID, err := hex.DecodeString(p.PeerID)
+		if err != nil {
+			peerID = nil
+		}
+
+		withID = append(withID, dictPeer{IP: p.IP, PeerID: string(peerID), Port: p.Port})
+		withoutID = append(withoutID, dictPeerNoID{IP: p.IP, Port: p.Port})
+	}
+
+	if noPeerID {
+		res.Peers = withoutID
+	} else {
+		res.Peers = withID
+	}
+
+	// Marshal struct into bencode
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := bencode.Marshal(buf, res); err != nil {
+		log.Println(err.Error())
+		return h.Error(ErrAnnounceFailure.Error())
+	}
 
-	// Append peers list, terminate with an "e"
-	return append(append(out, compactPeers...), byte('e'))
+	return buf.Bytes()
 }
 
 // errorResponse defines the response structure of an HTTP tracker error
@@ -118,7 +202,13 @@ func (h HTTPTracker) Protocol() string {
 
 // scrapeResponse defines the top-level response structure of an HTTP tracker scrape
 type scrapeResponse struct {
-	Files map[string]scrapeFile "files"
+	Files map[string]interface{} "files"
+	Flags scrapeFlags            "flags"
+}
+
+// scrapeFlags defines the flags of an HTTP tracker scrape, which advise clients how to scrape (BEP 48)
+type scrapeFlags struct {
+	MinRequestInterval int "min_request_interval"
 }
 
 // scrapeFile defines the fields of a scrape response for a single info_hash
@@ -126,14 +216,24 @@ type scrapeFile struct {
 	Complete   int "complete"
 	Downloaded int "downloaded"
 	Incomplete int "incomplete"
-	// optional field: Name string "name"
+}
+
+// namedScrapeFile defines the fields of a scrape response for a single info_hash, when the file's name is known
+type namedScrapeFile struct {
+	Complete   int    "complete"
+	Downloaded int    "downloaded"
+	Incomplete int    "incomplete"
+	Name       string "name"
 }
 
 // Scrape reports scrape for one or more files, using HTTP format
-func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
+func (h HTTPTracker) Scrape(ctx context.Context, files []data.FileRecord) []byte {
 	// Response struct
 	scrape := scrapeResponse{
-		Files: make(map[string]scrapeFile),
+		Files: make(map[string]interface{}),
+		Flags: scrapeFlags{
+			MinRequestInterval: scrapeInterval(),
+		},
 	}
 
 	// WaitGroup to wait for all scrape file entries to be generated
@@ -151,26 +251,26 @@ func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
 			var err error
 
 			// Seeders count
-			fileInfo.Complete, err = f.Seeders()
+			fileInfo.Complete, err = f.SeedersContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
 
 			// Completion count
-			fileInfo.Downloaded, err = f.Completed()
+			fileInfo.Downloaded, err = f.CompletedContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
 
 			// Leechers count
-			fileInfo.Incomplete, err = f.Leechers()
+			fileInfo.Incomplete, err = f.LeechersContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
 
 			// Add hash and file info to map
 			mutex.Lock()
-			scrape.Files[f.InfoHash] = fileInfo
+			scrape.Files[f.InfoHash] = scrapeEntry(f, fileInfo)
 			mutex.Unlock()
 
 			// Inform waitgroup that this file is ready
@@ -181,6 +281,11 @@ func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
 	// Wait for all information to be generated
 	wg.Wait()
 
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return h.Error(ErrTrackerBusy.Error())
+	}
+
 	// Marshal struct into bencode
 	buf := bytes.NewBuffer(make([]byte, 0))
 	if err := bencode.Marshal(buf, scrape); err != nil {
@@ -190,3 +295,54 @@ func (h HTTPTracker) Scrape(files []data.FileRecord) []byte {
 
 	return buf.Bytes()
 }
+
+// FullScrape reports scrape for every specified file, using HTTP format.  Counts for all files are retrieved
+// together, and any failure to retrieve them is returned, rather than reported as counts of zero.
+func (h HTTPTracker) FullScrape(ctx context.Context, files []data.FileRecord) ([]byte, error) {
+	// Response struct
+	scrape := scrapeResponse{
+		Files: make(map[string]interface{}, len(files)),
+		Flags: scrapeFlags{
+			MinRequestInterval: scrapeInterval(),
+		},
+	}
+
+	// Retrieve counts of all files
+	stats, err := new(data.FileRecordRepository).StatsContext(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		fileStats := stats[f.ID]
+		scrape.Files[f.InfoHash] = scrapeEntry(f, scrapeFile{fileStats.Seeders, fileStats.Completed, fileStats.Leechers})
+	}
+
+	// Marshal struct into bencode
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := bencode.Marshal(buf, scrape); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scrapeEntry returns the scrape response entry for a file, which includes the file's name if it was registered
+// from a .torrent file
+func scrapeEntry(f data.FileRecord, fileInfo scrapeFile) interface{} {
+	if f.Name != "" {
+		return namedScrapeFile{fileInfo.Complete, fileInfo.Downloaded, fileInfo.Incomplete, f.Name}
+	}
+
+	return fileInfo
+}
+
+// scrapeInterval returns the minimum number of seconds a client should wait between scrapes, which
+// defaults to the announce interval if not configured
+func scrapeInterval() int {
+	if common.Static.Config.Scrape.MinInterval > 0 {
+		return common.Static.Config.Scrape.MinInterval
+	}
+
+	return common.Static.Config.Interval
+}
@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"bytes"
+	"encoding/hex"
 	"log"
 	"net/url"
 	"testing"
@@ -9,6 +10,8 @@ import (
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 
+	"code.google.com/p/go.net/context"
+
 	// Import bencode library
 	bencode "code.google.com/p/bencode-go"
 )
@@ -43,10 +46,11 @@ func TestHTTPAnnounce(t *testing.T) {
 	query.Set("uploaded", "0")
 	query.Set("downloaded", "0")
 	query.Set("left", "0")
+	query.Set("compact", "1")
 
 	// Create a HTTP tracker, trigger an announce
 	tracker := HTTPTracker{}
-	res := tracker.Announce(query, file)
+	res := tracker.Announce(context.Background(), query, file)
 	log.Println(string(res))
 
 	// Unmarshal response
@@ -61,6 +65,109 @@ func TestHTTPAnnounce(t *testing.T) {
 	}
 }
 
+// TestHTTPAnnounceDict verifies that the HTTP tracker produces a dictionary peer list for non-compact announces,
+// with or without peer IDs
+func TestHTTPAnnounceDict(t *testing.T) {
+	log.Println("TestHTTPAnnounceDict()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate and save mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6469637470656572733030303030303030303030",
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+	if file, err = file.Load(file.InfoHash, "info_hash"); err != nil {
+		t.Fatalf("Failed to load mock file: %s", err.Error())
+	}
+
+	// Generate an active peer, which has announced with a peer ID
+	peerID := "-GT0001-abcdefghijkl"
+	peer := data.PeerRecord{
+		InfoHash: file.InfoHash,
+		PeerID:   hex.EncodeToString([]byte(peerID)),
+		UserID:   1,
+		IP:       "10.9.9.9",
+		Port:     6881,
+		Left:     1,
+	}
+	if err := peer.Save(); err != nil {
+		t.Fatalf("Failed to save mock peer: %s", err.Error())
+	}
+
+	// Generate fake non-compact announce query
+	query := url.Values{}
+	query.Set("numwant", "50")
+
+	// Create a HTTP tracker, trigger an announce
+	tracker := HTTPTracker{}
+	res := tracker.Announce(context.Background(), query, file)
+	log.Println(string(res))
+
+	// Verify peer dictionary, with peer ID
+	if !bytes.Contains(res, []byte("5:peersld2:ip8:10.9.9.97:peer id20:"+peerID+"4:porti6881eee")) {
+		t.Fatalf("Announce did not contain peer dictionary: %s", string(res))
+	}
+
+	// Trigger an announce without peer IDs
+	query.Set("no_peer_id", "1")
+	res = tracker.Announce(context.Background(), query, file)
+	log.Println(string(res))
+
+	// Verify peer dictionary, without peer ID
+	if !bytes.Contains(res, []byte("5:peersld2:ip8:10.9.9.94:porti6881eee")) {
+		t.Fatalf("Announce did not contain peer dictionary without peer ID: %s", string(res))
+	}
+
+	// Delete mock records
+	if err := peer.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock peer: %s", err.Error())
+	}
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+}
+
+// TestHTTPAnnounceBusy verifies that the HTTP tracker reports a busy error once its request deadline passes
+func TestHTTPAnnounceBusy(t *testing.T) {
+	log.Println("TestHTTPAnnounceBusy()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Generate mock data.FileRecord
+	file := data.FileRecord{
+		InfoHash: "6465616462656566303030303030303030303030",
+		Verified: true,
+	}
+
+	// Create a context which is already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Create a HTTP tracker, trigger an announce
+	tracker := HTTPTracker{}
+	res := tracker.Announce(ctx, url.Values{}, file)
+	log.Println(string(res))
+
+	// Verify busy error is reported
+	if !bytes.Contains(res, []byte(ErrTrackerBusy.Error())) {
+		t.Fatalf("Announce did not report tracker busy: %s", string(res))
+	}
+}
+
 // TestHTTPTrackerError verifies that the HTTP tracker error format is correct
 func TestHTTPTrackerError(t *testing.T) {
 	log.Println("TestHTTPTrackerError()")
@@ -100,6 +207,7 @@ func TestHTTPTrackerScrape(t *testing.T) {
 	file2 := data.FileRecord{
 		InfoHash: "6265656664656164303030303030303030303030",
 		Verified: true,
+		Name:     "goat.txt",
 	}
 
 	// Save mock file
@@ -112,9 +220,14 @@ func TestHTTPTrackerScrape(t *testing.T) {
 
 	// Create a HTTP tracker, trigger a scrape
 	tracker := HTTPTracker{}
-	res := tracker.Scrape(files)
+	res := tracker.Scrape(context.Background(), files)
 	log.Println(string(res))
 
+	// Verify only the named file carries its name
+	if bytes.Count(res, []byte("4:name")) != 1 || !bytes.Contains(res, []byte("4:name8:goat.txt")) {
+		t.Fatalf("Scrape response does not carry file name")
+	}
+
 	// Unmarshal response
 	scrape := scrapeResponse{}
 	if err := bencode.Unmarshal(bytes.NewReader(res), &scrape); err != nil {
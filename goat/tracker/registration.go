@@ -0,0 +1,102 @@
+package tracker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
+)
+
+// registrationWindow is the period over which unknown info hashes are counted against the registration limit
+const registrationWindow = time.Hour
+
+// registrations counts the unknown info hashes recorded during the current registration window
+var registrations = new(registrationLimiter)
+
+// registrationLimiter limits the number of unknown info hashes recorded per registration window, so that
+// clients announcing random hashes cannot flood the files table
+type registrationLimiter struct {
+	sync.Mutex
+	start time.Time
+	count int
+}
+
+// Allow reports whether another unknown info hash may be recorded at the specified time, counting it if so.
+// A limit of 0 allows any number of info hashes.
+func (r *registrationLimiter) Allow(now time.Time, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// Begin a new window once the current one has passed
+	if now.Sub(r.start) >= registrationWindow {
+		r.start = now
+		r.count = 0
+	}
+
+	if r.count >= limit {
+		return false
+	}
+
+	r.count++
+	return true
+}
+
+// registerFile applies the configured registration policy to an unknown info hash.  In "open" mode, the file is
+// registered and verified, so that the announce may continue with the returned file.  In "approval" mode, the
+// default, the file is recorded as unverified, awaiting manual approval.  In "closed" mode, nothing is recorded.
+// In each mode but "open", or once the hourly limit for a mode is reached, a message for the client is returned.
+func registerFile(ctx context.Context, infoHash string) (data.FileRecord, string, error) {
+	conf := common.Static.Config.Registration
+
+	switch conf.Mode {
+	case "closed":
+		return data.FileRecord{}, "Unregistered torrent", nil
+	case "open":
+		if !registrations.Allow(time.Now(), conf.OpenLimit) {
+			log.Printf("tracker: registration limit reached, ignoring new file [hash: %s]", infoHash)
+			return data.FileRecord{}, "Unregistered torrent", nil
+		}
+
+		log.Printf("tracker: detected new file, registering it [hash: %s]", infoHash)
+
+		// Register and verify file, and load it again to fetch its ID
+		file := data.FileRecord{InfoHash: infoHash, Verified: true}
+		if err := file.SaveContext(ctx); err != nil {
+			return data.FileRecord{}, "", err
+		}
+
+		file, err := file.LoadContext(ctx, infoHash, "info_hash")
+		if err != nil {
+			return data.FileRecord{}, "", err
+		}
+		if file == (data.FileRecord{}) {
+			return data.FileRecord{}, "Unregistered torrent", nil
+		}
+
+		return file, "", nil
+	}
+
+	if !registrations.Allow(time.Now(), conf.ApprovalLimit) {
+		log.Printf("tracker: registration limit reached, ignoring new file [hash: %s]", infoHash)
+		return data.FileRecord{}, "Unregistered torrent", nil
+	}
+
+	log.Printf("tracker: detected new file, awaiting manual approval [hash: %s]", infoHash)
+
+	// Create an entry in file table for this hash, but mark it as unverified, and save it asynchronously
+	go func(file data.FileRecord) {
+		if err := file.Save(); err != nil {
+			log.Println(err.Error())
+		}
+	}(data.FileRecord{InfoHash: infoHash, Verified: false})
+
+	return data.FileRecord{}, "Unregistered torrent", nil
+}
@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
+)
+
+// TestRegistrationLimiter verifies that unknown info hashes are limited per registration window
+func TestRegistrationLimiter(t *testing.T) {
+	log.Println("TestRegistrationLimiter()")
+
+	limiter := new(registrationLimiter)
+	now := time.Unix(1400000000, 0)
+
+	// No limit
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow(now, 0) {
+			t.Fatalf("[%02d] Unlimited registration refused", i)
+		}
+	}
+
+	var tests = []struct {
+		after   time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{time.Minute, true},
+		{2 * time.Minute, false},
+		{registrationWindow - time.Second, false},
+		// New window
+		{registrationWindow, true},
+		{registrationWindow + time.Minute, true},
+		{registrationWindow + 2*time.Minute, false},
+	}
+
+	for i, test := range tests {
+		if allowed := limiter.Allow(now.Add(test.after), 2); allowed != test.allowed {
+			t.Fatalf("[%02d] Registration allowed: %t, expected %t", i, allowed, test.allowed)
+		}
+	}
+}
+
+// TestRegisterFile verifies that unknown info hashes are handled according to the registration policy
+func TestRegisterFile(t *testing.T) {
+	log.Println("TestRegisterFile()")
+
+	// Load config
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+
+	// Restore registration limiter once done
+	limiter := registrations
+	defer func() {
+		registrations = limiter
+	}()
+
+	var tests = []struct {
+		mode     string
+		limit    int
+		infoHash string
+		msg      string
+		verified bool
+	}{
+		// Open mode registers and verifies new files, up to its limit
+		{"open", 1, "7265676973746572303030303030303030303031", "", true},
+		{"open", 1, "7265676973746572303030303030303030303032", "Unregistered torrent", false},
+		// Approval mode records unverified files
+		{"approval", 0, "7265676973746572303030303030303030303033", "Unregistered torrent", false},
+		// Closed mode records nothing
+		{"closed", 0, "7265676973746572303030303030303030303034", "Unregistered torrent", false},
+	}
+
+	for i, test := range tests {
+		registrations = new(registrationLimiter)
+		common.Static.Config.Registration.Mode = test.mode
+		common.Static.Config.Registration.OpenLimit = test.limit
+		common.Static.Config.Registration.ApprovalLimit = test.limit
+
+		// Exhaust limit before registering the file under test, when it should be refused
+		if test.limit > 0 && test.msg != "" {
+			registrations.Allow(time.Now(), test.limit)
+		}
+
+		file, msg, err := registerFile(context.Background(), test.infoHash)
+		if err != nil {
+			t.Fatalf("[%02d] Failed to register file: %s", i, err.Error())
+		}
+		if msg != test.msg {
+			t.Fatalf("[%02d] Message, expected %q, got %q", i, test.msg, msg)
+		}
+		if test.verified && (file.ID == 0 || !file.Verified) {
+			t.Fatalf("[%02d] File not registered: %v", i, file)
+		}
+
+		// Approval mode saves unverified files asynchronously
+		var stored data.FileRecord
+		for j := 0; j < 50; j++ {
+			if stored, err = stored.Load(test.infoHash, "info_hash"); err != nil {
+				t.Fatalf("[%02d] Failed to load file: %s", i, err.Error())
+			}
+			if stored != (data.FileRecord{}) || test.mode != "approval" {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// Only files registered or awaiting approval are stored
+		recorded := test.verified || test.mode == "approval"
+		if (stored != (data.FileRecord{})) != recorded || stored.Verified != test.verified {
+			t.Fatalf("[%02d] Stored file, expected recorded %t, got %v", i, recorded, stored)
+		}
+
+		if recorded {
+			if err := stored.Delete(); err != nil {
+				t.Fatalf("[%02d] Failed to delete file: %s", i, err.Error())
+			}
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package tracker
 
 import (
+	"encoding/hex"
 	"errors"
 	"log"
+	"net"
 	"net/url"
+	"strconv"
 
 	"github.com/mdlayher/goat/goat/data"
+
+	"code.google.com/p/go.net/context"
 )
 
 var (
@@ -20,18 +25,21 @@ var (
 
 	// ErrScrapeFailure - caused when the tracker fails to generate a valid scrape response
 	ErrScrapeFailure = errors.New("tracker: failed to create scrape response")
+
+	// ErrTrackerBusy - caused when the tracker cannot generate a response before the request deadline
+	ErrTrackerBusy = errors.New("tracker: tracker busy, please try again later")
 )
 
 // TorrentTracker defines the common interface for trackers to generate their responses
 type TorrentTracker interface {
-	Announce(url.Values, data.FileRecord) []byte
+	Announce(context.Context, url.Values, data.FileRecord) []byte
 	Error(string) []byte
 	Protocol() string
-	Scrape([]data.FileRecord) []byte
+	Scrape(context.Context, []data.FileRecord) []byte
 }
 
-// Announce generates and triggers a tracker announces request
-func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []byte {
+// Announce generates and triggers a tracker announces request, bounded by the deadline of ctx
+func Announce(ctx context.Context, tracker TorrentTracker, user data.UserRecord, query url.Values) []byte {
 	// Store announce information in struct
 	announce := new(data.AnnounceLog)
 	err := announce.FromValues(query)
@@ -39,12 +47,13 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		return tracker.Error("Malformed announce")
 	}
 
-	// Request to store announce
-	go func(announce *data.AnnounceLog) {
-		if err := announce.Save(); err != nil {
+	// Queue announce to be stored, along with any alternate addresses the client reported (BEP 7)
+	alternates := alternateAnnounces(query, *announce)
+	for _, a := range append([]data.AnnounceLog{*announce}, alternates...) {
+		if err := data.QueueAnnounceLog(a); err != nil {
 			log.Println(err.Error())
 		}
-	}(announce)
+	}
 
 	// Only report event when needed
 	event := ""
@@ -55,29 +64,22 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 	log.Printf("announce: [%s %s:%d] %s%s", tracker.Protocol(), announce.IP, announce.Port, event, announce.InfoHash)
 
 	// Check for a matching file via info_hash
-	file, err := new(data.FileRecord).Load(announce.InfoHash, "info_hash")
+	file, err := new(data.FileRecord).LoadContext(ctx, announce.InfoHash, "info_hash")
 	if err != nil {
 		log.Println(err.Error())
-		return tracker.Error(ErrAnnounceFailure.Error())
+		return tracker.Error(failure(err, ErrAnnounceFailure))
 	}
 
-	// Torrent is currently unregistered
+	// Torrent is currently unregistered, so apply the registration policy
 	if file == (data.FileRecord{}) {
-		log.Printf("tracker: detected new file, awaiting manual approval [hash: %s]", announce.InfoHash)
-
-		// Create an entry in file table for this hash, but mark it as unverified
-		file.InfoHash = announce.InfoHash
-		file.Verified = false
-
-		// Save file asynchronously
-		go func(file data.FileRecord) {
-			if err := file.Save(); err != nil {
-				log.Println(err.Error())
-			}
-		}(file)
-
-		// Report error
-		return tracker.Error("Unregistered torrent")
+		var msg string
+		if file, msg, err = registerFile(ctx, announce.InfoHash); err != nil {
+			log.Println(err.Error())
+			return tracker.Error(failure(err, ErrAnnounceFailure))
+		}
+		if msg != "" {
+			return tracker.Error(msg)
+		}
 	}
 
 	// Ensure file is verified, meaning we will permit tracking of it
@@ -85,6 +87,15 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		return tracker.Error("Unverified torrent")
 	}
 
+	// Record peer in swarm store or peers table asynchronously
+	go func(file data.FileRecord, userID int, announces []data.AnnounceLog) {
+		for _, a := range announces {
+			if err := file.SwarmAnnounce(a, userID); err != nil {
+				log.Println(err.Error())
+			}
+		}
+	}(file, user.ID, append([]data.AnnounceLog{*announce}, alternates...))
+
 	// Launch peer reaper asynchronously to remove old peers from this file
 	go func(file data.FileRecord) {
 		// Start peer reaper
@@ -99,16 +110,32 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		}
 	}(file)
 
-	// If UDP tracker, we cannot reliably detect user, so we announce anonymously
-	if _, ok := tracker.(UDPTracker); ok {
-		return tracker.Announce(query, file)
+	// If UDP tracker and no passkey was sent, we cannot reliably detect user, so we announce anonymously
+	if _, ok := tracker.(UDPTracker); ok && user == (data.UserRecord{}) {
+		return tracker.Announce(ctx, query, file)
+	}
+
+	// Account only the traffic reported since this client's previous announce in the same session
+	uploaded, downloaded, err := accountTraffic(ctx, user.ID, *announce)
+	if err != nil {
+		log.Println(err.Error())
+		return tracker.Error(failure(err, ErrAnnounceFailure))
+	}
+
+	// Add traffic to the user's lifetime totals asynchronously
+	if uploaded > 0 || downloaded > 0 {
+		go func(user data.UserRecord, uploaded int64, downloaded int64) {
+			if err := user.AddTraffic(uploaded, downloaded); err != nil {
+				log.Println(err.Error())
+			}
+		}(user, uploaded, downloaded)
 	}
 
 	// Check existing record for this user with this file and this IP
-	fileUser, err := new(data.FileUserRecord).Load(file.ID, user.ID, query.Get("ip"))
+	fileUser, err := new(data.FileUserRecord).LoadContext(ctx, file.ID, user.ID, query.Get("ip"))
 	if err != nil {
 		log.Println(err.Error())
-		return tracker.Error(ErrAnnounceFailure.Error())
+		return tracker.Error(failure(err, ErrAnnounceFailure))
 	}
 
 	// New user, starting torrent
@@ -128,9 +155,8 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		}
 
 		// Track the initial uploaded, download, and left values
-		// NOTE: clients report absolute values, so delta should NEVER be calculated for these
-		fileUser.Uploaded = announce.Uploaded
-		fileUser.Downloaded = announce.Downloaded
+		fileUser.Uploaded = uploaded
+		fileUser.Downloaded = downloaded
 		fileUser.Left = announce.Left
 	} else {
 		// Else, pre-existing record, so update
@@ -154,21 +180,11 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 		// Add an announce
 		fileUser.Announced = fileUser.Announced + 1
 
-		// Store latest statistics, but do so in a sane way (no removing upload/download, no adding left)
-		// NOTE: clients report absolute values, so delta should NEVER be calculated for these
-		// NOTE: It is also worth noting that if a client re-downloads a file they have previously downloaded,
-		// but the data.FileUserRecord relationship is not cleared, they will essentially get a "free" download, with
-		// no extra download penalty to their share ratio
-		// For the time being, this behavior will be expected and acceptable
-		if announce.Uploaded > fileUser.Uploaded {
-			fileUser.Uploaded = announce.Uploaded
-		}
-		if announce.Downloaded > fileUser.Downloaded {
-			fileUser.Downloaded = announce.Downloaded
-		}
-		if announce.Left < fileUser.Left {
-			fileUser.Left = announce.Left
-		}
+		// Add the traffic accounted for this announce, and store the latest amount left
+		// NOTE: a client which re-downloads a file reports more left, and all of its new download is accounted
+		fileUser.Uploaded = fileUser.Uploaded + uploaded
+		fileUser.Downloaded = fileUser.Downloaded + downloaded
+		fileUser.Left = announce.Left
 	}
 
 	// Update file/user relationship record asynchronously
@@ -179,11 +195,73 @@ func Announce(tracker TorrentTracker, user data.UserRecord, query url.Values) []
 	}(fileUser)
 
 	// Create announce
-	return tracker.Announce(query, file)
+	return tracker.Announce(ctx, query, file)
 }
 
-// Scrape generates and triggers a tracker scrape request
-func Scrape(tracker TorrentTracker, query url.Values) []byte {
+// peerRequest describes the peer making an announce, so that its peer list can be selected for it
+func peerRequest(query url.Values) data.PeerRequest {
+	req := data.PeerRequest{
+		IP:     query.Get("ip"),
+		Seeder: query.Get("left") == "0",
+	}
+
+	// Port and peer_id are validated when the announce is logged, so invalid values are ignored here
+	if port, err := strconv.ParseUint(query.Get("port"), 10, 16); err == nil {
+		req.Port = uint16(port)
+	}
+	if query.Get("peer_id") != "" {
+		req.PeerID = hex.EncodeToString([]byte(query.Get("peer_id")))
+	}
+
+	return req
+}
+
+// alternateAnnounces generates copies of an announce for the ipv4 and ipv6 parameters, allowing a dual-stack
+// client to be reached at an address other than the one it announced from (BEP 7).  Parameters may be a bare
+// address, or an address with port, in which case the port overrides the announced port.  Invalid addresses,
+// addresses of the wrong family, and addresses identical to the announced address are ignored.
+func alternateAnnounces(query url.Values, announce data.AnnounceLog) []data.AnnounceLog {
+	alternates := make([]data.AnnounceLog, 0)
+
+	for _, param := range []string{"ipv4", "ipv6"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+
+		// Split off port, if one is present
+		host, port := value, announce.Port
+		if h, p, err := net.SplitHostPort(value); err == nil {
+			n, err := strconv.Atoi(p)
+			if err != nil || n <= 0 || n > 65535 {
+				continue
+			}
+
+			host, port = h, n
+		}
+
+		// Verify address is valid, and of the correct family
+		ip := net.ParseIP(host)
+		if ip == nil || (ip.To4() != nil) != (param == "ipv4") {
+			continue
+		}
+
+		// Skip addresses which are the same as the announced address
+		if ip.Equal(net.ParseIP(announce.IP)) && port == announce.Port {
+			continue
+		}
+
+		a := announce
+		a.IP = ip.String()
+		a.Port = port
+		alternates = append(alternates, a)
+	}
+
+	return alternates
+}
+
+// Scrape generates and triggers a tracker scrape request, bounded by the deadline of ctx
+func Scrape(ctx context.Context, tracker TorrentTracker, query url.Values) []byte {
 	// List of files to be scraped
 	scrapeFiles := make([]data.FileRecord, 0)
 
@@ -200,20 +278,18 @@ func Scrape(tracker TorrentTracker, query url.Values) []byte {
 			return tracker.Error("Malformed scrape")
 		}
 
-		// Request to store scrape
-		go func(scrape *data.ScrapeLog) {
-			if err := scrape.Save(); err != nil {
-				log.Println(err.Error())
-			}
-		}(scrape)
+		// Queue scrape to be stored
+		if err := data.QueueScrapeLog(*scrape); err != nil {
+			log.Println(err.Error())
+		}
 
 		log.Printf("scrape: [%s %s] %s", tracker.Protocol(), scrape.IP, scrape.InfoHash)
 
 		// Check for a matching file via info_hash
-		file, err := new(data.FileRecord).Load(scrape.InfoHash, "info_hash")
+		file, err := new(data.FileRecord).LoadContext(ctx, scrape.InfoHash, "info_hash")
 		if err != nil {
 			log.Println(err.Error())
-			return tracker.Error(ErrScrapeFailure.Error())
+			return tracker.Error(failure(err, ErrScrapeFailure))
 		}
 
 		// Torrent is not currently registered
@@ -245,5 +321,15 @@ func Scrape(tracker TorrentTracker, query url.Values) []byte {
 	}
 
 	// Create scrape
-	return tracker.Scrape(scrapeFiles)
+	return tracker.Scrape(ctx, scrapeFiles)
+}
+
+// failure chooses the error message reported to a client when a data call fails, so that clients
+// are told to retry when the tracker is merely busy
+func failure(err error, fallback error) string {
+	if err == data.ErrTimeout {
+		return ErrTrackerBusy.Error()
+	}
+
+	return fallback.Error()
 }
@@ -0,0 +1,54 @@
+package tracker
+
+import (
+	"log"
+	"net/url"
+	"testing"
+
+	"github.com/mdlayher/goat/goat/data"
+)
+
+// TestAlternateAnnounces verifies that ipv4 and ipv6 parameters generate alternate announces (BEP 7)
+func TestAlternateAnnounces(t *testing.T) {
+	log.Println("TestAlternateAnnounces()")
+
+	announce := data.AnnounceLog{IP: "192.168.1.1", Port: 6881}
+
+	var tests = []struct {
+		ipv4  string
+		ipv6  string
+		peers []data.Peer
+	}{
+		// No alternate addresses
+		{"", "", []data.Peer{}},
+		// Bare IPv6 address uses announced port
+		{"", "2001:db8::1", []data.Peer{{IP: "2001:db8::1", Port: 6881}}},
+		// IPv6 address with port
+		{"", "[2001:db8::1]:7000", []data.Peer{{IP: "2001:db8::1", Port: 7000}}},
+		// Both families
+		{"10.0.0.1:7000", "2001:db8::1", []data.Peer{{IP: "10.0.0.1", Port: 7000}, {IP: "2001:db8::1", Port: 6881}}},
+		// Same as announced address
+		{"192.168.1.1", "", []data.Peer{}},
+		// Wrong address families
+		{"2001:db8::1", "10.0.0.1", []data.Peer{}},
+		// Invalid address and port
+		{"example.com", "[2001:db8::1]:99999", []data.Peer{}},
+	}
+
+	for i, test := range tests {
+		query := url.Values{}
+		query.Set("ipv4", test.ipv4)
+		query.Set("ipv6", test.ipv6)
+
+		alternates := alternateAnnounces(query, announce)
+		if len(alternates) != len(test.peers) {
+			t.Fatalf("[%02d] Unexpected alternates: %v", i, alternates)
+		}
+
+		for j, a := range alternates {
+			if a.IP != test.peers[j].IP || uint16(a.Port) != test.peers[j].Port {
+				t.Fatalf("[%02d] Unexpected alternate: %s:%d", i, a.IP, a.Port)
+			}
+		}
+	}
+}
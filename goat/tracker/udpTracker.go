@@ -10,6 +10,8 @@ import (
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 	"github.com/mdlayher/goat/goat/data/udp"
+
+	"code.google.com/p/go.net/context"
 )
 
 // orderedScrape is used to ensure that UDP scrape results are returned in the correct order
@@ -21,10 +23,13 @@ type orderedScrape struct {
 // UDPTracker generates responses in the UDP datagram format
 type UDPTracker struct {
 	TransID uint32
+
+	// IPv6 is set when the client is connected over IPv6, and should receive IPv6 peers (BEP 15)
+	IPv6 bool
 }
 
 // Announce announces using UDP format
-func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
+func (u UDPTracker) Announce(ctx context.Context, query url.Values, file data.FileRecord) []byte {
 	// Create UDP announce response
 	announce := udp.AnnounceResponse{
 		Action:   1,
@@ -33,18 +38,23 @@ func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 	}
 
 	// Calculate file seeders and leechers
-	seeders, err := file.Seeders()
+	seeders, err := file.SeedersContext(ctx)
 	if err != nil {
 		log.Println(err.Error())
 	}
 	announce.Seeders = uint32(seeders)
 
-	leechers, err := file.Leechers()
+	leechers, err := file.LeechersContext(ctx)
 	if err != nil {
 		log.Println(err.Error())
 	}
 	announce.Leechers = uint32(leechers)
 
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return u.Error(ErrTrackerBusy.Error())
+	}
+
 	// Convert to UDP byte buffer
 	announceBuf, err := announce.MarshalBinary()
 	if err != nil {
@@ -59,12 +69,15 @@ func (u UDPTracker) Announce(query url.Values, file data.FileRecord) []byte {
 	}
 
 	// Retrieve compact peer list
-	// Note: because we are UDP, we send the second parameter 'false' to get
-	// a "best guess" peer list, due to anonymous announces
-	peers, err := file.CompactPeerList(numwant, false)
+	peers, peers6, err := file.CompactPeerListContext(ctx, numwant, peerRequest(query))
 	if err != nil {
 		log.Println(err.Error())
-		return u.Error(ErrPeerListFailure.Error())
+		return u.Error(failure(err, ErrPeerListFailure))
+	}
+
+	// Clients connected over IPv6 receive 18 byte IPv6 peers, rather than 6 byte IPv4 peers
+	if u.IPv6 {
+		peers = peers6
 	}
 
 	// Add compact peer list
@@ -103,7 +116,7 @@ func (u UDPTracker) Protocol() string {
 }
 
 // Scrape scrapes using UDP format
-func (u UDPTracker) Scrape(files []data.FileRecord) []byte {
+func (u UDPTracker) Scrape(ctx context.Context, files []data.FileRecord) []byte {
 	// Buffered channel to receive UDP scrape stats structs
 	resChan := make(chan *orderedScrape, len(files))
 
@@ -122,21 +135,21 @@ func (u UDPTracker) Scrape(files []data.FileRecord) []byte {
 		go func(f data.FileRecord, o *orderedScrape) {
 			// Seeders count
 			var err error
-			seeders, err := f.Seeders()
+			seeders, err := f.SeedersContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
 			o.File.Seeders = uint32(seeders)
 
 			// Completion count
-			completed, err := f.Completed()
+			completed, err := f.CompletedContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
 			o.File.Completed = uint32(completed)
 
 			// Leechers count
-			leechers, err := f.Leechers()
+			leechers, err := f.LeechersContext(ctx)
 			if err != nil {
 				log.Println(err.Error())
 			}
@@ -163,6 +176,11 @@ func (u UDPTracker) Scrape(files []data.FileRecord) []byte {
 	// Close response channel
 	close(resChan)
 
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return u.Error(ErrTrackerBusy.Error())
+	}
+
 	// Create UDP scrape response
 	scrape := udp.ScrapeResponse{
 		Action:    2,
@@ -9,6 +9,8 @@ import (
 	"github.com/mdlayher/goat/goat/common"
 	"github.com/mdlayher/goat/goat/data"
 	"github.com/mdlayher/goat/goat/data/udp"
+
+	"code.google.com/p/go.net/context"
 )
 
 // TestUDPAnnounce verifies that the UDP tracker announce output format is correct
@@ -45,7 +47,7 @@ func TestUDPAnnounce(t *testing.T) {
 
 	// Create a UDP tracker, trigger an announce
 	tracker := UDPTracker{TransID: uint32(1234)}
-	res := tracker.Announce(query, file)
+	res := tracker.Announce(context.Background(), query, file)
 
 	// Decode response
 	announce := new(udp.AnnounceResponse)
@@ -148,7 +150,7 @@ func TestUDPTrackerScrape(t *testing.T) {
 
 	// Create a UDP tracker, trigger a scrape
 	tracker := UDPTracker{TransID: uint32(1234)}
-	res := tracker.Scrape(files)
+	res := tracker.Scrape(context.Background(), files)
 
 	// Decode response
 	scrape := new(udp.ScrapeResponse)
@@ -0,0 +1,154 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/ws"
+
+	"code.google.com/p/go.net/context"
+)
+
+// WebSocketTracker generates responses in the WebTorrent JSON format.  Relaying WebRTC offers and answers
+// between peers is left to the WebSocket router, which holds the connections.
+type WebSocketTracker struct {
+	// Action and InfoHash are echoed in errors, so the client can match them with its request
+	Action   string
+	InfoHash string
+}
+
+// Announce announces using WebTorrent format
+func (w WebSocketTracker) Announce(ctx context.Context, query url.Values, file data.FileRecord) []byte {
+	// Generate response struct
+	announce := ws.AnnounceResponse{
+		Action:   "announce",
+		InfoHash: ws.ToBinaryString(query.Get("info_hash")),
+		Interval: common.Static.Config.Interval,
+	}
+
+	// Get seeders count on file
+	var err error
+	announce.Complete, err = file.SeedersContext(ctx)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	// Get leechers count on file
+	announce.Incomplete, err = file.LeechersContext(ctx)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return w.Error(ErrTrackerBusy.Error())
+	}
+
+	// Marshal struct into JSON
+	buf, err := json.Marshal(announce)
+	if err != nil {
+		log.Println(err.Error())
+		return w.Error(ErrAnnounceFailure.Error())
+	}
+
+	return buf
+}
+
+// Error reports a JSON []byte response as specified by input string
+func (w WebSocketTracker) Error(msg string) []byte {
+	res := ws.ErrorResponse{
+		Action:        w.Action,
+		InfoHash:      w.InfoHash,
+		FailureReason: msg,
+	}
+
+	// Marshal struct into JSON
+	buf, err := json.Marshal(res)
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+
+	return buf
+}
+
+// Protocol returns the protocol used by this tracker
+func (w WebSocketTracker) Protocol() string {
+	return "WebSocket"
+}
+
+// Scrape reports scrape for one or more files, using WebTorrent format
+func (w WebSocketTracker) Scrape(ctx context.Context, files []data.FileRecord) []byte {
+	// Response struct
+	scrape := ws.ScrapeResponse{
+		Action: "scrape",
+		Files:  make(map[string]ws.ScrapeFile),
+	}
+
+	// WaitGroup to wait for all scrape file entries to be generated
+	var wg sync.WaitGroup
+	wg.Add(len(files))
+
+	// Mutex for safe locking on map writes
+	var mutex sync.Mutex
+
+	// Iterate all files in parallel
+	for _, f := range files {
+		go func(f data.FileRecord) {
+			defer wg.Done()
+
+			// Generate ScrapeFile struct
+			fileInfo := ws.ScrapeFile{}
+			var err error
+
+			// Seeders count
+			if fileInfo.Complete, err = f.SeedersContext(ctx); err != nil {
+				log.Println(err.Error())
+			}
+
+			// Completion count
+			if fileInfo.Downloaded, err = f.CompletedContext(ctx); err != nil {
+				log.Println(err.Error())
+			}
+
+			// Leechers count
+			if fileInfo.Incomplete, err = f.LeechersContext(ctx); err != nil {
+				log.Println(err.Error())
+			}
+
+			// Files are keyed by binary string info hash
+			infoHash, err := hex.DecodeString(f.InfoHash)
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+
+			// Add hash and file info to map
+			mutex.Lock()
+			scrape.Files[ws.ToBinaryString(string(infoHash))] = fileInfo
+			mutex.Unlock()
+		}(f)
+	}
+
+	// Wait for all information to be generated
+	wg.Wait()
+
+	// Database too slow to answer, so the client should retry later
+	if ctx.Err() != nil {
+		return w.Error(ErrTrackerBusy.Error())
+	}
+
+	// Marshal struct into JSON
+	buf, err := json.Marshal(scrape)
+	if err != nil {
+		log.Println(err.Error())
+		return w.Error(ErrScrapeFailure.Error())
+	}
+
+	return buf
+}
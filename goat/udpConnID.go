@@ -0,0 +1,115 @@
+package goat
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// udpConnIDBucket is the period for which a generated connection ID is the same for one address.
+	// IDs are accepted during the bucket they were generated in and the one after it, so each ID is
+	// valid for up to two minutes, as BEP 15 requires.
+	udpConnIDBucket = time.Minute
+
+	// udpConnIDRotate is the period after which the connection ID secret is replaced.  The previous
+	// secret is kept, so IDs generated just before a rotation remain valid.
+	udpConnIDRotate = 30 * time.Minute
+)
+
+// udpConnIDs generates and validates UDP connection IDs, which are an HMAC of a client's address and
+// the current time bucket.  Because IDs can be verified by recomputing them, no per-client state is kept.
+type udpConnIDs struct {
+	sync.RWMutex
+	secret   []byte
+	previous []byte
+	rotated  time.Time
+}
+
+// udpConnID is the connection ID generator used by the UDP router
+var udpConnID = new(udpConnIDs)
+
+// Generate returns the connection ID for an address at the specified time
+func (u *udpConnIDs) Generate(addr *net.UDPAddr, now time.Time) uint64 {
+	u.rotate(now)
+
+	u.RLock()
+	defer u.RUnlock()
+
+	return udpConnIDHash(u.secret, addr, now.Unix()/int64(udpConnIDBucket/time.Second))
+}
+
+// Valid reports whether a connection ID was generated for an address within the validity window
+func (u *udpConnIDs) Valid(id uint64, addr *net.UDPAddr, now time.Time) bool {
+	u.rotate(now)
+
+	u.RLock()
+	defer u.RUnlock()
+
+	// Check the current and previous time buckets, against the current and previous secrets
+	bucket := now.Unix() / int64(udpConnIDBucket/time.Second)
+	for _, secret := range [][]byte{u.secret, u.previous} {
+		if secret == nil {
+			continue
+		}
+
+		for _, b := range []int64{bucket, bucket - 1} {
+			if udpConnIDHash(secret, addr, b) == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rotate replaces the secret with a new random one, if it has not been replaced recently
+func (u *udpConnIDs) rotate(now time.Time) {
+	u.RLock()
+	fresh := u.secret != nil && now.Sub(u.rotated) < udpConnIDRotate
+	u.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	// Check again, in case another goroutine rotated the secret first
+	if u.secret != nil && now.Sub(u.rotated) < udpConnIDRotate {
+		return
+	}
+
+	secret := make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		// Without a source of randomness, keep using the current secret rather than a predictable one
+		if u.secret != nil {
+			return
+		}
+
+		panic(err)
+	}
+
+	u.previous = u.secret
+	u.secret = secret
+	u.rotated = now
+}
+
+// udpConnIDHash computes a connection ID from a secret, address, and time bucket
+func udpConnIDHash(secret []byte, addr *net.UDPAddr, bucket int64) uint64 {
+	mac := hmac.New(sha256.New, secret)
+
+	// Address is always hashed in its 16 byte form, followed by its port and the time bucket
+	buf := make([]byte, net.IPv6len+2+8)
+	copy(buf, addr.IP.To16())
+	binary.BigEndian.PutUint16(buf[net.IPv6len:], uint16(addr.Port))
+	binary.BigEndian.PutUint64(buf[net.IPv6len+2:], uint64(bucket))
+	mac.Write(buf)
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package goat
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestUDPConnID verifies that UDP connection IDs are only valid for their address, within the validity window
+func TestUDPConnID(t *testing.T) {
+	log.Println("TestUDPConnID()")
+
+	ids := new(udpConnIDs)
+	addr := &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 6881}
+	now := time.Unix(1400000000, 0)
+
+	// Generate a connection ID for this address
+	id := ids.Generate(addr, now)
+	if id != ids.Generate(addr, now) {
+		t.Fatalf("Connection ID not stable within its time bucket")
+	}
+
+	var tests = []struct {
+		addr  *net.UDPAddr
+		after time.Duration
+		valid bool
+	}{
+		// Same address, immediately
+		{addr, 0, true},
+		// Same address, in the next time bucket
+		{addr, udpConnIDBucket, true},
+		// Same address, after the validity window
+		{addr, 2 * udpConnIDBucket, false},
+		// Different port
+		{&net.UDPAddr{IP: addr.IP, Port: 6882}, 0, false},
+		// Different IP
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 6881}, 0, false},
+		// IPv6 address
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6881}, 0, false},
+	}
+
+	for i, test := range tests {
+		if valid := ids.Valid(id, test.addr, now.Add(test.after)); valid != test.valid {
+			t.Fatalf("[%02d] Connection ID valid: %t, expected %t", i, valid, test.valid)
+		}
+	}
+
+	// Connection IDs survive a secret rotation
+	ids.rotated = now.Add(-udpConnIDRotate)
+	previous := ids.secret
+	if !ids.Valid(id, addr, now) {
+		t.Fatalf("Connection ID not valid after secret rotation")
+	}
+	if string(previous) == string(ids.secret) {
+		t.Fatalf("Secret was not rotated")
+	}
+
+	// But not a second rotation
+	ids.rotated = now.Add(-udpConnIDRotate)
+	if ids.Valid(id, addr, now) {
+		t.Fatalf("Connection ID still valid after two secret rotations")
+	}
+}
@@ -29,9 +29,6 @@ var (
 	errUDPWrite = errors.New("udp: udpTracker cannot generate UDP udpTracker response")
 )
 
-// UDP address to connection ID map
-var udpAddrToID = map[string]uint64{}
-
 // Handle incoming UDP connections and return response
 func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 	// Create shutdown function
@@ -78,8 +75,24 @@ func handleUDP(l *net.UDPConn, sendChan chan bool, recvChan chan bool) {
 
 		// Spawn a goroutine to handle the connection and send back the response
 		go func(l *net.UDPConn, buf []byte, addr *net.UDPAddr) {
+			// If configured, datagrams relayed by trusted proxies begin with a PROXY protocol header, which
+			// carries the client's address.  Responses are still sent back through the proxy.
+			client := addr
+			if common.Static.Config.Proxy.ProxyProtocol && inNets(addr.IP, trustedNets) {
+				source, payload, err := parseProxyDatagram(buf)
+				if err != nil {
+					log.Printf("proxy: %s: %s", addr.String(), err.Error())
+					return
+				}
+
+				buf = payload
+				if source != nil {
+					client = source
+				}
+			}
+
 			// Capture initial response from buffer
-			res, err := parseUDP(buf, addr)
+			res, err := parseUDP(buf, client)
 			if err != nil {
 				// Client sent a malformed UDP handshake
 				log.Println(err.Error())
@@ -115,7 +128,7 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 	}
 
 	// Create a udpTracker to handle this client
-	udpTracker := tracker.UDPTracker{TransID: packet.TransID}
+	udpTracker := tracker.UDPTracker{TransID: packet.TransID, IPv6: addr.IP.To4() == nil}
 
 	// Check for maintenance mode
 	if common.Static.Maintenance {
@@ -131,17 +144,11 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 			return udpTracker.Error("Invalid UDP udpTracker handshake"), errUDPHandshake
 		}
 
-		// Generate a connection ID, which will be expected for this client next call
-		expID := uint64(common.RandRange(1, 1000000000))
-
-		// Store this client's address and ID in map
-		udpAddrToID[addr.String()] = expID
-
-		// Generate connect response
+		// Generate connect response, with a connection ID which will be expected for this client next call
 		connect := udp.ConnectResponse{
 			Action:  0,
 			TransID: packet.TransID,
-			ConnID:  expID,
+			ConnID:  udpConnID.Generate(addr, time.Now()),
 		}
 
 		// Grab bytes from connect response
@@ -155,25 +162,14 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 	}
 
 	// For all udpTracker actions other than connect, we must validate the connection ID for this
-	// address, ensuring it matches the previously set value
-
-	// Ensure connection ID map contains this IP address
-	expID, ok := udpAddrToID[addr.String()]
-	if !ok {
-		return udpTracker.Error("Client must properly handshake before announce"), errUDPHandshake
-	}
-
-	// Validate expected connection ID using map
-	if packet.ConnID != expID {
+	// address, ensuring it was generated for this address within the last two minutes
+	if !udpConnID.Valid(packet.ConnID, addr, time.Now()) {
 		return udpTracker.Error("Invalid UDP connection ID"), errUDPHandshake
 	}
 
-	// Clear this IP from the connection map after 2 minutes
-	// note: this is done to conserve memory and prevent session fixation
-	go func(addr *net.UDPAddr) {
-		<-time.After(2 * time.Minute)
-		delete(udpAddrToID, addr.String())
-	}(addr)
+	// Bound all data calls made on behalf of this request by the configured timeout
+	ctx, cancel := requestContext()
+	defer cancel()
 
 	// Action 1: Announce
 	if packet.Action == 1 {
@@ -187,13 +183,48 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 		// Convert UDP announce to query map
 		query := announce.ToValues()
 
-		// Check if a proper IP was set, and if not, use the UDP connection address
-		if query.Get("ip") == "0" {
-			query.Set("ip", strings.Split(addr.String(), ":")[0])
+		// Check if a proper IP was set, and may be used, and if not, use the UDP connection address.  The IP
+		// field can only carry an IPv4 address, so IPv6 clients always use their connection address.
+		if query.Get("ip") == "0" || udpTracker.IPv6 || !ipParamAllowed(addr.IP) {
+			query.Set("ip", addr.IP.String())
 		}
 
-		// Trigger an anonymous announce
-		return tracker.Announce(udpTracker, data.UserRecord{}, query), nil
+		// Apply client rules, which are the only way to whitelist UDP clients, as they send no User-Agent
+		verdict, err := clientVerdict(ctx, query.Get("peer_id"))
+		if err != nil {
+			log.Println(err.Error())
+
+			// Database too slow to answer, so do not penalize the client
+			if err == data.ErrTimeout {
+				return udpTracker.Error(tracker.ErrTrackerBusy.Error()), nil
+			}
+		}
+		if verdict == data.ClientBlacklisted {
+			return udpTracker.Error("Your client is blacklisted"), nil
+		}
+		if common.Static.Config.Whitelist && verdict != data.ClientWhitelisted {
+			return udpTracker.Error("Your client is not whitelisted"), nil
+		}
+
+		// Validate passkey sent using URLData (BEP 41), and torrent limit
+		user, msg, err := announceUser(ctx, announce.Passkey())
+		if err != nil {
+			log.Println(err.Error())
+
+			// Database too slow to answer, so do not penalize the client
+			if err == data.ErrTimeout {
+				return udpTracker.Error(tracker.ErrTrackerBusy.Error()), nil
+			}
+		}
+		if msg != "" {
+			return udpTracker.Error(msg), nil
+		}
+
+		// Put passkey in query map
+		query.Set("passkey", user.Passkey)
+
+		// Trigger an announce, which is anonymous if no passkey was sent
+		return tracker.Announce(ctx, udpTracker, user, query), nil
 	}
 
 	// Action 2: Scrape
@@ -209,10 +240,10 @@ func parseUDP(buf []byte, addr *net.UDPAddr) ([]byte, error) {
 		query := scrape.ToValues()
 
 		// Store IP in query map
-		query.Set("ip", strings.Split(addr.String(), ":")[0])
+		query.Set("ip", addr.IP.String())
 
 		// Trigger a scrape
-		return tracker.Scrape(udpTracker, query), nil
+		return tracker.Scrape(ctx, udpTracker, query), nil
 	}
 
 	// No action matched
@@ -32,6 +32,24 @@ func TestUDPRouter(t *testing.T) {
 		t.Fatalf("Failed to save mock file: %s", err.Error())
 	}
 
+	// Generate and save mock user, whose passkey is sent using URLData
+	user := new(data.UserRecord)
+	if err := user.Create("udptest", "udptest", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	// Generate and save mock client rules, as UDP clients can only be whitelisted by their peer_id
+	whitelist := data.ClientRuleRecord{Prefix: "-GT", MinVersion: "0001"}
+	blacklist := data.ClientRuleRecord{Prefix: "-GT", MinVersion: "0009", MaxVersion: "0009", Blacklist: true}
+	for _, rule := range []data.ClientRuleRecord{whitelist, blacklist} {
+		if err := rule.Save(); err != nil {
+			t.Fatalf("Failed to save mock client rule: %s", err.Error())
+		}
+	}
+
 	// Fake UDP address
 	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 	if err != nil {
@@ -71,7 +89,7 @@ func TestUDPRouter(t *testing.T) {
 		Action:     1,
 		TransID:    connRes.TransID,
 		InfoHash:   []byte("deadbeef000000000000"),
-		PeerID:     []byte("00001111222233334444"),
+		PeerID:     []byte("-GT0001-222233334444"),
 		Downloaded: 0,
 		Left:       0,
 		Uploaded:   0,
@@ -80,6 +98,34 @@ func TestUDPRouter(t *testing.T) {
 		Port:       5000,
 	}
 
+	// Announces from clients which are not whitelisted, or are blacklisted, or without a passkey must be rejected
+	var rejected = []struct {
+		peerID string
+		msg    string
+	}{
+		{"-GT0000-222233334444", "Your client is not whitelisted"},
+		{"-XL0012-222233334444", "Your client is not whitelisted"},
+		{"-GT0009-222233334444", "Your client is blacklisted"},
+		{"-GT0001-222233334444", "No passkey found in announce URL"},
+	}
+
+	for i, test := range rejected {
+		announce.PeerID = []byte(test.peerID)
+		announceBuf, err := announce.MarshalBinary()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		res, err = parseUDP(announceBuf, addr)
+		errRes := new(udp.ErrorResponse)
+		if err != nil || errRes.UnmarshalBinary(res) != nil || errRes.Error != test.msg {
+			t.Fatalf("[%02d] Announce was not rejected with %q: %q", i, test.msg, errRes.Error)
+		}
+	}
+
+	// Send passkey using URLData
+	announce.URLData = "/" + user.Passkey + "/announce"
+
 	// Get announce bytes
 	announceBuf, err := announce.MarshalBinary()
 	if err != nil {
@@ -156,8 +202,26 @@ func TestUDPRouter(t *testing.T) {
 	}
 	log.Println(scrapeRes)
 
-	// Delete mock file
+	// Delete mock file, client rules, and user
 	if err := file.Delete(); err != nil {
 		t.Fatalf("Failed to delete mock file: %s", err.Error())
 	}
+
+	rules, err := new(data.ClientRuleRecordRepository).All()
+	if err != nil {
+		t.Fatalf("Failed to load mock client rules: %s", err.Error())
+	}
+	for _, rule := range rules {
+		if rule.Prefix != "-GT" {
+			continue
+		}
+
+		if err := rule.Delete(); err != nil {
+			t.Fatalf("Failed to delete mock client rule: %s", err.Error())
+		}
+	}
+
+	if user, err := user.Load("udptest", "username"); err != nil || user.Delete() != nil {
+		t.Fatalf("Failed to delete mock user")
+	}
 }
@@ -0,0 +1,421 @@
+package goat
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/ws"
+	"github.com/mdlayher/goat/goat/tracker"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// wsQueueSize is the number of messages which may wait to be written to a WebTorrent peer before it is considered
+// too slow, and disconnected
+const wsQueueSize = 32
+
+// wsWriteTimeout is the time allowed to write a single message to a WebTorrent peer
+const wsWriteTimeout = 10 * time.Second
+
+// errWSQueueFull is returned when a WebTorrent peer is disconnected for not reading its messages
+var errWSQueueFull = errors.New("goat: WebSocket send queue full")
+
+// errWSClosed is returned when sending to a WebTorrent peer which has disconnected
+var errWSClosed = errors.New("goat: WebSocket closed")
+
+// wsPeer is a WebTorrent client connected to the tracker, to which offers and answers may be relayed.  Messages
+// are queued and written by a single goroutine, so a slow peer never blocks the peers relaying to it.
+type wsPeer struct {
+	conn  *websocket.Conn
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newWSPeer creates a wsPeer for a connection, whose queue is written once write is started
+func newWSPeer(conn *websocket.Conn) *wsPeer {
+	return &wsPeer{
+		conn:  conn,
+		queue: make(chan []byte, wsQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// send queues a single JSON message for this peer, without waiting for it to be written.  A peer whose queue is
+// full is not keeping up, and is disconnected.
+func (p *wsPeer) send(msg []byte) error {
+	select {
+	case <-p.done:
+		return errWSClosed
+	default:
+	}
+
+	select {
+	case p.queue <- msg:
+		return nil
+	default:
+		p.close()
+		return errWSQueueFull
+	}
+}
+
+// close stops this peer's writer, which closes its connection
+func (p *wsPeer) close() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+}
+
+// closed reports whether this peer has been closed
+func (p *wsPeer) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// write writes queued messages to this peer until it is closed, or a write fails, and then closes its connection,
+// which also ends any read waiting on it
+func (p *wsPeer) write() {
+	defer func() {
+		if err := p.conn.Close(); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case msg := <-p.queue:
+			if err := p.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+				log.Println(err.Error())
+			}
+
+			if err := websocket.Message.Send(p.conn, string(msg)); err != nil {
+				log.Println(err.Error())
+				p.close()
+				return
+			}
+		}
+	}
+}
+
+// wsSwarm tracks the connected peers in each swarm, keyed by binary string info hash and peer_id
+type wsSwarm struct {
+	sync.RWMutex
+	files map[string]map[string]*wsPeer
+}
+
+// wsSwarms contains all WebTorrent peers connected to this tracker
+var wsSwarms = &wsSwarm{files: map[string]map[string]*wsPeer{}}
+
+// join adds a peer to a swarm, returning false if its peer_id is owned by another live connection
+func (s *wsSwarm) join(infoHash string, peerID string, p *wsPeer) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if owner := s.files[infoHash][peerID]; owner != nil && owner != p && !owner.closed() {
+		return false
+	}
+
+	if s.files[infoHash] == nil {
+		s.files[infoHash] = map[string]*wsPeer{}
+	}
+	s.files[infoHash][peerID] = p
+	return true
+}
+
+// taken reports whether a peer_id in a swarm is owned by a live connection other than the specified peer
+func (s *wsSwarm) taken(infoHash string, peerID string, p *wsPeer) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	owner := s.files[infoHash][peerID]
+	return owner != nil && owner != p && !owner.closed()
+}
+
+// leave removes a peer from a swarm, if it is still the connection using its peer_id
+func (s *wsSwarm) leave(infoHash string, peerID string, p *wsPeer) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.files[infoHash][peerID] != p {
+		return
+	}
+
+	delete(s.files[infoHash], peerID)
+	if len(s.files[infoHash]) == 0 {
+		delete(s.files, infoHash)
+	}
+}
+
+// peer returns the peer using a peer_id in a swarm, or nil if it is not connected
+func (s *wsSwarm) peer(infoHash string, peerID string) *wsPeer {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.files[infoHash][peerID]
+}
+
+// peers returns up to n peers in a swarm other than the specified peer_id, in no particular order
+func (s *wsSwarm) peers(infoHash string, exclude string, n int) map[string]*wsPeer {
+	s.RLock()
+	defer s.RUnlock()
+
+	peers := map[string]*wsPeer{}
+	for id, p := range s.files[infoHash] {
+		if len(peers) >= n {
+			break
+		}
+
+		if id != exclude && !p.closed() {
+			peers[id] = p
+		}
+	}
+
+	return peers
+}
+
+// isWebSocket reports whether a HTTP request is asking to be upgraded to a WebSocket
+func isWebSocket(r *http.Request) bool {
+	return strings.ToLower(r.Header.Get("Upgrade")) == "websocket"
+}
+
+// handleWebSocket upgrades a HTTP request to a WebSocket, and handles the WebTorrent messages sent on it
+func handleWebSocket(w http.ResponseWriter, r *http.Request, passkey string, ip string, client string) {
+	// WebSocket Server, rather than Handler, is used because browsers may connect from any origin
+	websocket.Server{Handler: func(conn *websocket.Conn) {
+		serveWebSocket(conn, passkey, ip, client)
+	}}.ServeHTTP(w, r)
+}
+
+// serveWebSocket reads WebTorrent messages from a connection until it is closed
+func serveWebSocket(conn *websocket.Conn, passkey string, ip string, client string) {
+	// Start writing messages queued for this peer
+	peer := newWSPeer(conn)
+	written := make(chan struct{})
+	go func() {
+		peer.write()
+		close(written)
+	}()
+
+	// Swarms joined by this connection, and the peer_id used in each, so they can be left on close
+	joined := map[string]string{}
+	defer func() {
+		for infoHash, peerID := range joined {
+			wsSwarms.leave(infoHash, peerID, peer)
+		}
+
+		// Wait for the writer to close the connection
+		peer.close()
+		<-written
+	}()
+
+	for {
+		// Clients announce at least once per interval, so one which misses two is gone
+		if common.Static.Config.Interval > 0 {
+			timeout := 2 * time.Duration(common.Static.Config.Interval) * time.Second
+			if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+				log.Println(err.Error())
+			}
+		}
+
+		// Read next message
+		var req ws.Request
+		if err := websocket.JSON.Receive(conn, &req); err != nil {
+			// Client disconnected, stopped announcing, or was closed by its writer
+			if err == io.EOF || peer.closed() {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return
+			}
+
+			// Malformed message, which cannot be answered reliably, so drop the client
+			log.Println(err.Error())
+			return
+		}
+
+		// Count incoming messages
+		atomic.AddInt64(&common.Static.HTTP.Minute, 1)
+		atomic.AddInt64(&common.Static.HTTP.HalfHour, 1)
+		atomic.AddInt64(&common.Static.HTTP.Hour, 1)
+		atomic.AddInt64(&common.Static.HTTP.Total, 1)
+
+		// Generate and send response, if one is needed
+		res := parseWebSocket(peer, req, joined, passkey, ip, client)
+		if res == nil {
+			continue
+		}
+
+		if err := peer.send(res); err != nil {
+			log.Println(err.Error())
+			return
+		}
+	}
+}
+
+// parseWebSocket handles a single WebTorrent message, returning the response for the client, if any
+func parseWebSocket(peer *wsPeer, req ws.Request, joined map[string]string, passkey string, ip string, client string) []byte {
+	// Create a tracker to handle this message, echoing its first info hash in errors
+	wsTracker := tracker.WebSocketTracker{Action: req.Action}
+	infoHashes, err := req.InfoHashes()
+	if err == nil && len(infoHashes) > 0 {
+		wsTracker.InfoHash = infoHashes[0]
+	}
+
+	// Check for maintenance mode
+	if common.Static.Maintenance {
+		return wsTracker.Error("Maintenance: " + common.Static.StatusMessage)
+	}
+
+	// Bound all data calls made on behalf of this message by the configured timeout
+	ctx, cancel := requestContext()
+	defer cancel()
+
+	// Scrape
+	if req.Action == "scrape" {
+		query, err := req.ScrapeValues()
+		if err != nil {
+			return wsTracker.Error("Malformed scrape")
+		}
+
+		query.Set("ip", ip)
+		query.Set("passkey", passkey)
+		return tracker.Scrape(ctx, wsTracker, query)
+	}
+
+	// Only announce remains
+	if req.Action != "announce" || len(infoHashes) != 1 {
+		return wsTracker.Error("Malformed announce")
+	}
+	infoHash := infoHashes[0]
+
+	// Convert announce to query map
+	query, err := req.ToValues()
+	if err != nil {
+		return wsTracker.Error("Malformed announce")
+	}
+
+	query.Set("ip", ip)
+	query.Set("client", client)
+
+	// Apply client rules.  The client's User-Agent was whitelisted when it connected, but its peer_id is only
+	// known once it announces.
+	verdict, err := clientVerdict(ctx, query.Get("peer_id"))
+	if err != nil {
+		log.Println(err.Error())
+
+		// Database too slow to answer, so do not penalize the client
+		if err == data.ErrTimeout {
+			return wsTracker.Error(tracker.ErrTrackerBusy.Error())
+		}
+	}
+	if verdict == data.ClientBlacklisted {
+		return wsTracker.Error("Your client is blacklisted")
+	}
+
+	// Validate passkey and torrent limit
+	user, msg, err := announceUser(ctx, passkey)
+	if err != nil {
+		log.Println(err.Error())
+
+		// Database too slow to answer, so do not penalize the client
+		if err == data.ErrTimeout {
+			return wsTracker.Error(tracker.ErrTrackerBusy.Error())
+		}
+	}
+	if msg != "" {
+		return wsTracker.Error(msg)
+	}
+
+	// Each peer_id in a swarm belongs to the first live connection which announced it
+	if wsSwarms.taken(infoHash, req.PeerID, peer) {
+		return wsTracker.Error("peer_id in use by another connection")
+	}
+
+	// Answers are relayed to the peer which made the offer, and are not announces themselves.  Only peers which
+	// joined the swarm may answer.
+	if req.Answer != nil {
+		if joined[infoHash] != req.PeerID {
+			return wsTracker.Error("Answer sent before announce")
+		}
+
+		if to := wsSwarms.peer(infoHash, req.ToPeerID); to != nil {
+			relay(to, ws.RelayMessage{
+				Action:   "announce",
+				InfoHash: infoHash,
+				PeerID:   req.PeerID,
+				Answer:   req.Answer,
+				OfferID:  req.OfferID,
+			})
+		}
+
+		return nil
+	}
+
+	query.Set("passkey", user.Passkey)
+
+	// Perform tracker announce
+	res := tracker.Announce(ctx, wsTracker, user, query)
+
+	// Only peers which announced successfully take part in the swarm
+	var failure ws.ErrorResponse
+	if err := json.Unmarshal(res, &failure); err != nil || failure.FailureReason != "" {
+		return res
+	}
+
+	// Stopped peers leave the swarm
+	if req.Event == "stopped" {
+		wsSwarms.leave(infoHash, req.PeerID, peer)
+		delete(joined, infoHash)
+		return res
+	}
+
+	// Another connection may have joined using this peer_id during the announce
+	if !wsSwarms.join(infoHash, req.PeerID, peer) {
+		return wsTracker.Error("peer_id in use by another connection")
+	}
+	joined[infoHash] = req.PeerID
+
+	// Relay each offer to a different peer in the swarm, which will answer if it wishes to connect
+	i := 0
+	for _, to := range wsSwarms.peers(infoHash, req.PeerID, len(req.Offers)) {
+		relay(to, ws.RelayMessage{
+			Action:   "announce",
+			InfoHash: infoHash,
+			PeerID:   req.PeerID,
+			Offer:    req.Offers[i].Offer,
+			OfferID:  req.Offers[i].OfferID,
+		})
+		i++
+	}
+
+	return res
+}
+
+// relay queues an offer or answer for another peer
+func relay(to *wsPeer, msg ws.RelayMessage) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if err := to.send(buf); err != nil {
+		log.Println(err.Error())
+	}
+}
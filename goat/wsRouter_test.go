@@ -0,0 +1,202 @@
+package goat
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/goat/goat/common"
+	"github.com/mdlayher/goat/goat/data"
+	"github.com/mdlayher/goat/goat/data/ws"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// TestWebSocketRouter verifies that WebTorrent clients can announce, exchange offers and answers, and scrape
+func TestWebSocketRouter(t *testing.T) {
+	log.Println("TestWebSocketRouter()")
+
+	// Load config, enabling WebSocket tracker
+	config, err := common.LoadConfig()
+	if err != nil {
+		t.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	common.Static.Config = config
+	common.Static.Config.WebSocket = true
+
+	// Generate mock data.FileRecord, whose info hash includes a byte which is not valid UTF-8
+	infoHash := "wsrouter\xfftest0000000"
+	file := data.FileRecord{
+		InfoHash: hex.EncodeToString([]byte(infoHash)),
+		Verified: true,
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Failed to save mock file: %s", err.Error())
+	}
+
+	// Generate mock user and whitelisted client
+	user := new(data.UserRecord)
+	if err := user.Create("wstest", "wstest", 10); err != nil {
+		t.Fatalf("Failed to create mock user: %s", err.Error())
+	}
+	if err := user.Save(); err != nil {
+		t.Fatalf("Failed to save mock user: %s", err.Error())
+	}
+
+	whitelist := data.WhitelistRecord{Client: "goat_ws_test", Approved: true}
+	if err := whitelist.Save(); err != nil {
+		t.Fatalf("Failed to save mock whitelist: %s", err.Error())
+	}
+
+	// Start HTTP server, and connect two WebTorrent clients
+	server := httptest.NewServer(http.HandlerFunc(parseHTTP))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		wsConfig, err := websocket.NewConfig(strings.Replace(server.URL, "http", "ws", 1)+"/"+user.Passkey+"/announce", server.URL)
+		if err != nil {
+			t.Fatalf("Failed to create WebSocket config: %s", err.Error())
+		}
+		wsConfig.Header = http.Header{"User-Agent": {"goat_ws_test"}}
+
+		conn, err := websocket.DialConfig(wsConfig)
+		if err != nil {
+			t.Fatalf("Failed to connect WebSocket: %s", err.Error())
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		return conn
+	}
+
+	// receive reads a single message, failing the test if it is a tracker error
+	receive := func(conn *websocket.Conn) map[string]interface{} {
+		var msg map[string]interface{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive WebSocket message: %s", err.Error())
+		}
+		if reason, ok := msg["failure reason"]; ok {
+			t.Fatalf("Tracker returned error: %s", reason)
+		}
+
+		return msg
+	}
+
+	binaryHash := ws.ToBinaryString(infoHash)
+	announce := func(conn *websocket.Conn, peerID string, offer string) {
+		msg := map[string]interface{}{
+			"action":     "announce",
+			"info_hash":  binaryHash,
+			"peer_id":    peerID,
+			"numwant":    1,
+			"uploaded":   0,
+			"downloaded": 0,
+			"left":       nil,
+			"event":      "started",
+			"offers":     []interface{}{map[string]interface{}{"offer": map[string]string{"sdp": offer}, "offer_id": offer}},
+		}
+		if err := websocket.JSON.Send(conn, msg); err != nil {
+			t.Fatalf("Failed to send announce: %s", err.Error())
+		}
+	}
+
+	peerA, peerB := "-WW0001-aaaaaaaaaaaa", "-WW0001-bbbbbbbbbbbb"
+	connA, connB := dial(), dial()
+	defer connA.Close()
+	defer connB.Close()
+
+	// First peer announces into an empty swarm
+	announce(connA, peerA, "offerA")
+	if res := receive(connA); res["action"] != "announce" || res["info_hash"] != binaryHash {
+		t.Fatalf("Unexpected announce response: %v", res)
+	}
+
+	// Second peer announces, and its offer is relayed to the first peer
+	announce(connB, peerB, "offerB")
+	if res := receive(connB); res["action"] != "announce" {
+		t.Fatalf("Unexpected announce response: %v", res)
+	}
+
+	relayed := receive(connA)
+	if relayed["peer_id"] != peerB || relayed["offer_id"] != "offerB" || relayed["offer"] == nil {
+		t.Fatalf("Unexpected relayed offer: %v", relayed)
+	}
+
+	// First peer answers, and the answer is relayed to the second peer
+	answer := map[string]interface{}{
+		"action":     "announce",
+		"info_hash":  binaryHash,
+		"peer_id":    peerA,
+		"to_peer_id": peerB,
+		"offer_id":   "offerB",
+		"answer":     map[string]string{"sdp": "answerA"},
+	}
+	if err := websocket.JSON.Send(connA, answer); err != nil {
+		t.Fatalf("Failed to send answer: %s", err.Error())
+	}
+
+	relayed = receive(connB)
+	if relayed["peer_id"] != peerA || relayed["offer_id"] != "offerB" || relayed["answer"] == nil {
+		t.Fatalf("Unexpected relayed answer: %v", relayed)
+	}
+
+	// A third connection may neither answer before announcing, nor take over a peer_id in use
+	connC := dial()
+	defer connC.Close()
+
+	failure := func(conn *websocket.Conn) string {
+		var msg map[string]interface{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive WebSocket message: %s", err.Error())
+		}
+
+		reason, _ := msg["failure reason"].(string)
+		return reason
+	}
+
+	answer["peer_id"] = "-WW0001-cccccccccccc"
+	if err := websocket.JSON.Send(connC, answer); err != nil {
+		t.Fatalf("Failed to send answer: %s", err.Error())
+	}
+	if reason := failure(connC); reason == "" {
+		t.Fatalf("Answer before announce was relayed")
+	}
+
+	announce(connC, peerA, "offerC")
+	if reason := failure(connC); reason == "" {
+		t.Fatalf("Announce took over peer_id of another connection")
+	}
+
+	// Scrape the file
+	if err := websocket.JSON.Send(connA, map[string]interface{}{"action": "scrape", "info_hash": []string{binaryHash}}); err != nil {
+		t.Fatalf("Failed to send scrape: %s", err.Error())
+	}
+
+	res := receive(connA)
+	buf, _ := json.Marshal(res)
+	scrape := ws.ScrapeResponse{}
+	if err := json.Unmarshal(buf, &scrape); err != nil || scrape.Action != "scrape" {
+		t.Fatalf("Unexpected scrape response: %v", res)
+	}
+	if _, ok := scrape.Files[binaryHash]; !ok {
+		t.Fatalf("Scrape response missing file: %v", res)
+	}
+
+	// Delete mock records
+	if err := file.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock file: %s", err.Error())
+	}
+	if whitelist, err = whitelist.Load("goat_ws_test", "client"); err != nil {
+		t.Fatalf("Failed to load mock whitelist: %s", err.Error())
+	}
+	if err := whitelist.Delete(); err != nil {
+		t.Fatalf("Failed to delete mock whitelist: %s", err.Error())
+	}
+	if user, err := user.Load("wstest", "username"); err != nil || user.Delete() != nil {
+		t.Fatalf("Failed to delete mock user")
+	}
+}
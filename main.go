@@ -19,9 +19,15 @@ var config = flag.String("config", "", "Override config file location with custo
 // mySQLDSN is a flag which allows manual override of MySQL DSN configuration
 var mySQLDSN = flag.String("mysqldsn", "", "Override config file with the following MySQL DSN configuration.")
 
+// postgresDSN is a flag which allows manual override of PostgreSQL DSN configuration
+var postgresDSN = flag.String("postgresdsn", "", "Override config file with the following PostgreSQL DSN configuration.")
+
 // qlDBPath is a flag which allows override of the default ql database file location
 var qlDBPath = flag.String("qldb", "", "Override ql database file location with custom path.")
 
+// memoryDBPath is a flag which enables snapshot and restore of the memory database using a file
+var memoryDBPath = flag.String("memorydb", "", "Snapshot memory database to this file on shutdown, and restore it on startup.")
+
 // test is a flag which causes goat to start, and exit shortly after
 var test = flag.Bool("test", false, "Make goat start, and exit shortly after. Used for testing.")
 
@@ -32,7 +38,14 @@ func main() {
 	// Pass command-line flags to override default configurations
 	common.ConfigPath = config
 	data.MySQLDSN = mySQLDSN
+	data.PostgresDSN = postgresDSN
 	data.QLDBPath = qlDBPath
+	data.MemoryDBPath = memoryDBPath
+
+	// Run an administrative command, such as export or import, instead of the tracker
+	if flag.NArg() > 0 {
+		os.Exit(goat.Command(flag.Args()))
+	}
 
 	// If test mode, trigger quit shortly after startup
 	// Used for CI tests, so that we ensure goat starts up and is able to stop gracefully
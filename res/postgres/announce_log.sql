@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS announce_log (
+	id serial NOT NULL
+	, info_hash varchar(40) NOT NULL
+	, passkey varchar(40) NOT NULL
+	, "key" varchar(8) NOT NULL
+	, ip varchar(15) NOT NULL
+	, port integer NOT NULL
+	, udp boolean NOT NULL
+	, uploaded bigint NOT NULL
+	, downloaded bigint NOT NULL
+	, "left" bigint NOT NULL
+	, event varchar(10) NOT NULL
+	, client varchar(50) NOT NULL
+	, "time" integer NOT NULL
+	, PRIMARY KEY (id)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id serial NOT NULL
+	, user_id integer NOT NULL
+	, pubkey varchar(40) NOT NULL
+	, secret varchar(40) NOT NULL
+	, expire integer NOT NULL
+	, PRIMARY KEY (id)
+	, UNIQUE (pubkey)
+	, UNIQUE (secret)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS files (
+	id serial NOT NULL
+	, info_hash varchar(40) NOT NULL
+	, verified boolean NOT NULL
+	, create_time integer NOT NULL
+	, update_time integer NOT NULL
+	, PRIMARY KEY (id)
+	, UNIQUE (info_hash)
+);
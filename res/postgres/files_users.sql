@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS files_users (
+	file_id integer NOT NULL
+	, user_id integer NOT NULL
+	, ip varchar(15) NOT NULL
+	, active boolean NOT NULL
+	, completed boolean NOT NULL
+	, announced integer NOT NULL
+	, uploaded bigint NOT NULL
+	, downloaded bigint NOT NULL
+	, "left" bigint NOT NULL
+	, "time" integer NOT NULL
+	, UNIQUE (file_id, user_id, ip)
+);
+CREATE INDEX IF NOT EXISTS files_users_ip ON files_users (ip);
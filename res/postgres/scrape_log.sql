@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS scrape_log (
+	id serial NOT NULL
+	, info_hash varchar(40) NOT NULL
+	, passkey varchar(40) NOT NULL
+	, ip varchar(15) NOT NULL
+	, "time" integer NOT NULL
+	, PRIMARY KEY (id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS users (
+	id serial NOT NULL
+	, username varchar(20) NOT NULL
+	, password varchar(60) NOT NULL
+	, passkey varchar(40) NOT NULL
+	, torrent_limit integer NOT NULL
+	, PRIMARY KEY (id)
+	, UNIQUE (username)
+	, UNIQUE (password)
+	, UNIQUE (passkey)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS whitelist (
+	id serial NOT NULL
+	, client varchar(50) NOT NULL
+	, approved boolean NOT NULL
+	, PRIMARY KEY (id)
+	, UNIQUE (client)
+);